@@ -0,0 +1,106 @@
+package main
+
+// The commands take the app as an import path under the GOPATH, or as the
+// path of its directory, e.g. "gospf run .".  An app outside the GOPATH is
+// given the import path of its go.mod module, or else the name of its
+// directory, and linked at that import path in a workspace that is put first
+// in the GOPATH, so that it builds like any other.
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+// isDirPath reports whether the argument is the path of a directory, rather
+// than an import path.
+func isDirPath(arg string) bool {
+	slashed := filepath.ToSlash(arg)
+	return slashed == "." || slashed == ".." || strings.HasPrefix(slashed, "./") ||
+		strings.HasPrefix(slashed, "../") || filepath.IsAbs(arg)
+}
+
+// resolveAppPath returns the import path of the app given on the command
+// line.  If it is given as a directory outside the GOPATH, the directory is
+// linked into the GOPATH at that import path.
+func resolveAppPath(arg string) (string, error) {
+	if !isDirPath(arg) {
+		return arg, nil
+	}
+	dir, err := filepath.Abs(arg)
+	if err != nil {
+		return "", wrapError(err, "Failed to find "+arg)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", exitf(exitConfigError, "Abort: %s is not a directory.", arg)
+	}
+
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		rel, err := filepath.Rel(filepath.Join(gopath, "src"), dir)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel), nil
+		}
+	}
+
+	importPath := moduleImportPath(dir)
+	if importPath == "" {
+		importPath = filepath.Base(dir)
+	}
+	if err := linkIntoGopath(dir, importPath); err != nil {
+		return "", err
+	}
+	gospf.TRACE.Printf("Building %s from %s", importPath, dir)
+	return importPath, nil
+}
+
+// moduleImportPath returns the import path of dir within the module of the
+// nearest go.mod, or "" if there is none.
+func moduleImportPath(dir string) string {
+	for root := dir; ; {
+		if module := harness.ModulePath(filepath.Join(root, "go.mod")); module != "" {
+			rel, _ := filepath.Rel(root, dir)
+			return path.Join(module, filepath.ToSlash(rel))
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return ""
+		}
+		root = parent
+	}
+}
+
+// linkIntoGopath links dir at the import path in a workspace of its own, and
+// puts that workspace first in the GOPATH.  The workspace is kept in the user
+// cache directory, so that the app has the same path on each run.
+func linkIntoGopath(dir, importPath string) error {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	workspace := filepath.Join(cacheDir, "gospf", "gopath", fmt.Sprintf("%x", sha1.Sum([]byte(dir)))[:12])
+	link := filepath.Join(workspace, "src", filepath.FromSlash(importPath))
+	if target, err := os.Readlink(link); err != nil || target != dir {
+		os.Remove(link)
+		if err = os.MkdirAll(filepath.Dir(link), 0777); err == nil {
+			err = os.Symlink(dir, link)
+		}
+		if err != nil {
+			return wrapError(err, "Failed to link the app into "+workspace)
+		}
+	}
+
+	gopath := workspace
+	if build.Default.GOPATH != "" {
+		gopath += string(filepath.ListSeparator) + build.Default.GOPATH
+	}
+	os.Setenv("GOPATH", gopath)
+	build.Default.GOPATH = gopath
+	return nil
+}
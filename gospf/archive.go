@@ -0,0 +1,176 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveFormats are the archive formats supported by archiveDir, by their
+// file name extension.
+var archiveFormats = []string{"tar.gz", "tar.zst", "zip"}
+
+// archiveDir writes the files in srcDir to the archive destFilename, in the
+// given format.  It returns the name of the archive.  A reproducible archive
+// is byte-identical for the same files: their times, owners and permissions
+// are normalized.
+func archiveDir(destFilename, srcDir, format string, reproducible bool) (string, error) {
+	archiveFile, err := os.Create(destFilename)
+	if err != nil {
+		return "", wrapError(err, "Failed to create archive")
+	}
+	defer archiveFile.Close()
+
+	switch format {
+	case "tar.gz":
+		err = tarGzDir(archiveFile, srcDir, reproducible)
+	case "tar.zst":
+		err = tarZstDir(archiveFile, srcDir, reproducible)
+	case "zip":
+		err = zipDir(archiveFile, srcDir, reproducible)
+	default:
+		err = exitf(exitUsage, "Unknown archive format %q.  Use %s.", format, strings.Join(archiveFormats, ", "))
+	}
+	if err != nil {
+		os.Remove(destFilename)
+		return "", err
+	}
+	return archiveFile.Name(), wrapError(archiveFile.Close(), "Failed to write archive")
+}
+
+func tarGzDir(w io.Writer, srcDir string, reproducible bool) error {
+	gzipWriter := gzip.NewWriter(w)
+	if err := tarDir(gzipWriter, srcDir, "", reproducible); err != nil {
+		return err
+	}
+	return wrapError(gzipWriter.Close(), "Failed to compress archive")
+}
+
+// tarZstDir compresses with the zstd command, which must be installed.
+func tarZstDir(w io.Writer, srcDir string, reproducible bool) error {
+	zstdPath, err := exec.LookPath("zstd")
+	if err != nil {
+		return errorf("The zstd executable was not found in PATH.  It is required for tar.zst archives.")
+	}
+
+	zstdCmd := exec.Command(zstdPath, "-q", "-c")
+	zstdCmd.Stdout, zstdCmd.Stderr = w, os.Stderr
+	zstdInput, err := zstdCmd.StdinPipe()
+	if err != nil {
+		return wrapError(err, "Failed to run zstd")
+	}
+	if err = zstdCmd.Start(); err != nil {
+		return wrapError(err, "Failed to run zstd")
+	}
+
+	err = tarDir(zstdInput, srcDir, "", reproducible)
+	zstdInput.Close()
+	if waitErr := zstdCmd.Wait(); err == nil {
+		err = wrapError(waitErr, "Failed to compress archive")
+	}
+	return err
+}
+
+// tarDir writes the files in srcDir to the tar archive, with their names
+// prefixed by the given prefix.
+func tarDir(w io.Writer, srcDir, prefix string, reproducible bool) error {
+	tarWriter := tar.NewWriter(w)
+	err := walkFiles(srcDir, func(name string, info os.FileInfo, srcFile io.Reader) error {
+		header := &tar.Header{
+			Name:    prefix + name,
+			Size:    info.Size(),
+			Mode:    int64(info.Mode()),
+			ModTime: info.ModTime(),
+		}
+		if reproducible {
+			header.Mode = int64(reproducibleMode(info))
+			header.ModTime = reproducibleTime()
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return wrapError(err, "Failed to write tar entry header")
+		}
+
+		_, err := io.Copy(tarWriter, srcFile)
+		return wrapError(err, "Failed to copy")
+	})
+	if err != nil {
+		return err
+	}
+	return wrapError(tarWriter.Close(), "Failed to write archive")
+}
+
+func zipDir(w io.Writer, srcDir string, reproducible bool) error {
+	zipWriter := zip.NewWriter(w)
+	err := walkFiles(srcDir, func(name string, info os.FileInfo, srcFile io.Reader) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return wrapError(err, "Failed to write zip entry header")
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+		if reproducible {
+			header.SetMode(reproducibleMode(info))
+			header.Modified = reproducibleTime()
+		}
+
+		entry, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return wrapError(err, "Failed to write zip entry header")
+		}
+		_, err = io.Copy(entry, srcFile)
+		return wrapError(err, "Failed to copy")
+	})
+	if err != nil {
+		return err
+	}
+	return wrapError(zipWriter.Close(), "Failed to write archive")
+}
+
+// walkFiles calls fn with each regular file under srcDir, named by its
+// slash-separated path relative to srcDir.
+func walkFiles(srcDir string, fn func(name string, info os.FileInfo, srcFile io.Reader) error) error {
+	return filepath.Walk(srcDir, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return wrapError(err, "Failed to read "+srcPath)
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		srcFile, err := os.Open(srcPath)
+		if err != nil {
+			return wrapError(err, "Failed to read source file")
+		}
+		defer srcFile.Close()
+
+		name := filepath.ToSlash(strings.TrimLeft(srcPath[len(srcDir):], string(os.PathSeparator)))
+		return fn(name, info, srcFile)
+	})
+}
+
+// reproducibleTime is the modification time of the files in a reproducible
+// archive: $SOURCE_DATE_EPOCH, if set, or else the earliest time that zip
+// archives can hold.
+func reproducibleTime() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// reproducibleMode is the mode of a file in a reproducible archive, which
+// only keeps whether the file is executable, rather than depending on the
+// umask of the build.
+func reproducibleMode(info os.FileInfo) os.FileMode {
+	if info.Mode()&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
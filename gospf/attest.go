@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+// A provenance attestation describes how a package was built, in the SLSA
+// provenance format, wrapped in an in-toto statement whose subjects are the
+// packaged files.  It is signed with the key in package.attest.key, as a
+// DSSE envelope, and packaged as provenanceFile.
+
+const (
+	provenanceFile   = "provenance.intoto.jsonl"
+	inTotoStatement  = "https://in-toto.io/Statement/v0.1"
+	inTotoPayload    = "application/vnd.in-toto+json"
+	slsaProvenance   = "https://slsa.dev/provenance/v0.2"
+	packageBuildType = "https://github.com/hubply/cmd/package@v1"
+)
+
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []provenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenance          `json:"predicate"`
+}
+
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenance struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		Parameters map[string]string `json:"parameters"`
+	} `json:"invocation"`
+	Metadata struct {
+		BuildStartedOn  time.Time `json:"buildStartedOn"`
+		BuildFinishedOn time.Time `json:"buildFinishedOn"`
+		Reproducible    bool      `json:"reproducible"`
+	} `json:"metadata"`
+	Materials []provenanceSubject `json:"materials"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// attestPackage writes the signed provenance of the build in buildDir to
+// buildDir/provenanceFile.
+func attestPackage(buildDir, mode string, started time.Time) error {
+	signer, keyID, err := loadAttestKey()
+	if err != nil {
+		return err
+	}
+
+	statement := provenanceStatement{
+		Type:          inTotoStatement,
+		PredicateType: slsaProvenance,
+	}
+	if statement.Subject, err = digestFiles(buildDir); err != nil {
+		return err
+	}
+
+	p := &statement.Predicate
+	p.Builder.ID = gospf.Config.StringDefault("package.attest.builder", defaultBuilderID())
+	p.BuildType = packageBuildType
+	p.Invocation.Parameters = map[string]string{
+		"importPath": gospf.ImportPath,
+		"runMode":    mode,
+		"tags":       harness.BuildTags(),
+		"goVersion":  runtime.Version(),
+		"goos":       runtime.GOOS,
+		"goarch":     runtime.GOARCH,
+	}
+	p.Metadata.BuildStartedOn = started.UTC()
+	p.Metadata.BuildFinishedOn = time.Now().UTC()
+	p.Metadata.Reproducible = harness.Reproducible()
+
+	source, err := sourceMaterial()
+	if err != nil {
+		return err
+	}
+	deps, err := dependencyMaterials()
+	if err != nil {
+		return err
+	}
+	p.Materials = append([]provenanceSubject{source}, deps...)
+
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return wrapError(err, "Failed to encode the provenance")
+	}
+	sig, err := signer(dssePreAuthEncoding(inTotoPayload, payload))
+	if err != nil {
+		return wrapError(err, "Failed to sign the provenance")
+	}
+	envelope, err := json.Marshal(dsseEnvelope{
+		PayloadType: inTotoPayload,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{{KeyID: keyID, Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		return wrapError(err, "Failed to encode the provenance")
+	}
+	return wrapError(ioutil.WriteFile(filepath.Join(buildDir, provenanceFile), append(envelope, '\n'), 0666),
+		"Failed to write the provenance")
+}
+
+// loadAttestKey returns a function signing with the private key in
+// package.attest.key, a PKCS #8 PEM file holding an Ed25519 or ECDSA key, and
+// the ID of the key.  The ID is package.attest.keyid, or else the SHA-256 of
+// the public key.
+func loadAttestKey() (func([]byte) ([]byte, error), string, error) {
+	keyPath := gospf.Config.StringDefault("package.attest.key", "")
+	if keyPath == "" {
+		return nil, "", exitf(exitConfigError, "package.attest.key is not set.  Set it to the PEM file of the signing key.")
+	}
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(gospf.BasePath, keyPath)
+	}
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, "", exitf(exitConfigError, "Failed to read the signing key: %s", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", exitf(exitConfigError, "%s is not a PEM file.", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", exitf(exitConfigError, "Failed to parse the signing key %s: %s", keyPath, err)
+	}
+
+	var (
+		signer func([]byte) ([]byte, error)
+		public crypto.PublicKey
+	)
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		signer = func(message []byte) ([]byte, error) {
+			return ed25519.Sign(key, message), nil
+		}
+		public = key.Public()
+	case *ecdsa.PrivateKey:
+		signer = func(message []byte) ([]byte, error) {
+			digest := sha256.Sum256(message)
+			return ecdsa.SignASN1(rand.Reader, key, digest[:])
+		}
+		public = key.Public()
+	default:
+		return nil, "", exitf(exitConfigError, "The signing key %s is not an Ed25519 or ECDSA key.", keyPath)
+	}
+
+	keyID := gospf.Config.StringDefault("package.attest.keyid", "")
+	if keyID == "" {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, "", wrapError(err, "Failed to encode the public key")
+		}
+		sum := sha256.Sum256(der)
+		keyID = hex.EncodeToString(sum[:])
+	}
+	return signer, keyID, nil
+}
+
+// dssePreAuthEncoding returns the message that is signed for a DSSE payload.
+func dssePreAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func defaultBuilderID() string {
+	hostname, _ := os.Hostname()
+	user := gospf.FirstNonEmpty(os.Getenv("USER"), os.Getenv("USERNAME"))
+	return fmt.Sprintf("gospf://%s@%s", user, hostname)
+}
+
+// digestFiles returns the SHA-256 digests of the files under dir, named by
+// their slash-separated paths relative to it.
+func digestFiles(dir string) ([]provenanceSubject, error) {
+	var subjects []provenanceSubject
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		digest, err := fileDigest(filename)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, filename)
+		subjects = append(subjects, provenanceSubject{filepath.ToSlash(rel), map[string]string{"sha256": digest}})
+		return nil
+	})
+	return subjects, wrapError(err, "Failed to digest the packaged files")
+}
+
+func fileDigest(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sourceMaterial returns the git commit of the app's source, which must be
+// clean, so that the commit describes what was built.
+func sourceMaterial() (provenanceSubject, error) {
+	git := func(args ...string) (string, error) {
+		out, err := exec.Command("git", append([]string{"-C", gospf.BasePath}, args...)...).Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	commit, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return provenanceSubject{}, errorf("Failed to find the git commit of %s.  Attestation requires the app to be in a git repository.", gospf.BasePath)
+	}
+	if status, _ := git("status", "--porcelain", "--", "."); status != "" {
+		return provenanceSubject{}, errorf("%s has uncommitted changes.  Commit them before attesting the package.", gospf.BasePath)
+	}
+	uri := gospf.ImportPath
+	if remote, err := git("config", "--get", "remote.origin.url"); err == nil && remote != "" {
+		uri = remote
+	}
+	return provenanceSubject{"git+" + uri + "@" + commit, map[string]string{"sha1": commit}}, nil
+}
+
+// dependencyMaterials returns the digests of the sources of the packages
+// built into the app, other than the app's own and the standard library.
+func dependencyMaterials() ([]provenanceSubject, error) {
+	lines, err := harness.GoListDeps("{{.ImportPath}}\t{{.Dir}}\t{{join .GoFiles \" \"}} {{join .CgoFiles \" \"}}")
+	if err != nil {
+		return nil, errorf("Failed to list the dependencies of the app: %s", err)
+	}
+
+	var materials []provenanceSubject
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 || fields[0] == gospf.ImportPath || strings.HasPrefix(fields[0], gospf.ImportPath+"/") {
+			continue
+		}
+		files := strings.Fields(fields[2])
+		sort.Strings(files)
+		hash := sha256.New()
+		for _, name := range files {
+			digest, err := fileDigest(filepath.Join(fields[1], name))
+			if err != nil {
+				return nil, wrapError(err, "Failed to digest "+fields[0])
+			}
+			fmt.Fprintf(hash, "%s  %s\n", digest, name)
+		}
+		materials = append(materials, provenanceSubject{fields[0], map[string]string{"sha256": hex.EncodeToString(hash.Sum(nil))}})
+	}
+	return materials, nil
+}
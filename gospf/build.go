@@ -1,7 +1,8 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -12,46 +13,167 @@ import (
 )
 
 var cmdBuild = &Command{
-	UsageLine: "build [import path] [target path]",
+	UsageLine: "build [-o output] [-test] [-profile name] [-autoget policy] [-deps-bundle dir] [-manifest file] [import path] [target path] [run mode]",
 	Short:     "build a Gospf application (e.g. for deployment)",
 	Long: `
 Build the Gospf web application named by the given import path.
 This allows it to be deployed and run on a machine that lacks a Go installation.
 
+The target path receives the app binary, the app with its conf, views and
+public directories, and run.sh / run.bat scripts that start the binary in
+the given run mode.  Run mode defaults to "prod".
+
 WARNING: The target path will be completely deleted, if it already exists!
 
 For example:
 
     gospf build github.com/gospf/samples/chat /tmp/chat
+
+    gospf build github.com/gospf/samples/chat /tmp/chat staging
+
+The -o flag sets the path of the built binary, overriding build.output.
+The binary is copied into the target path under the same name.
+
+The -profile flag builds the app with a build profile of app.conf, overriding
+build.profile.  A profile is a section [build.profile.<name>] that sets tags,
+gcflags, ldflags, race, vet, flags and env.<NAME> for the go commands, e.g.
+
+    [build.profile.fastdev]
+    gcflags = all=-N -l
+
+    [build.profile.ci]
+    race = true
+    vet = true
+    tags = integration
+
+The -test flag also builds the app with its test suites registered, as
+"gospf test" runs it, into the target path as <binary>-test.  Both binaries
+are built from the same processing of the source, and compiled concurrently.
+
+The -autoget flag sets whether packages the app imports but are missing are
+fetched with "go get": "off", "prompt" (the default) or "on".  This overrides
+build.autoget.
+
+The go commands of the build may be set up for a module proxy with
+build.goproxy, build.goprivate and build.gonosumdb, which set GOPROXY,
+GOPRIVATE and GONOSUMDB, overriding the environment with a warning, and
+build.goflags, which is added to GOFLAGS.  Invalid values fail the build.
+
+Apps that use cgo need a C compiler.  build.cgo=on sets CGO_ENABLED=1, which
+go build otherwise leaves off for cross builds, e.g. with GOOS set, and
+checks for the compiler of CC first; build.cgo=off sets CGO_ENABLED=0.  The
+builds that fail for a missing C compiler or library, or because cgo is
+disabled, are reported with what to install or set.
+
+The app is built with the tags of build.tags and build.tags.<run mode>, and
+the tag gospf_<run mode>, e.g. gospf_prod, so that debug-only code may be
+left out of the build with a "//go:build gospf_dev" constraint.
+
+With build.reproducible set, the same source tree builds a byte-identical
+binary with build and package, though not with run: it is built with
+-trimpath, and APP_VERSION is the full commit hash of the source rather than
+its nearest tag, and the build time is that of SOURCE_DATE_EPOCH.
+
+APP_VERSION is taken from the first of build.version.providers that gives
+one, by default "env, git, hg, file, ci": the APP_VERSION environment
+variable, "git describe", "hg identify", the VERSION file of the app, or
+build.version.file, and the commit of CI variables such as GITHUB_SHA.  The
+build also generates the app/tmp/buildinfo package, whose Version, BuildTime
+and GoVersion variables the app may import, e.g. for a status page.
+
+Besides app/, the directories of codepaths.extra are processed for
+controllers, test suites, jobs and interceptors, e.g. "codepaths.extra =
+plugins/*/controllers", relative to the app directory unless absolute.  Each
+of them also gets the reverse routes of its own controllers in its routes
+directory.  Two controllers may not have the same name, since the routes refer
+to them by name.
+
+The generated app/tmp/main.go and app/routes/routes.go may be customized with
+templates of the same name in conf/templates, e.g. conf/templates/main.go.tmpl,
+and other conf/templates/NAME.go.tmpl templates generate more files into
+app/tmp.  See the harness package for the arguments of the templates.
+
+The -deps-bundle flag builds the app strictly from the dependencies in the
+given bundle, made by "gospf deps bundle", e.g. on a network without access to
+the package hosts.  Packages missing from the bundle fail the build.
+
+The -manifest flag writes a JSON description of the build to the given file:
+the path of the binary in the target path, the build tags, the generated
+files, the controllers found, the packages the app depends on and how long
+the build took, e.g. for release tooling.
 `,
 }
 
 func init() {
 	cmdBuild.Run = buildApp
+	cmdBuild.Flag.StringVar(&harness.OutputPath, "o", "", "path of the built binary")
+	cmdBuild.Flag.BoolVar(&buildTestVariant, "test", false, "also build the app with its test suites")
+	cmdBuild.Flag.StringVar(&harness.Profile, "profile", "", "build profile of app.conf to build with")
+	cmdBuild.Flag.StringVar(&harness.AutoGet, "autoget", "", "off, prompt or on")
+	cmdBuild.Flag.StringVar(&buildDepsBundle, "deps-bundle", "", "build from the dependency bundle in dir")
+	cmdBuild.Flag.StringVar(&buildManifestPath, "manifest", "", "write a JSON manifest of the build to file")
 }
 
-func buildApp(args []string) {
-	if len(args) != 2 {
-		fmt.Fprintf(os.Stderr, "%s\n%s", cmdBuild.UsageLine, cmdBuild.Long)
-		return
+var buildDepsBundle, buildManifestPath string
+
+// buildTestVariant is set by -test.
+var buildTestVariant bool
+
+// buildFilter, if set, selects the files of the app that are copied into the
+// target path.
+var buildFilter *packageFilter
+
+func buildApp(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return exitf(exitUsage, "%s\n%s", cmdBuild.UsageLine, cmdBuild.Long)
 	}
 
-	appImportPath, destPath := args[0], args[1]
+	appImportPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+	destPath, mode := args[1], defaultMode("prod")
+	if len(args) == 3 {
+		mode = args[2]
+	}
 	if !gospf.Initialized {
-		gospf.Init("", appImportPath, "")
+		if buildDepsBundle != "" {
+			cleanup, err := useDepsBundle(appImportPath, buildDepsBundle)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+		}
+		if err := buildError(harness.InitApp(mode, appImportPath)); err != nil {
+			return err
+		}
 	}
 
 	// First, verify that it is either already empty or looks like a previous
 	// build (to avoid clobbering anything)
-	if exists(destPath) && !empty(destPath) && !exists(path.Join(destPath, "run.sh")) {
-		errorf("Abort: %s exists and does not look like a build directory.", destPath)
+	if exists(destPath) && !exists(path.Join(destPath, "run.sh")) {
+		isEmpty, err := empty(destPath)
+		if err != nil {
+			return err
+		}
+		if !isEmpty {
+			return errorf("Abort: %s exists and does not look like a build directory.", destPath)
+		}
 	}
 
 	os.RemoveAll(destPath)
 	os.MkdirAll(destPath, 0777)
 
-	app, reverr := harness.Build()
-	panicOnError(reverr, "Failed to build")
+	harness.Release = true
+	var opts harness.BuildOptions
+	if buildTestVariant {
+		opts.Variants = []harness.Variant{{Name: "test", TestSuites: true}}
+	}
+	manifest, reverr := harness.BuildOnce(opts)
+	if err := buildError(reverr); err != nil {
+		return err
+	}
+	app := harness.NewApp(manifest.Binary)
 
 	// Included are:
 	// - run scripts
@@ -63,15 +185,36 @@ func buildApp(args []string) {
 	srcPath := path.Join(destPath, "src")
 	destBinaryPath := path.Join(destPath, filepath.Base(app.BinaryPath))
 	tmpGospfPath := path.Join(srcPath, filepath.FromSlash(gospf.GOSPF_IMPORT_PATH))
-	mustCopyFile(destBinaryPath, app.BinaryPath)
-	mustChmod(destBinaryPath, 0755)
-	mustCopyDir(path.Join(tmpGospfPath, "conf"), path.Join(gospf.GospfPath, "conf"), nil)
-	mustCopyDir(path.Join(tmpGospfPath, "templates"), path.Join(gospf.GospfPath, "templates"), nil)
-	mustCopyDir(path.Join(srcPath, filepath.FromSlash(appImportPath)), gospf.BasePath, nil)
+	if err := copyFile(destBinaryPath, app.BinaryPath); err != nil {
+		return err
+	}
+	if err := chmod(destBinaryPath, 0755); err != nil {
+		return err
+	}
+	for name, binaryPath := range manifest.Variants {
+		destVariantPath := path.Join(destPath, filepath.Base(binaryPath))
+		if err := copyFile(destVariantPath, binaryPath); err != nil {
+			return err
+		}
+		if err := chmod(destVariantPath, 0755); err != nil {
+			return err
+		}
+		manifest.Variants[name] = destVariantPath
+	}
+	if buildManifestPath != "" {
+		manifest.Binary = destBinaryPath
+		if err := writeBuildManifest(buildManifestPath, manifest); err != nil {
+			return err
+		}
+	}
+	copies := map[string]string{ // dest => src
+		path.Join(tmpGospfPath, "conf"):                       path.Join(gospf.GospfPath, "conf"),
+		path.Join(tmpGospfPath, "templates"):                  path.Join(gospf.GospfPath, "templates"),
+		path.Join(srcPath, filepath.FromSlash(appImportPath)): gospf.BasePath,
+	}
 
 	// Find all the modules used and copy them over.
 	config := gospf.Config.Raw()
-	modulePaths := make(map[string]string) // import path => filesystem path
 	for _, section := range config.Sections() {
 		options, _ := config.SectionOptions(section)
 		for _, key := range options {
@@ -84,29 +227,55 @@ func buildApp(args []string) {
 			}
 			modulePath, err := gospf.ResolveImportPath(moduleImportPath)
 			if err != nil {
-				gospf.ERROR.Fatalln("Failed to load module %s: %s", key[len("module."):], err)
+				return exitf(exitConfigError, "Failed to load module %s: %s", key[len("module."):], err)
 			}
-			modulePaths[moduleImportPath] = modulePath
+			copies[path.Join(srcPath, moduleImportPath)] = modulePath
+		}
+	}
+	appDestPath := path.Join(srcPath, filepath.FromSlash(appImportPath))
+	for dest, src := range copies {
+		filter := buildFilter
+		if dest != appDestPath {
+			filter = nil
+		}
+		if err := copyDirFiltered(dest, src, nil, filter); err != nil {
+			return err
 		}
 	}
-	for importPath, fsPath := range modulePaths {
-		mustCopyDir(path.Join(srcPath, importPath), fsPath, nil)
+	if buildFilter != nil {
+		infof("%s", buildFilter.summary())
 	}
 
 	tmplData, runShPath := map[string]interface{}{
 		"BinName":    filepath.Base(app.BinaryPath),
 		"ImportPath": appImportPath,
+		"RunMode":    mode,
 	}, path.Join(destPath, "run.sh")
 
-	mustRenderTemplate(
+	err = renderTemplate(
 		runShPath,
 		filepath.Join(gospf.GospfPath, "..", "cmd", "gospf", "package_run.sh.template"),
 		tmplData)
+	if err != nil {
+		return err
+	}
 
-	mustChmod(runShPath, 0755)
+	if err = chmod(runShPath, 0755); err != nil {
+		return err
+	}
 
-	mustRenderTemplate(
+	return renderTemplate(
 		filepath.Join(destPath, "run.bat"),
 		filepath.Join(gospf.GospfPath, "..", "cmd", "gospf", "package_run.bat.template"),
 		tmplData)
 }
+
+// writeBuildManifest writes the manifest of the build as JSON to the file.
+func writeBuildManifest(filename string, manifest *harness.BuildManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return wrapError(err, "Failed to encode the build manifest")
+	}
+	return wrapError(ioutil.WriteFile(filename, append(data, '\n'), 0666),
+		"Failed to write the build manifest")
+}
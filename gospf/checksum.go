@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hubply/gospf"
+)
+
+// Each package is written along with its SHA-256 checksum, as <package>.sha256
+// in the format of sha256sum, so that a deployment pipeline can check it with
+// "sha256sum -c".  With package.signing.key set, the checksum file is signed
+// as well, as <package>.sha256.sig, by gpg or minisign as package.signing.tool
+// says:
+//
+//	gpg       the key is a key ID or user ID of the keyring, and the signature
+//	          is an armored detached signature
+//	minisign  the key is the path of a minisign secret key file, relative to
+//	          the app directory unless absolute
+//
+// By default, the key is taken for a minisign key file if such a file exists,
+// and for a gpg key otherwise.  The tools prompt for the passphrase of the key,
+// if any, as usual.
+
+// The signing tools.
+const (
+	signingGPG      = "gpg"
+	signingMinisign = "minisign"
+)
+
+// checksumPackage writes the checksum of the package, and signs it if
+// package.signing.key is set.
+func checksumPackage(filename string) error {
+	digest, err := fileDigest(filename)
+	if err != nil {
+		return wrapError(err, "Failed to compute the checksum of "+filename)
+	}
+	checksumFile, sigFile := filename+".sha256", filename+".sha256.sig"
+	// A signature of a previous package would not match.
+	os.Remove(sigFile)
+	if err = ioutil.WriteFile(checksumFile, []byte(fmt.Sprintf("%s  %s\n", digest, filepath.Base(filename))), 0666); err != nil {
+		return wrapError(err, "Failed to write the checksum")
+	}
+	resultf([]interface{}{"checksum", checksumFile}, "Checksum: %s", checksumFile)
+
+	if gospf.Config.StringDefault("package.signing.key", "") == "" {
+		return nil
+	}
+	if err = signFile(checksumFile, sigFile); err != nil {
+		return err
+	}
+	resultf([]interface{}{"signature", sigFile}, "Signature: %s", sigFile)
+	return nil
+}
+
+// signFile writes the detached signature of the file to sigFile, with the key
+// of package.signing.key.
+func signFile(filename, sigFile string) error {
+	key := gospf.Config.StringDefault("package.signing.key", "")
+	keyFile := key
+	if !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(gospf.BasePath, keyFile)
+	}
+	tool := signingGPG
+	if exists(keyFile) {
+		tool = signingMinisign
+	}
+	tool = gospf.Config.StringDefault("package.signing.tool", tool)
+
+	var cmd *exec.Cmd
+	switch tool {
+	case signingGPG:
+		cmd = exec.Command("gpg", "--yes", "--armor", "--local-user", key, "--output", sigFile, "--detach-sign", filename)
+	case signingMinisign:
+		cmd = exec.Command("minisign", "-S", "-s", keyFile, "-m", filename, "-x", sigFile)
+	default:
+		return exitf(exitConfigError, "package.signing.tool: %q is not one of gpg and minisign.", tool)
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return exitf(exitConfigError, "%s is not on the PATH.  Install it to sign the package, or unset package.signing.key.", tool)
+	}
+
+	// The tool may prompt for the passphrase of the key.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	gospf.TRACE.Println("Exec:", cmd.Args)
+	if err := cmd.Run(); err != nil {
+		return errorf("Failed to sign %s with %s: %s", filename, tool, err)
+	}
+	return nil
+}
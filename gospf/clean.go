@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"go/build"
 	"os"
 	"path"
@@ -25,24 +24,26 @@ func init() {
 	cmdClean.Run = cleanApp
 }
 
-func cleanApp(args []string) {
+func cleanApp(args []string) error {
 	if len(args) == 0 {
-		fmt.Fprintf(os.Stderr, cmdClean.Long)
-		return
+		return exitf(exitUsage, "%s", cmdClean.Long)
 	}
 
-	appPkg, err := build.Import(args[0], "", build.FindOnly)
+	importPath, err := resolveAppPath(args[0])
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Abort: Failed to find import path:", err)
-		return
+		return err
+	}
+	appPkg, err := build.Import(importPath, "", build.FindOnly)
+	if err != nil {
+		return exitf(exitConfigError, "Abort: Failed to find import path: %s", err)
 	}
 
 	// Remove the app/tmp directory.
 	tmpDir := path.Join(appPkg.Dir, "app", "tmp")
-	fmt.Println("Removing:", tmpDir)
+	infof("Removing: %s", tmpDir)
 	err = os.RemoveAll(tmpDir)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Abort:", err)
-		return
+		return errorf("Abort: %s", err)
 	}
+	return nil
 }
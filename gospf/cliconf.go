@@ -0,0 +1,129 @@
+package main
+
+// Projects can pin the defaults of the command line in conf/cli.conf, or in
+// the [cli] section of conf/app.conf, e.g.
+//
+//	# Flags for every command, before the command name.
+//	flags = -porcelain
+//
+//	# The run mode of the commands given an import path but no run mode.
+//	mode = staging
+//	build.mode = prod
+//
+//	# Flags and arguments of a command.
+//	run.flags = -n 2 -autoget on
+//	run.args = github.com/acme/shop
+//	package.flags = -format zip -trimpath
+//
+// The file is looked up from the current directory up.  Flags are separated
+// by spaces, and come before those given on the command line, so that those
+// take precedence.  Arguments are only used when the command is given none.
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// cliConfig holds the settings of conf/cli.conf, by key.
+	cliConfig = map[string]string{}
+
+	// cliCommand is the name of the command being run.
+	cliCommand string
+)
+
+// loadCLIConfig reads the settings of the nearest conf/cli.conf, or else of
+// the [cli] section of the nearest conf/app.conf.
+func loadCLIConfig() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	for {
+		for _, conf := range []struct{ name, section string }{
+			{"cli.conf", ""},
+			{"app.conf", "cli"},
+		} {
+			filename := filepath.Join(dir, "conf", conf.name)
+			file, err := os.Open(filename)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return wrapError(err, "Failed to read "+filename)
+			}
+			defer file.Close()
+			if cliConfig, err = parseCLIConfig(file, conf.section); err != nil {
+				return exitf(exitConfigError, "Failed to read %s: %s", filename, err)
+			}
+			return nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// parseCLIConfig reads the key = value settings in the given section, or
+// outside of any section if it is empty.  Blank lines and lines starting with
+// # or ; are ignored.
+func parseCLIConfig(r io.Reader, section string) (map[string]string, error) {
+	settings := make(map[string]string)
+	current := ""
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if current != section {
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			return settings, errorf("line %d: expected key = value", lineNum)
+		}
+		settings[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return settings, scanner.Err()
+}
+
+// cliFlags returns the pinned flags for the command, or for every command if
+// it is empty.
+func cliFlags(command string) []string {
+	key := "flags"
+	if command != "" {
+		key = command + ".flags"
+	}
+	return strings.Fields(cliConfig[key])
+}
+
+// cliArgs returns the given arguments of the command, or its pinned ones if
+// there are none.
+func cliArgs(command string, args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+	return strings.Fields(cliConfig[command+".args"])
+}
+
+// defaultMode returns the run mode of the command when none is given: the
+// pinned one, if any, or else the given one.
+func defaultMode(mode string) string {
+	if pinned := cliConfig[cliCommand+".mode"]; pinned != "" {
+		return pinned
+	}
+	if pinned := cliConfig["mode"]; pinned != "" {
+		return pinned
+	}
+	return mode
+}
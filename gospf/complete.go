@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+// cmdComplete is not listed in the usage.  Shell completion scripts invoke it
+// with the words typed so far, the last being the one to complete, e.g.
+//
+//	gospf __complete run github.com/hubply/samples/chat ""
+//
+// and it prints one suggestion per line.  For bash:
+//
+//	_gospf() {
+//		COMPREPLY=($(gospf __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+//	}
+//	complete -o default -F _gospf gospf
+var cmdComplete = &Command{
+	UsageLine: "__complete [words]",
+	Long: `
+Complete prints suggestions for the last of the given command line words.
+It is used by shell completion scripts.
+`,
+}
+
+func init() {
+	cmdComplete.Run = completeArgs
+}
+
+func completeArgs(args []string) error {
+	for _, suggestion := range complete(args) {
+		fmt.Println(suggestion)
+	}
+	return nil
+}
+
+// complete returns the suggestions for the last of the given words.
+func complete(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+
+	if len(words) == 1 {
+		var names []string
+		for _, cmd := range commands {
+			if cmd.Short != "" {
+				names = append(names, cmd.Name())
+			}
+		}
+		return filterPrefix(names, current)
+	}
+
+	var cmd *Command
+	for _, c := range commands {
+		if c.Name() == words[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		return nil
+	}
+
+	if strings.HasPrefix(current, "-") {
+		var flags []string
+		cmd.Flag.VisitAll(func(f *flag.Flag) {
+			flags = append(flags, "-"+f.Name)
+		})
+		return filterPrefix(flags, current)
+	}
+
+	// Positional arguments preceding the current word.
+	var positional []string
+	for _, word := range words[1 : len(words)-1] {
+		if !strings.HasPrefix(word, "-") {
+			positional = append(positional, word)
+		}
+	}
+	switch cmd {
+	case cmdEnv:
+		if len(positional) == 0 {
+			return filterPrefix([]string{"export"}, current)
+		}
+		positional = positional[1:]
+	case cmdConfig:
+		if len(positional) == 0 {
+			return filterPrefix([]string{"check"}, current)
+		}
+		positional = positional[1:]
+	case cmdGenerate:
+		if len(positional) == 0 {
+			return filterPrefix(generatorNames(), current)
+		}
+		return nil
+	}
+
+	switch {
+	case len(positional) == 1 && (cmd == cmdRun || cmd == cmdWorker || cmd == cmdWatch || cmd == cmdTest || cmd == cmdPackage || cmd == cmdEnv || cmd == cmdConfig || cmd == cmdInspect):
+		return filterPrefix(completeRunModes(positional[0]), current)
+	case len(positional) == 2 && cmd == cmdBuild:
+		return filterPrefix(completeRunModes(positional[0]), current)
+	case len(positional) == 2 && cmd == cmdTest:
+		return filterPrefix(completeTestSuites(positional[0], positional[1]), current)
+	}
+	return nil
+}
+
+// completeRunModes returns the run modes defined in the app's app.conf, which
+// are the names of its sections, other than those of the build profiles and
+// of the command line.
+func completeRunModes(importPath string) []string {
+	appPkg, err := build.Import(importPath, "", build.FindOnly)
+	if err != nil {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(appPkg.Dir, "conf", "app.conf"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var modes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if section := strings.TrimSpace(line[1 : len(line)-1]); harness.IsModeSection(section) {
+				modes = append(modes, section)
+			}
+		}
+	}
+	return modes
+}
+
+// completeTestSuites returns the names of the app's test suites.
+func completeTestSuites(importPath, mode string) []string {
+	gospf.Init(mode, importPath, "")
+	sourceInfo, err := harness.ProcessSource(harness.SourcePaths())
+	if err != nil || sourceInfo == nil {
+		return nil
+	}
+
+	var names []string
+	for _, suite := range sourceInfo.TestSuites() {
+		names = append(names, suite.StructName)
+	}
+	return names
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	var filtered []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	sort.Strings(filtered)
+	return filtered
+}
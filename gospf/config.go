@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdConfig = &Command{
+	UsageLine: "config check [import path] [run mode]",
+	Short:     "check the configuration of a Gospf application",
+	Long: `
+Config check loads the configuration of the Gospf application named by the
+given import path in the given run mode, or else in each of the run modes
+defined in conf/app.conf, and reports each check as ok or fail:
+
+    - the run mode is defined in conf/app.conf
+    - the run modes it extends are defined, and do not extend each other
+    - app.conf sets the required keys of the config schema, conf/schema.yml,
+      with values of the declared types
+
+The run modes are the sections of app.conf, other than the build profiles
+and the [cli] section.  A run mode may extend another with mode.extends,
+taking the options that it does not set from it, e.g.
+
+    [staging]
+    mode.extends = prod
+    http.port = 8080
+
+The commands fail if the run mode is not defined, rather than run the app
+with the options of no mode.
+
+For example:
+
+    gospf config check github.com/hubply/samples/booking staging
+
+The command exits with status 3 if any check fails.
+`,
+}
+
+func init() {
+	cmdConfig.Run = configCommand
+}
+
+func configCommand(args []string) error {
+	if len(args) < 2 || args[0] != "check" {
+		return exitf(exitUsage, "Usage: gospf %s\nRun 'gospf help config' for usage.\n", cmdConfig.UsageLine)
+	}
+	importPath, err := resolveAppPath(args[1])
+	if err != nil {
+		return err
+	}
+	var modes []string
+	if len(args) >= 3 {
+		modes = args[2:3]
+	} else {
+		gospf.Init(defaultMode("dev"), importPath, "")
+		if modes = harness.RunModes(); len(modes) == 0 {
+			return exitf(exitConfigError, "conf/app.conf defines no run mode.  Add a section for each, e.g. [dev] and [prod].")
+		}
+	}
+
+	d := &doctor{}
+	for _, mode := range modes {
+		d.checkConfig(mode, importPath)
+	}
+	if d.failures > 0 {
+		return exitf(exitConfigError, pluralize(d.failures, "%d check failed.", "%d checks failed."), d.failures)
+	}
+	return nil
+}
+
+// checkConfig loads the app in the run mode, and checks its configuration.
+func (d *doctor) checkConfig(mode, importPath string) {
+	check := "mode " + mode
+	if err := harness.InitApp(mode, importPath); err != nil {
+		d.fail(check, err.Description, "add a section for the run mode to conf/app.conf, and extend the modes defined")
+		return
+	}
+	chain, _ := harness.ModeChain(mode)
+	if len(chain) > 1 {
+		d.ok(check, "defined, extending %s", strings.Join(chain[1:], ", "))
+	} else {
+		d.ok(check, "defined")
+	}
+
+	schema, err := harness.LoadConfigSchema()
+	switch {
+	case err != nil:
+		d.fail(check, err.Description, "fix "+harness.ConfigSchemaFile)
+	case schema == nil:
+	default:
+		if err = schema.Check(); err != nil {
+			d.fail(check, err.Description, "set the key in conf/app.conf, or in the run mode's section")
+		} else {
+			d.ok(check, "the %d keys of %s are valid", len(schema.Keys), harness.ConfigSchemaFile)
+		}
+	}
+}
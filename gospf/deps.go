@@ -0,0 +1,211 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdDeps = &Command{
+	UsageLine: "deps bundle [import path] [target path] [run mode]",
+	Short:     "bundle the dependencies of a Gospf application",
+	Long: `
+Bundle copies the source of every package that the Gospf application named by
+the given import path depends on, including Gospf itself, into the target
+path.  The target path is laid out as a GOPATH workspace, so that it may be
+carried to a machine without network access, and the app built there with:
+
+    gospf build -deps-bundle <target path> [import path] [build path]
+
+Packages are copied along with the rest of their repository, e.g. the
+templates of Gospf, but without the version control metadata.  The app is
+built first, in the given run mode, to find its dependencies.  Run mode
+defaults to "prod".
+
+The target path must be new, empty or a bundle written before, which is
+replaced.  Bundle marks its bundles with a .gospf-bundle file.
+
+For example:
+
+    gospf deps bundle github.com/hubply/samples/chat /media/usb/chat-deps
+`,
+}
+
+func init() {
+	cmdDeps.Run = depsCommand
+}
+
+func depsCommand(args []string) error {
+	if len(args) == 0 || args[0] != "bundle" {
+		return exitf(exitUsage, "%s\n%s", cmdDeps.UsageLine, cmdDeps.Long)
+	}
+	return bundleDeps(args[1:])
+}
+
+// bundleMarker is the file that marks a dependency bundle, so that it may be
+// replaced by the next one.
+const bundleMarker = ".gospf-bundle"
+
+func bundleDeps(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return exitf(exitUsage, "%s\n%s", cmdDeps.UsageLine, cmdDeps.Long)
+	}
+	appImportPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+	destPath, mode := args[1], defaultMode("prod")
+	if len(args) == 3 {
+		mode = args[2]
+	}
+	if err := buildError(harness.InitApp(mode, appImportPath)); err != nil {
+		return err
+	}
+
+	if exists(destPath) && !exists(filepath.Join(destPath, bundleMarker)) {
+		isEmpty, err := empty(destPath)
+		if err != nil {
+			return err
+		}
+		if !isEmpty {
+			return exitf(exitUsage, "%s is not empty, and is not a dependency bundle.  Give a new or empty directory.", destPath)
+		}
+	}
+
+	_, reverr := harness.Build()
+	if err := buildError(reverr); err != nil {
+		return err
+	}
+
+	lines, err := harness.GoListDeps("{{.ImportPath}}\t{{.Dir}}")
+	if err != nil {
+		return errorf("Failed to list the dependencies of the app: %s", err)
+	}
+
+	roots := make(map[string]string) // Import path => directory.
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) < 2 || fields[0] == gospf.ImportPath || strings.HasPrefix(fields[0], gospf.ImportPath+"/") {
+			continue
+		}
+		importPath, dir := repoRoot(fields[0], fields[1])
+		roots[importPath] = dir
+	}
+
+	var importPaths []string
+	for importPath := range roots {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	// The bundle is marked first, so that a bundle left incomplete is replaced
+	// as well.
+	os.RemoveAll(destPath)
+	marker := "# Written by gospf deps bundle, which replaces this directory.\n"
+	err = os.MkdirAll(destPath, 0777)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(destPath, bundleMarker), []byte(marker), 0666)
+	}
+	if err != nil {
+		return wrapError(err, "Failed to create the dependency bundle")
+	}
+	var last string
+	for _, importPath := range importPaths {
+		// Skip the repositories nested in one already copied.
+		if last != "" && strings.HasPrefix(importPath, last+"/") {
+			continue
+		}
+		last = importPath
+		infof("Bundling %s", importPath)
+		if err := copyTree(filepath.Join(destPath, "src", filepath.FromSlash(importPath)), roots[importPath]); err != nil {
+			return err
+		}
+	}
+
+	resultf([]interface{}{"bundle", destPath, len(importPaths)}, "Your dependency bundle is ready: %s", destPath)
+	return nil
+}
+
+// repoRoot returns the import path and directory of the repository holding
+// the package in dir, or of the package itself if it is not in a repository.
+func repoRoot(importPath, dir string) (string, string) {
+	for d, p := dir, importPath; strings.Contains(p, "/"); d, p = filepath.Dir(d), path.Dir(p) {
+		for _, vcs := range []string{".git", ".hg", ".bzr", ".svn"} {
+			if exists(filepath.Join(d, vcs)) {
+				return p, d
+			}
+		}
+	}
+	return importPath, dir
+}
+
+// copyTree copies the files under srcDir to destDir, skipping dot files and
+// directories.
+func copyTree(destDir, srcDir string) error {
+	return filepath.Walk(srcDir, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return wrapError(err, "Failed to read "+srcPath)
+		}
+		if strings.HasPrefix(info.Name(), ".") && srcPath != srcDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(srcDir, srcPath)
+		destPath := filepath.Join(destDir, rel)
+		if info.IsDir() {
+			return wrapError(os.MkdirAll(destPath, 0777), "Failed to create directory "+destPath)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(destPath, srcPath)
+	})
+}
+
+// useDepsBundle sets up the GOPATH to build the app from the dependency
+// bundle in bundleDir only: the bundle, followed by a temporary workspace
+// linking to the app.  It returns a function to remove the workspace.
+func useDepsBundle(appImportPath, bundleDir string) (func(), error) {
+	if !exists(filepath.Join(bundleDir, "src")) {
+		return nil, exitf(exitUsage, "%s is not a dependency bundle.  Create one with \"gospf deps bundle\".", bundleDir)
+	}
+	bundleDir, err := filepath.Abs(bundleDir)
+	if err != nil {
+		return nil, wrapError(err, "Failed to find "+bundleDir)
+	}
+	pkg, err := build.Default.Import(appImportPath, "", build.FindOnly)
+	if err != nil {
+		return nil, exitf(exitConfigError, "Abort: Failed to find import path: %s", err)
+	}
+
+	workspace, err := ioutil.TempDir("", "gospf-deps")
+	if err != nil {
+		return nil, wrapError(err, "Failed to create a temporary directory")
+	}
+	cleanup := func() { os.RemoveAll(workspace) }
+	link := filepath.Join(workspace, "src", filepath.FromSlash(appImportPath))
+	if err = os.MkdirAll(filepath.Dir(link), 0777); err == nil {
+		err = os.Symlink(pkg.Dir, link)
+	}
+	if err != nil {
+		cleanup()
+		return nil, wrapError(err, "Failed to link the app into "+workspace)
+	}
+
+	gopath := bundleDir + string(filepath.ListSeparator) + workspace
+	os.Setenv("GOPATH", gopath)
+	build.Default.GOPATH = gopath
+	// Anything missing from the bundle must fail the build, rather than be
+	// fetched.
+	harness.AutoGet = harness.AutoGetOff
+	return cleanup, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdDiffRoutes = &Command{
+	UsageLine: "diff-routes -against gitref|mode [-requests file] [import path] [run mode]",
+	Short:     "compare the responses of a Gospf application to a reference",
+	Long: `
+Diff-routes builds the Gospf application named by the given import path, runs
+it next to a reference, sends the same requests to both and reports the
+responses that differ in status or body.  It is a safety net before merging a
+large refactoring.
+
+The -against flag names the reference.  If it is a run mode of the app, the
+reference is the same build run in that mode.  Otherwise it is taken as a git
+ref (a branch, tag or commit), which is checked out in a temporary worktree
+and built in the given run mode.
+
+The -requests flag names a file of requests to replay, one per line, as the
+method and path, e.g. "GET /hotels?page=2".  A line with only a path is a GET.
+Blank lines and lines starting with # are ignored.  Without it, a GET request
+is sent to every route of conf/routes that has no parameters.
+
+For example:
+
+    gospf diff-routes -against main github.com/hubply/samples/booking
+
+    gospf diff-routes -against prod -requests requests.txt github.com/hubply/samples/booking
+
+Run mode defaults to "dev".
+
+The command exits with status 6 if any response differs.
+`,
+}
+
+var (
+	diffAgainst  string
+	diffRequests string
+)
+
+func init() {
+	cmdDiffRoutes.Run = diffRoutes
+	cmdDiffRoutes.Flag.StringVar(&diffAgainst, "against", "", "git ref or run mode to compare to")
+	cmdDiffRoutes.Flag.StringVar(&diffRequests, "requests", "", "file of requests to replay")
+}
+
+// replayRequest is a request sent to both apps.
+type replayRequest struct {
+	Method, Path string
+}
+
+func diffRoutes(args []string) error {
+	if len(args) == 0 {
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help diff-routes' for usage.\n")
+	}
+	if diffAgainst == "" {
+		return exitf(exitUsage, "No reference given.  Set -against to a git ref or a run mode.")
+	}
+
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	mode := defaultMode("dev")
+	if len(args) >= 2 {
+		mode = args[1]
+	}
+	if err := buildError(harness.InitApp(mode, importPath)); err != nil {
+		return err
+	}
+
+	requests, err := loadReplayRequests()
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		return exitf(exitUsage, "No requests to replay.")
+	}
+
+	app, reverr := harness.Build()
+	if err := buildError(reverr); err != nil {
+		return err
+	}
+
+	var reference *harness.App
+	if gospf.ContainsString(harness.RunModes(), diffAgainst) {
+		reference = harness.NewApp(app.BinaryPath)
+		reference.RunMode = diffAgainst
+	} else {
+		tmpDir, err := ioutil.TempDir("", "gospf-diff-routes")
+		if err != nil {
+			return wrapError(err, "Failed to create a temporary directory")
+		}
+		defer os.RemoveAll(tmpDir)
+
+		worktree, err := checkoutReference(tmpDir, diffAgainst)
+		if worktree != "" {
+			defer exec.Command("git", "-C", gospf.BasePath, "worktree", "remove", "--force", worktree).Run()
+		}
+		if err != nil {
+			return err
+		}
+
+		if reference, err = buildReference(tmpDir, mode); err != nil {
+			return err
+		}
+	}
+
+	current, err := startApp(app)
+	if err != nil {
+		return err
+	}
+	defer current.Kill()
+	ref, err := startApp(reference)
+	if err != nil {
+		return err
+	}
+	defer ref.Kill()
+
+	infof("Comparing %d requests against %s", len(requests), diffAgainst)
+	currentUrl := fmt.Sprintf("http://127.0.0.1:%d", app.Port)
+	refUrl := fmt.Sprintf("http://127.0.0.1:%d", reference.Port)
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	differing := 0
+	for _, req := range requests {
+		status, body, err := replay(client, currentUrl, req)
+		if err != nil {
+			return errorf("Failed to request %s %s: %s", req.Method, req.Path, err)
+		}
+		refStatus, refBody, err := replay(client, refUrl, req)
+		if err != nil {
+			return errorf("Failed to request %s %s from %s: %s", req.Method, req.Path, diffAgainst, err)
+		}
+
+		switch {
+		case status != refStatus:
+			differing++
+			resultf([]interface{}{"status", req.Method, req.Path, status, refStatus},
+				"%s %s: status %d, %d against %s", req.Method, req.Path, status, refStatus, diffAgainst)
+		case !bytes.Equal(body, refBody):
+			differing++
+			line := firstDifferingLine(body, refBody)
+			resultf([]interface{}{"body", req.Method, req.Path, line},
+				"%s %s: body differs from line %d", req.Method, req.Path, line)
+		}
+	}
+
+	if differing > 0 {
+		return exitf(exitTestFailure, "%d of %d responses differ.", differing, len(requests))
+	}
+	resultf([]interface{}{"same", len(requests)}, "All %d responses are the same.", len(requests))
+	return nil
+}
+
+// loadReplayRequests returns the requests of the -requests file, or else the
+// requests generated from the routes.
+func loadReplayRequests() ([]replayRequest, error) {
+	if diffRequests == "" {
+		return routeRequests(filepath.Join(gospf.BasePath, "conf", "routes"))
+	}
+
+	file, err := os.Open(diffRequests)
+	if err != nil {
+		return nil, exitf(exitUsage, "Failed to open %s: %s", diffRequests, err)
+	}
+	defer file.Close()
+
+	var requests []replayRequest
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0 || strings.HasPrefix(fields[0], "#"):
+		case len(fields) == 1:
+			requests = append(requests, replayRequest{"GET", fields[0]})
+		default:
+			requests = append(requests, replayRequest{strings.ToUpper(fields[0]), fields[1]})
+		}
+	}
+	return requests, wrapError(scanner.Err(), "Failed to read "+diffRequests)
+}
+
+// routeRequests returns a GET request for every route of the given routes
+// file that accepts GET and has no parameters.
+func routeRequests(routesPath string) ([]replayRequest, error) {
+	file, err := os.Open(routesPath)
+	if err != nil {
+		return nil, exitf(exitConfigError, "Failed to open the routes: %s", err)
+	}
+	defer file.Close()
+
+	var requests []replayRequest
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		method, path := strings.ToUpper(fields[0]), fields[1]
+		if method != "GET" && method != "*" || strings.ContainsAny(path, ":*{") || seen[path] {
+			continue
+		}
+		seen[path] = true
+		requests = append(requests, replayRequest{"GET", path})
+	}
+	return requests, wrapError(scanner.Err(), "Failed to read "+routesPath)
+}
+
+// checkoutReference checks out the app at the given git ref in a worktree
+// under tmpDir, and links it from tmpDir/src at its import path, so that
+// tmpDir may be put first in the GOPATH to build it.  It returns the path of
+// the worktree.
+func checkoutReference(tmpDir, ref string) (string, error) {
+	git := func(args ...string) (string, error) {
+		out, err := exec.Command("git", append([]string{"-C", gospf.BasePath}, args...)...).CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	if _, err := git("rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return "", exitf(exitUsage, "%s is neither a run mode of the app nor a git ref.", ref)
+	}
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", errorf("Failed to find the git repository of %s: %s", gospf.BasePath, top)
+	}
+	prefix, err := filepath.Rel(top, gospf.BasePath)
+	if err != nil {
+		return "", wrapError(err, "Failed to find the app in its git repository")
+	}
+
+	worktree := filepath.Join(tmpDir, "worktree")
+	if out, err := git("worktree", "add", "--detach", worktree, ref); err != nil {
+		return "", errorf("Failed to check out %s: %s", ref, out)
+	}
+
+	link := filepath.Join(tmpDir, "src", filepath.FromSlash(gospf.ImportPath))
+	if err := os.MkdirAll(filepath.Dir(link), 0777); err != nil {
+		return worktree, wrapError(err, "Failed to create directory "+filepath.Dir(link))
+	}
+	return worktree, wrapError(os.Symlink(filepath.Join(worktree, prefix), link), "Failed to link "+link)
+}
+
+// buildReference builds the app checked out under tmpDir with "gospf build",
+// and returns it, to be run from its build directory.
+func buildReference(tmpDir, mode string) (*harness.App, error) {
+	buildDir, binDir := filepath.Join(tmpDir, "build"), filepath.Join(tmpDir, "bin")
+	cmd := exec.Command(os.Args[0], "build",
+		"-o", filepath.Join(binDir, "reference"), gospf.ImportPath, buildDir, mode)
+	cmd.Env = append(os.Environ(), "GOPATH="+tmpDir+string(filepath.ListSeparator)+build.Default.GOPATH)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	infof("Building %s", diffAgainst)
+	if err := cmd.Run(); err != nil {
+		return nil, exitf(exitCompileError, "Failed to build %s: %s", diffAgainst, err)
+	}
+
+	// The binary name may have a run mode or .exe suffix.
+	infos, err := ioutil.ReadDir(binDir)
+	if err != nil || len(infos) != 1 {
+		return nil, errorf("Failed to find the binary built for %s.", diffAgainst)
+	}
+	reference := harness.NewApp(filepath.Join(binDir, infos[0].Name()))
+	reference.SrcPath = filepath.Join(buildDir, "src")
+	return reference, nil
+}
+
+// startApp runs the app on a free port, and waits until it is ready.
+func startApp(app *harness.App) (harness.AppCmd, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return harness.AppCmd{}, wrapError(err, "Failed to find a free port")
+	}
+	app.Port = listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cmd := app.Cmd()
+	if err := cmd.Start(); err != nil {
+		return cmd, errorf("%s", err)
+	}
+	return cmd, nil
+}
+
+// replay sends the request to the app at the given URL, and returns the
+// status and body of its response.
+func replay(client *http.Client, baseUrl string, r replayRequest) (int, []byte, error) {
+	req, err := http.NewRequest(r.Method, baseUrl+r.Path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// firstDifferingLine returns the number of the first line that differs
+// between the two bodies, counting from 1.
+func firstDifferingLine(a, b []byte) int {
+	linesA, linesB := bytes.Split(a, []byte("\n")), bytes.Split(b, []byte("\n"))
+	for i := range linesA {
+		if i >= len(linesB) || !bytes.Equal(linesA[i], linesB[i]) {
+			return i + 1
+		}
+	}
+	return len(linesA) + 1
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdDoctor = &Command{
+	UsageLine: "doctor [import path] [run mode]",
+	Short:     "check the environment and a Gospf application for problems",
+	Long: `
+Doctor checks that the environment can build the Gospf application named by
+the given import path, and that the application is consistent.  It reports
+each check as ok, warn or fail, with a suggested fix for the problems found.
+
+The checks are:
+
+    - the go toolchain is on the PATH, at the version of devenv.go if set
+    - the import path resolves to a directory under the GOPATH
+    - conf/app.conf is well-formed
+    - the run mode, and those it extends, are defined in conf/app.conf
+    - conf/routes is well-formed
+    - the actions of the routes exist in the app's controllers
+    - the validation keys belong to actions that a route refers to
+
+For example:
+
+    gospf doctor github.com/hubply/samples/booking
+
+Run mode defaults to "dev".
+
+The command exits with status 3 if any check fails.
+`,
+}
+
+func init() {
+	cmdDoctor.Run = runDoctor
+}
+
+// doctor reports the outcome of its checks.
+type doctor struct {
+	failures int
+}
+
+func (d *doctor) ok(check, format string, args ...interface{}) {
+	d.report("ok", check, fmt.Sprintf(format, args...), "")
+}
+
+func (d *doctor) warn(check, message, fix string) {
+	d.report("warn", check, message, fix)
+}
+
+func (d *doctor) fail(check, message, fix string) {
+	d.failures++
+	d.report("fail", check, message, fix)
+}
+
+func (d *doctor) report(status, check, message, fix string) {
+	resultf([]interface{}{"doctor", status, check, message, fix}, "[%-4s] %s: %s", status, check, message)
+	if fix != "" && !porcelainOutput {
+		printf("       fix: %s", fix)
+	}
+}
+
+func runDoctor(args []string) error {
+	if len(args) == 0 {
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help doctor' for usage.\n")
+	}
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+	mode := defaultMode("dev")
+	if len(args) >= 2 {
+		mode = args[1]
+	}
+
+	d := &doctor{}
+	d.checkToolchain()
+	if basePath := d.checkImportPath(importPath); basePath != "" {
+		if d.checkAppConf(filepath.Join(basePath, "conf", "app.conf")) {
+			d.checkRunMode(mode, importPath)
+			d.checkDevenvGo()
+			lines := d.checkRoutes(filepath.Join(gospf.BasePath, "conf", "routes"))
+			d.checkActions(lines)
+		}
+	}
+
+	if d.failures > 0 {
+		return exitf(exitConfigError, pluralize(d.failures, "%d check failed.", "%d checks failed."), d.failures)
+	}
+	return nil
+}
+
+func (d *doctor) checkToolchain() {
+	goPath, err := exec.LookPath("go")
+	if err != nil {
+		d.fail("go toolchain", "go is not on the PATH", "install Go from https://golang.org/dl/ and add its bin directory to the PATH")
+		return
+	}
+	out, err := exec.Command(goPath, "version").Output()
+	if err != nil {
+		d.fail("go toolchain", fmt.Sprintf("%s version failed: %s", goPath, err), "reinstall Go")
+		return
+	}
+	d.ok("go toolchain", "%s", strings.TrimSpace(string(out)))
+}
+
+// checkDevenvGo checks the version of the toolchain against devenv.go, the
+// version that "gospf env export" requires.
+func (d *doctor) checkDevenvGo() {
+	required := gospf.Config.StringDefault("devenv.go", "")
+	if required == "" {
+		return
+	}
+	if local := localGoVersion(); local != required {
+		d.warn("go version", fmt.Sprintf("the app requires go %s (devenv.go), but gospf was built with go %s", required, local),
+			"install go "+required+", or update devenv.go in conf/app.conf")
+		return
+	}
+	d.ok("go version", "go %s, as required by devenv.go", required)
+}
+
+// checkImportPath returns the directory of the app, or "" if the import path
+// does not resolve.
+func (d *doctor) checkImportPath(importPath string) string {
+	pkg, err := build.Default.Import(importPath, "", build.FindOnly)
+	if err != nil {
+		d.fail("import path", fmt.Sprintf("%s is not found in the GOPATH", importPath),
+			"move the app to $GOPATH/src/"+importPath+", give the path of its directory instead, or check the spelling of the import path")
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(pkg.Dir, "app")); err != nil {
+		d.fail("import path", fmt.Sprintf("%s has no app directory", pkg.Dir),
+			"check that the import path names a Gospf app, not one of its packages")
+		return ""
+	}
+	d.ok("import path", "%s", pkg.Dir)
+	return pkg.Dir
+}
+
+// confLine matches a well-formed line of app.conf: a section, an option, a
+// comment or a blank line.
+var confLine = regexp.MustCompile(`^\s*(\[[^\]]+\]|[^=:\s#;][^=:]*[=:].*|[#;].*)?\s*$`)
+
+// checkAppConf reports whether app.conf is well-formed.
+func (d *doctor) checkAppConf(confPath string) bool {
+	lines, err := readLines(confPath)
+	if err != nil {
+		d.fail("app.conf", err.Error(), "create conf/app.conf, e.g. by copying it from a new app made by \"gospf new\"")
+		return false
+	}
+	for i, line := range lines {
+		if !confLine.MatchString(line) {
+			d.fail("app.conf", fmt.Sprintf("line %d is not a section, an option or a comment: %q", i+1, line),
+				"write options as \"key = value\", and sections as \"[name]\"")
+			return false
+		}
+	}
+	d.ok("app.conf", "%s", confPath)
+	return true
+}
+
+// checkRunMode loads the app in the run mode, which must be defined in
+// app.conf, as must the modes it extends.
+func (d *doctor) checkRunMode(mode, importPath string) {
+	if err := harness.InitApp(mode, importPath); err != nil {
+		d.fail("run mode", err.Description, "add a section for the run mode to conf/app.conf, or run in one of those defined")
+		return
+	}
+	d.ok("run mode", "%s", mode)
+}
+
+var routeMethods = []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS", "WS", "*"}
+
+// checkRoutes returns the lines of the routes file, and checks that its
+// routes are well-formed.
+func (d *doctor) checkRoutes(routesPath string) []string {
+	lines, err := readLines(routesPath)
+	if err != nil {
+		d.fail("routes", err.Error(), "create conf/routes, e.g. by copying it from a new app made by \"gospf new\"")
+		return nil
+	}
+
+	routes, problems := 0, 0
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "module:") {
+			continue
+		}
+		problem := ""
+		switch {
+		case len(fields) < 3:
+			problem = "expected a method, a path and an action"
+		case !gospf.ContainsString(routeMethods, strings.ToUpper(fields[0])):
+			problem = fmt.Sprintf("unknown method %s", fields[0])
+		case !strings.HasPrefix(fields[1], "/"):
+			problem = fmt.Sprintf("the path %s does not start with /", fields[1])
+		}
+		if problem != "" {
+			problems++
+			d.fail("routes", fmt.Sprintf("line %d: %s", i+1, problem),
+				`write routes as "METHOD /path Controller.Action", e.g. "GET / App.Index"`)
+			continue
+		}
+		routes++
+	}
+	if problems == 0 {
+		d.ok("routes", "%d routes", routes)
+	}
+	return lines
+}
+
+// validationFunc matches the function of a validation key, e.g.
+// "myapp/app/controllers.(*Application).Index", capturing the controller
+// and action.
+var validationFunc = regexp.MustCompile(`\.\(?\*?(\w+)\)?\.(\w+)$`)
+
+// checkActions checks that the routes refer to actions of the app's
+// controllers, as the build does, and that the validation keys belong to
+// actions with routes.
+func (d *doctor) checkActions(lines []string) {
+	sourceInfo, err := harness.ProcessSource(harness.SourcePaths())
+	if err != nil {
+		d.fail("actions", fmt.Sprintf("%s: %s", err.Title, err.Description), "fix the error, or run \"gospf run\" for details")
+		return
+	}
+
+	controllers := sourceInfo.ControllerSpecs()
+	actions := make(map[string]bool)
+	for _, spec := range controllers {
+		for _, method := range spec.MethodSpecs {
+			actions[spec.StructName+"."+method.Name] = true
+		}
+	}
+	var moduleImportPaths []string
+	for _, module := range gospf.Modules {
+		moduleImportPaths = append(moduleImportPaths, module.ImportPath)
+	}
+
+	findings, routed := harness.MatchRoutes(lines, controllers, moduleImportPaths)
+	missing := 0
+	for _, finding := range findings {
+		// The actions without a route are left to the validation keys.
+		if finding.Path != "" {
+			continue
+		}
+		message := fmt.Sprintf("line %d of conf/routes: %s", finding.Line, finding.Message)
+		if !finding.Strict {
+			d.warn("actions", message, "route to the action that replaces it")
+			continue
+		}
+		missing++
+		d.fail("actions", message, "add the action to the controller, or fix the route")
+	}
+	if missing == 0 {
+		d.ok("actions", "%d actions", len(actions))
+	}
+
+	// Without the routed actions, a route refers to all of them.
+	if routed == nil {
+		return
+	}
+	unused := 0
+	for key := range sourceInfo.ValidationKeys {
+		match := validationFunc.FindStringSubmatch(key)
+		if match == nil {
+			continue
+		}
+		action := match[1] + "." + match[2]
+		if actions[action] && !routed[action] {
+			unused++
+			d.warn("validation keys", fmt.Sprintf("%s validates parameters, but no route refers to it", action),
+				"add a route to the action, or remove the unused action")
+		}
+	}
+	if unused == 0 {
+		d.ok("validation keys", "%d validated functions", len(sourceInfo.ValidationKeys))
+	}
+}
+
+func readLines(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
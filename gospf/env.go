@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdEnv = &Command{
+	UsageLine: "env export [-format nix|devcontainer] [import path] [run mode]",
+	Short:     "export a reproducible development environment",
+	Long: `
+Export writes a description of the environment needed to build the Gospf
+application named by the given import path.  It is generated from the app's
+configuration, so that a new checkout is ready to run.
+
+The format may be "nix", which writes shell.nix to the app directory, or
+"devcontainer", which writes .devcontainer/devcontainer.json.
+
+The following app.conf keys are used:
+
+    devenv.go        Go version to require (defaults to the local toolchain)
+    devenv.protoc    protoc version to include, if any ("true" for any version)
+    devenv.node      Node.js major version to include, if any
+    devenv.env.NAME  environment variable NAME to set
+
+The keys are read from the section of the run mode and the DEFAULT one, not
+from those of the other modes.  The build tags of the run mode are exported
+through GOFLAGS.
+
+For example:
+
+    gospf env export -format devcontainer github.com/hubply/samples/chat
+`,
+}
+
+var envFormat string
+
+func init() {
+	cmdEnv.Run = envCommand
+	cmdEnv.Flag.StringVar(&envFormat, "format", "nix", "nix or devcontainer")
+}
+
+// devEnv describes the tools and variables an app needs to be developed.
+type devEnv struct {
+	AppName    string
+	ImportPath string
+	GoVersion  string // e.g. "1.21"
+	Protoc     string // e.g. "3.21.12", "true" for any version, or "" for none
+	NodeMajor  string // e.g. "18", or "" for none
+	EnvVars    map[string]string
+	EnvVarKeys []string // Sorted keys of EnvVars
+}
+
+func envCommand(args []string) error {
+	if len(args) < 2 || args[0] != "export" {
+		return exitf(exitUsage, "Usage: gospf %s\nRun 'gospf help env' for usage.\n", cmdEnv.UsageLine)
+	}
+
+	mode := defaultMode("dev")
+	if len(args) >= 3 {
+		mode = args[2]
+	}
+	importPath, err := resolveAppPath(args[1])
+	if err != nil {
+		return err
+	}
+	if err := buildError(harness.InitApp(mode, importPath)); err != nil {
+		return err
+	}
+
+	env := loadDevEnv()
+	switch envFormat {
+	case "nix":
+		destPath := filepath.Join(gospf.BasePath, "shell.nix")
+		f, err := os.Create(destPath)
+		if err != nil {
+			return wrapError(err, "Failed to create "+destPath)
+		}
+		tmpl(f, shellNixTemplate, env)
+		if err = f.Close(); err != nil {
+			return wrapError(err, "Failed to close "+destPath)
+		}
+		resultf([]interface{}{"environment", destPath}, "Your environment is ready: %s", destPath)
+
+	case "devcontainer":
+		destDir := filepath.Join(gospf.BasePath, ".devcontainer")
+		if err := os.MkdirAll(destDir, 0777); err != nil {
+			return wrapError(err, "Failed to create directory "+destDir)
+		}
+		destPath := filepath.Join(destDir, "devcontainer.json")
+		if err := writeJSON(destPath, env.devcontainer()); err != nil {
+			return err
+		}
+		resultf([]interface{}{"environment", destPath}, "Your environment is ready: %s", destPath)
+
+	default:
+		return exitf(exitUsage, "Unknown environment format %q.  Use nix or devcontainer.", envFormat)
+	}
+	return nil
+}
+
+func loadDevEnv() *devEnv {
+	env := &devEnv{
+		AppName:    gospf.AppName,
+		ImportPath: gospf.ImportPath,
+		GoVersion:  gospf.Config.StringDefault("devenv.go", localGoVersion()),
+		Protoc:     gospf.Config.StringDefault("devenv.protoc", ""),
+		NodeMajor:  gospf.Config.StringDefault("devenv.node", ""),
+		EnvVars:    make(map[string]string),
+	}
+	if env.Protoc == "false" {
+		env.Protoc = ""
+	}
+
+	for _, key := range configKeys("devenv.env.") {
+		env.EnvVars[key[len("devenv.env."):]] = gospf.Config.StringDefault(key, "")
+	}
+	env.EnvVars["GOFLAGS"] = "-tags=" + harness.BuildTags()
+	for key := range env.EnvVars {
+		env.EnvVarKeys = append(env.EnvVarKeys, key)
+	}
+	sort.Strings(env.EnvVarKeys)
+	return env
+}
+
+// localGoVersion returns the major.minor version of the Go toolchain that
+// built this command, e.g. "1.21".
+func localGoVersion() string {
+	version := strings.TrimPrefix(runtime.Version(), "go")
+	if parts := strings.SplitN(version, ".", 3); len(parts) >= 2 {
+		return parts[0] + "." + parts[1]
+	}
+	return version
+}
+
+// NixGoAttr returns the nixpkgs attribute providing the required Go version.
+func (env *devEnv) NixGoAttr() string {
+	return "go_" + strings.Replace(env.GoVersion, ".", "_", -1)
+}
+
+func (env *devEnv) devcontainer() map[string]interface{} {
+	features := map[string]interface{}{}
+	if env.NodeMajor != "" {
+		features["ghcr.io/devcontainers/features/node:1"] = map[string]string{"version": env.NodeMajor}
+	}
+	if env.Protoc != "" {
+		version := env.Protoc
+		if version == "true" {
+			version = "latest"
+		}
+		features["ghcr.io/devcontainers-contrib/features/protoc:1"] = map[string]string{"version": version}
+	}
+
+	return map[string]interface{}{
+		"name":              env.AppName,
+		"image":             "mcr.microsoft.com/devcontainers/go:1-" + env.GoVersion,
+		"features":          features,
+		"containerEnv":      env.EnvVars,
+		"postCreateCommand": "go get " + env.ImportPath + " github.com/hubply/cmd/gospf",
+	}
+}
+
+func writeJSON(destPath string, data interface{}) error {
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return wrapError(err, "Failed to encode "+destPath)
+	}
+
+	err = ioutil.WriteFile(destPath, append(encoded, '\n'), 0666)
+	return wrapError(err, "Failed to write "+destPath)
+}
+
+const shellNixTemplate = `# Generated by "gospf env export".
+{ pkgs ? import <nixpkgs> {} }:
+
+pkgs.mkShell {
+  name = "{{.AppName}}";
+  buildInputs = [
+    pkgs.{{.NixGoAttr}}{{if .Protoc}}
+    pkgs.protobuf{{end}}{{if .NodeMajor}}
+    pkgs.nodejs_{{.NodeMajor}}{{end}}
+  ];
+  shellHook = ''{{range .EnvVarKeys}}
+    export {{.}}={{index $.EnvVars . | printf "%q"}}{{end}}
+  '';
+}
+`
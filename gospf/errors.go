@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+// Exit codes of the gospf command.  Scripts may rely on these to tell the
+// kinds of failure apart.
+const (
+	exitFailure      = 1 // A runtime or packaging failure.
+	exitUsage        = 2 // The command line arguments are invalid.
+	exitConfigError  = 3 // The app or its configuration could not be found or loaded.
+	exitCodegenError = 4 // Generating code for the app failed.
+	exitCompileError = 5 // The app failed to compile.
+	exitTestFailure  = 6 // One or more tests failed.
+)
+
+// CommandError aborts a command.  Commands return it from Run, and main
+// prints it and exits with its ExitCode.
+type CommandError struct {
+	Message  string
+	Err      error // The underlying error, if any.
+	ExitCode int
+	Stack    []byte // Where the error was created.  Printed with -debug.
+}
+
+func (e *CommandError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+// errorf returns an error that aborts the command as a runtime failure.
+func errorf(format string, args ...interface{}) error {
+	return exitf(exitFailure, format, args...)
+}
+
+// exitf returns an error that aborts the command with the given exit code.
+func exitf(code int, format string, args ...interface{}) error {
+	return &CommandError{
+		Message:  fmt.Sprintf(T(format), args...),
+		ExitCode: code,
+		Stack:    debug.Stack(),
+	}
+}
+
+// wrapError returns an error that aborts the command as a runtime failure if
+// err is set, and nil otherwise.
+func wrapError(err error, msg string) error {
+	if gospfErr, ok := err.(*gospf.Error); (ok && gospfErr == nil) || err == nil {
+		return nil
+	}
+	return &CommandError{
+		Message:  msg,
+		Err:      err,
+		ExitCode: exitFailure,
+		Stack:    debug.Stack(),
+	}
+}
+
+// buildError returns an error that aborts the command if the app failed to
+// build, with the exit code for the kind of failure.
+func buildError(err *gospf.Error) error {
+	if err == nil {
+		return nil
+	}
+	if harness.IsConfigError(err) {
+		return exitf(exitConfigError, "Invalid configuration: %s", err)
+	}
+	if harness.IsCodeGenerationError(err) {
+		return exitf(exitCodegenError, "Failed to generate code: %s", err)
+	}
+	return exitf(exitCompileError, "Failed to build: %s", err)
+}
+
+// exitWithError prints the error that aborted a command, and exits.
+func exitWithError(err error) {
+	cmdErr, ok := err.(*CommandError)
+	if !ok {
+		cmdErr = &CommandError{Message: err.Error(), ExitCode: exitFailure}
+	}
+
+	message := cmdErr.Message
+	if cmdErr.Err != nil {
+		message = fmt.Sprintf(T("Abort: %s: %s\n"), cmdErr.Message, cmdErr.Err)
+	}
+	// Ensure the user's command prompt starts on the next line.
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	fmt.Fprint(os.Stderr, message)
+	if debugOutput && cmdErr.Stack != nil {
+		fmt.Fprintf(os.Stderr, "\n%s", cmdErr.Stack)
+	}
+	os.Exit(cmdErr.ExitCode)
+}
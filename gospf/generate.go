@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdGenerate = &Command{
+	UsageLine: "generate config [import path] | job [name] [import path]",
+	Short:     "generate code for a Gospf application",
+	Long: `
+Generate writes code for the Gospf application named by the given import path.
+
+"generate config" reads the config schema in conf/schema.yml and writes
+app/config/config.go, with a Config struct holding a field for each key of
+the schema, and a Load function to read it from app.conf.  For example,
+this schema:
+
+    db.host:
+      type: string
+      required: true
+      doc: Host name of the database server.
+    db.pool:
+      type: int
+      default: 10
+
+results in a Config with the fields DbHost string and DbPool int.  The types
+are string, int, float, bool and duration.
+
+When an app has a schema, building it checks that app.conf sets the required
+keys, and that the values are of the declared types.
+
+"generate job" writes a job with the given name to app/jobs, to be run by
+"gospf worker".
+
+For example:
+
+    gospf generate config github.com/hubply/samples/booking
+
+    gospf generate job SendNewsletter github.com/hubply/samples/booking
+`,
+}
+
+// generators maps the kinds of code that may be generated to the function
+// generating them, which is passed the arguments following the kind.
+var generators = map[string]func(args []string) error{
+	"config": generateConfig,
+	"job":    generateJob,
+}
+
+func init() {
+	cmdGenerate.Run = generateCode
+}
+
+func generateCode(args []string) error {
+	if len(args) == 0 {
+		return exitf(exitUsage, "No kind of code given.\nRun 'gospf help generate' for usage.\n")
+	}
+	generator, ok := generators[args[0]]
+	if !ok {
+		return exitf(exitUsage, "Unknown kind of code %q.  Use %s.", args[0], strings.Join(generatorNames(), ", "))
+	}
+	return generator(args[1:])
+}
+
+func generatorNames() []string {
+	var names []string
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func generateConfig(args []string) error {
+	if len(args) == 0 {
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help generate' for usage.\n")
+	}
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+	gospf.Init("dev", importPath, "")
+
+	schema, err := harness.LoadConfigSchema()
+	if err != nil {
+		return exitf(exitConfigError, "Failed to load the config schema: %s", err)
+	}
+	if schema == nil {
+		return exitf(exitConfigError, "%s not found.", filepath.Join(gospf.BasePath, harness.ConfigSchemaFile))
+	}
+
+	destPath := filepath.Join(gospf.AppPath, "config", "config.go")
+	if err := writeGoSource(destPath, configTemplate, schema); err != nil {
+		return err
+	}
+	resultf([]interface{}{"generated", destPath}, "Generated %s", destPath)
+	return nil
+}
+
+func generateJob(args []string) error {
+	if len(args) < 2 {
+		return exitf(exitUsage, "No job name or import path given.\nRun 'gospf help generate' for usage.\n")
+	}
+	name := args[0]
+	if !token.IsIdentifier(name) || !token.IsExported(name) {
+		return exitf(exitUsage, "Invalid job name %q.  It must be an exported Go identifier.", name)
+	}
+	importPath, err := resolveAppPath(args[1])
+	if err != nil {
+		return err
+	}
+	gospf.Init("dev", importPath, "")
+
+	destPath := filepath.Join(gospf.AppPath, "jobs", snakeCase(name)+".go")
+	if exists(destPath) {
+		return errorf("Abort: %s already exists.", destPath)
+	}
+	if err := writeGoSource(destPath, jobTemplate, map[string]string{"Name": name}); err != nil {
+		return err
+	}
+	resultf([]interface{}{"generated", destPath}, "Generated %s", destPath)
+	return nil
+}
+
+// snakeCase returns the name with its words separated by underscores, e.g.
+// "send_newsletter" for "SendNewsletter", and "sync_http_log" for
+// "SyncHTTPLog".
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var buf bytes.Buffer
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			buf.WriteByte('_')
+		}
+		buf.WriteRune(unicode.ToLower(r))
+	}
+	return buf.String()
+}
+
+// writeGoSource renders the template to the given Go source file, creating
+// its directory if necessary.
+func writeGoSource(destPath, text string, data interface{}) error {
+	var buf bytes.Buffer
+	tmpl(&buf, text, data)
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return exitf(exitCodegenError, "Failed to generate %s: %s", destPath, err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(destPath), 0777); err != nil {
+		return wrapError(err, "Failed to create directory "+filepath.Dir(destPath))
+	}
+	return wrapError(ioutil.WriteFile(destPath, src, 0666), "Failed to write "+destPath)
+}
+
+const jobTemplate = `package jobs
+
+import "github.com/gospf/gospf"
+
+// {{.Name}} is run by "gospf worker".
+type {{.Name}} struct{}
+
+// Run does the job.  It is called in its own goroutine when the worker
+// starts, and the worker exits once the Run of every job has returned.
+func (j *{{.Name}}) Run() {
+	gospf.INFO.Println("Running {{.Name}}")
+}
+`
+
+const configTemplate = `// GENERATED CODE - DO NOT EDIT
+// Generated by "gospf generate config" from conf/schema.yml.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gospf/gospf"
+)
+
+// Config holds the app's configuration.
+type Config struct {
+{{range .Keys}}{{if .Doc}}	// {{.Doc}}
+{{end}}	{{.Field}} {{.GoType}} // {{.Name}}
+{{end}}}
+
+// Load reads the configuration of the current run mode.  It returns an error
+// if a required key is not set, or if a value is not of the declared type.
+func Load() (*Config, error) {
+	var l loader
+	c := &Config{ {{range .Keys}}
+		{{.Field}}: l.{{.Type}}({{printf "%q" .Name}}, {{printf "%q" .Default}}, {{.Required}}),{{end}}
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	return c, nil
+}
+
+// loader reads config values, keeping the first error.
+type loader struct {
+	err error
+}
+
+func (l *loader) value(key, defaultValue string, required bool) (string, bool) {
+	value, found := gospf.Config.String(key)
+	if !found {
+		if required && l.err == nil {
+			l.err = fmt.Errorf("config: %s is required", key)
+		}
+		value = defaultValue
+	}
+	return value, value != ""
+}
+
+func (l *loader) fail(key string, err error) {
+	if l.err == nil {
+		l.err = fmt.Errorf("config: %s: %s", key, err)
+	}
+}
+
+func (l *loader) string(key, defaultValue string, required bool) string {
+	value, _ := l.value(key, defaultValue, required)
+	return value
+}
+
+func (l *loader) int(key, defaultValue string, required bool) int {
+	value, ok := l.value(key, defaultValue, required)
+	if !ok {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		l.fail(key, err)
+	}
+	return i
+}
+
+func (l *loader) float(key, defaultValue string, required bool) float64 {
+	value, ok := l.value(key, defaultValue, required)
+	if !ok {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.fail(key, err)
+	}
+	return f
+}
+
+func (l *loader) bool(key, defaultValue string, required bool) bool {
+	value, ok := l.value(key, defaultValue, required)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.fail(key, err)
+	}
+	return b
+}
+
+func (l *loader) duration(key, defaultValue string, required bool) time.Duration {
+	value, ok := l.value(key, defaultValue, required)
+	if !ok {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		l.fail(key, err)
+	}
+	return d
+}
+`
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/hubply/cmd/harness"
+)
+
+var cmdInspect = &Command{
+	UsageLine: "inspect [-o file] [import path] [run mode]",
+	Short:     "print the structure of a Gospf application as JSON",
+	Long: `
+Inspect processes the source of the Gospf application named by the given
+import path, as a build does, and prints its structure as JSON, for the tools
+that read it, e.g. the autocompletion of an editor or a documentation
+generator.  Nothing is built.  It holds:
+
+    controllers     the controllers, with their actions and interceptors: the
+                    file and line of each action, its arguments and their
+                    types, the names it renders and its deprecation notice
+    interceptors    the interceptor functions, which intercept all the
+                    controllers
+    validationKeys  the names of the values validated, by function and line
+    testSuites      the test suites
+    jobs            the jobs
+
+The paths of the files are relative to the app's root when under it.
+
+For example:
+
+    gospf inspect github.com/hubply/samples/booking > booking.json
+
+The -o flag writes the JSON to the given file instead of the standard output.
+
+Run mode defaults to "dev".
+`,
+}
+
+var inspectOutput string
+
+func init() {
+	cmdInspect.Run = inspectApp
+	cmdInspect.Flag.StringVar(&inspectOutput, "o", "", "path of the JSON file")
+}
+
+func inspectApp(args []string) error {
+	if len(args) == 0 {
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help inspect' for usage.\n")
+	}
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+	mode := defaultMode("dev")
+	if len(args) >= 2 {
+		mode = args[1]
+	}
+	if err := buildError(harness.InitApp(mode, importPath)); err != nil {
+		return err
+	}
+
+	sourceInfo, compileError := harness.ProcessSource(harness.SourcePaths())
+	if err := buildError(compileError); err != nil {
+		return err
+	}
+	description := harness.DescribeSource(sourceInfo)
+
+	if inspectOutput != "" {
+		if err := writeJSON(inspectOutput, description); err != nil {
+			return err
+		}
+		resultf([]interface{}{"inspect", inspectOutput}, "The structure of the app is in %s", inspectOutput)
+		return nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return wrapError(encoder.Encode(description), "Failed to write the structure of the app")
+}
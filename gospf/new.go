@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"go/build"
 	"math/rand"
 	"os"
@@ -13,7 +12,7 @@ import (
 )
 
 var cmdNew = &Command{
-	UsageLine: "new [path] [skeleton]",
+	UsageLine: "new [-devcontainer] [-vscode] [path] [skeleton]",
 	Short:     "create a skeleton Gospf application",
 	Long: `
 New creates a few files to get a new Gospf application running quickly.
@@ -28,11 +27,18 @@ For example:
     gospf new import/path/helloworld
 
     gospf new import/path/helloworld import/path/skeleton
+
+The -devcontainer flag adds a .devcontainer configuration for the app.
+
+The -vscode flag adds editor tasks for "gospf run", "gospf test" and
+"gospf build", and a launch configuration that debugs the built app.
 `,
 }
 
 func init() {
 	cmdNew.Run = newApp
+	cmdNew.Flag.BoolVar(&newDevcontainer, "devcontainer", false, "generate a .devcontainer configuration")
+	cmdNew.Flag.BoolVar(&newVSCode, "vscode", false, "generate .vscode tasks and launch configurations")
 }
 
 var (
@@ -49,32 +55,50 @@ var (
 	basePath     string
 	importPath   string
 	skeletonPath string
+
+	// editor and environment scaffolding
+	newDevcontainer bool
+	newVSCode       bool
 )
 
-func newApp(args []string) {
+func newApp(args []string) error {
 	// check for proper args by count
 	if len(args) == 0 {
-		errorf("No import path given.\nRun 'gospf help new' for usage.\n")
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help new' for usage.\n")
 	}
 	if len(args) > 2 {
-		errorf("Too many arguments provided.\nRun 'gospf help new' for usage.\n")
+		return exitf(exitUsage, "Too many arguments provided.\nRun 'gospf help new' for usage.\n")
 	}
 
-	// checking and setting go paths
-	initGoPaths()
-
-	// checking and setting application
-	setApplicationPath(args)
+	steps := []func() error{
+		// checking and setting go paths
+		initGoPaths,
+		// checking and setting application
+		func() error { return setApplicationPath(args) },
+		// checking and setting skeleton
+		func() error { return setSkeletonPath(args) },
+		// copy files to new app directory
+		copyNewAppFiles,
+	}
 
-	// checking and setting skeleton
-	setSkeletonPath(args)
+	// add editor and environment settings, if requested
+	if newDevcontainer {
+		steps = append(steps, writeDevcontainer)
+	}
+	if newVSCode {
+		steps = append(steps, writeVSCodeSettings)
+	}
 
-	// copy files to new app directory
-	copyNewAppFiles()
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
 
 	// goodbye world
-	fmt.Fprintln(os.Stdout, "Your application is ready:\n  ", appPath)
-	fmt.Fprintln(os.Stdout, "\nYou can run it with:\n   revel run", importPath)
+	resultf([]interface{}{"app", appPath}, "Your application is ready:\n   %s", appPath)
+	infof("\nYou can run it with:\n   gospf run %s", importPath)
+	return nil
 }
 
 const alphaNumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
@@ -88,11 +112,11 @@ func generateSecret() string {
 }
 
 // lookup and set Go related variables
-func initGoPaths() {
+func initGoPaths() error {
 	// lookup go path
 	gopath = build.Default.GOPATH
 	if gopath == "" {
-		errorf("Abort: GOPATH environment variable is not set. " +
+		return exitf(exitConfigError, "Abort: GOPATH environment variable is not set. "+
 			"Please refer to http://golang.org/doc/code.html to configure your Go environment.")
 	}
 
@@ -103,27 +127,27 @@ func initGoPaths() {
 	var err error
 	gocmd, err = exec.LookPath("go")
 	if err != nil {
-		errorf("Go executable not found in PATH.")
+		return exitf(exitConfigError, "Go executable not found in PATH.")
 	}
-
+	return nil
 }
 
-func setApplicationPath(args []string) {
+func setApplicationPath(args []string) error {
 	var err error
 	importPath = args[0]
 	if filepath.IsAbs(importPath) {
-		errorf("Abort: '%s' looks like a directory.  Please provide a Go import path instead.",
+		return exitf(exitUsage, "Abort: '%s' looks like a directory.  Please provide a Go import path instead.",
 			importPath)
 	}
 
 	_, err = build.Import(importPath, "", build.FindOnly)
 	if err == nil {
-		errorf("Abort: Import path %s already exists.\n", importPath)
+		return errorf("Abort: Import path %s already exists.\n", importPath)
 	}
 
 	gospfPkg, err = build.Import(gospf.GOSPF_IMPORT_PATH, "", build.FindOnly)
 	if err != nil {
-		errorf("Abort: Could not find gospf source code: %s\n", err)
+		return exitf(exitConfigError, "Abort: Could not find gospf source code: %s\n", err)
 	}
 
 	appPath = filepath.Join(srcRoot, filepath.FromSlash(importPath))
@@ -139,9 +163,10 @@ func setApplicationPath(args []string) {
 		// is a subdirectory such as $GOROOT/src/path/to/revelapp
 		basePath += "/"
 	}
+	return nil
 }
 
-func setSkeletonPath(args []string) {
+func setSkeletonPath(args []string) error {
 	var err error
 	if len(args) == 2 { // user specified
 		skeletonName := args[1]
@@ -149,13 +174,13 @@ func setSkeletonPath(args []string) {
 		if err != nil {
 			// Execute "go get <pkg>"
 			getCmd := exec.Command(gocmd, "get", "-d", skeletonName)
-			fmt.Println("Exec:", getCmd.Args)
+			infof("Exec: %s", getCmd.Args)
 			getOutput, err := getCmd.CombinedOutput()
 
 			// check getOutput for no buildible string
 			bpos := bytes.Index(getOutput, []byte("no buildable Go source files in"))
 			if err != nil && bpos == -1 {
-				errorf("Abort: Could not find or 'go get' Skeleton  source code: %s\n%s\n", getOutput, skeletonName)
+				return errorf("Abort: Could not find or 'go get' Skeleton  source code: %s\n%s\n", getOutput, skeletonName)
 			}
 		}
 		// use the
@@ -163,24 +188,93 @@ func setSkeletonPath(args []string) {
 
 	} else {
 		// use the revel default
-		skeletonPath = filepath.Join(revelPkg.Dir, "skeleton")
+		skeletonPath = filepath.Join(gospfPkg.Dir, "skeleton")
 	}
+	return nil
 }
 
-func copyNewAppFiles() {
+func copyNewAppFiles() error {
 	var err error
 	err = os.MkdirAll(appPath, 0777)
-	panicOnError(err, "Failed to create directory "+appPath)
+	if err != nil {
+		return wrapError(err, "Failed to create directory "+appPath)
+	}
 
-	mustCopyDir(appPath, skeletonPath, map[string]interface{}{
+	err = copyDir(appPath, skeletonPath, map[string]interface{}{
 		// app.conf
 		"AppName":  appName,
 		"BasePath": basePath,
 		"Secret":   generateSecret(),
 	})
+	if err != nil {
+		return err
+	}
 
-	// Dotfiles are skipped by mustCopyDir, so we have to explicitly copy the .gitignore.
+	// Dotfiles are skipped by copyDir, so we have to explicitly copy the .gitignore.
 	gitignore := ".gitignore"
-	mustCopyFile(filepath.Join(appPath, gitignore), filepath.Join(skeletonPath, gitignore))
+	return copyFile(filepath.Join(appPath, gitignore), filepath.Join(skeletonPath, gitignore))
+}
 
+func writeDevcontainer() error {
+	destDir := filepath.Join(appPath, ".devcontainer")
+	if err := os.MkdirAll(destDir, 0777); err != nil {
+		return wrapError(err, "Failed to create directory "+destDir)
+	}
+
+	env := &devEnv{
+		AppName:    appName,
+		ImportPath: importPath,
+		GoVersion:  localGoVersion(),
+		EnvVars:    map[string]string{},
+	}
+	return writeJSON(filepath.Join(destDir, "devcontainer.json"), env.devcontainer())
+}
+
+// writeVSCodeSettings generates tasks to run, test and build the app, and a
+// launch configuration that builds the app binary and debugs it.
+func writeVSCodeSettings() error {
+	destDir := filepath.Join(appPath, ".vscode")
+	if err := os.MkdirAll(destDir, 0777); err != nil {
+		return wrapError(err, "Failed to create directory "+destDir)
+	}
+
+	binPath := filepath.ToSlash(filepath.Join("bin", appName))
+	task := func(label string, args ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"label":          label,
+			"type":           "shell",
+			"command":        "gospf",
+			"args":           args,
+			"problemMatcher": []string{"$go"},
+		}
+	}
+	runTask := task("gospf: run", "run", importPath, "dev")
+	runTask["isBackground"] = true
+	testTask := task("gospf: test", "test", importPath, "dev")
+	testTask["group"] = map[string]interface{}{"kind": "test", "isDefault": true}
+	buildTask := task("gospf: build", "build", "-o", binPath, importPath, "${workspaceFolder}/target")
+	buildTask["group"] = map[string]interface{}{"kind": "build", "isDefault": true}
+
+	err := writeJSON(filepath.Join(destDir, "tasks.json"), map[string]interface{}{
+		"version": "2.0.0",
+		"tasks":   []interface{}{runTask, testTask, buildTask},
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(filepath.Join(destDir, "launch.json"), map[string]interface{}{
+		"version": "0.2.0",
+		"configurations": []interface{}{
+			map[string]interface{}{
+				"name":          "Debug " + appName,
+				"type":          "go",
+				"request":       "launch",
+				"mode":          "exec",
+				"program":       "${workspaceFolder}/" + binPath,
+				"args":          []string{"-importPath", importPath, "-runMode", "dev"},
+				"preLaunchTask": "gospf: build",
+			},
+		},
+	})
 }
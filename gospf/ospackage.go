@@ -0,0 +1,416 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+// osPackage describes the native OS package of an app, as a .deb or a .rpm.
+// The build directory is installed under Prefix, and run by a systemd
+// service of the same name as the package, as User.
+type osPackage struct {
+	Name        string
+	Version     string // In the form of both dpkg and rpm versions.
+	Arch        string // The GOARCH of the binary.
+	Maintainer  string
+	Description string
+	License     string
+	User        string
+	Prefix      string
+}
+
+// newOSPackage returns the package metadata read from the package.*
+// configuration, with the defaults derived from the app.
+func newOSPackage(defaultName string) *osPackage {
+	name := gospf.Config.StringDefault("package.name", packageNameOf(defaultName))
+	version := gospf.Config.StringDefault("package.version", harness.AppVersion())
+	arch := os.Getenv("GOARCH")
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	return &osPackage{
+		Name:        name,
+		Version:     packageVersion(version),
+		Arch:        arch,
+		Maintainer:  gospf.Config.StringDefault("package.maintainer", "Unknown <root@localhost>"),
+		Description: gospf.Config.StringDefault("package.description", "The "+gospf.AppName+" web application"),
+		License:     gospf.Config.StringDefault("package.license", "Proprietary"),
+		User:        gospf.Config.StringDefault("package.user", name),
+		Prefix:      gospf.Config.StringDefault("package.prefix", "/opt/"+name),
+	}
+}
+
+// packageNameOf returns the name, in the lower case letters, digits and
+// hyphens that package names may hold.
+func packageNameOf(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+	if name = strings.Trim(name, "-"); name == "" {
+		name = "app"
+	}
+	return name
+}
+
+// packageVersion returns the version in the form of both dpkg and rpm
+// versions: starting with a digit, and made of letters, digits, periods,
+// pluses and tildes.  A version without a digit first, e.g. "git-1a2b3c" from
+// git describe, is given the upstream version 0, e.g. "0+git.1a2b3c".
+func packageVersion(version string) string {
+	version = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '+', r == '~':
+			return r
+		default:
+			return '.'
+		}
+	}, strings.TrimPrefix(version, "v"))
+	if version == "" {
+		return "0"
+	}
+	if version[0] < '0' || version[0] > '9' {
+		version = "0+" + version
+	}
+	return version
+}
+
+// unitPath returns the path of the systemd unit of the package, under the
+// given directory of units.
+func (p *osPackage) unitPath(unitDir string) string {
+	return filepath.Join(unitDir, p.Name+".service")
+}
+
+// stage lays out the files of the package under root: the build directory
+// under the prefix, and the systemd unit under unitDir.
+func (p *osPackage) stage(root, buildDir, unitDir string) error {
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(root, p.Prefix)), 0755); err != nil {
+		return wrapError(err, "Failed to create the package root")
+	}
+	if err := copyDir(filepath.Join(root, p.Prefix), buildDir, nil); err != nil {
+		return err
+	}
+	unit := filepath.Join(root, p.unitPath(unitDir))
+	if err := os.MkdirAll(filepath.Dir(unit), 0755); err != nil {
+		return wrapError(err, "Failed to create the unit directory")
+	}
+	return p.render(unit, osPackageUnit, 0644)
+}
+
+// render executes the template with the package into the file.
+func (p *osPackage) render(filename, tmpl string, mode os.FileMode) error {
+	var buf bytes.Buffer
+	if err := template.Must(template.New(filepath.Base(filename)).Parse(tmpl)).Execute(&buf, p); err != nil {
+		return wrapError(err, "Failed to render "+filepath.Base(filename))
+	}
+	return wrapError(ioutil.WriteFile(filename, buf.Bytes(), mode), "Failed to write "+filename)
+}
+
+// DebArch returns the Debian architecture of the package.
+func (p *osPackage) DebArch() string {
+	switch p.Arch {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	}
+	return p.Arch
+}
+
+// RpmArch returns the RPM architecture of the package.
+func (p *osPackage) RpmArch() string {
+	switch p.Arch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "i686"
+	case "arm":
+		return "armv7hl"
+	}
+	return p.Arch
+}
+
+// writeDeb writes the package of the build directory as a .deb into destDir,
+// and returns its path.
+func writeDeb(p *osPackage, buildDir, destDir string, reproducible bool) (string, error) {
+	root, err := ioutil.TempDir("", "deb")
+	if err != nil {
+		return "", wrapError(err, "Failed to create the package root")
+	}
+	defer os.RemoveAll(root)
+	if err = p.stage(root, buildDir, "/lib/systemd/system"); err != nil {
+		return "", err
+	}
+
+	var data bytes.Buffer
+	installedSize, err := debTarGz(&data, root, reproducible)
+	if err != nil {
+		return "", err
+	}
+
+	control, err := ioutil.TempDir("", "deb-control")
+	if err != nil {
+		return "", wrapError(err, "Failed to create the control directory")
+	}
+	defer os.RemoveAll(control)
+	controlFile := osPackageDebControl + fmt.Sprintf("Installed-Size: %d\n", (installedSize+1023)/1024)
+	files := []struct {
+		name, tmpl string
+		mode       os.FileMode
+	}{
+		{"control", controlFile, 0644},
+		{"preinst", osPackagePreinst, 0755},
+		{"postinst", osPackagePostinst, 0755},
+		{"prerm", osPackagePrerm, 0755},
+		{"postrm", osPackagePostrm, 0755},
+	}
+	for _, file := range files {
+		if err = p.render(filepath.Join(control, file.name), file.tmpl, file.mode); err != nil {
+			return "", err
+		}
+	}
+	var controlTar bytes.Buffer
+	if _, err = debTarGz(&controlTar, control, reproducible); err != nil {
+		return "", err
+	}
+
+	mtime := time.Now()
+	if reproducible {
+		mtime = reproducibleTime()
+	}
+	destFile := filepath.Join(destDir, fmt.Sprintf("%s_%s_%s.deb", p.Name, p.Version, p.DebArch()))
+	var deb bytes.Buffer
+	deb.WriteString("!<arch>\n")
+	writeArMember(&deb, "debian-binary", []byte("2.0\n"), mtime)
+	writeArMember(&deb, "control.tar.gz", controlTar.Bytes(), mtime)
+	writeArMember(&deb, "data.tar.gz", data.Bytes(), mtime)
+	if err = ioutil.WriteFile(destFile, deb.Bytes(), 0666); err != nil {
+		return "", wrapError(err, "Failed to write "+destFile)
+	}
+	return destFile, nil
+}
+
+// debTarGz writes the tree under root as a gzipped tar of paths starting with
+// "./", owned by root, as dpkg expects.  It returns the size of the files.
+func debTarGz(w io.Writer, root string, reproducible bool) (int64, error) {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	var size int64
+	err := filepath.Walk(root, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return wrapError(err, "Failed to read "+srcPath)
+		}
+		name := "./" + filepath.ToSlash(strings.TrimLeft(srcPath[len(root):], string(os.PathSeparator)))
+		header := &tar.Header{
+			Name:    name,
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime(),
+			Uname:   "root",
+			Gname:   "root",
+		}
+		if reproducible {
+			header.Mode = int64(reproducibleMode(info))
+			header.ModTime = reproducibleTime()
+		}
+		if info.IsDir() {
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+			if name != "./" {
+				header.Name += "/"
+			}
+			return wrapError(tarWriter.WriteHeader(header), "Failed to write tar entry header")
+		}
+		header.Typeflag, header.Size = tar.TypeReg, info.Size()
+		size += info.Size()
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return wrapError(err, "Failed to write tar entry header")
+		}
+		srcFile, err := os.Open(srcPath)
+		if err != nil {
+			return wrapError(err, "Failed to read source file")
+		}
+		defer srcFile.Close()
+		_, err = io.Copy(tarWriter, srcFile)
+		return wrapError(err, "Failed to copy")
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = tarWriter.Close(); err != nil {
+		return 0, wrapError(err, "Failed to write archive")
+	}
+	return size, wrapError(gzipWriter.Close(), "Failed to compress archive")
+}
+
+// writeArMember writes a member of an ar archive, as found in .deb files.
+func writeArMember(w *bytes.Buffer, name string, data []byte, mtime time.Time) {
+	fmt.Fprintf(w, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, mtime.Unix(), 0, 0, "100644", len(data))
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte('\n')
+	}
+}
+
+// writeRpm writes the package of the build directory as a .rpm into destDir
+// with rpmbuild, which must be installed, and returns its path.
+func writeRpm(p *osPackage, buildDir, destDir string) (string, error) {
+	rpmbuildPath, err := exec.LookPath("rpmbuild")
+	if err != nil {
+		return "", errorf("The rpmbuild executable was not found in PATH.  It is required for -rpm.")
+	}
+	topDir, err := ioutil.TempDir("", "rpm")
+	if err != nil {
+		return "", wrapError(err, "Failed to create the rpmbuild directory")
+	}
+	defer os.RemoveAll(topDir)
+	root := filepath.Join(topDir, "ROOT")
+	if err = p.stage(root, buildDir, "/usr/lib/systemd/system"); err != nil {
+		return "", err
+	}
+
+	spec := filepath.Join(topDir, p.Name+".spec")
+	if err = p.render(spec, osPackageRpmSpec, 0644); err != nil {
+		return "", err
+	}
+	cmd := exec.Command(rpmbuildPath, "-bb", "--quiet",
+		"--define", "_topdir "+topDir,
+		"--define", "_stage "+root,
+		"--target", p.RpmArch(),
+		spec)
+	gospf.TRACE.Println("Exec:", cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", errorf("Failed to build the rpm: %s\n%s", err, out)
+	}
+
+	rpms, _ := filepath.Glob(filepath.Join(topDir, "RPMS", "*", "*.rpm"))
+	if len(rpms) != 1 {
+		return "", errorf("Failed to find the rpm built in %s", filepath.Join(topDir, "RPMS"))
+	}
+	destFile := filepath.Join(destDir, filepath.Base(rpms[0]))
+	if err = copyFile(destFile, rpms[0]); err != nil {
+		return "", err
+	}
+	return destFile, nil
+}
+
+// Quote quotes the value for the scripts.
+func (p *osPackage) Quote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// Templates of the files of the packages.  The scripts serve both dpkg and
+// rpm, which pass different arguments to them: the prerm script stops the
+// service when the package is removed, on "remove" from dpkg and on 0 from
+// rpm, rather than upgraded.
+const (
+	osPackageUnit = `[Unit]
+Description={{.Description}}
+After=network.target
+
+[Service]
+Type=simple
+User={{.User}}
+WorkingDirectory={{.Prefix}}
+ExecStart={{.Prefix}}/run.sh
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	osPackageDebControl = `Package: {{.Name}}
+Version: {{.Version}}
+Architecture: {{.DebArch}}
+Maintainer: {{.Maintainer}}
+Section: web
+Priority: optional
+Description: {{.Description}}
+`
+
+	osPackagePreinst = `#!/bin/sh
+set -e
+if ! id -u {{.Quote .User}} >/dev/null 2>&1; then
+	useradd --system --no-create-home --home-dir {{.Quote .Prefix}} --shell /usr/sbin/nologin {{.Quote .User}}
+fi
+`
+
+	osPackagePostinst = `#!/bin/sh
+set -e
+if command -v systemctl >/dev/null 2>&1; then
+	systemctl daemon-reload || true
+	systemctl enable {{.Quote .Name}}.service || true
+	systemctl restart {{.Quote .Name}}.service || true
+fi
+`
+
+	osPackagePrerm = `#!/bin/sh
+set -e
+case "$1" in
+remove|0)
+	if command -v systemctl >/dev/null 2>&1; then
+		systemctl stop {{.Quote .Name}}.service || true
+		systemctl disable {{.Quote .Name}}.service || true
+	fi
+	;;
+esac
+`
+
+	osPackagePostrm = `#!/bin/sh
+set -e
+if command -v systemctl >/dev/null 2>&1; then
+	systemctl daemon-reload || true
+fi
+`
+
+	osPackageRpmSpec = `%define debug_package %{nil}
+%define __os_install_post %{nil}
+
+Name: {{.Name}}
+Version: {{.Version}}
+Release: 1
+Summary: {{.Description}}
+License: {{.License}}
+Packager: {{.Maintainer}}
+AutoReqProv: no
+
+%description
+{{.Description}}
+
+%install
+cp -a %{_stage}/. %{buildroot}/
+
+%files
+%defattr(-,root,root,-)
+{{.Prefix}}
+/usr/lib/systemd/system/{{.Name}}.service
+
+%pre
+` + osPackagePreinst + `
+%post
+` + osPackagePostinst + `
+%preun
+` + osPackagePrerm + `
+%postun
+` + osPackagePostrm
+)
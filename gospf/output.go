@@ -0,0 +1,131 @@
+package main
+
+// All user-facing output of the command line tool goes through this file, so
+// that messages are translated and prefixed consistently, and can be silenced
+// (-q) or made machine-parsable (-porcelain).
+//
+// Messages are identified by their English format string, as with gettext.
+// A catalog maps those to the format string for another language.  Messages
+// missing from a catalog are printed in English.  A message that counts
+// something has a format for the singular and one for the plural, chosen with
+// pluralize, and the catalog translates each, e.g. "%d check failed." and
+// "%d checks failed.".  The languages of the catalogs form their plurals as
+// English does.
+//
+// -porcelain covers the results of the commands, which go through resultf;
+// the progress messages of infof are not printed with it.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+)
+
+var (
+	quietOutput     bool
+	porcelainOutput bool
+	debugOutput     bool
+)
+
+func init() {
+	flag.BoolVar(&quietOutput, "q", false, "only print errors and results")
+	flag.BoolVar(&porcelainOutput, "porcelain", false, "print results as tab-separated lines")
+	flag.BoolVar(&harness.NoColor, "no-color", false, "do not color the terminal output")
+	flag.BoolVar(&debugOutput, "debug", false, "print the stack trace of errors")
+}
+
+// catalogs maps a language code to its message catalog.
+var catalogs = map[string]map[string]string{
+	"es": {
+		"Abort: %s: %s\n":                                "Abortado: %s: %s\n",
+		"Abort: Failed to find import path: %s":          "Abortado: no se encontró la ruta de importación: %s",
+		"Abort: %s":                                      "Abortado: %s",
+		"Removing: %s":                                   "Eliminando: %s",
+		"Your application is ready:\n   %s":              "Tu aplicación está lista:\n   %s",
+		"\nYou can run it with:\n   gospf run %s":        "\nPuedes ejecutarla con:\n   gospf run %s",
+		"Your archive is ready: %s":                      "Tu archivo está listo: %s",
+		"Your environment is ready: %s":                  "Tu entorno está listo: %s",
+		"Your dependency bundle is ready: %s":            "Tu paquete de dependencias está listo: %s",
+		"Your report is ready: %s":                       "Tu informe está listo: %s",
+		"\n%d test suite to run.\n":                      "\n%d suite de pruebas a ejecutar.\n",
+		"\n%d test suites to run.\n":                     "\n%d suites de pruebas a ejecutar.\n",
+		"All Tests Passed.":                              "Todas las pruebas pasaron.",
+		"Failures:":                                      "Fallos:",
+		"Some tests failed.  See file://%s for results.": "Algunas pruebas fallaron.  Consulta file://%s para ver los resultados.",
+		"Exec: %s":                               "Ejecutando: %s",
+		"Generated %s":                           "Generado: %s",
+		"%d of %d responses differ.":             "%d de %d respuestas difieren.",
+		"All %d responses are the same.":         "Las %d respuestas son iguales.",
+		"%d check failed.":                       "%d comprobación fallida.",
+		"%d checks failed.":                      "%d comprobaciones fallidas.",
+		"Redeploying the cluster of %s":          "Redesplegando el clúster de %s",
+		"%d of %d harnesses failed to redeploy.": "%d de %d harnesses no se pudieron redesplegar.",
+	},
+}
+
+// language returns the user's language code, e.g. "es" for LANG=es_ES.UTF-8.
+func language() string {
+	for _, name := range []string{"GOSPF_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		fields := strings.FieldsFunc(os.Getenv(name), func(r rune) bool {
+			return r == '_' || r == '.' || r == '-' || r == '@'
+		})
+		if len(fields) > 0 {
+			return strings.ToLower(fields[0])
+		}
+	}
+	return "en"
+}
+
+// T returns the given message in the user's language.
+func T(format string) string {
+	if translated, ok := catalogs[language()][format]; ok {
+		return translated
+	}
+	return format
+}
+
+func printf(format string, args ...interface{}) {
+	fprintf(os.Stdout, format, args...)
+}
+
+// fprintf is like printf, to the writer.
+func fprintf(w io.Writer, format string, args ...interface{}) {
+	format = T(format)
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// infof prints a progress message, unless the output is quiet or porcelain.
+func infof(format string, args ...interface{}) {
+	if quietOutput || porcelainOutput {
+		return
+	}
+	printf(format, args...)
+}
+
+// resultf prints the outcome of a command, which is shown even when the
+// output is quiet.  With -porcelain, the given fields are printed instead, on
+// a single line separated by tabs.  By convention, the first field names the
+// kind of result, e.g. "archive".
+func resultf(fields []interface{}, format string, args ...interface{}) {
+	fresultf(os.Stdout, fields, format, args...)
+}
+
+// fresultf is like resultf, to the writer.
+func fresultf(w io.Writer, fields []interface{}, format string, args ...interface{}) {
+	if porcelainOutput {
+		var line []string
+		for _, field := range fields {
+			line = append(line, strings.Replace(fmt.Sprint(field), "\n", " ", -1))
+		}
+		fmt.Fprintln(w, strings.Join(line, "\t"))
+		return
+	}
+	fprintf(w, format, args...)
+}
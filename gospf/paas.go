@@ -0,0 +1,171 @@
+package main
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+// paasTargets are the platforms that "gospf package -target" packages for:
+// Heroku, whose slugs have the build under ./app, and the other platforms of
+// buildpacks, which take the build directory as it is.
+var paasTargets = []string{"heroku", "paas"}
+
+// paasVariable is an environment variable the app reads on the platform.
+type paasVariable struct {
+	Name, Doc string
+}
+
+// checkPaaSTarget returns an error if the app cannot be packaged for the
+// target with the other flags.
+func checkPaaSTarget(target string) error {
+	if !gospf.ContainsString(paasTargets, target) {
+		return exitf(exitUsage, "Unknown target %q.  Use %s.", target, strings.Join(paasTargets, " or "))
+	}
+	if packageDeb || packageRpm {
+		return exitf(exitUsage, "-target=%s packages an archive, and cannot be combined with -deb or -rpm.", target)
+	}
+	goos := os.Getenv("GOOS")
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if target == "heroku" && goos != "linux" {
+		return exitf(exitUsage, "Heroku runs Linux binaries.  Package with GOOS=linux GOARCH=amd64.")
+	}
+	return nil
+}
+
+// writePaaSFiles writes the files that the platforms start the app with into
+// the build directory: the Procfile, start.sh, which reads $PORT, and
+// RUNTIME.md, which documents the variables the app reads.
+func writePaaSFiles(buildDir, binaryPath, mode string) error {
+	data := map[string]interface{}{
+		"AppName":    gospf.AppName,
+		"BinName":    filepath.Base(binaryPath),
+		"ImportPath": gospf.ImportPath,
+		"RunMode":    mode,
+		"Variables":  paasVariables(filepath.Join(gospf.BasePath, "conf", "app.conf")),
+	}
+	files := []struct {
+		name, tmpl string
+		mode       os.FileMode
+	}{
+		{"Procfile", paasProcfile, 0644},
+		{"start.sh", paasStartScript, 0755},
+		{"RUNTIME.md", paasRuntimeDoc, 0644},
+	}
+	for _, file := range files {
+		f, err := os.OpenFile(filepath.Join(buildDir, file.name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, file.mode)
+		if err != nil {
+			return wrapError(err, "Failed to write "+file.name)
+		}
+		err = template.Must(template.New(file.name).Parse(file.tmpl)).Execute(f, data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return wrapError(err, "Failed to write "+file.name)
+		}
+	}
+	return nil
+}
+
+// confVariablePattern matches the environment variables that app.conf reads,
+// e.g. ${DATABASE_URL}.
+var confVariablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// paasVariables returns the environment variables of the app.conf file, other
+// than those of start.sh: those its values read, and those its env.NAME keys
+// set in development, which the platform must set instead.
+func paasVariables(filename string) []paasVariable {
+	lines, err := gospf.ReadLines(filename)
+	if err != nil {
+		return nil
+	}
+	docs := make(map[string]string)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		i := strings.IndexAny(line, "=:")
+		if i < 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), line[i+1:]
+		for _, match := range confVariablePattern.FindAllStringSubmatch(value, -1) {
+			if _, found := docs[match[1]]; !found {
+				docs[match[1]] = "Read by " + key + " of app.conf."
+			}
+		}
+		if name := strings.TrimPrefix(key, "env."); name != key && name != "" {
+			if _, found := docs[name]; !found {
+				docs[name] = "Set by " + key + " of app.conf in development, and to be set on the platform."
+			}
+		}
+	}
+	for _, name := range []string{"PORT", "GOSPF_RUN_MODE", "GOSPF_ADDR"} {
+		delete(docs, name)
+	}
+
+	variables := make([]paasVariable, 0, len(docs))
+	for name, doc := range docs {
+		variables = append(variables, paasVariable{name, doc})
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+	return variables
+}
+
+// writeSlug writes the build directory to destFilename as a Heroku slug: a
+// gzipped tar archive with the build under ./app, where Heroku runs it.
+func writeSlug(destFilename, buildDir string) (string, error) {
+	slugFile, err := os.Create(destFilename)
+	if err != nil {
+		return "", wrapError(err, "Failed to create the slug")
+	}
+	defer slugFile.Close()
+	gzipWriter := gzip.NewWriter(slugFile)
+	if err = tarDir(gzipWriter, buildDir, "./app/", harness.Reproducible()); err == nil {
+		err = wrapError(gzipWriter.Close(), "Failed to compress the slug")
+	}
+	if err != nil {
+		os.Remove(destFilename)
+		return "", err
+	}
+	return slugFile.Name(), wrapError(slugFile.Close(), "Failed to write the slug")
+}
+
+const (
+	paasProcfile = `web: ./start.sh
+`
+
+	paasStartScript = `#!/bin/sh
+# Starts {{.AppName}} on the port the platform sets in $PORT, on all interfaces.
+SCRIPTPATH=$(cd "$(dirname "$0")"; pwd)
+exec "$SCRIPTPATH/{{.BinName}}" -importPath {{.ImportPath}} -srcPath "$SCRIPTPATH/src" \
+	-runMode "${GOSPF_RUN_MODE:-{{.RunMode}}}" -port "${PORT:-0}" -addr "${GOSPF_ADDR:-0.0.0.0}" "$@"
+`
+
+	paasRuntimeDoc = `# Runtime variables of {{.AppName}}
+
+The platform starts the app with start.sh, as the web process of the
+Procfile.  start.sh reads:
+
+| Variable | Description |
+| --- | --- |
+| ` + "`PORT`" + ` | The port to listen on, set by the platform.  Without it, http.port of app.conf. |
+| ` + "`GOSPF_RUN_MODE`" + ` | The run mode, ` + "`{{.RunMode}}`" + ` by default. |
+| ` + "`GOSPF_ADDR`" + ` | The address to listen on, ` + "`0.0.0.0`" + ` by default. |
+{{if .Variables}}
+The app reads:
+
+| Variable | Description |
+| --- | --- |
+{{range .Variables}}| ` + "`{{.Name}}`" + ` | {{.Doc}} |
+{{end}}{{end}}`
+)
@@ -1,15 +1,20 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"github.com/hubply/cmd/harness"
 	"github.com/hubply/gospf"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 )
 
 var cmdPackage = &Command{
-	UsageLine: "package [import path]",
+	UsageLine: "package [-o output] [-format format] [-name name] [-trimpath] [-strip] [-upx] [-attest] [-deb] [-rpm] [-target platform] [-exclude glob] [-include glob] [import path] [run mode]",
 	Short:     "package a Gospf application (e.g. for deployment)",
 	Long: `
 Package the Gospf web application named by the given import path.
@@ -18,34 +23,292 @@ This allows it to be deployed and run on a machine that lacks a Go installation.
 For example:
 
     gospf package github.com/hubply/samples/chat
+
+The packaged run scripts start the app in the given run mode, which
+defaults to "prod".
+
+The -o flag sets the path of the built binary, overriding build.output.
+The binary is packaged under the same name.
+
+The -format flag sets the archive format: "tar.gz" (the default), "zip" or
+"tar.zst".  Creating a tar.zst archive requires the zstd command.
+
+The -name flag sets the name of the archive, without the extension.  It
+defaults to the name of the app directory.
+
+The -trimpath flag removes the file system paths of the build machine from
+the binary, as go build -trimpath.
+
+The -strip flag omits the symbol table and the debug information from the
+binary, with the -s -w linker flags, which makes it much smaller.  Stack
+traces keep their function names and line numbers.
+
+The -upx flag compresses the binary with upx, which must be on the PATH.
+The binary decompresses itself in memory when it starts.
+
+With build.reproducible set, the same source tree packages into a
+byte-identical archive, e.g. to verify a release by rebuilding it: the binary
+is built with -trimpath and the commit hash as APP_VERSION, and the files are
+archived with the same times (those of $SOURCE_DATE_EPOCH, if set), owners
+and permissions.  Only the provenance attestation, which records when the
+build ran, differs.
+
+The options applied are recorded in manifest.json in the archive, along with
+the import path, the run mode, the build tags and the Go version.
+
+The -attest flag adds a provenance attestation to the archive, as
+provenance.intoto.jsonl: an in-toto statement of SLSA provenance, listing the
+digests of the packaged files, the builder, the build parameters, the git
+commit of the app and the digests of its dependencies.  It is signed as a
+DSSE envelope with the key in package.attest.key, a PKCS #8 PEM file of an
+Ed25519 or ECDSA key.  The builder ID may be set with package.attest.builder,
+and the key ID with package.attest.keyid.  The app must be in a git
+repository without uncommitted changes.
+
+The -deb and -rpm flags package the app as native OS packages instead of an
+archive: a .deb, and a .rpm built with rpmbuild, which must be on the PATH.
+The packages install the build under /opt/<name>, and a systemd service of
+the same name that runs it as the user <name>, which is created on install.
+The service is enabled and restarted on install, and stopped on removal.
+Their metadata is read from app.conf:
+
+    package.name         the name, the app directory's by default
+    package.version      the version, the APP_VERSION of the app by default
+    package.maintainer   the maintainer, e.g. "Jane Doe <jane@example.com>"
+    package.description  the description
+    package.license      the license, of the .rpm
+    package.user         the user that runs the service, the name by default
+    package.prefix       the directory of the build, /opt/<name> by default
+
+The packages are written to the current directory.
+
+The -target flag packages the app for a platform that runs buildpack-style
+builds, such as Heroku, Dokku or Cloud Foundry, with no assembly by hand.
+The build gets a Procfile, whose web process is start.sh, which starts the
+app on the port of $PORT, on all interfaces, and RUNTIME.md, which documents
+the environment variables the app reads: PORT, GOSPF_RUN_MODE and GOSPF_ADDR,
+which override the run mode and the address, and those that app.conf reads,
+as ${NAME}, or sets in development, with env.NAME.
+
+"-target=heroku" writes a Heroku slug, <name>-slug.tar.gz, with the build
+under ./app, to be released with the slug endpoints of the Platform API with
+the process types {"web": "./start.sh"}.  The app must be built for Linux,
+e.g. with GOOS=linux GOARCH=amd64.  "-target=paas" writes the archive of
+-format, with the Procfile at its root, for the other platforms.
+
+The archive, or each package, is written with its SHA-256 checksum, as
+<archive>.sha256, to be checked with "sha256sum -c".  With
+package.signing.key set, the checksum file is signed as <archive>.sha256.sig,
+with gpg or minisign:
+
+    package.signing.key   the gpg key ID, or the minisign secret key file
+    package.signing.tool  gpg or minisign; minisign if the key is a file
+
+The signature is then checked with, e.g.
+
+    gpg --verify app.tar.gz.sha256.sig app.tar.gz.sha256
+    minisign -V -p app.pub -m app.tar.gz.sha256 -x app.tar.gz.sha256.sig
+
+The -exclude flag leaves the files matching the glob out of the package, and
+may be repeated.  The globs are added to those of package.exclude, and matched
+against the paths relative to the app directory, as those of watch.ignore:
+"node_modules" only leaves out the directory at the top of the app,
+"**/testdata" leaves out those at any depth, and a directory that matches
+leaves out everything in it.  The -include flag, and package.include, bring
+back the files matching the glob that would be left out, e.g.
+
+    package.exclude = **/node_modules, **/testdata, public/src
+    package.include = public/src/LICENSE
+
+Dot files and directories are always left out.  The size of what was packaged
+is printed by top-level directory, along with the paths left out.
 `,
 }
 
+var (
+	packageFormat, packageName string
+	packageAttest              bool
+	packageUPX                 bool
+	packageDeb, packageRpm     bool
+	packageTarget              string
+
+	packageExclude, packageInclude globFlag
+)
+
 func init() {
 	cmdPackage.Run = packageApp
+	cmdPackage.Flag.StringVar(&harness.OutputPath, "o", "", "path of the built binary")
+	cmdPackage.Flag.StringVar(&packageFormat, "format", "tar.gz", "tar.gz, zip or tar.zst")
+	cmdPackage.Flag.StringVar(&packageName, "name", "", "name of the archive")
+	cmdPackage.Flag.BoolVar(&harness.TrimPath, "trimpath", false, "remove file system paths from the binary")
+	cmdPackage.Flag.BoolVar(&harness.Strip, "strip", false, "omit the symbol table and debug information")
+	cmdPackage.Flag.BoolVar(&packageUPX, "upx", false, "compress the binary with upx")
+	cmdPackage.Flag.BoolVar(&packageAttest, "attest", false, "add a signed provenance attestation")
+	cmdPackage.Flag.BoolVar(&packageDeb, "deb", false, "package the app as a .deb")
+	cmdPackage.Flag.BoolVar(&packageRpm, "rpm", false, "package the app as a .rpm")
+	cmdPackage.Flag.StringVar(&packageTarget, "target", "", "heroku or paas, to package for a buildpack-style platform")
+	cmdPackage.Flag.Var(&packageExclude, "exclude", "leave the files matching the glob out of the package")
+	cmdPackage.Flag.Var(&packageInclude, "include", "package the files matching the glob, even if excluded")
 }
 
-func packageApp(args []string) {
+func packageApp(args []string) error {
 	if len(args) == 0 {
-		fmt.Fprint(os.Stderr, cmdPackage.Long)
-		return
+		return exitf(exitUsage, "%s", cmdPackage.Long)
 	}
 
-	appImportPath := args[0]
-	gospf.Init("", appImportPath, "")
+	appImportPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+	mode := defaultMode("prod")
+	if len(args) >= 2 {
+		mode = args[1]
+	}
+	if !gospf.ContainsString(archiveFormats, packageFormat) {
+		return exitf(exitUsage, "Unknown archive format %q.  Use %s.", packageFormat, strings.Join(archiveFormats, ", "))
+	}
+	if packageUPX {
+		if _, err := exec.LookPath("upx"); err != nil {
+			return exitf(exitUsage, "upx is not on the PATH.  Install it from https://upx.github.io/, or package without -upx.")
+		}
+	}
+	if packageRpm {
+		if _, err := exec.LookPath("rpmbuild"); err != nil {
+			return exitf(exitUsage, "rpmbuild is not on the PATH.  Install the rpm-build package, or package without -rpm.")
+		}
+	}
+	if packageTarget != "" {
+		if err := checkPaaSTarget(packageTarget); err != nil {
+			return err
+		}
+	}
+	if err := buildError(harness.InitApp(mode, appImportPath)); err != nil {
+		return err
+	}
 
 	// Remove the archive if it already exists.
-	destFile := filepath.Base(gospf.BasePath) + ".tar.gz"
+	name := packageName
+	if name == "" {
+		name = filepath.Base(gospf.BasePath)
+	}
+	destFile := name + "." + packageFormat
+	if packageTarget == "heroku" {
+		destFile = name + "-slug.tar.gz"
+	}
 	os.Remove(destFile)
 
 	// Collect stuff in a temp directory.
 	tmpDir, err := ioutil.TempDir("", filepath.Base(gospf.BasePath))
-	panicOnError(err, "Failed to get temp dir")
+	if err != nil {
+		return wrapError(err, "Failed to get temp dir")
+	}
 
-	buildApp([]string{args[0], tmpDir})
+	buildFilter = newPackageFilter(packageExclude, packageInclude)
+	started := time.Now()
+	if err = buildApp([]string{appImportPath, tmpDir, mode}); err != nil {
+		return err
+	}
+	binaryPath := filepath.Join(tmpDir, filepath.Base(harness.BinaryPath()))
+	if packageUPX {
+		infof("Compressing %s", filepath.Base(binaryPath))
+		if out, err := exec.Command("upx", "-q", "--best", binaryPath).CombinedOutput(); err != nil {
+			return errorf("Failed to compress the binary with upx: %s\n%s", err, out)
+		}
+	}
+	if packageTarget != "" {
+		if err = writePaaSFiles(tmpDir, binaryPath, mode); err != nil {
+			return err
+		}
+	}
+	if err = writeManifest(tmpDir, binaryPath, mode); err != nil {
+		return err
+	}
+	if packageAttest {
+		if err = attestPackage(tmpDir, mode, started); err != nil {
+			return err
+		}
+	}
 
-	// Create the zip file.
-	archiveName := mustTarGzDir(destFile, tmpDir)
+	if packageDeb || packageRpm {
+		return writeOSPackages(tmpDir, name)
+	}
 
-	fmt.Println("Your archive is ready:", archiveName)
+	// Create the archive.
+	var archiveName string
+	if packageTarget == "heroku" {
+		archiveName, err = writeSlug(destFile, tmpDir)
+	} else {
+		archiveName, err = archiveDir(destFile, tmpDir, packageFormat, harness.Reproducible())
+	}
+	if err != nil {
+		return err
+	}
+
+	resultf([]interface{}{"archive", archiveName}, "Your archive is ready: %s", archiveName)
+	return checksumPackage(archiveName)
+}
+
+// writeOSPackages writes the native OS packages of the build in buildDir that
+// the flags ask for.
+func writeOSPackages(buildDir, name string) error {
+	p := newOSPackage(name)
+	if packageDeb {
+		debName, err := writeDeb(p, buildDir, ".", harness.Reproducible())
+		if err != nil {
+			return err
+		}
+		resultf([]interface{}{"deb", debName}, "Your package is ready: %s", debName)
+		if err = checksumPackage(debName); err != nil {
+			return err
+		}
+	}
+	if packageRpm {
+		rpmName, err := writeRpm(p, buildDir, ".")
+		if err != nil {
+			return err
+		}
+		resultf([]interface{}{"rpm", rpmName}, "Your package is ready: %s", rpmName)
+		if err = checksumPackage(rpmName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifestFile records how a package was built.
+const manifestFile = "manifest.json"
+
+type buildManifest struct {
+	ImportPath   string `json:"importPath"`
+	RunMode      string `json:"runMode"`
+	Binary       string `json:"binary"`
+	Tags         string `json:"tags"`
+	GoVersion    string `json:"goVersion"`
+	TrimPath     bool   `json:"trimpath"`
+	Strip        bool   `json:"strip"`
+	UPX          bool   `json:"upx"`
+	Reproducible bool   `json:"reproducible"`
+	Target       string `json:"target,omitempty"`
+}
+
+// writeManifest writes the manifest of the build in buildDir to
+// buildDir/manifestFile.
+func writeManifest(buildDir, binaryPath, mode string) error {
+	manifest, err := json.MarshalIndent(buildManifest{
+		ImportPath:   gospf.ImportPath,
+		RunMode:      mode,
+		Binary:       filepath.Base(binaryPath),
+		Tags:         harness.BuildTags(),
+		GoVersion:    runtime.Version(),
+		TrimPath:     harness.TrimPath,
+		Strip:        harness.Strip,
+		UPX:          packageUPX,
+		Reproducible: harness.Reproducible(),
+		Target:       packageTarget,
+	}, "", "  ")
+	if err != nil {
+		return wrapError(err, "Failed to encode the build manifest")
+	}
+	return wrapError(ioutil.WriteFile(filepath.Join(buildDir, manifestFile), append(manifest, '\n'), 0666),
+		"Failed to write the build manifest")
 }
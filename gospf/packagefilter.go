@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+// packageFilter selects the files of the app that are packaged.  The
+// patterns of package.exclude and -exclude are globs matched against the
+// paths relative to the app directory, as those of watch.ignore: a file is
+// left out if it, or a directory it is in, matches one.  The patterns of
+// package.include and -include bring files back that would be left out.
+type packageFilter struct {
+	exclude, include []string
+
+	sizes    map[string]int64 // The bytes packaged, by top-level path.
+	excluded []string         // The paths left out, with their subpaths omitted.
+}
+
+func newPackageFilter(exclude, include []string) *packageFilter {
+	return &packageFilter{
+		exclude: append(packageConfigList("package.exclude"), exclude...),
+		include: append(packageConfigList("package.include"), include...),
+		sizes:   make(map[string]int64),
+	}
+}
+
+// packageConfigList returns the comma-separated values of the config key.
+func packageConfigList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(gospf.Config.StringDefault(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// skip reports whether the file or directory at the slash-separated path,
+// relative to the app directory, is left out of the package, and records the
+// size of the files that are not.  A directory is only skipped as a whole
+// when no include pattern may bring back a file in it.
+func (f *packageFilter) skip(rel string, info os.FileInfo) bool {
+	if f.excludes(rel) {
+		if info.IsDir() && len(f.include) > 0 {
+			return false
+		}
+		if !f.inExcluded(rel) {
+			f.excluded = append(f.excluded, rel)
+		}
+		return true
+	}
+	if !info.IsDir() {
+		f.sizes[strings.SplitN(rel, "/", 2)[0]] += info.Size()
+	}
+	return false
+}
+
+// excludes reports whether the path is left out by the exclude patterns,
+// without an include pattern to bring it back.  The files of an excluded
+// directory may still be brought back by the include patterns.
+func (f *packageFilter) excludes(rel string) bool {
+	return f.matches(f.exclude, rel) && !f.matches(f.include, rel)
+}
+
+// matches reports whether the path, or a directory it is in, matches one of
+// the patterns.
+func (f *packageFilter) matches(patterns []string, rel string) bool {
+	elements := strings.Split(rel, "/")
+	for i := range elements {
+		name := strings.Join(elements[:i+1], "/")
+		for _, pattern := range patterns {
+			if harness.MatchGlob(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *packageFilter) inExcluded(rel string) bool {
+	for _, excluded := range f.excluded {
+		if strings.HasPrefix(rel, excluded+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// summary describes the size of what was packaged, by top-level path, and
+// what was left out.
+func (f *packageFilter) summary() string {
+	var names []string
+	var total int64
+	for name, size := range f.sizes {
+		names = append(names, name)
+		total += size
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s %s", name, harness.FormatSize(f.sizes[name])))
+	}
+	summary := fmt.Sprintf("Packaged %s of the app: %s", harness.FormatSize(total), strings.Join(parts, ", "))
+	if len(f.excluded) > 0 {
+		summary += fmt.Sprintf("\nLeft out %d paths: %s", len(f.excluded), strings.Join(f.excluded, ", "))
+	}
+	return summary
+}
+
+// globFlag collects the values of a repeated glob flag.
+type globFlag []string
+
+func (g *globFlag) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globFlag) Set(value string) error {
+	*g = append(*g, value)
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdRedeploy = &Command{
+	UsageLine: "redeploy [-secret secret] [harness URL]",
+	Short:     "rebuild a Gospf application on every harness of a cluster",
+	Long: `
+Redeploy rebuilds and restarts the app on every harness of the cluster that
+the harness at the given URL belongs to, e.g. the staging servers of a team.
+The harnesses of a cluster are configured with harness.cluster.peers; see
+"gospf help run".
+
+Each harness first runs harness.cluster.redeploy.command in the app's
+directory, if set, e.g. "git pull --ff-only", and then rebuilds the app.
+
+For example:
+
+    gospf redeploy http://staging1:9000
+
+The -secret flag sets the shared secret of the cluster,
+harness.cluster.secret.  It defaults to $GOSPF_CLUSTER_SECRET.
+
+The command exits with status 1 if the app fails to redeploy on any harness.
+`,
+}
+
+var redeploySecret string
+
+func init() {
+	cmdRedeploy.Run = redeployCluster
+	cmdRedeploy.Flag.StringVar(&redeploySecret, "secret", "", "shared secret of the cluster")
+}
+
+func redeployCluster(args []string) error {
+	if len(args) != 1 {
+		return exitf(exitUsage, "No harness URL given.\nRun 'gospf help redeploy' for usage.\n")
+	}
+	secret := gospf.FirstNonEmpty(redeploySecret, os.Getenv("GOSPF_CLUSTER_SECRET"))
+	if secret == "" {
+		return exitf(exitUsage, "No secret given.  Set -secret or $GOSPF_CLUSTER_SECRET to harness.cluster.secret.")
+	}
+
+	node := strings.TrimRight(args[0], "/")
+	infof("Redeploying the cluster of %s", node)
+	result := &harness.ClusterRedeploy{}
+	if err := harness.PostCluster(&http.Client{}, node, secret, "redeploy", nil, result); err != nil {
+		return errorf("Failed to redeploy: %s", err)
+	}
+
+	failed := 0
+	for _, n := range result.Nodes {
+		status := "ok"
+		if n.Error != "" {
+			failed++
+			status = n.Error
+		}
+		resultf([]interface{}{"redeploy", n.Node, status}, "%s: %s", n.Node, status)
+	}
+	if failed > 0 {
+		return errorf("%d of %d harnesses failed to redeploy.", failed, len(result.Nodes))
+	}
+	return nil
+}
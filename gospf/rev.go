@@ -5,10 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"github.com/agtorre/gocolorize"
+	"github.com/hubply/cmd/harness"
 	"io"
 	"math/rand"
 	"os"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"text/template"
 	"time"
@@ -16,8 +18,17 @@ import (
 
 // Cribbed from the genius organization of the "go" command.
 type Command struct {
-	Run                    func(args []string)
+	Run                    func(args []string) error
 	UsageLine, Short, Long string
+
+	// Flag is a set of flags specific to this command.
+	Flag flag.FlagSet
+}
+
+func (cmd *Command) Usage() {
+	fmt.Fprintf(os.Stderr, "usage: gospf %s\n", cmd.UsageLine)
+	cmd.Flag.PrintDefaults()
+	os.Exit(exitUsage)
 }
 
 func (cmd *Command) Name() string {
@@ -32,21 +43,44 @@ func (cmd *Command) Name() string {
 var commands = []*Command{
 	cmdNew,
 	cmdRun,
+	cmdRunMulti,
+	cmdWorker,
+	cmdWatch,
 	cmdBuild,
 	cmdPackage,
+	cmdDeps,
+	cmdRedeploy,
 	cmdClean,
 	cmdTest,
+	cmdDiffRoutes,
+	cmdDoctor,
+	cmdInspect,
+	cmdConfig,
+	cmdReport,
+	cmdEnv,
+	cmdGenerate,
+	cmdComplete,
 }
 
 func main() {
-	if runtime.GOOS == "windows" {
-		gocolorize.SetPlain(true)
-	}
-	fmt.Fprintf(os.Stdout, gocolorize.NewColor("blue").Paint(header))
 	flag.Usage = func() { usage(1) }
-	flag.Parse()
+	if err := loadCLIConfig(); err != nil {
+		exitWithError(err)
+	}
+	flag.CommandLine.Parse(append(cliFlags(""), os.Args[1:]...))
 	args := flag.Args()
 
+	if runtime.GOOS == "windows" {
+		harness.NoColor = true
+	}
+	gocolorize.SetPlain(harness.NoColor)
+
+	// Completion output is read by the shell, and that of inspect by tools, so
+	// they must not have a header.
+	if !quietOutput && !porcelainOutput && (len(args) == 0 || args[0] != cmdComplete.Name() && args[0] != cmdInspect.Name()) {
+		fmt.Fprintf(os.Stdout, gocolorize.NewColor("blue").Paint(header))
+	}
+
 	if len(args) < 1 || args[0] == "help" {
 		if len(args) == 1 {
 			usage(0)
@@ -62,35 +96,34 @@ func main() {
 		usage(2)
 	}
 
-	// Commands use panic to abort execution when something goes wrong.
-	// Panics are logged at the point of error.  Ignore those.
+	// Commands return an error to abort execution when something goes wrong.
+	// Anything else that panics is a bug; report it without the Go panic
+	// output, unless -debug is set.
 	defer func() {
 		if err := recover(); err != nil {
-			if _, ok := err.(LoggedError); !ok {
-				// This panic was not expected / logged.
-				panic(err)
-			}
-			os.Exit(1)
+			exitWithError(&CommandError{
+				Message:  fmt.Sprintf("Internal error: %v", err),
+				ExitCode: exitFailure,
+				Stack:    debug.Stack(),
+			})
 		}
 	}()
 
 	for _, cmd := range commands {
 		if cmd.Name() == args[0] {
-			cmd.Run(args[1:])
+			cliCommand = cmd.Name()
+			cmd.Flag.Usage = cmd.Usage
+			if err := cmd.Flag.Parse(append(cliFlags(cliCommand), args[1:]...)); err != nil {
+				cmd.Usage()
+			}
+			if err := cmd.Run(cliArgs(cliCommand, cmd.Flag.Args())); err != nil {
+				exitWithError(err)
+			}
 			return
 		}
 	}
 
-	errorf("unknown command %q\nRun 'gospf help' for usage.\n", args[0])
-}
-
-func errorf(format string, args ...interface{}) {
-	// Ensure the user's command prompt starts on the next line.
-	if !strings.HasSuffix(format, "\n") {
-		format += "\n"
-	}
-	fmt.Fprintf(os.Stderr, format, args...)
-	panic(LoggedError{}) // Panic instead of os.Exit so that deferred will run.
+	exitWithError(exitf(exitUsage, "unknown command %q\nRun 'gospf help' for usage.\n", args[0]))
 }
 
 const header = `~
@@ -101,10 +134,29 @@ const header = `~
 const usageTemplate = `usage: gospf command [arguments]
 
 The commands are:
-{{range .}}
-    {{.Name | printf "%-11s"}} {{.Short}}{{end}}
+{{range .}}{{if .Short}}
+    {{.Name | printf "%-11s"}} {{.Short}}{{end}}{{end}}
 
 Use "gospf help [command]" for more information.
+
+A project can pin the defaults of the flags, arguments and run mode of the
+commands in conf/cli.conf, or in the [cli] section of conf/app.conf:
+
+    flags = -porcelain
+    mode = staging
+    run.flags = -n 2
+    run.args = github.com/acme/shop
+
+The flags given on the command line take precedence.
+
+The exit status is 0 on success, and otherwise:
+
+    1  runtime or packaging failure
+    2  invalid arguments
+    3  app or configuration not found
+    4  code generation failed
+    5  compilation failed
+    6  tests failed
 `
 
 var helpTemplate = `usage: gospf {{.UsageLine}}
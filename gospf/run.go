@@ -1,13 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/hubply/cmd/harness"
-	"github.com/hubply/gospf"
 	"strconv"
+	"strings"
 )
 
 var cmdRun = &Command{
-	UsageLine: "run [import path] [run mode] [port]",
+	UsageLine: "run [-mode mode] [-port port] [-addr addr] [-no-watch] [-o output] [-profile name] [-n instances] [-autoget policy] [-modes modes] [-no-proxy] [-interactive] [-remote] [-e KEY=VALUE] [-reset] [import path] [run mode] [port]",
 	Short:     "run a Revel application",
 	Long: `
 Run the Revel web application named by the given import path.
@@ -16,60 +18,411 @@ For example, to run the chat room sample application:
 
     gospf run github.com/hubply/samples/chat dev
 
+The import path may also be the path of the app's directory, which need not
+be in the GOPATH, e.g. "gospf run .".  An app outside the GOPATH is given the
+import path of the module in its go.mod, or else the name of its directory.
+Its dependencies must still be found in the GOPATH or its vendor directory.
+
 The run mode is used to select which set of app.conf configuration should
-apply and may be used to determine logic in the application itself.
+apply and may be used to determine logic in the application itself.  It
+must be defined in app.conf, as a section, which may extend another mode with
+mode.extends, e.g. "mode.extends = prod" in [staging].  "gospf config check"
+checks the run modes.
+
+Run mode defaults to "dev", or to the mode pinned in conf/cli.conf.  The
+import path, run mode and flags themselves can be pinned there as well, e.g.
+
+    run.args = github.com/hubply/samples/chat
+    run.flags = -n 2
+
+The run mode and the port are set with the -mode and -port flags, or for
+compatibility as the second and third arguments.  For example:
+
+    gospf run -mode prod -port 8080 github.com/hubply/samples/chat
+
+    gospf run github.com/hubply/samples/chat prod 8080
+
+The -addr flag sets the address to listen on, overriding http.addr, e.g.
+"127.0.0.1" to only accept connections from the local machine.
+
+The -no-watch flag builds and runs the app once, without the harness, as
+watch=false does.
+
+The -o flag sets the path of the built binary, overriding build.output.
+
+The -profile flag builds the app with a build profile of app.conf, overriding
+build.profile.  A profile is a section [build.profile.<name>] that sets tags,
+gcflags, ldflags, race, vet, flags and env.<NAME> for the go commands, e.g.
+
+    [build.profile.fastdev]
+    gcflags = all=-N -l
+
+    [build.profile.ci]
+    race = true
+    vet = true
+    tags = integration
+
+The -n flag runs several instances of the app behind the harness, which
+distributes requests among them round-robin.  This overrides
+harness.instances, and requires watched mode.
+
+The -autoget flag sets whether packages the app imports but are missing are
+fetched with "go get": "off", "prompt" (the default) or "on".  This overrides
+build.autoget.
+
+The -modes flag runs the app in several run modes at once, e.g. "dev,admin",
+from a single build.  Each mode gets its own instances, and the harness routes
+the requests under /<mode>, without the prefix, which the X-Forwarded-Prefix
+header gives (or, with harness.routing=host, the requests for the host names
+starting with "<mode>.") to them.  The first mode serves all other requests,
+and its configuration is used for the harness and the build.  This overrides
+the run mode argument, and requires watched mode.
+
+In watched mode, if the app exits on its own, the following requests show its
+exit code and last output to stderr.  With harness.restart=true, the app is
+also restarted, after a delay that doubles with each exit, up to 30 seconds.
+
+In watched mode, a change to the source is rebuilt in the background, and the
+running app keeps serving requests until the new build replaces it
+(stale-while-rebuilding).  If the build fails, the following requests show
+the error, and wait for the next build until it succeeds.  With
+harness.rebuild.header=true, the responses served by the previous build while
+the app is rebuilt have the header "X-Gospf-Stale: rebuilding".  With
+harness.rebuild=wait, requests wait for every rebuild instead, so that they
+are always served by the latest source.
+
+The requests whose paths start with a prefix of harness.rebuild.skip,
+"/public/, /favicon.ico" by default, e.g. "/public/, /favicon.ico, /health",
+and those with the header "X-Gospf-Rebuild: skip", go straight to the
+running app, without checking the source for changes or waiting for a
+rebuild.  Once the app runs, page loads with many assets check the source
+once, rather than once per asset.
+
+In watched mode, the harness watches the code paths of the app and its
+modules, and the directories listed in watch.paths, relative to the app's
+root directory unless absolute, e.g. "watch.paths = internal, ../shared",
+and the directories of the packages listed in watch.packages, with the
+packages under them, e.g. "watch.packages = github.com/hubply/gospf" to
+rebuild the app on changes to the framework, which is quicker than watching
+the whole GOPATH with watch.gopath.
+The directories matching the globs of watch.ignore, relative to a watched
+path, are not watched: "testdata" only ignores the testdata directory at the
+top of each, and "**/node_modules" ignores those at any depth.  The tmp,
+routes and views directories at the top of the app directory are never
+watched for rebuilds.  A .gospfignore file at the root of the app lists more
+files and directories to ignore, in the syntax of .gitignore, e.g. "*_gen.go"
+or "/public/vendor/".  It is read when the harness starts.
+
+An app whose shared libraries are in sibling modules, e.g. in a monorepo,
+builds with their local source when they are listed in build.workspace,
+relative to the app's root directory unless absolute, e.g.
+"build.workspace = ../shared, ../lib/auth", or in the use directives of the
+go.work file of the app.  Their source is processed with the app's, and
+watched, so that a change to a shared library rebuilds the app.
+
+The directories created under the watched paths while the harness runs,
+such as a new package under app/controllers, are watched as well, and the
+source files that appear in them rebuild the app.
+
+The output of go build is streamed to the console, with the packages listed
+as they are compiled.  A browser that waits for a build for more than half a
+second is shown a building page instead, with the time elapsed and the last
+output, which reloads until the build is done.
+
+With error.editor set, the location of a build error links to the file in
+the editor, on the error page and on the console: "vscode" and "goland" open
+vscode:// and goland:// URLs, and a command, e.g. "vim --remote", is run by
+the harness with "+<line> <path>" as arguments when the link is followed.  A
+template with {{Path}}, {{Line}} and {{Column}}, e.g. "subl {{Path}}:{{Line}}",
+sets the URL or the arguments.  error.link takes precedence.
+
+With build.vet=true, the app's code is analyzed once it compiles, by go vet
+and by the command of build.vet.analyzer, if set, e.g. "staticcheck".  The
+findings are reported like compile errors.  Those of go vet have the severity
+of build.vet.severity, "error" by default, and those of the analyzer that of
+build.vet.analyzer.severity, "warning" by default.  The findings of the
+severity of build.vet.fail or higher, "error" by default, fail the build,
+and the others are logged; build.vet.fail=none logs them all.
+
+When the routes are generated, conf/routes is checked against the actions of
+the controllers.  The console warns of the routes to actions that do not
+exist, of the exported actions of the app that no route refers to, and of the
+routes to actions whose doc comment has a "Deprecated:" paragraph.  With
+routes.strict=true, the routes to missing controllers or actions fail the
+build instead.  The actions without a route are only warned of, since they may
+be exported as helpers, e.g. of the tests.
+
+With build.sizereport=true, the packages that take the most space in the
+binary, build.sizereport.top of them (10 by default), are listed after each
+build, as measured by "go tool nm".
+
+With harness.handoff=true, the websockets open to the app survive a rebuild:
+the previous instance keeps serving them until they close, or for at most
+harness.handoff.timeout (30s by default), while the new instance runs on a new
+port and serves all new connections.
+
+Once the app is started, the harness probes it until it is ready before
+proxying requests to it: with harness.ready.path set, e.g. "/healthz", until a
+GET of the path answers with a status below 500, and otherwise until its port
+accepts connections, for at most harness.ready.timeout (30s by default).
+Meanwhile, browsers are shown a starting page, which reloads until the app is
+ready, and other requests wait.
+
+The harness serves HTTP/2 as well as HTTP/1.1 when http.ssl is set, and
+forwards requests to the app over HTTP/2 when the app accepts it.  Without
+TLS, harness.h2c=true also serves HTTP/2 in cleartext (h2c), and
+harness.proxy.h2c=true forwards requests to the app over h2c, which the app
+must then serve.  h2c requires gospf to be built with Go 1.24 or later: built
+with an earlier Go, the harness ignores both with a warning.
+
+With harness.accesslog=true, the harness logs each request on the console:
+its method, path, status and latency, and whether the new build of the app
+served it, the old one while the app was rebuilt, or the harness itself.
+harness.accesslog.file appends the requests to the given file as well, in the
+combined log format.
+
+After each successful build, the harness prints a summary of it: the path and
+size of the binary, how long the build took, the numbers of controllers,
+actions and routes, the URL of the app and the number of paths watched.
+harness.summary=false leaves it out.
+
+When a build fails, the error page and the console show a short diff of the
+files changed since the last successful build: the Go files of the app, its
+go.mod and the files of conf/.  harness.builddiff.lines (40) limits its
+length, and harness.builddiff=false leaves it out.
+
+The harness rewrites the responses of the app that refer to its internal
+host and port, which the browser cannot reach: the URLs of the Location,
+Content-Location and Refresh headers get the public host of the request, and
+a cookie Domain of the internal host gets the public one, or is removed if
+that is not a domain name, e.g. localhost.  harness.proxy.rewrite=false
+forwards the responses as they are.
+
+The app instances listen on free ports picked by the system, unless
+harness.port is set.  harness.port.min and harness.port.max restrict them to
+a range instead, e.g. the ports a firewall or a container lets through:
+the harness tries the ports of the range from a random one, and stops with
+an error if they are all in use.  With harness.port.handoff=true, the
+harness keeps listening on the port of each instance and hands the listener
+to the app, so that no other process can take the port while the app starts
+or restarts.  The app's main.go must then accept -listenerFd, as the
+generated one does.  gospf.Run opens its own listener and can't serve the one
+handed over, so the generated main accepts the connections on it and copies
+each to and from the server on a unix socket of the temporary directory.  This
+costs an extra hop in the app's process: a second connection and a copy of
+every byte each way.  The connections accepted before the server listens wait
+for it, for up to 30 seconds.  The handoff is not supported on Windows.
+
+With harness.socket=true, the harness talks to the app over a unix socket in
+the temporary directory, passed to the app with -socket, instead of a TCP
+port.  This avoids races for free ports and firewall prompts, and is faster.
+harness.port is then ignored.
+
+run.maxmem and run.maxfds limit the resources of the app, so that a runaway
+build does not take the machine down with it.  The harness samples the memory
+resident of each instance every run.sample.interval (2s by default), and
+kills those above run.maxmem, e.g. "512MB": the requests are then shown that
+the app exceeded its memory limit.  The app gets a GOMEMLIMIT of run.maxmem,
+unless its environment sets one.  run.maxfds is the most files the app may
+have open, and is only supported on Linux.  In dev mode, /_gospf/status
+shows the memory, CPU usage and open files last sampled of each instance, as
+JSON.
+
+In watched mode, the commands configured as assets.NAME in app.conf, e.g.
+"assets.css = sassc app/styles/main.scss public/css/main.css", are run in the
+app's directory alongside the app, with their output prefixed by their name.
+Those that exit are run again after each rebuild, and a command is restarted
+when it changes in app.conf.  If one fails, the following requests show its
+error and last output until it succeeds.
 
-Run mode defaults to "dev".
+With harness.cluster.peers set to the base URLs of several harnesses, e.g.
+team staging servers, the harnesses form a cluster.  Each of them sets
+harness.cluster.self to its own URL among the peers, and they share
+harness.cluster.secret.  The first peer that is up leads the cluster: the
+others register with it every harness.cluster.heartbeat (2s by default), and
+it distributes the requests it receives among them round-robin, so the
+staging domain should point at it.  "gospf redeploy" rebuilds the app on
+every harness at once.
 
-You can set a port as an optional third parameter.  For example:
+With harness.replay=true in dev mode, the harness records the last
+harness.replay.size (20) GET and HEAD requests, with their responses, and
+replays them once the app is rebuilt, reporting the requests whose status
+code or body changed.  harness.replay.methods lists the methods recorded,
+and harness.replay.maxbody (64KB) limits the bodies recorded.  The last
+report is shown under /_gospf/replay, which also replays them on demand, and
+with harness.replay.auto=false, only on demand.
 
-    gospf run github.com/hubply/samples/chat prod 8080`,
+The -no-proxy flag, or harness.proxy=false, runs the app on the public port
+directly, without the harness's proxy in front of it.  The source is still
+watched: the app is rebuilt as soon as it changes, and restarted once the
+build succeeds, with a brief downtime.  Build errors are only shown on the
+console, and the previous build keeps running until they are fixed.  Only
+one instance runs, in the first run mode, and the features of the proxy are
+disabled: error pages, the /_gospf/ pages and clusters.
+
+The -interactive flag gives the app the input of the console, for apps that
+prompt for a passphrase or credentials on startup.  The app then runs in the
+foreground with gospf, and does not time out while it waits for input.  An
+app that seems to wait on a prompt as it starts, whose output ends with an
+unfinished line, is reported on the console, and under the proxy, browsers
+are shown the prompt rather than wait for the app.
+
+The -remote flag, or harness.remote=true, exposes the harness to the
+network, e.g. to try the app from a phone: it listens on all interfaces, and
+prints the URLs to open from other machines, with a QR code if qrencode is
+installed.  Those URLs hold an access token, generated on each run unless
+harness.remote.token is set, which the browser then keeps in a cookie.  The
+requests of other machines without the token are refused.  It requires the
+proxy.
+
+With harness.admin.token set, the proxy serves an admin API for editor
+plugins and scripts, whose requests send the token as a bearer token:
+"POST /@gospf/rebuild" rebuilds the app even if its source is unchanged,
+"POST /@gospf/restart" restarts it without rebuilding, and "POST
+/@gospf/clean" removes the generated code and the binary and rebuilds it.
+Each responds with the result of the build as JSON, e.g.
+
+    curl -X POST -H "Authorization: Bearer $TOKEN" localhost:9000/@gospf/rebuild
+
+The proxy streams its events as Server-Sent Events at /@gospf/events, which
+needs no token: build-started, build-succeeded, build-failed, with the error
+as JSON, and app-restarted.  Browsers may reload the page on app-restarted,
+and editor plugins follow the builds without polling, e.g.
+
+    curl -N localhost:9000/@gospf/events
+
+The -e flag sets a variable in the app's environment, and may be repeated.
+The app's environment is merged from, in increasing order of precedence:
+
+    1. the env.NAME keys of app.conf, for the run mode
+    2. the .env file in the app's root directory
+    3. the environment of gospf
+    4. the -e flags
+
+Each run records its run mode, port and flags in .gospf/session, in the app
+directory, so that a plain "gospf run" in that directory resumes the previous
+run.  The flags given take precedence over those recorded.  The -e flags are
+not recorded, as they often hold secrets.  The -reset flag forgets the previous
+run: "gospf run -reset" only clears the session, and "gospf run -reset" with an
+import path clears it and runs the app without recording the run.`,
 }
 
+var (
+	runModes string
+	runReset bool
+	runOpts  harness.RunOptions
+)
+
 func init() {
 	cmdRun.Run = runApp
+	cmdRun.Flag.StringVar(&runOpts.Mode, "mode", "", "run mode")
+	cmdRun.Flag.IntVar(&runOpts.Port, "port", 0, "port to listen on")
+	cmdRun.Flag.StringVar(&runOpts.Addr, "addr", "", "address to listen on")
+	cmdRun.Flag.BoolVar(&runOpts.NoWatch, "no-watch", false, "build and run the app once, without watching it")
+	cmdRun.Flag.StringVar(&harness.OutputPath, "o", "", "path of the built binary")
+	cmdRun.Flag.StringVar(&harness.Profile, "profile", "", "build profile of app.conf to build with")
+	cmdRun.Flag.IntVar(&harness.Instances, "n", 0, "number of app instances to run")
+	cmdRun.Flag.StringVar(&harness.AutoGet, "autoget", "", "off, prompt or on")
+	cmdRun.Flag.StringVar(&runModes, "modes", "", "comma-separated run modes to run at once")
+	cmdRun.Flag.BoolVar(&harness.NoProxy, "no-proxy", false, "run the app on the public port, without the proxy")
+	cmdRun.Flag.BoolVar(&harness.Interactive, "interactive", false, "give the app the input of the console, for its prompts")
+	cmdRun.Flag.BoolVar(&harness.Remote, "remote", false, "expose the harness to the network, with an access token")
+	cmdRun.Flag.Var((*envFlag)(&harness.Env), "e", "set KEY=VALUE in the app's environment")
+	cmdRun.Flag.BoolVar(&runReset, "reset", false, "forget the run mode, port and flags of the previous run")
 }
 
-func runApp(args []string) {
-	if len(args) == 0 {
-		errorf("No import path given.\nRun 'gospf help run' for usage.\n")
+// envFlag collects the values of a repeated KEY=VALUE flag.
+type envFlag []string
+
+func (e *envFlag) String() string {
+	return strings.Join(*e, " ")
+}
+
+func (e *envFlag) Set(value string) error {
+	if strings.Index(value, "=") <= 0 {
+		return errors.New("expected KEY=VALUE")
 	}
+	*e = append(*e, value)
+	return nil
+}
 
-	// Determine the run mode.
-	mode := "dev"
-	if len(args) >= 2 {
-		mode = args[1]
+func runApp(args []string) error {
+	if len(args) == 0 {
+		if runReset {
+			if err := resetSession("."); err != nil {
+				return err
+			}
+			resultf([]interface{}{"reset", sessionFile}, "Forgot the previous run.")
+			return nil
+		}
+		// A plain "gospf run" in the app directory resumes the previous run.
+		session, err := loadSession(".")
+		if err != nil {
+			return err
+		}
+		if session == nil {
+			return exitf(exitUsage, "No import path given.\nRun 'gospf help run' for usage.\n")
+		}
+		resumed := append([]string{"-mode=" + session.Mode}, session.Flags...)
+		if session.Port != 0 {
+			resumed = append(resumed, fmt.Sprintf("-port=%d", session.Port))
+		}
+		if err = resumeFlags(&cmdRun.Flag, resumed); err != nil {
+			return err
+		}
+		infof("Resuming the previous run: %s", strings.Join(resumed, " "))
+		args = []string{"."}
+	}
+	if len(args) > 3 {
+		return exitf(exitUsage, "Too many arguments.\nRun 'gospf help run' for usage.\n")
 	}
 
-	// Find and parse app.conf
-	gospf.Init(mode, args[0], "")
-	gospf.LoadMimeConfig()
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+	opts := harness.RunOptions{
+		ImportPath: importPath,
+		Mode:       runOpts.Mode,
+		Port:       runOpts.Port,
+		Addr:       runOpts.Addr,
+		NoWatch:    runOpts.NoWatch,
+	}
 
-	// Determine the override port, if any.
-	port := gospf.HttpPort
+	// The run mode and the port may be given as arguments, for compatibility.
+	if len(args) >= 2 {
+		if opts.Mode != "" && opts.Mode != args[1] {
+			return exitf(exitUsage, "The run mode is given both as -mode %s and as the argument %s.", opts.Mode, args[1])
+		}
+		opts.Mode = args[1]
+	}
 	if len(args) == 3 {
-		var err error
-		if port, err = strconv.Atoi(args[2]); err != nil {
-			errorf("Failed to parse port as integer: %s", args[2])
+		port, err := strconv.Atoi(args[2])
+		if err != nil {
+			return exitf(exitUsage, "Failed to parse port as integer: %s", args[2])
+		}
+		if opts.Port != 0 && opts.Port != port {
+			return exitf(exitUsage, "The port is given both as -port %d and as the argument %d.", opts.Port, port)
 		}
+		opts.Port = port
 	}
-
-	gospf.INFO.Printf("Running %s (%s) in %s mode\n", gospf.AppName, gospf.ImportPath, mode)
-	gospf.TRACE.Println("Base path:", gospf.BasePath)
-
-	// If the app is run in "watched" mode, use the harness to run it.
-	if gospf.Config.BoolDefault("watch", true) && gospf.Config.BoolDefault("watch.code", true) {
-		gospf.TRACE.Println("Running in watched mode.")
-		gospf.HttpPort = port
-		harness.NewHarness().Run() // Never returns.
+	if opts.Mode == "" {
+		opts.Mode = defaultMode("dev")
+	}
+	if runModes != "" {
+		harness.Modes = strings.Split(runModes, ",")
+		opts.Mode = harness.Modes[0]
 	}
 
-	// Else, just build and run the app.
-	gospf.TRACE.Println("Running in live build mode.")
-	app, err := harness.Build()
-	if err != nil {
-		errorf("Failed to build app: %s", err)
+	if dir := appDir(importPath); runReset && dir != "" {
+		if err := resetSession(dir); err != nil {
+			infof("%s", err)
+		}
+	} else if dir != "" {
+		session := &runSession{Mode: opts.Mode, Port: opts.Port, Flags: givenFlags(&cmdRun.Flag)}
+		if err := saveSession(dir, session); err != nil {
+			infof("%s", err)
+		}
 	}
-	app.Port = port
-	app.Cmd().Run()
+	return buildError(harness.RunApp(opts))
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hubply/cmd/harness"
+)
+
+var cmdRunMulti = &Command{
+	UsageLine: "run-multi [apps file]",
+	Short:     "run several Gospf applications behind one proxy",
+	Long: `
+Run several Gospf web applications at once, e.g. a suite of services or
+micro-frontends developed together, behind a proxy that routes the requests
+to them by host name or path prefix.
+
+Each app is run by "gospf run" with its own harness, on its own port, so that
+it is watched and rebuilt independently of the others, with its output
+prefixed by its name.  The apps are listed in a YAML file, gospf-multi.yaml
+by default:
+
+    port: 9000               # The port of the proxy.
+    apps:
+      - name: shop
+        path: ./shop         # The import path or directory of the app.
+        prefix: /shop        # Routes the requests under /shop to it.
+      - name: admin
+        path: github.com/acme/admin
+        mode: staging        # The run mode, "dev" by default.
+        host: admin.localhost
+      - name: www
+        path: ./www          # Serves the requests of no other app.
+
+A request is routed to the app for its host name, or else to the app with
+the longest prefix of its path, or else to the first app without a host or a
+prefix.  The prefix is kept in the path of the request, unless the app sets
+"strip: true".  The harness of each app listens on a free port, unless the
+app sets "port".  The proxy listens on all interfaces, unless "addr" is set.
+
+Only this subset of YAML is read: scalar keys, and the list of apps.
+`,
+}
+
+func init() {
+	cmdRunMulti.Run = runMulti
+}
+
+func runMulti(args []string) error {
+	if len(args) > 1 {
+		return exitf(exitUsage, "%s\n%s", cmdRunMulti.UsageLine, cmdRunMulti.Long)
+	}
+	filename := "gospf-multi.yaml"
+	if len(args) == 1 {
+		filename = args[0]
+	}
+	conf, err := harness.LoadMultiConfig(filename)
+	if err != nil {
+		return exitf(exitConfigError, "Failed to load the apps: %s", err)
+	}
+
+	command, err := os.Executable()
+	if err != nil {
+		return wrapError(err, "Failed to find the gospf command")
+	}
+	harness.RunMulti(conf, command) // Never returns.
+	return nil
+}
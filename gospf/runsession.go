@@ -0,0 +1,159 @@
+package main
+
+// "gospf run" records the run mode, the port and the flags of each run in
+// .gospf/session, in the app directory, so that a plain "gospf run" in that
+// directory resumes the previous run, e.g.
+//
+//	mode = staging
+//	port = 9001
+//	flags = "-profile=debug" "-o=bin/my app"
+//
+// Each flag is quoted, as a Go string, so that its value may hold spaces.  The
+// flags given on the command line take precedence over those recorded, and
+// "gospf run -reset" forgets them.  The -e flags are not recorded, as their
+// values are often secrets.
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sessionFile is the path of the session, relative to the app directory.
+var sessionFile = filepath.Join(".gospf", "session")
+
+// runSession is the run mode, the port and the flags of a run.
+type runSession struct {
+	Mode  string
+	Port  int
+	Flags []string // As "-name=value".
+}
+
+// sessionFlags are the flags of "gospf run" that are not recorded, as they
+// are either recorded apart, only apply once or may hold secrets.
+var sessionFlags = map[string]bool{"mode": true, "port": true, "reset": true, "e": true}
+
+// loadSession returns the session recorded in the app directory, or nil if
+// there is none.
+func loadSession(dir string) (*runSession, error) {
+	filename := filepath.Join(dir, sessionFile)
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapError(err, "Failed to read "+filename)
+	}
+	defer file.Close()
+	settings, err := parseCLIConfig(file, "")
+	if err != nil {
+		return nil, exitf(exitConfigError, "Failed to read %s: %s.  Run with -reset to forget it.", filename, err)
+	}
+
+	session := &runSession{Mode: settings["mode"]}
+	if session.Flags, err = unquoteFlags(settings["flags"]); err != nil {
+		return nil, exitf(exitConfigError, "Failed to read %s: %s.  Run with -reset to forget it.", filename, err)
+	}
+	if port := settings["port"]; port != "" {
+		if session.Port, err = strconv.Atoi(port); err != nil {
+			return nil, exitf(exitConfigError, "Failed to read %s: invalid port %q.  Run with -reset to forget it.", filename, port)
+		}
+	}
+	return session, nil
+}
+
+// saveSession records the session in the app directory.
+func saveSession(dir string, session *runSession) error {
+	filename := filepath.Join(dir, sessionFile)
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return wrapError(err, "Failed to record the session")
+	}
+	content := "# The last run of \"gospf run\", resumed by a plain \"gospf run\" in the app directory.\n"
+	content += fmt.Sprintf("mode = %s\n", session.Mode)
+	if session.Port != 0 {
+		content += fmt.Sprintf("port = %d\n", session.Port)
+	}
+	if len(session.Flags) > 0 {
+		quoted := make([]string, len(session.Flags))
+		for i, flag := range session.Flags {
+			quoted[i] = strconv.Quote(flag)
+		}
+		content += fmt.Sprintf("flags = %s\n", strings.Join(quoted, " "))
+	}
+	return wrapError(ioutil.WriteFile(filename, []byte(content), 0666), "Failed to record the session")
+}
+
+// unquoteFlags returns the flags recorded, each quoted as a Go string and
+// separated by spaces.
+func unquoteFlags(recorded string) ([]string, error) {
+	var flags []string
+	for recorded = strings.TrimSpace(recorded); recorded != ""; recorded = strings.TrimSpace(recorded) {
+		quoted, err := strconv.QuotedPrefix(recorded)
+		if err != nil {
+			return nil, errorf("invalid flags %q", recorded)
+		}
+		flag, _ := strconv.Unquote(quoted)
+		flags = append(flags, flag)
+		recorded = recorded[len(quoted):]
+	}
+	return flags, nil
+}
+
+// resetSession forgets the session recorded in the app directory.
+func resetSession(dir string) error {
+	err := os.Remove(filepath.Join(dir, sessionFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return wrapError(err, "Failed to forget the session")
+}
+
+// givenFlags returns the flags of the command that were set, as "-name=value",
+// other than those of sessionFlags.
+func givenFlags(flags *flag.FlagSet) []string {
+	var given []string
+	flags.Visit(func(f *flag.Flag) {
+		if sessionFlags[f.Name] {
+			return
+		}
+		given = append(given, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	return given
+}
+
+// resumeFlags sets the flags of the session that were not set on the
+// command line.
+func resumeFlags(flags *flag.FlagSet, session []string) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, arg := range session {
+		name, value := strings.TrimLeft(arg, "-"), "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		if set[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return exitf(exitConfigError, "Failed to resume the flag %s of %s: %s.  Run with -reset to forget it.", arg, sessionFile, err)
+		}
+	}
+	return nil
+}
+
+// appDir returns the directory of the app of the import path, or "" if it is
+// not found.
+func appDir(importPath string) string {
+	pkg, err := build.Import(importPath, "", build.FindOnly)
+	if err != nil {
+		return ""
+	}
+	return pkg.Dir
+}
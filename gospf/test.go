@@ -16,7 +16,7 @@ import (
 )
 
 var cmdTest = &Command{
-	UsageLine: "test [import path] [run mode] [suite.method]",
+	UsageLine: "test [-cover] [-coverpkg patterns] [-parallel n] [import path] [run mode] [suite.method]",
 	Short:     "run all tests from the command-line",
 	Long: `
 Run all tests for the Revel app named by the given import path.
@@ -38,26 +38,63 @@ For example, to run all of UserTest:
 or one of UserTest's methods:
 
     gospf test outspoken test UserTest.Test1
+
+The -cover flag measures the coverage of the app's code by the tests: the app
+is built with go build -cover, and its coverage counters are written when it
+is stopped after the tests.  The coverage profile is written to
+test-results/coverage.out, in the format of go test -coverprofile, with an
+HTML report in test-results/coverage.html, and the total is printed.
+
+The -coverpkg flag sets the comma-separated patterns of the packages whose
+coverage is measured, "<import path>/app/..." by default.
+
+The -parallel flag runs the suites with n instances of the app at once, or
+test.parallel of app.conf, each on a free port.  A suite is run by a single
+instance, so the suites must not depend on each other, nor share state
+through the instances, e.g. their database.  Each instance may be given its
+own, with test.instance.env.NAME, which sets the variable NAME in its
+environment, with {n} replaced by the number of the instance, from 1, and
+{port} by its port:
+
+    test.instance.env.DB_SCHEMA = booking_test_{n}
+
+The results are printed in the order of the suites, as they would be without
+-parallel.  The output of each instance is written to test-results/app-n.log
+rather than test-results/app.log.
 `,
 }
 
+// testParallel is the number of app instances that run the suites, or zero
+// for test.parallel.
+var testParallel int
+
 func init() {
 	cmdTest.Run = testApp
+	cmdTest.Flag.BoolVar(&harness.Cover, "cover", false, "measure the coverage of the app's code")
+	cmdTest.Flag.StringVar(&harness.CoverPkg, "coverpkg", "", "comma-separated patterns of the packages to measure")
+	cmdTest.Flag.IntVar(&testParallel, "parallel", 0, "the number of app instances that run the suites")
 }
 
-func testApp(args []string) {
+func testApp(args []string) error {
 	var err error
 	if len(args) == 0 {
-		errorf("No import path given.\nRun 'gospf help test' for usage.\n")
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help test' for usage.\n")
 	}
 
-	mode := "dev"
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	mode := defaultMode("dev")
 	if len(args) >= 2 {
 		mode = args[1]
 	}
 
 	// Find and parse app.conf
-	gospf.Init(mode, args[0], "")
+	if err := buildError(harness.InitApp(mode, importPath)); err != nil {
+		return err
+	}
 
 	// Ensure that the testrunner is loaded in this mode.
 	testRunnerFound := false
@@ -68,7 +105,7 @@ func testApp(args []string) {
 		}
 	}
 	if !testRunnerFound {
-		errorf(`Error: The testrunner module is not running.
+		return exitf(exitConfigError, `Error: The testrunner module is not running.
 
 You can add it to a run mode configuration with the following line:
 
@@ -80,31 +117,37 @@ You can add it to a run mode configuration with the following line:
 	// Create a directory to hold the test result files.
 	resultPath := path.Join(gospf.BasePath, "test-results")
 	if err = os.RemoveAll(resultPath); err != nil {
-		errorf("Failed to remove test result directory %s: %s", resultPath, err)
+		return errorf("Failed to remove test result directory %s: %s", resultPath, err)
 	}
 	if err = os.Mkdir(resultPath, 0777); err != nil {
-		errorf("Failed to create test result directory %s: %s", resultPath, err)
-	}
-
-	// Direct all the output into a file in the test-results directory.
-	file, err := os.OpenFile(path.Join(resultPath, "app.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		errorf("Failed to create log file: %s", err)
+		return errorf("Failed to create test result directory %s: %s", resultPath, err)
 	}
 
 	app, reverr := harness.Build()
-	if reverr != nil {
-		errorf("Error building: %s", reverr)
+	if err := buildError(reverr); err != nil {
+		return err
+	}
+	coverDir := path.Join(resultPath, "covdata")
+	if harness.Cover {
+		if err = os.Mkdir(coverDir, 0777); err != nil {
+			return errorf("Failed to create coverage directory %s: %s", coverDir, err)
+		}
 	}
-	cmd := app.Cmd()
-	cmd.Stderr = io.MultiWriter(cmd.Stderr, file)
-	cmd.Stdout = io.MultiWriter(cmd.Stderr, file)
 
-	// Start the app...
-	if err := cmd.Start(); err != nil {
-		errorf("%s", err)
+	// Start the app...  The other instances, if any, are started once the
+	// suites to run are known.
+	instances := harness.NewTestInstances(harness.TestParallelism(testParallel))
+	var cmds []harness.AppCmd
+	defer func() {
+		for _, cmd := range cmds {
+			cmd.Kill()
+		}
+	}()
+	cmd, err := startTestInstance(app.BinaryPath, instances[0], len(instances) > 1, resultPath, coverDir)
+	if err != nil {
+		return err
 	}
-	defer cmd.Kill()
+	cmds = append(cmds, cmd)
 	gospf.INFO.Printf("Testing %s (%s) in %s mode\n", gospf.AppName, gospf.ImportPath, mode)
 
 	// Get a list of tests.
@@ -113,7 +156,7 @@ You can add it to a run mode configuration with the following line:
 	var (
 		testSuites []controllers.TestSuiteDesc
 		resp       *http.Response
-		baseUrl    = fmt.Sprintf("http://127.0.0.1:%d", gospf.HttpPort)
+		baseUrl    = fmt.Sprintf("http://127.0.0.1:%d", instances[0].Port)
 	)
 	for i := 0; ; i++ {
 		if resp, err = http.Get(baseUrl + "/@tests.list"); err == nil {
@@ -123,104 +166,223 @@ You can add it to a run mode configuration with the following line:
 			time.Sleep(3 * time.Second)
 			continue
 		}
-		errorf("Failed to request test list: %s", err)
+		return errorf("Failed to request test list: %s", err)
 	}
 	defer resp.Body.Close()
 	json.NewDecoder(resp.Body).Decode(&testSuites)
 
 	// If a specific TestSuite[.Method] is specified, only run that suite/test
 	if len(args) == 3 {
-		testSuites = filterTestSuites(testSuites, args[2])
+		if testSuites, err = filterTestSuites(testSuites, args[2]); err != nil {
+			return err
+		}
+	}
+	infof(pluralize(len(testSuites), "\n%d test suite to run.\n", "\n%d test suites to run.\n"), len(testSuites))
+
+	// Start the other instances, no more than there are suites.
+	for len(instances) > 1 && len(instances) > len(testSuites) {
+		instances = instances[:len(instances)-1]
+	}
+	if len(instances) > 1 {
+		type startResult struct {
+			cmd harness.AppCmd
+			err error
+		}
+		started := make(chan startResult, len(instances)-1)
+		for _, instance := range instances[1:] {
+			go func(instance harness.TestInstance) {
+				cmd, err := startTestInstance(app.BinaryPath, instance, true, resultPath, coverDir)
+				started <- startResult{cmd, err}
+			}(instance)
+		}
+		for range instances[1:] {
+			result := <-started
+			if result.err != nil {
+				if err == nil {
+					err = result.err
+				}
+				continue
+			}
+			cmds = append(cmds, result.cmd)
+		}
+		if err != nil {
+			return err
+		}
+		infof("Running them with %d instances of the app.\n", len(instances))
 	}
-	fmt.Printf("\n%d test suite%s to run.\n", len(testSuites), pluralize(len(testSuites), "", "s"))
-	fmt.Println()
 
 	// Load the result template, which we execute for each suite.
 	module, _ := gospf.ModuleByName("testrunner")
 	TemplateLoader := gospf.NewTemplateLoader([]string{path.Join(module.Path, "app", "views")})
 	if err := TemplateLoader.Refresh(); err != nil {
-		errorf("Failed to compile templates: %s", err)
+		return errorf("Failed to compile templates: %s", err)
 	}
 	resultTemplate, err := TemplateLoader.Template("TestRunner/SuiteResult.html")
 	if err != nil {
-		errorf("Failed to load suite result template: %s", err)
+		return errorf("Failed to load suite result template: %s", err)
 	}
 
-	// Run each suite.
+	// Run each suite, with the first instance free.  The output of the suites
+	// is printed in their order, whichever finishes first.
 	var (
-		overallSuccess = true
-		failedResults  []controllers.TestSuiteResult
+		output  = newSuiteOutput(os.Stdout, len(testSuites))
+		results = make([]controllers.TestSuiteResult, len(testSuites))
+		errs    = make([]error, len(testSuites))
+		next    = make(chan int)
 	)
-	for _, suite := range testSuites {
-		// Print the name of the suite we're running.
-		name := suite.Name
-		if len(name) > 22 {
-			name = name[:19] + "..."
-		}
-		fmt.Printf("%-22s", name)
-
-		// Run every test.
-		startTime := time.Now()
-		suiteResult := controllers.TestSuiteResult{Name: suite.Name, Passed: true}
-		for _, test := range suite.Tests {
-			testUrl := baseUrl + "/@tests/" + suite.Name + "/" + test.Name
-			resp, err := http.Get(testUrl)
-			if err != nil {
-				errorf("Failed to fetch test result at url %s: %s", testUrl, err)
+	for _, instance := range instances {
+		go func(baseUrl string) {
+			for i := range next {
+				results[i], errs[i] = runTestSuite(baseUrl, testSuites[i], output.writer(i))
+				output.finish(i)
 			}
-			defer resp.Body.Close()
+		}(fmt.Sprintf("http://127.0.0.1:%d", instance.Port))
+	}
+	for i := range testSuites {
+		next <- i
+	}
+	close(next)
+	output.wait()
 
-			var testResult controllers.TestResult
-			json.NewDecoder(resp.Body).Decode(&testResult)
-			if !testResult.Passed {
-				suiteResult.Passed = false
-			}
-			suiteResult.Results = append(suiteResult.Results, testResult)
+	var (
+		overallSuccess = true
+		failedResults  []controllers.TestSuiteResult
+	)
+	for i, suiteResult := range results {
+		if errs[i] != nil {
+			return errs[i]
 		}
-		overallSuccess = overallSuccess && suiteResult.Passed
-
-		// Print result.  (Just PASSED or FAILED, and the time taken)
-		suiteResultStr, suiteAlert := "PASSED", ""
+		suiteResultStr := "PASSED"
 		if !suiteResult.Passed {
-			suiteResultStr, suiteAlert = "FAILED", "!"
+			overallSuccess = false
+			suiteResultStr = "FAILED"
 			failedResults = append(failedResults, suiteResult)
 		}
-		fmt.Printf("%8s%3s%6ds\n", suiteResultStr, suiteAlert, int(time.Since(startTime).Seconds()))
+
 		// Create the result HTML file.
 		suiteResultFilename := path.Join(resultPath,
-			fmt.Sprintf("%s.%s.html", suite.Name, strings.ToLower(suiteResultStr)))
+			fmt.Sprintf("%s.%s.html", suiteResult.Name, strings.ToLower(suiteResultStr)))
 		suiteResultFile, err := os.Create(suiteResultFilename)
 		if err != nil {
-			errorf("Failed to create result file %s: %s", suiteResultFilename, err)
+			return errorf("Failed to create result file %s: %s", suiteResultFilename, err)
 		}
-		if err = resultTemplate.Render(suiteResultFile, suiteResult); err != nil {
-			errorf("Failed to render result template: %s", err)
+		err = resultTemplate.Render(suiteResultFile, suiteResult)
+		suiteResultFile.Close()
+		if err != nil {
+			return errorf("Failed to render result template: %s", err)
 		}
 	}
 
-	fmt.Println()
+	infof("")
+	if harness.Cover {
+		for _, cmd := range cmds {
+			cmd.Stop(coverStopTimeout)
+		}
+		if err = writeCoverage(coverDir, resultPath); err != nil {
+			return err
+		}
+	}
 	if overallSuccess {
-		writeResultFile(resultPath, "result.passed", "passed")
-		fmt.Println("All Tests Passed.")
-	} else {
-		for _, failedResult := range failedResults {
-			fmt.Printf("Failures:\n")
-			for _, result := range failedResult.Results {
-				if !result.Passed {
-					fmt.Printf("%s.%s\n", failedResult.Name, result.Name)
-					fmt.Printf("%s\n\n", result.ErrorSummary)
-				}
+		if err = writeResultFile(resultPath, "result.passed", "passed"); err != nil {
+			return err
+		}
+		resultf([]interface{}{"result", "passed"}, "All Tests Passed.")
+		return nil
+	}
+
+	for _, failedResult := range failedResults {
+		infof("Failures:")
+		for _, result := range failedResult.Results {
+			if !result.Passed {
+				resultf([]interface{}{"failure", failedResult.Name, result.Name, result.ErrorSummary},
+					"%s.%s\n%s\n", failedResult.Name, result.Name, result.ErrorSummary)
 			}
 		}
-		writeResultFile(resultPath, "result.failed", "failed")
-		errorf("Some tests failed.  See file://%s for results.", resultPath)
 	}
+	if err = writeResultFile(resultPath, "result.failed", "failed"); err != nil {
+		return err
+	}
+	return exitf(exitTestFailure, "Some tests failed.  See file://%s for results.", resultPath)
 }
 
-func writeResultFile(resultPath, name, content string) {
+// startTestInstance starts an instance of the app that runs test suites,
+// logging its output to the test results.
+func startTestInstance(binaryPath string, instance harness.TestInstance, parallel bool, resultPath, coverDir string) (harness.AppCmd, error) {
+	// Direct all the output into a file in the test-results directory.
+	logName := "app.log"
+	if parallel {
+		logName = fmt.Sprintf("app-%d.log", instance.Number)
+	}
+	file, err := os.OpenFile(path.Join(resultPath, logName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return harness.AppCmd{}, errorf("Failed to create log file: %s", err)
+	}
+
+	app := harness.NewApp(binaryPath)
+	if parallel {
+		app.Port = instance.Port
+	}
+	cmd := app.Cmd()
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, file)
+	cmd.Stdout = io.MultiWriter(cmd.Stderr, file)
+	cmd.Env = append(cmd.Env, instance.Env...)
+	if harness.Cover {
+		cmd.Env = append(cmd.Env, "GOCOVERDIR="+coverDir)
+	}
+	if err := cmd.Start(); err != nil {
+		return harness.AppCmd{}, errorf("%s", err)
+	}
+	return cmd, nil
+}
+
+// runTestSuite runs the tests of the suite with the app instance at the URL,
+// and prints its result to the writer.
+func runTestSuite(baseUrl string, suite controllers.TestSuiteDesc, w io.Writer) (controllers.TestSuiteResult, error) {
+	// Print the name of the suite we're running.
+	name := suite.Name
+	if len(name) > 22 {
+		name = name[:19] + "..."
+	}
+	if !porcelainOutput {
+		fmt.Fprintf(w, "%-22s", name)
+	}
+
+	// Run every test.
+	startTime := time.Now()
+	suiteResult := controllers.TestSuiteResult{Name: suite.Name, Passed: true}
+	for _, test := range suite.Tests {
+		testUrl := baseUrl + "/@tests/" + suite.Name + "/" + test.Name
+		resp, err := http.Get(testUrl)
+		if err != nil {
+			return suiteResult, errorf("Failed to fetch test result at url %s: %s", testUrl, err)
+		}
+
+		var testResult controllers.TestResult
+		json.NewDecoder(resp.Body).Decode(&testResult)
+		resp.Body.Close()
+		if !testResult.Passed {
+			suiteResult.Passed = false
+		}
+		suiteResult.Results = append(suiteResult.Results, testResult)
+	}
+
+	// Print result.  (Just PASSED or FAILED, and the time taken)
+	suiteResultStr, suiteAlert := "PASSED", ""
+	if !suiteResult.Passed {
+		suiteResultStr, suiteAlert = "FAILED", "!"
+	}
+	// The suite name was printed above, when it started running.
+	elapsed := int(time.Since(startTime).Seconds())
+	fresultf(w, []interface{}{"suite", suite.Name, suiteResultStr, elapsed},
+		"%8s%3s%6ds", suiteResultStr, suiteAlert, elapsed)
+	return suiteResult, nil
+}
+
+func writeResultFile(resultPath, name, content string) error {
 	if err := ioutil.WriteFile(path.Join(resultPath, name), []byte(content), 0666); err != nil {
-		errorf("Failed to write result file %s: %s", path.Join(resultPath, name), err)
+		return errorf("Failed to write result file %s: %s", path.Join(resultPath, name), err)
 	}
+	return nil
 }
 
 func pluralize(num int, singular, plural string) string {
@@ -232,12 +394,12 @@ func pluralize(num int, singular, plural string) string {
 
 // Filters test suites and individual tests to match
 // the parsed command line parameter
-func filterTestSuites(suites []controllers.TestSuiteDesc, suiteArgument string) []controllers.TestSuiteDesc {
+func filterTestSuites(suites []controllers.TestSuiteDesc, suiteArgument string) ([]controllers.TestSuiteDesc, error) {
 	var suiteName, testName string
 	argArray := strings.Split(suiteArgument, ".")
 	suiteName = argArray[0]
 	if suiteName == "" {
-		return suites
+		return suites, nil
 	}
 	if len(argArray) == 2 {
 		testName = argArray[1]
@@ -247,7 +409,7 @@ func filterTestSuites(suites []controllers.TestSuiteDesc, suiteArgument string)
 			continue
 		}
 		if testName == "" {
-			return []controllers.TestSuiteDesc{suite}
+			return []controllers.TestSuiteDesc{suite}, nil
 		}
 		// Only run a particular test in a suite
 		for _, test := range suite.Tests {
@@ -259,10 +421,9 @@ func filterTestSuites(suites []controllers.TestSuiteDesc, suiteArgument string)
 					Name:  suite.Name,
 					Tests: []controllers.TestDesc{test},
 				},
-			}
+			}, nil
 		}
-		errorf("Couldn't find test %s in suite %s", testName, suiteName)
+		return nil, exitf(exitUsage, "Couldn't find test %s in suite %s", testName, suiteName)
 	}
-	errorf("Couldn't find test suite %s", suiteName)
-	return nil
+	return nil, exitf(exitUsage, "Couldn't find test suite %s", suiteName)
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// coverStopTimeout is how long the app is given to write its coverage
+// counters when it is stopped after the tests.
+const coverStopTimeout = 10 * time.Second
+
+// writeCoverage merges the coverage counters written by the app to coverDir
+// into resultPath/coverage.out, renders them as resultPath/coverage.html, and
+// prints the total coverage.
+func writeCoverage(coverDir, resultPath string) error {
+	profile := path.Join(resultPath, "coverage.out")
+	if out, err := exec.Command("go", "tool", "covdata", "textfmt", "-i="+coverDir, "-o="+profile).CombinedOutput(); err != nil {
+		return errorf("Failed to merge the coverage counters of the app: %s\n%s", err, out)
+	}
+	report := path.Join(resultPath, "coverage.html")
+	if out, err := exec.Command("go", "tool", "cover", "-html="+profile, "-o="+report).CombinedOutput(); err != nil {
+		return errorf("Failed to render the coverage report: %s\n%s", err, out)
+	}
+
+	out, err := exec.Command("go", "tool", "cover", "-func="+profile).Output()
+	if err != nil {
+		return errorf("Failed to compute the total coverage: %s", err)
+	}
+	// The last line is e.g. "total:	(statements)	73.2%".
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) > 0 {
+		total := fields[len(fields)-1]
+		resultf([]interface{}{"coverage", total, report}, "Coverage: %s of statements.  See file://%s", total, report)
+	}
+	return nil
+}
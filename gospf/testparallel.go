@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// suiteOutput prints the output of the test suites run at once in the order
+// of the suites, as if they ran one after the other: the output of the first
+// suite not finished is printed as it is written, and that of the suites
+// after it is held until it finishes.
+type suiteOutput struct {
+	out      io.Writer
+	finished chan struct{} // Closed when all the suites are finished.
+
+	mu      sync.Mutex
+	current int // The first suite not finished.
+	held    []bytes.Buffer
+	done    []bool
+}
+
+// newSuiteOutput returns the output of n suites, printed to out.
+func newSuiteOutput(out io.Writer, n int) *suiteOutput {
+	o := &suiteOutput{
+		out:      out,
+		finished: make(chan struct{}),
+		held:     make([]bytes.Buffer, n),
+		done:     make([]bool, n),
+	}
+	if n == 0 {
+		close(o.finished)
+	}
+	return o
+}
+
+// writer returns the writer of the output of the i-th suite.
+func (o *suiteOutput) writer(i int) io.Writer {
+	return suiteWriter{o, i}
+}
+
+// finish records that the i-th suite is finished, and prints the output held
+// of the suites after it, up to the next one not finished.
+func (o *suiteOutput) finish(i int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.done[i] = true
+	for o.current < len(o.done) && o.done[o.current] {
+		o.current++
+		if o.current < len(o.held) {
+			o.out.Write(o.held[o.current].Bytes())
+			o.held[o.current].Reset()
+		}
+	}
+	if o.current == len(o.done) {
+		close(o.finished)
+	}
+}
+
+// wait waits until all the suites are finished.
+func (o *suiteOutput) wait() {
+	<-o.finished
+}
+
+// suiteWriter writes the output of a suite.
+type suiteWriter struct {
+	o *suiteOutput
+	i int
+}
+
+func (w suiteWriter) Write(p []byte) (int, error) {
+	w.o.mu.Lock()
+	defer w.o.mu.Unlock()
+	if w.i == w.o.current {
+		return w.o.out.Write(p)
+	}
+	return w.o.held[w.i].Write(p)
+}
@@ -1,84 +1,90 @@
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
 	"github.com/hubply/gospf"
 )
 
-// Use a wrapper to differentiate logged panics from unexpected ones.
-type LoggedError struct{ error }
-
-func panicOnError(err error, msg string) {
-	if revErr, ok := err.(*gospf.Error); (ok && revErr != nil) || (!ok && err != nil) {
-		fmt.Fprintf(os.Stderr, "Abort: %s: %s\n", msg, err)
-		panic(LoggedError{err})
-	}
-}
-
-func mustCopyFile(destFilename, srcFilename string) {
+func copyFile(destFilename, srcFilename string) error {
 	destFile, err := os.Create(destFilename)
-	panicOnError(err, "Failed to create file "+destFilename)
+	if err != nil {
+		return wrapError(err, "Failed to create file "+destFilename)
+	}
+	defer destFile.Close()
 
 	srcFile, err := os.Open(srcFilename)
-	panicOnError(err, "Failed to open file "+srcFilename)
-
-	_, err = io.Copy(destFile, srcFile)
-	panicOnError(err,
-		fmt.Sprintf("Failed to copy data from %s to %s", srcFile.Name(), destFile.Name()))
+	if err != nil {
+		return wrapError(err, "Failed to open file "+srcFilename)
+	}
+	defer srcFile.Close()
 
-	err = destFile.Close()
-	panicOnError(err, "Failed to close file "+destFile.Name())
+	if _, err = io.Copy(destFile, srcFile); err != nil {
+		return wrapError(err,
+			fmt.Sprintf("Failed to copy data from %s to %s", srcFile.Name(), destFile.Name()))
+	}
 
-	err = srcFile.Close()
-	panicOnError(err, "Failed to close file "+srcFile.Name())
+	return wrapError(destFile.Close(), "Failed to close file "+destFile.Name())
 }
 
-func mustRenderTemplate(destPath, srcPath string, data map[string]interface{}) {
+func renderTemplate(destPath, srcPath string, data map[string]interface{}) error {
 	tmpl, err := template.ParseFiles(srcPath)
-	panicOnError(err, "Failed to parse template "+srcPath)
+	if err != nil {
+		return wrapError(err, "Failed to parse template "+srcPath)
+	}
 
 	f, err := os.Create(destPath)
-	panicOnError(err, "Failed to create "+destPath)
+	if err != nil {
+		return wrapError(err, "Failed to create "+destPath)
+	}
+	defer f.Close()
 
-	err = tmpl.Execute(f, data)
-	panicOnError(err, "Failed to render template "+srcPath)
+	if err = tmpl.Execute(f, data); err != nil {
+		return wrapError(err, "Failed to render template "+srcPath)
+	}
 
-	err = f.Close()
-	panicOnError(err, "Failed to close "+f.Name())
+	return wrapError(f.Close(), "Failed to close "+f.Name())
 }
 
-func mustChmod(filename string, mode os.FileMode) {
-	err := os.Chmod(filename, mode)
-	panicOnError(err, fmt.Sprintf("Failed to chmod %d %q", mode, filename))
+func chmod(filename string, mode os.FileMode) error {
+	return wrapError(os.Chmod(filename, mode), fmt.Sprintf("Failed to chmod %d %q", mode, filename))
 }
 
 // copyDir copies a directory tree over to a new directory.  Any files ending in
 // ".template" are treated as a Go template and rendered using the given data.
 // Additionally, the trailing ".template" is stripped from the file name.
 // Also, dot files and dot directories are skipped.
-func mustCopyDir(destDir, srcDir string, data map[string]interface{}) error {
+func copyDir(destDir, srcDir string, data map[string]interface{}) error {
+	return copyDirFiltered(destDir, srcDir, data, nil)
+}
+
+// copyDirFiltered is copyDir, which also skips the files left out by the
+// filter, if given.
+func copyDirFiltered(destDir, srcDir string, data map[string]interface{}, filter *packageFilter) error {
 	var fullSrcDir string
 	// Handle symlinked directories.
 	f, err := os.Lstat(srcDir)
 	if err == nil && f.Mode()&os.ModeSymlink == os.ModeSymlink {
 		fullSrcDir, err = os.Readlink(srcDir)
 		if err != nil {
-			panic(err)
+			return wrapError(err, "Failed to read link "+srcDir)
 		}
 	} else {
 		fullSrcDir = srcDir
 	}
 
 	return filepath.Walk(fullSrcDir, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return wrapError(err, "Failed to read "+srcPath)
+		}
+
 		// Get the relative path from the source base, and the corresponding path in
 		// the dest directory.
 		relSrcPath := strings.TrimLeft(srcPath[len(fullSrcDir):], string(os.PathSeparator))
@@ -92,64 +98,42 @@ func mustCopyDir(destDir, srcDir string, data map[string]interface{}) error {
 			return nil
 		}
 
-		// Create a subdirectory if necessary.
+		if filter != nil && relSrcPath != "" && filter.skip(filepath.ToSlash(relSrcPath), info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Create a subdirectory if necessary.  An excluded directory that is
+		// only walked for the files the include patterns bring back is
+		// created with the first of them, so that it is not left empty.
 		if info.IsDir() {
+			if filter != nil && relSrcPath != "" && filter.excludes(filepath.ToSlash(relSrcPath)) {
+				return nil
+			}
 			err := os.MkdirAll(path.Join(destDir, relSrcPath), 0777)
 			if !os.IsExist(err) {
-				panicOnError(err, "Failed to create directory")
+				return wrapError(err, "Failed to create directory")
 			}
 			return nil
 		}
+		if filter != nil {
+			if err := os.MkdirAll(path.Dir(destPath), 0777); err != nil {
+				return wrapError(err, "Failed to create directory")
+			}
+		}
 
 		// If this file ends in ".template", render it as a template.
 		if strings.HasSuffix(relSrcPath, ".template") {
-			mustRenderTemplate(destPath[:len(destPath)-len(".template")], srcPath, data)
-			return nil
+			return renderTemplate(destPath[:len(destPath)-len(".template")], srcPath, data)
 		}
 
 		// Else, just copy it over.
-		mustCopyFile(destPath, srcPath)
-		return nil
+		return copyFile(destPath, srcPath)
 	})
 }
 
-func mustTarGzDir(destFilename, srcDir string) string {
-	zipFile, err := os.Create(destFilename)
-	panicOnError(err, "Failed to create archive")
-	defer zipFile.Close()
-
-	gzipWriter := gzip.NewWriter(zipFile)
-	defer gzipWriter.Close()
-
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
-
-	filepath.Walk(srcDir, func(srcPath string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-
-		srcFile, err := os.Open(srcPath)
-		panicOnError(err, "Failed to read source file")
-		defer srcFile.Close()
-
-		err = tarWriter.WriteHeader(&tar.Header{
-			Name:    strings.TrimLeft(srcPath[len(srcDir):], string(os.PathSeparator)),
-			Size:    info.Size(),
-			Mode:    int64(info.Mode()),
-			ModTime: info.ModTime(),
-		})
-		panicOnError(err, "Failed to write tar entry header")
-
-		_, err = io.Copy(tarWriter, srcFile)
-		panicOnError(err, "Failed to copy")
-
-		return nil
-	})
-
-	return zipFile.Name()
-}
-
 func exists(filename string) bool {
 	_, err := os.Stat(filename)
 	return err == nil
@@ -157,12 +141,35 @@ func exists(filename string) bool {
 
 // empty returns true if the given directory is empty.
 // the directory must exist.
-func empty(dirname string) bool {
+func empty(dirname string) (bool, error) {
 	dir, err := os.Open(dirname)
 	if err != nil {
-		errorf("error opening directory: %s", err)
+		return false, wrapError(err, "Failed to open directory")
 	}
 	defer dir.Close()
 	results, _ := dir.Readdir(1)
-	return len(results) == 0
+	return len(results) == 0, nil
+}
+
+// configKeys returns the sorted names of the options of the run mode that
+// begin with the given prefix: those of its section and of the DEFAULT one,
+// not those of the other modes.
+func configKeys(prefix string) []string {
+	config := gospf.Config.Raw()
+	found := make(map[string]struct{})
+	for _, section := range []string{"DEFAULT", gospf.RunMode} {
+		options, _ := config.SectionOptions(section)
+		for _, key := range options {
+			if strings.HasPrefix(key, prefix) {
+				found[key] = struct{}{}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
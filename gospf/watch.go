@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdWatch = &Command{
+	UsageLine: "watch [-json] [import path] [run mode]",
+	Short:     "report the compile errors of a Gospf application as it changes",
+	Long: `
+Watch builds the Gospf application named by the given import path, and builds
+it again each time its source changes, as "gospf run" does, but never runs
+it.  The errors of each build are printed on the console as they are found,
+so that an app that needs resources unavailable locally, e.g. a database or
+a message queue, still gets instant compile feedback.
+
+The source is watched as configured by the watch.* keys of app.conf, and the
+app is built as configured by the build.* keys.
+
+For example:
+
+    gospf watch github.com/hubply/samples/booking
+
+Run mode defaults to "dev".
+
+The -json flag writes each build to the standard output as a line of JSON
+instead, as the build events of the harness, e.g. for an editor plugin:
+
+    {"type":"build-succeeded","time":"...","durationMs":1520,"binary":"..."}
+    {"type":"build-failed","time":"...","durationMs":830,"error":{...}}
+
+As with "gospf run", the errors come with a diff of the files changed since
+the last successful build, unless harness.builddiff=false.
+
+The command runs until interrupted.
+`,
+}
+
+var watchJSON bool
+
+func init() {
+	cmdWatch.Run = watchCommand
+	cmdWatch.Flag.BoolVar(&watchJSON, "json", false, "write each build as a line of JSON")
+}
+
+func watchCommand(args []string) error {
+	if len(args) == 0 {
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help watch' for usage.\n")
+	}
+
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	mode := defaultMode("dev")
+	if len(args) >= 2 {
+		mode = args[1]
+	}
+	if err := buildError(harness.InitApp(mode, importPath)); err != nil {
+		return err
+	}
+
+	gospf.INFO.Printf("Watching %s (%s) in %s mode\n", gospf.AppName, gospf.ImportPath, mode)
+	encoder := json.NewEncoder(os.Stdout)
+	harness.WatchBuilds(func(result harness.BuildResult) {
+		// The errors are printed on the console by the build, and the
+		// standard output is left to the JSON with -json.
+		if watchJSON {
+			encoder.Encode(result)
+		} else if result.Error == nil {
+			infof("Build succeeded in %s", result.Duration.Round(100*time.Millisecond))
+		}
+	})
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/hubply/cmd/harness"
+	"github.com/hubply/gospf"
+)
+
+var cmdWorker = &Command{
+	UsageLine: "worker [-o output] [import path] [run mode]",
+	Short:     "run the jobs of a Gospf application",
+	Long: `
+Worker builds the Gospf application named by the given import path, and runs
+its jobs instead of its server.  The app is built and configured as by
+"gospf run".
+
+The jobs are the structs under app/jobs with a Run() method.  Each job is
+started in its own goroutine, and the worker exits once all of them return.
+Use "gospf generate job" to add one.
+
+The server is not started, so its startup hooks are not run.  Jobs should
+set up the resources they need in Run.
+
+For example:
+
+    gospf worker github.com/hubply/samples/booking prod
+
+Run mode defaults to "dev".
+
+The -o flag sets the path of the built binary, overriding build.output.
+`,
+}
+
+func init() {
+	cmdWorker.Run = runWorker
+	cmdWorker.Flag.StringVar(&harness.OutputPath, "o", "", "path of the built binary")
+}
+
+func runWorker(args []string) error {
+	if len(args) == 0 {
+		return exitf(exitUsage, "No import path given.\nRun 'gospf help worker' for usage.\n")
+	}
+
+	importPath, err := resolveAppPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	mode := defaultMode("dev")
+	if len(args) >= 2 {
+		mode = args[1]
+	}
+	if err := buildError(harness.InitApp(mode, importPath)); err != nil {
+		return err
+	}
+
+	gospf.INFO.Printf("Running the jobs of %s (%s) in %s mode\n", gospf.AppName, gospf.ImportPath, mode)
+	app, reverr := harness.Build()
+	if err := buildError(reverr); err != nil {
+		return err
+	}
+	app.Worker = true
+	app.Cmd().Run()
+	return nil
+}
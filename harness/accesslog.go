@@ -0,0 +1,203 @@
+package harness
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// With harness.accesslog=true, the harness logs each request it serves on the
+// console, with its status, how long it took, and what served it, colored by
+// the class of the status:
+//
+//	GET     /hotels?page=2                200    12ms  new
+//	POST    /login                        303     4ms  old
+//	GET     /favicon.ico                  500     0ms  harness
+//
+// The requests are served by the new build of the app, by the old one while
+// the app is rebuilt, or by the harness itself: its error and progress pages,
+// and those under /_gospf/.  With harness.accesslog.file set to a path,
+// relative to the app directory unless absolute, the requests are appended to
+// the file as well, in the combined log format of Apache and nginx.
+
+// The upstreams of the requests in the access log.
+const (
+	upstreamNew     = "new"
+	upstreamOld     = "old"
+	upstreamHarness = "harness"
+)
+
+// accessLog logs the requests served by the harness.
+type accessLog struct {
+	console io.Writer // The console, or nil if only the file is written.
+
+	mu   sync.Mutex // Protects the writes to the file.
+	file io.WriteCloser
+}
+
+// loadAccessLog returns the access log configured by harness.accesslog.*, or
+// nil if it is not enabled.
+func loadAccessLog() *accessLog {
+	l := &accessLog{}
+	if gospf.Config.BoolDefault("harness.accesslog", false) {
+		l.console = os.Stdout
+	}
+	if filename := gospf.Config.StringDefault("harness.accesslog.file", ""); filename != "" {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(gospf.BasePath, filename)
+		}
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			gospf.WARN.Println("Failed to open the access log:", err)
+		} else {
+			l.file = file
+		}
+	}
+	if l.console == nil && l.file == nil {
+		return nil
+	}
+	return l
+}
+
+// close closes the file of the log, if any.
+func (l *accessLog) close() {
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+// wrap returns the writer that records the response to the request, which
+// is served by the harness unless markUpstream says otherwise.
+func (l *accessLog) wrap(w http.ResponseWriter, r *http.Request) *accessWriter {
+	return &accessWriter{
+		ResponseWriter: w,
+		method:         r.Method,
+		uri:            r.RequestURI,
+		started:        time.Now(),
+		upstream:       upstreamHarness,
+	}
+}
+
+// log logs the request, once served.
+func (l *accessLog) log(w *accessWriter, r *http.Request) {
+	elapsed := time.Since(w.started)
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if l.console != nil {
+		fmt.Fprintln(l.console, consoleLine(w.method, w.uri, status, elapsed, w.upstream))
+	}
+	if l.file != nil {
+		l.mu.Lock()
+		fmt.Fprintln(l.file, combinedLine(r, w.method, w.uri, status, w.size, w.started))
+		l.mu.Unlock()
+	}
+}
+
+// markUpstream records in the access log whether the new or the old build of
+// the app serves the request, if it is logged.
+func markUpstream(w http.ResponseWriter, stale bool) {
+	if aw, ok := w.(*accessWriter); ok {
+		aw.upstream = upstreamNew
+		if stale {
+			aw.upstream = upstreamOld
+		}
+	}
+}
+
+// consoleLine returns the line of the request on the console.
+func consoleLine(method, uri string, status int, elapsed time.Duration, upstream string) string {
+	color := ansiGreen
+	switch {
+	case status >= 500:
+		color = ansiRed
+	case status >= 400:
+		color = ansiYellow
+	case status >= 300:
+		color = ansiCyan
+	}
+	if upstream != upstreamNew {
+		upstream = colorize(ansiGray, upstream)
+	}
+	return fmt.Sprintf("%-7s %-29s %s %6s  %s",
+		method, uri, colorize(color, strconv.Itoa(status)), formatLatency(elapsed), upstream)
+}
+
+// formatLatency formats the time a request took, e.g. "12ms" or "1.4s".
+func formatLatency(elapsed time.Duration) string {
+	if elapsed < time.Second {
+		return fmt.Sprintf("%dms", elapsed/time.Millisecond)
+	}
+	return elapsed.Round(100 * time.Millisecond).String()
+}
+
+// combinedLine returns the line of the request in the combined log format.
+func combinedLine(r *http.Request, method, uri string, status int, size int64, started time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %s %q %q",
+		host, user, started.Format("02/Jan/2006:15:04:05 -0700"),
+		method+" "+uri+" "+r.Proto, status, bytes, r.Referer(), r.UserAgent())
+}
+
+// accessWriter records the status and the size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	method, uri string
+	started     time.Time
+	upstream    string
+	status      int
+	size        int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the connection of a websocket, which the app then answers.
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the response writer does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
@@ -0,0 +1,49 @@
+package harness
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	var console bytes.Buffer
+	l := &accessLog{console: &console}
+	NoColor = true
+	defer func() { NoColor = false }()
+
+	r := httptest.NewRequest("GET", "/hotels?page=2", nil)
+	rec := httptest.NewRecorder()
+	w := l.wrap(rec, r)
+	markUpstream(w, true)
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("not found"))
+	l.log(w, r)
+
+	line := console.String()
+	for _, expected := range []string{"GET", "/hotels?page=2", "404", "old"} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("Expected %q in %q", expected, line)
+		}
+	}
+	if w.size != int64(len("not found")) {
+		t.Errorf("Expected a size of %d, got %d", len("not found"), w.size)
+	}
+}
+
+func TestCombinedLine(t *testing.T) {
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "10.0.0.7:51234"
+	r.Header.Set("Referer", "http://localhost:9000/")
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.SetBasicAuth("alice", "secret")
+	started := time.Date(2024, time.March, 5, 14, 2, 9, 0, time.UTC)
+
+	expected := `10.0.0.7 - alice [05/Mar/2024:14:02:09 +0000] "POST /login HTTP/1.1" 303 - "http://localhost:9000/" "curl/8.0"`
+	if line := combinedLine(r, r.Method, r.RequestURI, http.StatusSeeOther, 0, started); line != expected {
+		t.Errorf("Expected %s, got %s", expected, line)
+	}
+}
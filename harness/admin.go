@@ -0,0 +1,181 @@
+package harness
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// The admin API lets editor plugins and scripts drive the harness without
+// touching a file.  It is enabled by setting harness.admin.token, which the
+// requests must send as "Authorization: Bearer <token>":
+//
+//	POST /@gospf/rebuild   rebuilds the app, even if its source is unchanged
+//	POST /@gospf/restart   restarts the app instances, without rebuilding
+//	POST /@gospf/clean     removes the generated code, the source cache and
+//	                       the binary, and rebuilds the app from scratch
+//
+// Each responds once done with the result as JSON, e.g.
+//
+//	{"action": "rebuild", "ok": false, "durationMs": 1520,
+//	 "error": {"title": "Go Compilation Error", "path": "app/controllers/app.go",
+//	           "line": 12, "column": 2, "description": "undefined: x"}}
+//
+// The API is served by the proxy, whatever the run mode, and is not
+// available without it.
+
+const adminPathPrefix = "/@gospf/"
+
+// adminAPI serves the admin API, running its actions one at a time.
+type adminAPI struct {
+	token   string
+	actions map[string]func() *gospf.Error
+}
+
+// adminResult is the JSON response of an action.
+type adminResult struct {
+	Action     string      `json:"action"`
+	OK         bool        `json:"ok"`
+	DurationMs int64       `json:"durationMs"`
+	Error      *adminError `json:"error,omitempty"`
+}
+
+type adminError struct {
+	Title       string `json:"title"`
+	Path        string `json:"path,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Column      int    `json:"column,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// newAdminError returns the JSON of the error.
+func newAdminError(err *gospf.Error) *adminError {
+	return &adminError{
+		Title:       err.Title,
+		Path:        err.Path,
+		Line:        err.Line,
+		Column:      err.Column,
+		Description: err.Description,
+	}
+}
+
+// loadAdminAPI returns the admin API of the harness, or nil if
+// harness.admin.token is not set.
+func loadAdminAPI(h *Harness) *adminAPI {
+	token := gospf.Config.StringDefault("harness.admin.token", "")
+	if token == "" {
+		return nil
+	}
+	return &adminAPI{
+		token: token,
+		actions: map[string]func() *gospf.Error{
+			"rebuild": h.forceRebuild,
+			"restart": h.restart,
+			"clean":   h.clean,
+		},
+	}
+}
+
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(bearer), []byte(a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gospf"`)
+		http.Error(w, "Unauthorized: send harness.admin.token as a bearer token.", http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, adminPathPrefix)
+	action, found := a.actions[name]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gospf.INFO.Printf("Admin API: %s", name)
+	started := time.Now()
+	err := action()
+	result := adminResult{
+		Action:     name,
+		OK:         err == nil,
+		DurationMs: time.Since(started).Nanoseconds() / int64(time.Millisecond),
+	}
+	if err != nil {
+		result.Error = newAdminError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// forceRebuild rebuilds and restarts the app, whether or not its source
+// changed.  It waits for a rebuild in progress, which may predate the
+// request, and then runs its own.
+func (h *Harness) forceRebuild() *gospf.Error {
+	for {
+		var ran bool
+		err := h.builds.join(func() *gospf.Error {
+			ran = true
+			err := h.Refresh()
+			h.setBuildError(err)
+			return err
+		}).wait()
+		if ran {
+			return err
+		}
+	}
+}
+
+// restart restarts the app instances on the binary they run, or rebuilds the
+// app if it is not running.
+func (h *Harness) restart() *gospf.Error {
+	var binaryPath string
+	for _, b := range h.backends() {
+		if app := b.currentApp(); app != nil {
+			binaryPath = app.BinaryPath
+		}
+	}
+	if binaryPath == "" {
+		return h.forceRebuild()
+	}
+
+	h.refreshMu.Lock()
+	defer h.refreshMu.Unlock()
+	h.swap.Lock()
+	defer h.swap.Unlock()
+	h.kill()
+	for _, b := range h.backends() {
+		if err := b.start(binaryPath); err != nil {
+			return err
+		}
+		atomic.StoreInt32(&b.restarting, 0)
+	}
+	h.events.publish(eventAppRestarted, restartEvent{Binary: binaryPath, Reason: "restart"})
+	return nil
+}
+
+// clean removes the generated code, the source cache and the binary of the
+// app, and rebuilds it.
+func (h *Harness) clean() *gospf.Error {
+	h.refreshMu.Lock()
+	cleanSource("tmp", "routes")
+	cleanCodePathRoutes()
+	os.Remove(sourceCachePath())
+	cache = nil
+	if err := os.Remove(BinaryPath()); err != nil && !os.IsNotExist(err) {
+		gospf.WARN.Println("Failed to remove the binary:", err)
+	}
+	h.refreshMu.Unlock()
+	return h.forceRebuild()
+}
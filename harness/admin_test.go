@@ -0,0 +1,68 @@
+package harness
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hubply/gospf"
+)
+
+func TestAdminAPI(t *testing.T) {
+	var rebuilds int
+	api := &adminAPI{
+		token: "secret",
+		actions: map[string]func() *gospf.Error{
+			"rebuild": func() *gospf.Error {
+				rebuilds++
+				return nil
+			},
+			"clean": func() *gospf.Error {
+				return &gospf.Error{Title: "Go Compilation Error", Path: "app/controllers/app.go", Line: 12}
+			},
+		},
+	}
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, token := range []string{"", "wrong", "secrets"} {
+		if w := serve("POST", "/@gospf/rebuild", token); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the token %q to be refused, got %d", token, w.Code)
+		}
+	}
+	if w := serve("GET", "/@gospf/rebuild", "secret"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be refused, got %d", w.Code)
+	}
+	if w := serve("POST", "/@gospf/deploy", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown action to be not found, got %d", w.Code)
+	}
+	if rebuilds != 0 {
+		t.Fatalf("Expected no rebuild yet, got %d", rebuilds)
+	}
+
+	var result adminResult
+	w := serve("POST", "/@gospf/rebuild", "secret")
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %q: %v", w.Code, w.Body, err)
+	}
+	if rebuilds != 1 || result.Action != "rebuild" || !result.OK || result.Error != nil {
+		t.Errorf("Unexpected result %+v after %d rebuilds", result, rebuilds)
+	}
+
+	result = adminResult{}
+	w = serve("POST", "/@gospf/clean", "secret")
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Unexpected response %d %q: %v", w.Code, w.Body, err)
+	}
+	if result.OK || result.Error == nil || result.Error.Path != "app/controllers/app.go" || result.Error.Line != 12 {
+		t.Errorf("Expected the build error in the result, got %+v", result)
+	}
+}
@@ -2,21 +2,35 @@ package harness
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/hubply/gospf"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // App contains the configuration for running a Revel app.  (Not for the app itself)
 // Its only purpose is constructing the command to execute.
 type App struct {
-	BinaryPath string // Path to the app executable
-	Port       int    // Port to pass as a command line argument.
-	cmd        AppCmd // The last cmd returned.
+	BinaryPath string        // Path to the app executable
+	Port       int           // Port to pass as a command line argument.
+	Addr       string        // Address to pass as a command line argument, if set.
+	Socket     string        // Unix socket to listen on instead of the port, if set.
+	Listener   *os.File      // Listener to serve instead of the port, handed to the app, if set.
+	RunMode    string        // Run mode to pass as a command line argument, if not gospf.RunMode.
+	Worker     bool          // Run the app's jobs instead of its server.
+	SrcPath    string        // Source root to pass as a command line argument, if not the GOPATH.
+	Summary    *BuildSummary // The summary of the build of the binary, if built.
+	cmd        AppCmd        // The last cmd returned.
+	forwardTo  string        // The unix socket the last cmd forwards the connections of Listener to.
+	sockets    int32         // The number of websockets proxied to the app.
+	requests   int32         // The number of other requests proxied to the app.
 }
 
 func NewApp(binPath string) *App {
@@ -25,7 +39,32 @@ func NewApp(binPath string) *App {
 
 // Return a command to run the app server using the current configuration.
 func (a *App) Cmd() AppCmd {
-	a.cmd = NewAppCmd(a.BinaryPath, a.Port)
+	runMode := a.RunMode
+	if runMode == "" {
+		runMode = gospf.RunMode
+	}
+	a.cmd = newAppCmd(a.BinaryPath, a.Port, runMode)
+	if a.Worker {
+		a.cmd.Args = append(a.cmd.Args, "-worker")
+	}
+	if a.SrcPath != "" {
+		a.cmd.Args = append(a.cmd.Args, "-srcPath="+a.SrcPath)
+	}
+	if a.Addr != "" {
+		a.cmd.Args = append(a.cmd.Args, "-addr="+a.Addr)
+	}
+	if a.Socket != "" {
+		a.cmd.Args = append(a.cmd.Args, "-socket="+a.Socket)
+	}
+	a.forwardTo = ""
+	if a.Listener != nil {
+		// The first of the extra files is the file descriptor 3.  The app
+		// forwards its connections to the socket, which is probed for it
+		// to be ready, since the listener accepts them before it is.
+		a.forwardTo = newSocketPath()
+		a.cmd.ExtraFiles = []*os.File{a.Listener}
+		a.cmd.Args = append(a.cmd.Args, "-listenerFd=3", "-socket="+a.forwardTo)
+	}
 	return a.cmd
 }
 
@@ -34,71 +73,239 @@ func (a *App) Kill() {
 	a.cmd.Kill()
 }
 
+// Exited returns a channel that is closed when the last app command returned
+// exits, after it was started.
+func (a *App) Exited() <-chan struct{} {
+	return a.cmd.state.exited
+}
+
+// Killed reports whether the last app command returned was killed on purpose,
+// rather than exiting on its own.
+func (a *App) Killed() bool {
+	return atomic.LoadInt32(&a.cmd.state.killed) != 0
+}
+
+// ExitError describes the exit of the last app command returned, with the
+// last output it wrote to stderr.
+func (a *App) ExitError() *gospf.Error {
+	if err, ok := a.cmd.state.limit.Load().(*gospf.Error); ok {
+		return err
+	}
+	code := -1
+	if a.cmd.ProcessState != nil {
+		code = a.cmd.ProcessState.ExitCode()
+	}
+	return &gospf.Error{
+		Title:       "App Exited",
+		Description: fmt.Sprintf("The app exited (code %d).\n\n%s", code, a.cmd.state.stderr),
+	}
+}
+
+// killOverLimit kills the last app command returned, since it exceeded a
+// limit of its resources, which ExitError then describes.  Unlike Kill, the
+// app is not killed on purpose.
+func (a *App) killOverLimit(err *gospf.Error) {
+	a.cmd.state.limit.Store(err)
+	if a.cmd.Cmd != nil && a.cmd.Process != nil {
+		killProcessTree(a.cmd.Process)
+	}
+}
+
+// pid returns the process id of the last app command returned, or 0 if it
+// was not started.
+func (a *App) pid() int {
+	if a.cmd.Cmd == nil || a.cmd.Process == nil {
+		return 0
+	}
+	return a.cmd.Process.Pid
+}
+
+// ReloadTemplates asks the last app command returned to refresh its templates.
+func (a *App) ReloadTemplates() error {
+	return a.cmd.ReloadTemplates()
+}
+
 // AppCmd manages the running of a Revel app server.
-// It requires revel.Init to have been called previously.
+// It requires gospf.Init to have been called previously.
 type AppCmd struct {
 	*exec.Cmd
+	state *appState
 }
 
+// appState tracks the process of an app command.  It is shared by the copies
+// of the AppCmd.
+type appState struct {
+	exited  chan struct{} // Closed when the process exits.
+	killed  int32         // Set when the process is killed on purpose.
+	stderr  *tailWriter   // The last output of the process on stderr.
+	prompts *promptWriter // The prompt the process waits on as it starts.
+	limit   atomic.Value  // The *gospf.Error of the limit the process exceeded.
+}
+
+// stderrTailSize is how much of the app's output to stderr is kept to
+// describe why it exited.
+const stderrTailSize = 8 << 10
+
+// appStartTimeout is how long the app server has to start, not counting the
+// time it waits for input.
+const appStartTimeout = 30 * time.Second
+
 func NewAppCmd(binPath string, port int) AppCmd {
+	return newAppCmd(binPath, port, gospf.RunMode)
+}
+
+func newAppCmd(binPath string, port int, runMode string) AppCmd {
 	cmd := exec.Command(binPath,
 		fmt.Sprintf("-port=%d", port),
-		fmt.Sprintf("-importPath=%s", revel.ImportPath),
-		fmt.Sprintf("-runMode=%s", revel.RunMode))
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	return AppCmd{cmd}
+		fmt.Sprintf("-importPath=%s", gospf.ImportPath),
+		fmt.Sprintf("-runMode=%s", runMode))
+	state := &appState{
+		exited:  make(chan struct{}),
+		stderr:  &tailWriter{max: stderrTailSize},
+		prompts: &promptWriter{},
+	}
+	cmd.Stdout = io.MultiWriter(os.Stdout, state.prompts)
+	cmd.Stderr = io.MultiWriter(os.Stderr, state.stderr, state.prompts)
+	if Interactive {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Env = withMemoryLimit(appEnv(), loadRunLimits())
+	return AppCmd{cmd, state}
 }
 
 // Start the app server, and wait until it is ready to serve requests.
 func (cmd AppCmd) Start() error {
-	listeningWriter := startupListeningWriter{os.Stdout, make(chan bool)}
+	return cmd.StartContext(context.Background())
+}
+
+// StartContext is like Start, but kills the app server and gives up if the
+// context is done before the server is ready.  The prompts of the server on
+// startup are reported, and it does not time out while it waits for input.
+func (cmd AppCmd) StartContext(ctx context.Context) error {
+	listeningWriter := startupListeningWriter{cmd.Stdout, make(chan bool)}
 	cmd.Stdout = listeningWriter
-	revel.TRACE.Println("Exec app:", cmd.Path, cmd.Args)
+	setProcessGroup(cmd.Cmd)
+	gospf.TRACE.Println("Exec app:", cmd.Path, cmd.Args)
 	if err := cmd.Cmd.Start(); err != nil {
-		revel.ERROR.Fatalln("Error running:", err)
+		return fmt.Errorf("gospf/harness: error running app: %s", err)
 	}
+	applyRunLimits(cmd.Process.Pid, loadRunLimits())
 
-	select {
-	case <-cmd.waitChan():
-		return errors.New("revel/harness: app died")
+	exited := cmd.waitChan()
+	timeout := time.NewTimer(appStartTimeout)
+	defer timeout.Stop()
+	poll := time.NewTicker(promptIdle)
+	defer poll.Stop()
+	var reported string
+	for {
+		select {
+		case <-exited:
+			return fmt.Errorf("gospf/harness: app died\n\n%s", cmd.state.stderr)
 
-	case <-time.After(30 * time.Second):
-		cmd.Kill()
-		return errors.New("revel/harness: app timed out")
+		case <-timeout.C:
+			if cmd.state.prompts.prompt() != "" {
+				timeout.Reset(appStartTimeout)
+				continue
+			}
+			cmd.Kill()
+			return errors.New("gospf/harness: app timed out")
 
-	case <-listeningWriter.notifyReady:
-		return nil
+		case <-poll.C:
+			if prompt := cmd.state.prompts.prompt(); prompt != "" && prompt != reported {
+				reported = prompt
+				reportPrompt(prompt)
+			}
+
+		case <-ctx.Done():
+			cmd.Kill()
+			return fmt.Errorf("gospf/harness: app start interrupted: %s", ctx.Err())
+
+		case <-listeningWriter.notifyReady:
+			cmd.state.prompts.start()
+			return nil
+		}
 	}
-	panic("Impossible")
 }
 
 // Run the app server inline.  Never returns.
 func (cmd AppCmd) Run() {
-	revel.TRACE.Println("Exec app:", cmd.Path, cmd.Args)
+	gospf.TRACE.Println("Exec app:", cmd.Path, cmd.Args)
 	if err := cmd.Cmd.Run(); err != nil {
-		revel.ERROR.Fatalln("Error running:", err)
+		gospf.ERROR.Fatalln("Error running:", err)
 	}
 }
 
-// Terminate the app server if it's running.
+// Terminate the app server if it's running, along with any processes it
+// started.
 func (cmd AppCmd) Kill() {
-	if cmd.Cmd != nil && (cmd.ProcessState == nil || !cmd.ProcessState.Exited()) {
-		revel.TRACE.Println("Killing revel server pid", cmd.Process.Pid)
-		err := cmd.Process.Kill()
+	if cmd.Cmd != nil && cmd.Process != nil && (cmd.ProcessState == nil || !cmd.ProcessState.Exited()) {
+		gospf.TRACE.Println("Killing revel server pid", cmd.Process.Pid)
+		atomic.StoreInt32(&cmd.state.killed, 1)
+		err := killProcessTree(cmd.Process)
 		if err != nil {
-			revel.ERROR.Fatalln("Failed to kill revel server:", err)
+			gospf.ERROR.Fatalln("Failed to kill revel server:", err)
 		}
 	}
 }
 
-// Return a channel that is notified when Wait() returns.
+// Stop asks the app server to exit, with SIGTERM, and kills it if it has not
+// exited after the timeout.  The app must have been started with Start.
+func (cmd AppCmd) Stop(timeout time.Duration) {
+	if cmd.Cmd == nil || cmd.Process == nil {
+		return
+	}
+	gospf.TRACE.Println("Stopping revel server pid", cmd.Process.Pid)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err == nil {
+		select {
+		case <-cmd.state.exited:
+			return
+		case <-time.After(timeout):
+			gospf.WARN.Println("The app did not exit in time; killing it")
+		}
+	}
+	cmd.Kill()
+}
+
+// Signal the app server to reload its templates without restarting.
+// The generated main.go refreshes the templates upon receiving SIGHUP.
+func (cmd AppCmd) ReloadTemplates() error {
+	if cmd.Cmd == nil || cmd.Process == nil {
+		return errors.New("gospf/harness: app is not running")
+	}
+	gospf.TRACE.Println("Signaling revel server to reload templates, pid", cmd.Process.Pid)
+	return cmd.Process.Signal(syscall.SIGHUP)
+}
+
+// Return a channel that is closed when Wait() returns.
 func (cmd AppCmd) waitChan() <-chan struct{} {
-	ch := make(chan struct{}, 1)
 	go func() {
 		cmd.Wait()
-		ch <- struct{}{}
+		close(cmd.state.exited)
 	}()
-	return ch
+	return cmd.state.exited
+}
+
+// tailWriter keeps the last bytes written to it.
+type tailWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = append([]byte(nil), w.buf[len(w.buf)-w.max:]...)
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.buf)
 }
 
 // A io.Writer that copies to the destination, and listens for "Listening on.."
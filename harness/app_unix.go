@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package harness
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the app in its own process group, so that it and
+// any processes it spawns can be killed together.  With Interactive, the app
+// stays in the foreground process group, to read the terminal.
+func setProcessGroup(cmd *exec.Cmd) {
+	if Interactive {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessTree kills the process group led by the given process, falling
+// back to just the process if it does not lead a group.
+func killProcessTree(process *os.Process) error {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err == nil {
+		return nil
+	}
+	return process.Kill()
+}
@@ -0,0 +1,29 @@
+package harness
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/hubply/gospf"
+)
+
+// setProcessGroup starts the app in a new process group, so that a Ctrl+C
+// in the console is handled by the harness, which then kills the app.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killProcessTree kills the given process and every process it spawned.
+// Windows does not kill children along with their parent, so this uses
+// taskkill, falling back to killing just the process if that fails.
+func killProcessTree(process *os.Process) error {
+	taskkill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(process.Pid))
+	gospf.TRACE.Println("Exec:", taskkill.Args)
+	if output, err := taskkill.CombinedOutput(); err != nil {
+		gospf.TRACE.Println("taskkill failed:", err, string(output))
+		return process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package harness
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hubply/gospf"
+)
+
+// Asset builders are commands configured as assets.NAME in app.conf, e.g.
+//
+//	assets.js  = npm run watch
+//	assets.css = sassc app/styles/main.scss public/css/main.css
+//
+// The harness runs them in the app's directory, alongside the app.  Those
+// that exit are run again when the app is rebuilt, so that one-shot builders
+// stay current, and all of them are restarted when their command changes in
+// app.conf.  A builder that fails is reported on the following requests,
+// rather than letting the app serve stale assets.
+
+type assetBuilders struct {
+	mu       sync.Mutex
+	builders map[string]*assetBuilder // By name.
+}
+
+// assetBuilder is a run of an asset builder command.
+type assetBuilder struct {
+	name, command string
+	cmd           *exec.Cmd
+	output        *tailWriter
+	exited        chan struct{} // Closed when the command exits.
+	err           error         // Why the command failed, once exited.
+	stopped       int32         // Set when the command is stopped on purpose.
+}
+
+func newAssetBuilders() *assetBuilders {
+	return &assetBuilders{builders: make(map[string]*assetBuilder)}
+}
+
+// update runs the given commands, by name, restarting those that changed
+// and stopping those that are no longer configured.
+func (a *assetBuilders) update(commands map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for name, builder := range a.builders {
+		if commands[name] != builder.command {
+			builder.stop()
+			delete(a.builders, name)
+		}
+	}
+	for name, command := range commands {
+		if _, ok := a.builders[name]; !ok {
+			a.builders[name] = startAssetBuilder(name, command)
+		}
+	}
+}
+
+// rerun runs the commands that exited again.
+func (a *assetBuilders) rerun() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, builder := range a.builders {
+		select {
+		case <-builder.exited:
+			a.builders[name] = startAssetBuilder(name, builder.command)
+		default:
+		}
+	}
+}
+
+// failure describes the first of the commands that failed, if any.
+func (a *assetBuilders) failure() *gospf.Error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var names []string
+	for name := range a.builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		builder := a.builders[name]
+		select {
+		case <-builder.exited:
+			if builder.err != nil {
+				return &gospf.Error{
+					Title: "Asset Build Failed",
+					Description: fmt.Sprintf("The asset builder assets.%s (%s) failed: %s\n\n%s",
+						name, builder.command, builder.err, builder.output),
+				}
+			}
+		default:
+		}
+	}
+	return nil
+}
+
+// stopAll stops every command.
+func (a *assetBuilders) stopAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, builder := range a.builders {
+		builder.stop()
+		delete(a.builders, name)
+	}
+}
+
+func startAssetBuilder(name, command string) *assetBuilder {
+	b := &assetBuilder{
+		name:    name,
+		command: command,
+		output:  &tailWriter{max: stderrTailSize},
+		exited:  make(chan struct{}),
+	}
+	b.cmd = shellCommand(command)
+	b.cmd.Dir = gospf.BasePath
+	b.cmd.Env = appEnv()
+	prefixed := &prefixWriter{w: os.Stdout, prefix: "[assets." + name + "] "}
+	b.cmd.Stdout = io.MultiWriter(prefixed, b.output)
+	b.cmd.Stderr = io.MultiWriter(prefixed, b.output)
+	setProcessGroup(b.cmd)
+
+	gospf.INFO.Printf("Running assets.%s: %s", name, command)
+	if err := b.cmd.Start(); err != nil {
+		b.err = err
+		close(b.exited)
+		return b
+	}
+	go func() {
+		err := b.cmd.Wait()
+		if atomic.LoadInt32(&b.stopped) == 0 && err != nil {
+			gospf.ERROR.Printf("assets.%s failed: %s", name, err)
+			b.err = err
+		}
+		close(b.exited)
+	}()
+	return b
+}
+
+// shellCommand returns the command to run the given command line with the
+// shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// stop kills the command, if it is running, and waits for it to exit.  The
+// caller must hold the lock of the assetBuilders.
+func (b *assetBuilder) stop() {
+	select {
+	case <-b.exited:
+		return
+	default:
+	}
+	atomic.StoreInt32(&b.stopped, 1)
+	killProcessTree(b.cmd.Process)
+	<-b.exited
+}
+
+// prefixWriter writes each line with a prefix.
+type prefixWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  string
+	midLine bool
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var buf []byte
+	for _, c := range data {
+		if !p.midLine {
+			buf = append(buf, p.prefix...)
+			p.midLine = true
+		}
+		buf = append(buf, c)
+		if c == '\n' {
+			p.midLine = false
+		}
+	}
+	if _, err := p.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// assetsConfig restarts the asset builders whose command changed when
+// app.conf changes.
+type assetsConfig struct {
+	builders *assetBuilders
+}
+
+func (c assetsConfig) Refresh() *gospf.Error {
+	config, err := gospf.LoadConfig("app.conf")
+	if err != nil {
+		gospf.WARN.Println("Failed to reload app.conf for the asset builders:", err)
+		return nil
+	}
+	config.SetSection(gospf.RunMode)
+	inheritRunMode(config, gospf.RunMode)
+	c.builders.update(configValuesIn(config, "assets."))
+	return nil
+}
+
+func (c assetsConfig) WatchDir(info os.FileInfo) bool {
+	return true
+}
+
+func (c assetsConfig) WatchFile(filename string) bool {
+	return filepath.Base(filename) == "app.conf"
+}
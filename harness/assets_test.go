@@ -0,0 +1,57 @@
+package harness
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &prefixWriter{w: &out, prefix: "[assets.css] "}
+	for _, s := range []string{"compiling", " main.scss\ndone\n", "\n"} {
+		w.Write([]byte(s))
+	}
+	expected := "[assets.css] compiling main.scss\n[assets.css] done\n[assets.css] \n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestAssetBuilders(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	builders := newAssetBuilders()
+	defer builders.stopAll()
+
+	builders.update(map[string]string{"js": "sleep 60", "css": "echo broken >&2; exit 1"})
+	<-builders.builders["css"].exited
+	err := builders.failure()
+	if err == nil || !bytes.Contains([]byte(err.Description), []byte("broken")) {
+		t.Fatalf("Expected the failure of assets.css, got %v", err)
+	}
+
+	js := builders.builders["js"]
+	builders.update(map[string]string{"js": "sleep 60", "css": "true"})
+	if builders.builders["js"] != js {
+		t.Error("Expected the unchanged assets.js to keep running")
+	}
+	<-builders.builders["css"].exited
+	if err = builders.failure(); err != nil {
+		t.Errorf("Expected no failure once assets.css succeeds, got %v", err)
+	}
+
+	builders.update(map[string]string{"css": "true"})
+	if _, ok := builders.builders["js"]; ok {
+		t.Error("Expected assets.js to be stopped once removed")
+	}
+	select {
+	case <-js.exited:
+	default:
+		t.Error("Expected the removed assets.js to have exited")
+	}
+	if err = builders.failure(); err != nil {
+		t.Errorf("Expected a stopped builder not to fail, got %v", err)
+	}
+}
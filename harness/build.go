@@ -1,8 +1,18 @@
 package harness
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
 	"fmt"
+	"go/ast"
 	"go/build"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
 	"os"
 	"os/exec"
 	"path"
@@ -11,44 +21,211 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/hubply/gospf"
 )
 
 var importErrorPattern = regexp.MustCompile("cannot find package \"([^\"]+)\"")
 
+// OutputPath, if set, overrides the build.output configuration as the path of
+// the built app binary.
+var OutputPath string
+
+var (
+	// Release, if set, tells that the app is built for a release, by gospf
+	// build or package, which build.reproducible applies to.
+	Release bool
+
+	// TrimPath, if set, removes the file system paths from the built binary,
+	// as go build -trimpath.
+	TrimPath bool
+
+	// Strip, if set, omits the symbol table and the DWARF debug information
+	// from the built binary, as the -s -w linker flags.
+	Strip bool
+
+	// Cover, if set, instruments the built binary for coverage, as go build
+	// -cover.  The app then writes its coverage counters to $GOCOVERDIR when
+	// it exits, which it does on SIGTERM.
+	Cover bool
+
+	// CoverPkg, if set, is the comma-separated patterns of the packages whose
+	// coverage is measured with Cover, rather than those of the app.
+	CoverPkg string
+)
+
+// Reproducible reports whether build.reproducible is set for a release, in
+// which case the same source tree builds a byte-identical binary: the file
+// system paths are removed from it, and APP_VERSION is the commit of the
+// source rather than a description that depends on the tags fetched.  The
+// builds of gospf run and test keep the paths, e.g. for the debugger and the
+// links to the source of the errors.
+func Reproducible() bool {
+	return Release && gospf.Config.BoolDefault("build.reproducible", false)
+}
+
 // Build the app:
 // 1. Generate the the main.go file.
 // 2. Run the appropriate "go build" command.
 // Requires that gospf.Init has been called previously.
 // Returns the path to the built binary, and an error if there was a problem building it.
+// The error is also printed to the terminal.
 func Build(buildFlags ...string) (app *App, compileError *gospf.Error) {
+	return BuildContext(context.Background(), buildFlags...)
+}
+
+// BuildContext is like Build, but gives up when the context is done or the
+// build.timeout configured for the app elapses, whichever comes first.
+func BuildContext(ctx context.Context, buildFlags ...string) (app *App, compileError *gospf.Error) {
+	return buildContext(ctx, nil, buildFlags...)
+}
+
+// buildContext is BuildContext, which records the source and the generated
+// files of the build in the manifest, if given.
+func buildContext(ctx context.Context, manifest *BuildManifest, buildFlags ...string) (app *App, compileError *gospf.Error) {
+	apps, compileError := buildVariants(ctx, manifest, []Variant{DefaultVariant()}, buildFlags)
+	if compileError != nil {
+		return nil, compileError
+	}
+	return apps[0], nil
+}
+
+// buildVariants builds the variants of the app, returning their apps in the
+// same order.  The source is processed and the routes generated once for
+// all of them, and their go build commands run concurrently.
+func buildVariants(ctx context.Context, manifest *BuildManifest, variants []Variant, buildFlags []string) (apps []*App, compileError *gospf.Error) {
+	defer func() {
+		if compileError != nil {
+			PrintError(os.Stderr, compileError)
+		}
+	}()
+	if compileError = checkVariants(variants); compileError != nil {
+		return nil, compileError
+	}
+	progress.start()
+	defer progress.finish()
+	started := time.Now()
+
+	if timeout := buildTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	// First, clear the generated files (to avoid them messing with ProcessSource).
 	cleanSource("tmp", "routes")
+	cleanCodePathRoutes()
+
+	// Build with the local source of the modules of the workspace.
+	if compileError = linkWorkspace(); compileError != nil {
+		return nil, compileError
+	}
 
-	sourceInfo, compileError := ProcessSource(gospf.CodePaths)
+	// The environment of the go commands, with the module proxy configured.
+	env, compileError := GoEnv()
 	if compileError != nil {
 		return nil, compileError
 	}
 
+	// The build profile selected, which may set more of the environment.
+	profile, compileError := currentProfile()
+	if compileError != nil {
+		return nil, compileError
+	}
+	if profile != nil {
+		gospf.TRACE.Printf("Building with the profile %s", profile.Name)
+		env = profile.environ(env)
+	}
+
+	// Run the app's code generators, whose output may need processing.
+	if compileError = generate(ctx, env); compileError != nil {
+		return nil, compileError
+	}
+
+	// Check the configuration against the app's schema, if it has one.
+	schema, compileError := LoadConfigSchema()
+	if compileError != nil {
+		return nil, compileError
+	}
+	if schema != nil {
+		if compileError = schema.Check(); compileError != nil {
+			return nil, compileError
+		}
+	}
+
+	sourceInfo, compileError := ProcessSource(SourcePaths())
+	if compileError != nil {
+		return nil, compileError
+	}
+	if manifest != nil {
+		manifest.recordSource(sourceInfo)
+	}
+
 	// Add the db.import to the import paths.
 	if dbImportPath, found := gospf.Config.String("db.import"); found {
 		sourceInfo.InitImportPaths = append(sourceInfo.InitImportPaths, dbImportPath)
 	}
 
-	// Generate two source files.
+	// The routes refer to the controllers of all the code paths by name.
+	if compileError = checkControllerNames(sourceInfo.ControllerSpecs()); compileError != nil {
+		return nil, compileError
+	}
+	if compileError = checkRoutes(sourceInfo.ControllerSpecs()); compileError != nil {
+		return nil, compileError
+	}
+
+	importPaths := calcImportAliases(sourceInfo)
 	templateArgs := map[string]interface{}{
 		"Controllers":    sourceInfo.ControllerSpecs(),
 		"ValidationKeys": sourceInfo.ValidationKeys,
-		"ImportPaths":    calcImportAliases(sourceInfo),
-		"TestSuites":     sourceInfo.TestSuites(),
+		"ImportPaths":    importPaths,
+		"Jobs":           sourceInfo.JobSpecs,
+		// The interceptor functions, which intercept all the controllers.
+		"InterceptorFuncs": sourceInfo.InterceptorFuncs,
+		// The routes may only use the import paths of argument types.
+		"RouteImportPaths": calcRouteImportAliases(sourceInfo, importPaths),
 	}
-	genSource("tmp", "main.go", MAIN, templateArgs)
-	genSource("routes", "routes.go", ROUTES, templateArgs)
 
-	// Read build config.
-	buildTags := gospf.Config.StringDefault("build.tags", "")
+	// The app may override the templates, and generate more files.
+	templateName, templateSource, compileError := codeTemplate("routes.go", ROUTES)
+	if compileError != nil {
+		return nil, compileError
+	}
+	if compileError = genSource("routes", "routes.go", templateName, templateSource, templateArgs); compileError != nil {
+		return nil, compileError
+	}
+	if manifest != nil {
+		manifest.recordGenerated("routes", "routes.go")
+	}
+	if compileError = genCodePathRoutes(sourceInfo, importPaths, manifest); compileError != nil {
+		return nil, compileError
+	}
+	if compileError = genSource(buildInfoDir, "buildinfo.go", "buildinfo.go", BUILDINFO, nil); compileError != nil {
+		return nil, compileError
+	}
+	if manifest != nil {
+		manifest.recordGenerated(buildInfoDir, "buildinfo.go")
+	}
+	for _, variant := range variants {
+		// Test suites are only registered when they may be run.  Without
+		// them, the testing package and the test packages are pruned from
+		// the imports.
+		variantArgs := make(map[string]interface{}, len(templateArgs)+1)
+		for key, value := range templateArgs {
+			variantArgs[key] = value
+		}
+		if variant.TestSuites {
+			variantArgs["TestSuites"] = sourceInfo.TestSuites()
+		} else {
+			variantArgs["TestSuites"] = []*TypeInfo(nil)
+		}
+		if compileError = genMainSources(variant.dir(), variantArgs, manifest); compileError != nil {
+			return nil, compileError
+		}
+	}
 
 	// Build the user program (all code under app).
 	// It relies on the user having "go" installed.
@@ -57,52 +234,161 @@ func Build(buildFlags ...string) (app *App, compileError *gospf.Error) {
 		gospf.ERROR.Fatalf("Go executable not found in PATH.")
 	}
 
-	pkg, err := build.Default.Import(gospf.ImportPath, "", build.FindOnly)
-	if err != nil {
-		gospf.ERROR.Fatalln("Failure importing", gospf.ImportPath)
+	// Set up cgo, and check its C compiler.
+	if env, compileError = cgoEnv(ctx, goPath, env); compileError != nil {
+		return nil, compileError
 	}
 
-	// Binary path is a combination of $GOBIN/gospf.d directory, app's import path and its name.
-	binName := path.Join(pkg.BinDir, "gospf.d", gospf.ImportPath, path.Base(gospf.BasePath))
+	// The version of the app, and the rest of the information of the build.
+	info := newBuildInfo(ctx, goPath, env)
 
-	// Change binary path for Windows build
-	goos := runtime.GOOS
-	if goosEnv := os.Getenv("GOOS"); goosEnv != "" {
-		goos = goosEnv
+	apps = make([]*App, len(variants))
+	errs := make([]*gospf.Error, len(variants))
+	var wg sync.WaitGroup
+	for i, variant := range variants {
+		wg.Add(1)
+		go func(i int, variant Variant) {
+			defer wg.Done()
+			apps[i], errs[i] = goBuild(ctx, goPath, env, info, variant, profile, buildFlags)
+		}(i, variant)
 	}
-	if goos == "windows" {
-		binName += ".exe"
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Analyze the code, now that it compiles.
+	if vetEnabled(profile) {
+		if compileError = vet(ctx, goPath, env); compileError != nil {
+			return nil, compileError
+		}
+	}
+	reportSize(ctx, goPath, env, apps[0].BinaryPath)
+	for _, app := range apps {
+		app.Summary = newBuildSummary(sourceInfo, app.BinaryPath, started)
+	}
+	return apps, nil
+}
+
+// genMainSources generates the main package of a variant into dir: main.go,
+// and the additional files of the app's templates and of the coverage.
+func genMainSources(dir string, args map[string]interface{}, manifest *BuildManifest) *gospf.Error {
+	templateName, templateSource, compileError := codeTemplate("main.go", MAIN)
+	if compileError != nil {
+		return compileError
+	}
+	// The directories were cleaned at the start of the build, and hold the
+	// main packages of the other variants.
+	if compileError = writeSource(dir, "main.go", templateName, templateSource, args); compileError != nil {
+		return compileError
+	}
+	saveSource(dir, "runmode.go", mainRunModeSource())
+	generated := []string{"main.go", "runmode.go"}
+	extraFiles, compileError := genExtraSources(dir, args)
+	if compileError != nil {
+		return compileError
+	}
+	generated = append(generated, extraFiles...)
+	if Cover {
+		if compileError = writeSource(dir, "coverage.go", "coverage.go", COVERAGE, args); compileError != nil {
+			return compileError
+		}
+		generated = append(generated, "coverage.go")
+	}
+	if manifest != nil {
+		for _, filename := range generated {
+			manifest.recordGenerated(dir, filename)
+		}
+	}
+	return nil
+}
+
+// runModeSource is the source of runmodeinit.go, which the generated main
+// package compiles as well, as runmode.go.
+//
+//go:embed runmodeinit.go
+var runModeSource string
+
+// mainRunModeSource returns runmodeinit.go as a file of the generated main
+// package, which imports gospf as the generated main does.
+func mainRunModeSource() []byte {
+	source := strings.Replace(runModeSource, "package harness", "// GENERATED CODE - DO NOT EDIT\npackage main", 1)
+	source = strings.Replace(source, `"github.com/hubply/gospf"`, `"github.com/gospf/gospf"`, 1)
+	return []byte(source)
+}
+
+// goBuild builds the main package of the variant with go build, fetching the
+// missing packages as allowed by AutoGet.  The go commands run in the given
+// environment, with the flags of the build profile, if any, and the linker
+// flags of the information of the build.
+func goBuild(ctx context.Context, goPath string, env []string, info buildInfo, variant Variant, profile *buildProfile, buildFlags []string) (*App, *gospf.Error) {
+	buildTags := variant.buildTags()
+	binName := variant.binaryPath()
+	outputPrefix := "[go build] "
+	if variant.Name != "" {
+		outputPrefix = "[go build " + variant.Name + "] "
 	}
 
 	gotten := make(map[string]struct{})
 	for {
-		appVersion := getAppVersion()
-		versionLinkerFlags := fmt.Sprintf("-X %s/app.APP_VERSION \"%s\"", gospf.ImportPath, appVersion)
+		versionLinkerFlags := info.linkerFlags()
+		if Strip {
+			versionLinkerFlags += " -s -w"
+		}
+		if profile != nil && profile.LDFlags != "" {
+			versionLinkerFlags += " " + profile.LDFlags
+		}
+		// -v lists the packages as they are compiled, to show the progress
+		// of long builds.
 		flags := []string{
-			"build",
+			"build", "-v",
 			"-ldflags", versionLinkerFlags,
 			"-tags", buildTags,
 			"-o", binName}
+		if TrimPath || Reproducible() {
+			flags = append(flags, "-trimpath")
+		}
+		if Cover {
+			flags = append(flags, "-cover", "-coverpkg="+gospf.FirstNonEmpty(CoverPkg, gospf.ImportPath+"/app/..."))
+		}
 
 		// Add in build flags
+		if profile != nil {
+			flags = append(flags, profile.goBuildFlags()...)
+		}
 		flags = append(flags, buildFlags...)
 
 		// The main path
-		flags = append(flags, path.Join(gospf.ImportPath, "app", "tmp"))
+		flags = append(flags, variant.importPath())
 
-		buildCmd := exec.Command(goPath, flags...)
+		// Stream the output to the console as it comes, and keep it to parse
+		// the errors.
+		var buf bytes.Buffer
+		buildCmd := exec.CommandContext(ctx, goPath, flags...)
+		buildCmd.Env = env
+		buildCmd.Stdout = io.MultiWriter(&buf, progress, &prefixWriter{w: os.Stderr, prefix: outputPrefix})
+		buildCmd.Stderr = buildCmd.Stdout
 		gospf.TRACE.Println("Exec:", buildCmd.Args)
-		output, err := buildCmd.CombinedOutput()
+		err := buildCmd.Run()
+		output := buf.Bytes()
 
 		// If the build succeeded, we're done.
 		if err == nil {
 			return NewApp(binName), nil
 		}
-		gospf.ERROR.Println(string(output))
+		gospf.TRACE.Println(string(output))
+		if ctx.Err() != nil {
+			return nil, newCanceledError(ctx, "go build")
+		}
 
 		// See if it was an import error that we can go get.
 		matches := importErrorPattern.FindStringSubmatch(string(output))
 		if matches == nil {
+			if cgoError := diagnoseCgo(ctx, goPath, env, output); cgoError != nil {
+				return nil, cgoError
+			}
 			return nil, newCompileError(output)
 		}
 
@@ -113,53 +399,186 @@ func Build(buildFlags ...string) (app *App, compileError *gospf.Error) {
 		}
 		gotten[pkgName] = struct{}{}
 
+		if !allowGet(pkgName) {
+			return nil, newCompileError(output)
+		}
+
 		// Execute "go get <pkg>"
-		getCmd := exec.Command(goPath, "get", pkgName)
+		getCmd := exec.CommandContext(ctx, goPath, "get", pkgName)
+		getCmd.Env = env
 		gospf.TRACE.Println("Exec:", getCmd.Args)
 		getOutput, err := getCmd.CombinedOutput()
+		if ctx.Err() != nil {
+			return nil, newCanceledError(ctx, "go get "+pkgName)
+		}
 		if err != nil {
 			gospf.ERROR.Println(string(getOutput))
 			return nil, newCompileError(output)
 		}
+		logFetched(pkgName)
 
 		// Success getting the import, attempt to build again.
 	}
-	gospf.ERROR.Fatalf("Not reachable")
-	return nil, nil
 }
 
-// Try to define a version string for the compiled app
-// The following is tried (first match returns):
-// - Read a version explicitly specified in the APP_VERSION environment
-//   variable
-// - Read the output of "git describe" if the source is in a git repository
-// If no version can be determined, an empty string is returned.
-func getAppVersion() string {
-	if version := os.Getenv("APP_VERSION"); version != "" {
-		return version
+// AutoGet, if set, overrides the build.autoget configuration.
+var AutoGet string
+
+// Values of build.autoget, the policy for fetching packages that the app
+// imports but are missing from the GOPATH.
+const (
+	AutoGetOff    = "off"    // Report the missing package as a compile error.
+	AutoGetPrompt = "prompt" // Ask on the terminal before fetching it.
+	AutoGetOn     = "on"     // Fetch it with "go get".
+)
+
+// autoGetPolicy returns the configured build.autoget policy.
+func autoGetPolicy() string {
+	policy := AutoGet
+	if policy == "" {
+		policy = gospf.Config.StringDefault("build.autoget", AutoGetPrompt)
+	}
+	switch policy {
+	case AutoGetOff, AutoGetPrompt, AutoGetOn:
+		return policy
+	}
+	gospf.WARN.Printf("Invalid build.autoget %q, using %s", policy, AutoGetPrompt)
+	return AutoGetPrompt
+}
+
+// allowGet reports whether the missing package may be fetched.
+func allowGet(pkgName string) bool {
+	switch autoGetPolicy() {
+	case AutoGetOn:
+		return true
+	case AutoGetPrompt:
+		fmt.Fprintf(os.Stderr, "The app imports %s, which is missing.  Fetch it with \"go get\"? [y/N] ", pkgName)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+	gospf.INFO.Printf("Not fetching missing package %s: build.autoget is off", pkgName)
+	return false
+}
+
+// logFetched logs where a package fetched by "go get" was put.
+func logFetched(pkgName string) {
+	pkg, err := build.Import(pkgName, "", build.FindOnly)
+	if err != nil {
+		gospf.INFO.Printf("Fetched %s", pkgName)
+		return
+	}
+	gospf.INFO.Printf("Fetched %s into %s", pkgName, pkg.Dir)
+}
+
+// defaultBuildTimeout bounds the build, including any "go get" it runs, so
+// that an unreachable VCS host can not hang the harness.
+const defaultBuildTimeout = 10 * time.Minute
+
+// buildTimeout returns the build.timeout configured for the app, e.g. "90s".
+// A timeout of 0 disables it.
+func buildTimeout() time.Duration {
+	value := gospf.Config.StringDefault("build.timeout", "")
+	if value == "" {
+		return defaultBuildTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		gospf.WARN.Printf("Invalid build.timeout %q, using %s: %s", value, defaultBuildTimeout, err)
+		return defaultBuildTimeout
+	}
+	return timeout
+}
+
+// BuildTags returns the build tags of the app in the current run mode: those
+// of build.tags and build.tags.<mode>, those of the build profile, and the
+// gospf_<mode> tag, e.g. gospf_dev, so that code may be compiled only for a
+// run mode.
+func BuildTags() string {
+	return mergeBuildTags(gospf.RunMode,
+		gospf.Config.StringDefault("build.tags", ""),
+		gospf.Config.StringDefault("build.tags."+gospf.RunMode, ""),
+		profileTags())
+}
+
+// mergeBuildTags returns the comma-separated union of the given lists of
+// tags, which may be separated by commas or spaces, and the tag of the run
+// mode.
+func mergeBuildTags(runMode string, lists ...string) string {
+	modeTag := "gospf_" + strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, runMode)
+
+	var tags []string
+	seen := make(map[string]bool)
+	for _, list := range append(lists, modeTag) {
+		for _, tag := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
 	}
+	return strings.Join(tags, ",")
+}
 
-	// Check for the git binary
-	if gitPath, err := exec.LookPath("git"); err == nil {
-		// Check for the .git directory
-		gitDir := path.Join(gospf.BasePath, ".git")
-		info, err := os.Stat(gitDir)
-		if (err != nil && os.IsNotExist(err)) || !info.IsDir() {
-			return ""
+// newCanceledError returns the error for a build step that was interrupted
+// because the context was done.
+func newCanceledError(ctx context.Context, step string) *gospf.Error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &gospf.Error{
+			Title:       "Build Timed Out",
+			Description: fmt.Sprintf("%s did not finish in time.  The limit is set by build.timeout.", step),
 		}
-		gitCmd := exec.Command(gitPath, "--git-dir="+gitDir, "describe", "--always", "--dirty")
-		gospf.TRACE.Println("Exec:", gitCmd.Args)
-		output, err := gitCmd.Output()
+	}
+	return &gospf.Error{
+		Title:       "Build Canceled",
+		Description: fmt.Sprintf("%s was canceled.", step),
+	}
+}
+
+// includeTestSuites reports whether the app's test suites should be compiled
+// into the app for the current run mode.  This is the case when the
+// testrunner module is loaded, unless build.testsuites says otherwise.
+func includeTestSuites() bool {
+	testRunnerLoaded := gospf.Config.StringDefault("module.testrunner", "") != ""
+	return gospf.Config.BoolDefault("build.testsuites", testRunnerLoaded)
+}
 
+// BinaryPath returns the path of the binary produced by Build.
+// By default, it is a combination of $GOBIN/gospf.d directory, app's import
+// path and its name.  This may be changed with OutputPath or the build.output
+// config; relative paths are taken relative to the app's base path.
+// If build.output.modesuffix is set, the run mode is appended to the binary
+// name, e.g. "chat-dev" or "chat-prod".
+func BinaryPath() string {
+	binName := gospf.FirstNonEmpty(OutputPath, gospf.Config.StringDefault("build.output", ""))
+	if binName == "" {
+		pkg, err := build.Default.Import(gospf.ImportPath, "", build.FindOnly)
 		if err != nil {
-			gospf.WARN.Println("Cannot determine git repository version:", err)
-			return ""
+			gospf.ERROR.Fatalln("Failure importing", gospf.ImportPath)
 		}
+		binName = path.Join(pkg.BinDir, "gospf.d", gospf.ImportPath, path.Base(gospf.BasePath))
+	} else if !filepath.IsAbs(binName) {
+		binName = filepath.Join(gospf.BasePath, binName)
+	}
 
-		return "git-" + strings.TrimSpace(string(output))
+	if gospf.RunMode != "" && gospf.Config.BoolDefault("build.output.modesuffix", false) {
+		binName += "-" + gospf.RunMode
 	}
 
-	return ""
+	// Change binary path for Windows build
+	goos := runtime.GOOS
+	if goosEnv := os.Getenv("GOOS"); goosEnv != "" {
+		goos = goosEnv
+	}
+	if goos == "windows" && !strings.HasSuffix(binName, ".exe") {
+		binName += ".exe"
+	}
+	return binName
 }
 
 func cleanSource(dirs ...string) {
@@ -172,7 +591,10 @@ func cleanDir(dir string) {
 	gospf.INFO.Println("Cleaning dir " + dir)
 	tmpPath := path.Join(gospf.AppPath, dir)
 	f, err := os.Open(tmpPath)
-	if err != nil {
+	if os.IsNotExist(err) {
+		// Nothing was generated yet.
+		return
+	} else if err != nil {
 		gospf.ERROR.Println("Failed to clean dir:", err)
 	} else {
 		defer f.Close()
@@ -181,6 +603,9 @@ func cleanDir(dir string) {
 			gospf.ERROR.Println("Failed to clean dir:", err)
 		} else {
 			for _, info := range infos {
+				if info.Name() == sourceCacheFile {
+					continue
+				}
 				path := path.Join(tmpPath, info.Name())
 				if info.IsDir() {
 					err := os.RemoveAll(path)
@@ -199,17 +624,84 @@ func cleanDir(dir string) {
 }
 
 // genSource renders the given template to produce source code, which it writes
-// to the given directory and file.
-func genSource(dir, filename, templateSource string, args map[string]interface{}) {
-	sourceCode := gospf.ExecuteTemplate(
-		template.Must(template.New("").Parse(templateSource)),
-		args)
+// to the given directory and file.  The rendered code has its unused imports
+// pruned and is run through gofmt before being written.  The template is
+// named for its errors, by the path of the app's template if it is one.
+func genSource(dir, filename, templateName, templateSource string, args map[string]interface{}) *gospf.Error {
+	formatted, genErr := renderSource(dir, filename, templateName, templateSource, args)
+	if genErr != nil {
+		return genErr
+	}
 
 	// Create a fresh dir.
 	cleanSource(dir)
+	saveSource(dir, filename, formatted)
+	return nil
+}
+
+// writeSource is genSource, which adds the file to the directory rather than
+// replacing its content.
+func writeSource(dir, filename, templateName, templateSource string, args map[string]interface{}) *gospf.Error {
+	formatted, genErr := renderSource(dir, filename, templateName, templateSource, args)
+	if genErr != nil {
+		return genErr
+	}
+	saveSource(dir, filename, formatted)
+	return nil
+}
+
+// renderSource renders the template, and formats the source code.
+func renderSource(dir, filename, templateName, templateSource string, args map[string]interface{}) ([]byte, *gospf.Error) {
+	tmpl, err := template.New(templateName).Parse(templateSource)
+	if err != nil {
+		return nil, newTemplateError(templateName, err)
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, args); err != nil {
+		return nil, newTemplateError(templateName, err)
+	}
+	sourceCode := buf.String()
+
+	formatted, err := formatSource(filename, sourceCode)
+	if err != nil {
+		return nil, newGeneratedSourceError(path.Join(dir, filename), sourceCode, err)
+	}
+	return formatted, nil
+}
+
+// newTemplateError describes a failure to parse or execute the template of
+// generated code.
+func newTemplateError(templateName string, err error) *gospf.Error {
+	return &gospf.Error{
+		SourceType:  "template",
+		Title:       codeGenerationErrorTitle,
+		Path:        templateName,
+		Description: err.Error(),
+	}
+}
+
+// generatedHeader is the first line of the sources that the harness generates.
+const generatedHeader = "// GENERATED CODE - DO NOT EDIT"
+
+// isGeneratedSource reports whether the file starts with generatedHeader, and
+// so may be replaced or removed.
+func isGeneratedSource(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	header := make([]byte, len(generatedHeader))
+	_, err = io.ReadFull(file, header)
+	return err == nil && string(header) == generatedHeader
+}
+
+// saveSource writes the source code to the file in the given directory of
+// the app, creating the directory if necessary.
+func saveSource(dir, filename string, source []byte) {
 	tmpPath := path.Join(gospf.AppPath, dir)
-	err := os.Mkdir(tmpPath, 0777)
-	if err != nil && !os.IsExist(err) {
+	err := os.MkdirAll(tmpPath, 0777)
+	if err != nil {
 		gospf.ERROR.Fatalf("Failed to make '%v' directory: %v", dir, err)
 	}
 
@@ -219,18 +711,116 @@ func genSource(dir, filename, templateSource string, args map[string]interface{}
 	if err != nil {
 		gospf.ERROR.Fatalf("Failed to create file: %v", err)
 	}
-	_, err = file.WriteString(sourceCode)
+	_, err = file.Write(source)
 	if err != nil {
 		gospf.ERROR.Fatalf("Failed to write to file: %v", err)
 	}
 }
 
+// formatSource removes unused imports from the given source and formats it
+// the way gofmt would.
+func formatSource(filename, src string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	pruneImports(file)
+
+	var buf bytes.Buffer
+	if err = format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pruneImports drops the imports that are not referenced by the file.
+// Blank and dot imports are always kept.
+func pruneImports(file *ast.File) {
+	used := make(map[string]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		if selExpr, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := selExpr.X.(*ast.Ident); ok && ident.Obj == nil {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+
+	kept := make(map[*ast.ImportSpec]bool)
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			decls = append(decls, decl)
+			continue
+		}
+
+		var specs []ast.Spec
+		for _, spec := range genDecl.Specs {
+			importSpec := spec.(*ast.ImportSpec)
+			importPath, _ := strconv.Unquote(importSpec.Path.Value)
+			name := path.Base(importPath)
+			if importSpec.Name != nil {
+				name = importSpec.Name.Name
+			}
+			if name == "_" || name == "." || used[name] {
+				specs = append(specs, spec)
+				kept[importSpec] = true
+			}
+		}
+		if len(specs) == 0 {
+			continue
+		}
+		genDecl.Specs = specs
+		decls = append(decls, genDecl)
+	}
+	file.Decls = decls
+
+	var imports []*ast.ImportSpec
+	for _, importSpec := range file.Imports {
+		if kept[importSpec] {
+			imports = append(imports, importSpec)
+		}
+	}
+	file.Imports = imports
+}
+
+const codeGenerationErrorTitle = "Code Generation Error"
+
+// newGeneratedSourceError describes a failure to parse or format generated
+// code.  The generated source is attached so that it may be displayed.
+func newGeneratedSourceError(filename, src string, err error) *gospf.Error {
+	gospf.ERROR.Printf("Failed to format generated %s: %s\n%s", filename, err, src)
+	genError := &gospf.Error{
+		SourceType:  "generated code",
+		Title:       codeGenerationErrorTitle,
+		Path:        filename,
+		Description: err.Error(),
+		SourceLines: strings.Split(src, "\n"),
+	}
+	if errList, ok := err.(scanner.ErrorList); ok && len(errList) > 0 {
+		genError.Description = errList[0].Msg
+		genError.Line = errList[0].Pos.Line
+		genError.Column = errList[0].Pos.Column
+		traceGeneratedError(genError)
+	}
+	return genError
+}
+
+// IsCodeGenerationError reports whether an error returned by Build arose from
+// generating code for the app, rather than from the app's own code.
+func IsCodeGenerationError(err *gospf.Error) bool {
+	return err != nil && err.Title == codeGenerationErrorTitle
+}
+
 // Looks through all the method args and returns a set of unique import paths
 // that cover all the method arg types.
 // Additionally, assign package aliases when necessary to resolve ambiguity.
 func calcImportAliases(src *SourceInfo) map[string]string {
 	aliases := make(map[string]string)
-	typeArrays := [][]*TypeInfo{src.ControllerSpecs(), src.TestSuites()}
+	typeArrays := [][]*TypeInfo{src.ControllerSpecs(), src.TestSuites(), src.JobSpecs}
 	for _, specs := range typeArrays {
 		for _, spec := range specs {
 			addAlias(aliases, spec.ImportPath, spec.PackageName)
@@ -247,6 +837,10 @@ func calcImportAliases(src *SourceInfo) map[string]string {
 		}
 	}
 
+	for _, spec := range src.InterceptorFuncs {
+		addAlias(aliases, spec.ImportPath, spec.PackageName)
+	}
+
 	// Add the "InitImportPaths", with alias "_"
 	for _, importPath := range src.InitImportPaths {
 		if _, ok := aliases[importPath]; !ok {
@@ -257,6 +851,30 @@ func calcImportAliases(src *SourceInfo) map[string]string {
 	return aliases
 }
 
+// calcRouteImportAliases returns the aliases of the packages that declare the
+// types of the controller method arguments, so that the reverse routes may be
+// typed.  The controller packages are left out, since they import the routes;
+// arguments of types declared in them are left untyped.
+func calcRouteImportAliases(src *SourceInfo, aliases map[string]string) map[string]string {
+	controllerPaths := make(map[string]bool)
+	for _, spec := range src.ControllerSpecs() {
+		controllerPaths[spec.ImportPath] = true
+	}
+
+	routeAliases := make(map[string]string)
+	for _, spec := range src.ControllerSpecs() {
+		for _, methSpec := range spec.MethodSpecs {
+			for _, methArg := range methSpec.Args {
+				if methArg.ImportPath == "" || controllerPaths[methArg.ImportPath] {
+					continue
+				}
+				routeAliases[methArg.ImportPath] = aliases[methArg.ImportPath]
+			}
+		}
+	}
+	return routeAliases
+}
+
 func addAlias(aliases map[string]string, importPath, pkgName string) {
 	alias, ok := aliases[importPath]
 	if ok {
@@ -266,10 +884,14 @@ func addAlias(aliases map[string]string, importPath, pkgName string) {
 	aliases[importPath] = alias
 }
 
+// mainImports are the names of the packages that main.go imports, which the
+// aliases of the app's packages must not shadow.
+var mainImports = []string{"flag", "fmt", "io", "net", "os", "signal", "filepath", "reflect", "sync", "syscall", "time", "gospf", "testing"}
+
 func makePackageAlias(aliases map[string]string, pkgName string) string {
 	i := 0
 	alias := pkgName
-	for containsValue(aliases, alias) {
+	for containsValue(aliases, alias) || gospf.ContainsString(mainImports, alias) {
 		alias = fmt.Sprintf("%s%d", pkgName, i)
 		i++
 	}
@@ -312,6 +934,7 @@ func newCompileError(output []byte) *gospf.Error {
 		relFilename    = string(errorMatch[1]) // e.g. "src/gospf/sample/app/controllers/app.go"
 		absFilename, _ = filepath.Abs(relFilename)
 		line, _        = strconv.Atoi(string(errorMatch[2]))
+		column, _      = strconv.Atoi(strings.TrimSuffix(string(errorMatch[3]), ":"))
 		description    = string(errorMatch[4])
 		compileError   = &gospf.Error{
 			SourceType:  "Go code",
@@ -319,32 +942,86 @@ func newCompileError(output []byte) *gospf.Error {
 			Path:        relFilename,
 			Description: description,
 			Line:        line,
+			Column:      column,
 		}
 	)
 
-	errorLink := gospf.Config.StringDefault("error.link", "")
-
-	if errorLink != "" {
-		compileError.SetLink(errorLink)
-	}
-
 	fileStr, err := gospf.ReadLines(absFilename)
 	if err != nil {
 		compileError.MetaError = absFilename + ": " + err.Error()
 		gospf.ERROR.Println(compileError.MetaError)
+		setErrorLink(compileError)
 		return compileError
 	}
 
 	compileError.SourceLines = fileStr
+	traceGeneratedError(compileError)
+	setErrorLink(compileError)
 	return compileError
 }
 
+// sourceMarker precedes the generated code for a controller or an action,
+// e.g. "// gospf:source Application.Index at /app/controllers/app.go:12", so
+// that the errors in generated code can be traced back to the app's source.
+const sourceMarker = "// gospf:source "
+
+// traceGeneratedError points an error in generated code at the declaration of
+// the action that the code was generated for, found by the marker preceding
+// the line of the error.  The description names the generated line.  If the
+// declaring file can't be read, the error still points at the declaration,
+// without its source lines.
+func traceGeneratedError(genError *gospf.Error) {
+	lines := genError.SourceLines
+	if genError.Line < 1 || genError.Line > len(lines) {
+		return
+	}
+	for i := genError.Line - 1; i >= 0; i-- {
+		marker := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(marker, sourceMarker) {
+			continue
+		}
+		generated := fmt.Sprintf("%s:%d: %s", genError.Path, genError.Line, strings.TrimSpace(lines[genError.Line-1]))
+		name, position := marker[len(sourceMarker):], ""
+		if at := strings.Index(name, " at "); at >= 0 {
+			name, position = name[:at], name[at+len(" at "):]
+		}
+		genError.Description = fmt.Sprintf("%s\n\nThe error is in the code generated for %s, at %s", genError.Description, name, generated)
+
+		colon := strings.LastIndex(position, ":")
+		if colon < 0 {
+			return
+		}
+		line, err := strconv.Atoi(position[colon+1:])
+		if err != nil {
+			return
+		}
+		source, err := gospf.ReadLines(position[:colon])
+		if err != nil {
+			gospf.WARN.Printf("Failed to read the source of %s: %s", name, err)
+		}
+		genError.Path, genError.Line, genError.Column, genError.SourceLines = position[:colon], line, 0, source
+		if rel, err := filepath.Rel(gospf.BasePath, genError.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			genError.Path = rel
+		}
+		return
+	}
+}
+
 const MAIN = `// GENERATED CODE - DO NOT EDIT
 package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"reflect"
+	"sync"
+	"syscall"
+	"time"
 	"github.com/gospf/gospf"{{range $k, $v := $.ImportPaths}}
 	{{$v}} "{{$k}}"{{end}}
 	"github.com/gospf/gospf/testing"
@@ -355,19 +1032,22 @@ var (
 	port       *int    = flag.Int("port", 0, "By default, read from app.conf")
 	importPath *string = flag.String("importPath", "", "Go Import Path for the app.")
 	srcPath    *string = flag.String("srcPath", "", "Path to the source root.")
-
-	// So compiler won't complain if the generated code doesn't reference reflect package...
-	_ = reflect.Invalid
+	worker     *bool   = flag.Bool("worker", false, "Run the jobs instead of the server.")
+	socket     *string = flag.String("socket", "", "Path of a unix socket to listen on instead of the port.")
+	addr       *string = flag.String("addr", "", "By default, read from app.conf")
+	listenerFd *int    = flag.Int("listenerFd", 0, "File descriptor of an inherited listener to serve instead of the port.")
 )
 
 func main() {
 	flag.Parse()
-	gospf.Init(*runMode, *importPath, *srcPath)
+	initRunMode(*runMode, *importPath, *srcPath)
 	gospf.INFO.Println("Running gospf server")
 	{{range $i, $c := .Controllers}}
+	// gospf:source {{.StructName}}
 	gospf.RegisterController((*{{index $.ImportPaths .ImportPath}}.{{.StructName}})(nil),
 		[]*gospf.MethodType{
-			{{range .MethodSpecs}}&gospf.MethodType{
+			{{range .MethodSpecs}}// gospf:source {{$c.StructName}}.{{.Name}} at {{.File}}:{{.Line}}
+			&gospf.MethodType{
 				Name: "{{.Name}}",
 				Args: []*gospf.MethodArg{ {{range .Args}}
 					&gospf.MethodArg{Name: "{{.Name}}", Type: reflect.TypeOf((*{{index $.ImportPaths .ImportPath | .TypeExpr.TypeName}})(nil)) },{{end}}
@@ -380,31 +1060,178 @@ func main() {
 			},
 			{{end}}
 		})
+	{{range .Interceptors}}// gospf:source {{$c.StructName}}.{{.Name}} at {{.File}}:{{.Line}}
+	gospf.InterceptMethod((*{{index $.ImportPaths $c.ImportPath}}.{{$c.StructName}}).{{.Name}}, gospf.{{.When}})
+	{{end}}
+	{{end}}
+	{{range .InterceptorFuncs}}// gospf:source {{.PackageName}}.{{.Name}} at {{.File}}:{{.Line}}
+	gospf.InterceptFunc({{index $.ImportPaths .ImportPath}}.{{.Name}}, gospf.{{.When}}, &gospf.Controller{})
 	{{end}}
 	gospf.DefaultValidationKeys = map[string]map[int]string{ {{range $path, $lines := .ValidationKeys}}
 		"{{$path}}": { {{range $line, $key := $lines}}
 			{{$line}}: "{{$key}}",{{end}}
 		},{{end}}
 	}
+	{{if .TestSuites}}
 	testing.TestSuites = []interface{}{ {{range .TestSuites}}
 		(*{{index $.ImportPaths .ImportPath}}.{{.StructName}})(nil),{{end}}
 	}
+	{{end}}
+
+	// In a worker, run each job in its own goroutine until all of them return.
+	if *worker {
+		gospf.INFO.Println("Running gospf worker"){{if not .Jobs}}
+		gospf.WARN.Println("No jobs found under app/jobs"){{end}}
+		var wg sync.WaitGroup
+		for _, job := range []interface{ Run() }{ {{range .Jobs}}
+			&{{index $.ImportPaths .ImportPath}}.{{.StructName}}{},{{end}}
+		} {
+			wg.Add(1)
+			go func(job interface{ Run() }) {
+				defer wg.Done()
+				job.Run()
+			}(job)
+		}
+		wg.Wait()
+		return
+	}
+
+	// Reload the templates on SIGHUP, without restarting.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			gospf.INFO.Println("Reloading templates")
+			if err := gospf.MainTemplateLoader.Refresh(); err != nil {
+				gospf.ERROR.Println("Failed to reload templates:", err)
+			}
+		}
+	}()
+
+	if *addr != "" {
+		gospf.HttpAddr = *addr
+	}
+
+	// Serve the listener handed over by the harness, which reserved the port,
+	// through a unix socket, since the server listens on its own.
+	if *listenerFd != 0 {
+		*socket = forwardListener(*listenerFd, *socket)
+	}
+
+	// Listen on the unix socket, replacing any left by a previous run.
+	if *socket != "" {
+		os.Remove(*socket)
+		gospf.HttpAddr = "unix:" + *socket
+	}
 
 	gospf.Run(*port)
 }
+
+// forwardListener forwards the connections of the inherited listener to a
+// unix socket, that given by the harness if any, which it returns for the
+// server to listen on.  gospf.Run opens its own listener, so the connections
+// take this extra hop.
+func forwardListener(fd int, socket string) string {
+	listener, err := net.FileListener(os.NewFile(uintptr(fd), "listener"))
+	if err != nil {
+		gospf.ERROR.Fatalln("Failed to serve the inherited listener:", err)
+	}
+	if socket == "" {
+		socket = filepath.Join(os.TempDir(), fmt.Sprintf("gospf-app-%d.sock", os.Getpid()))
+	}
+	ready := make(chan struct{})
+	go waitForSocket(socket, ready)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				gospf.ERROR.Println("Failed to accept a connection:", err)
+				return
+			}
+			go forwardConn(conn, socket, ready)
+		}
+	}()
+	return socket
+}
+
+// waitForSocket closes ready once the server listens on the socket.  gospf.Run
+// does not tell when it listens, so the socket is dialed until it answers,
+// once for all the connections.
+func waitForSocket(socket string, ready chan struct{}) {
+	for {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			close(ready)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// forwardConn copies the connection to and from the server on the socket.
+// The connections accepted before the server listens wait for it, for up to
+// 30 seconds.
+func forwardConn(conn net.Conn, socket string, ready chan struct{}) {
+	defer conn.Close()
+	select {
+	case <-ready:
+	case <-time.After(30 * time.Second):
+		gospf.ERROR.Println("Failed to forward a connection: the server is not listening on", socket)
+		return
+	}
+	server, err := net.Dial("unix", socket)
+	if err != nil {
+		gospf.ERROR.Println("Failed to forward a connection:", err)
+		return
+	}
+	defer server.Close()
+	go func() {
+		io.Copy(server, conn)
+		server.(*net.UnixConn).CloseWrite()
+	}()
+	io.Copy(conn, server)
+}
+`
+
+// COVERAGE is generated into app/tmp when the app is built with Cover, so
+// that it exits on SIGTERM, writing its coverage counters, rather than being
+// killed.
+const COVERAGE = `// GENERATED CODE - DO NOT EDIT
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func init() {
+	go func() {
+		term := make(chan os.Signal, 1)
+		signal.Notify(term, syscall.SIGTERM)
+		<-term
+		os.Exit(0)
+	}()
+}
 `
+
 const ROUTES = `// GENERATED CODE - DO NOT EDIT
 package routes
 
-import "github.com/gospf/gospf"
+import (
+	"github.com/gospf/gospf"{{range $k, $v := $.RouteImportPaths}}
+	{{$v}} "{{$k}}"{{end}}
+)
 
 {{range $i, $c := .Controllers}}
+// gospf:source {{.StructName}}
 type t{{.StructName}} struct {}
 var {{.StructName}} t{{.StructName}}
 
 {{range .MethodSpecs}}
+// gospf:source {{$c.StructName}}.{{.Name}} at {{.File}}:{{.Line}}
 func (_ t{{$c.StructName}}) {{.Name}}({{range .Args}}
-		{{.Name}} {{if .ImportPath}}interface{}{{else}}{{.TypeExpr.TypeName ""}}{{end}},{{end}}
+		{{.Name}} {{if not .ImportPath}}{{.TypeExpr.TypeName ""}}{{else if index $.RouteImportPaths .ImportPath}}{{index $.RouteImportPaths .ImportPath | .TypeExpr.TypeName}}{{else}}interface{}{{end}},{{end}}
 		) string {
 	args := make(map[string]string)
 	{{range .Args}}
@@ -0,0 +1,221 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/hubply/gospf"
+)
+
+const unformattedSource = `package main
+
+import (
+	"flag"
+	"reflect"
+	_ "github.com/example/app/models"
+	controllers "github.com/example/app/controllers"
+	controllers0 "github.com/example/other/controllers"
+)
+
+func main() {
+		flag.Parse()
+	_ = controllers.Application{}
+}
+`
+
+func TestFormatSource(t *testing.T) {
+	formatted, err := formatSource("main.go", unformattedSource)
+	if err != nil {
+		t.Fatal("Failed to format source:", err)
+	}
+
+	src := string(formatted)
+	for _, expected := range []string{`"flag"`, `_ "github.com/example/app/models"`, `"github.com/example/app/controllers"`, "\tflag.Parse()\n"} {
+		if !strings.Contains(src, expected) {
+			t.Errorf("Expected formatted source to contain %q:\n%s", expected, src)
+		}
+	}
+	for _, unexpected := range []string{`"reflect"`, "controllers0"} {
+		if strings.Contains(src, unexpected) {
+			t.Errorf("Expected %q to be pruned:\n%s", unexpected, src)
+		}
+	}
+}
+
+func TestFormatSourceError(t *testing.T) {
+	if _, err := formatSource("main.go", "package main\nfunc main() {"); err == nil {
+		t.Error("Expected an error formatting invalid source")
+	}
+}
+
+func TestTypedRoutes(t *testing.T) {
+	const controllersPath = "github.com/example/app/controllers"
+	const modelsPath = "github.com/example/app/models"
+	src := &SourceInfo{controllerSpecs: []*TypeInfo{{
+		StructName: "Users",
+		ImportPath: controllersPath,
+		MethodSpecs: []*MethodSpec{{
+			Name: "Show",
+			Args: []*MethodArg{
+				{Name: "id", TypeExpr: TypeExpr{"int", "", 0, true}},
+				{Name: "user", TypeExpr: TypeExpr{"*User", "models", 1, true}, ImportPath: modelsPath},
+				{Name: "form", TypeExpr: TypeExpr{"Form", "controllers", 0, true}, ImportPath: controllersPath},
+			},
+		}},
+	}}}
+
+	aliases := calcImportAliases(src)
+	routeAliases := calcRouteImportAliases(src, aliases)
+	if len(routeAliases) != 1 || routeAliases[modelsPath] != "models" {
+		t.Fatalf("Expected only the models package, got %v", routeAliases)
+	}
+
+	sourceCode := gospf.ExecuteTemplate(template.Must(template.New("").Parse(ROUTES)), map[string]interface{}{
+		"Controllers":      src.ControllerSpecs(),
+		"RouteImportPaths": routeAliases,
+	})
+	formatted, err := formatSource("routes.go", sourceCode)
+	if err != nil {
+		t.Fatalf("Failed to format routes: %s\n%s", err, sourceCode)
+	}
+	for _, expected := range []string{"id int,", "user *models.User,", "form interface{},", `"` + modelsPath + `"`} {
+		if !strings.Contains(string(formatted), expected) {
+			t.Errorf("Expected routes to contain %q:\n%s", expected, formatted)
+		}
+	}
+}
+
+func TestMergeBuildTags(t *testing.T) {
+	tests := []struct {
+		runMode string
+		lists   []string
+		tags    string
+	}{
+		{"dev", nil, "gospf_dev"},
+		{"prod", []string{"netgo", ""}, "netgo,gospf_prod"},
+		{"dev", []string{"netgo sqlite", "sqlite,debug"}, "netgo,sqlite,debug,gospf_dev"},
+		{"staging-eu", []string{"", "gospf_staging_eu"}, "gospf_staging_eu"},
+	}
+	for _, test := range tests {
+		if tags := mergeBuildTags(test.runMode, test.lists...); tags != test.tags {
+			t.Errorf("mergeBuildTags(%q, %q) = %q, expected %q", test.runMode, test.lists, tags, test.tags)
+		}
+	}
+}
+
+func TestTraceGeneratedError(t *testing.T) {
+	controllerPath := filepath.Join(t.TempDir(), "users.go")
+	controllerSource := "package controllers\n\nfunc (c Users) Show(id int) gospf.Result {\n\treturn nil\n}\n"
+	if err := ioutil.WriteFile(controllerPath, []byte(controllerSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := &SourceInfo{controllerSpecs: []*TypeInfo{{
+		StructName: "Users",
+		ImportPath: "github.com/example/app/controllers",
+		MethodSpecs: []*MethodSpec{{
+			Name: "Show",
+			Args: []*MethodArg{{Name: "id", TypeExpr: TypeExpr{"int", "", 0, true}}},
+			File: controllerPath,
+			Line: 3,
+		}},
+	}}}
+	sourceCode := gospf.ExecuteTemplate(template.Must(template.New("").Parse(ROUTES)), map[string]interface{}{
+		"Controllers":      src.ControllerSpecs(),
+		"RouteImportPaths": map[string]string{},
+	})
+	formatted, err := formatSource("routes.go", sourceCode)
+	if err != nil {
+		t.Fatalf("Failed to format routes: %s\n%s", err, sourceCode)
+	}
+
+	lines := strings.Split(string(formatted), "\n")
+	line := 0
+	for i, l := range lines {
+		if strings.Contains(l, "gospf.Unbind(") {
+			line = i + 1
+		}
+	}
+	genError := &gospf.Error{Path: "app/routes/routes.go", Line: line, Description: "undefined: gospf.Unbind", SourceLines: lines}
+	traceGeneratedError(genError)
+	if genError.Path != controllerPath || genError.Line != 3 {
+		t.Errorf("Expected the error at the declaration of Users.Show, got %s:%d", genError.Path, genError.Line)
+	}
+	if len(genError.SourceLines) < 3 || !strings.Contains(genError.SourceLines[2], "Show(id int)") {
+		t.Errorf("Expected the source lines of the controller, got %q", genError.SourceLines)
+	}
+	if !strings.Contains(genError.Description, "generated for Users.Show, at app/routes/routes.go:") ||
+		!strings.Contains(genError.Description, `gospf.Unbind(args, "id", id)`) {
+		t.Errorf("Expected the description to name the generated line, got %q", genError.Description)
+	}
+
+	// The error points at the declaration even if its file can't be read.
+	os.Remove(controllerPath)
+	genError = &gospf.Error{Path: "app/routes/routes.go", Line: line, Description: "undefined: gospf.Unbind", SourceLines: lines}
+	traceGeneratedError(genError)
+	if genError.Path != controllerPath || genError.Line != 3 || genError.SourceLines != nil {
+		t.Errorf("Expected the error at the declaration without source lines, got %s:%d %q", genError.Path, genError.Line, genError.SourceLines)
+	}
+	if !strings.Contains(genError.Description, "generated for Users.Show") {
+		t.Errorf("Expected the description to name the generated line, got %q", genError.Description)
+	}
+
+	// Errors outside the generated code of an action are left as they are.
+	genError = &gospf.Error{Path: "app/routes/routes.go", Line: 1, Description: "expected", SourceLines: lines}
+	traceGeneratedError(genError)
+	if genError.Path != "app/routes/routes.go" || genError.Description != "expected" {
+		t.Errorf("Expected the error to be left as it is, got %#v", genError)
+	}
+}
+
+func TestMainRegistersInterceptors(t *testing.T) {
+	const controllersPath = "github.com/example/app/controllers"
+	src := &SourceInfo{
+		controllerSpecs: []*TypeInfo{{
+			StructName:   "App",
+			ImportPath:   controllersPath,
+			PackageName:  "controllers",
+			Interceptors: []*InterceptorSpec{{Name: "Before", ImportPath: controllersPath}},
+		}},
+		InterceptorFuncs: []*InterceptorSpec{{Name: "Panic", ImportPath: controllersPath, PackageName: "controllers"}},
+	}
+	importPaths := calcImportAliases(src)
+	sourceCode := gospf.ExecuteTemplate(template.Must(template.New("").Parse(MAIN)), map[string]interface{}{
+		"Controllers":      src.ControllerSpecs(),
+		"ImportPaths":      importPaths,
+		"InterceptorFuncs": src.InterceptorFuncs,
+	})
+	formatted, err := formatSource("main.go", sourceCode)
+	if err != nil {
+		t.Fatalf("Failed to format main: %s\n%s", err, sourceCode)
+	}
+	for _, expected := range []string{
+		"gospf.InterceptMethod((*controllers.App).Before, gospf.BEFORE)",
+		"gospf.InterceptFunc(controllers.Panic, gospf.PANIC, &gospf.Controller{})",
+	} {
+		if !strings.Contains(string(formatted), expected) {
+			t.Errorf("Expected main to contain %q:\n%s", expected, formatted)
+		}
+	}
+}
+
+func TestIsGeneratedSource(t *testing.T) {
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"generated.go": generatedHeader + "\npackage routes\n",
+		"written.go":   "package routes\n\n" + generatedHeader + "\n",
+		"short.go":     "package",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, expected := range map[string]bool{"generated.go": true, "written.go": false, "short.go": false, "missing.go": false} {
+		if isGeneratedSource(filepath.Join(dir, name)) != expected {
+			t.Errorf("Expected isGeneratedSource(%s) to be %v", name, expected)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// When a build fails, the harness shows what changed since the last
+// successful build, so that the edit that broke it stands out: the error page
+// and the console get a short diff of the files modified since, e.g.
+//
+//	Changed since the last successful build:
+//	--- app/controllers/app.go
+//	+++ app/controllers/app.go
+//	@@ -12,3 +12,3 @@
+//	 func (c App) Index() gospf.Result {
+//	-	greeting := "hello"
+//	+	greeting := hello
+//	 	return c.Render(greeting)
+//
+// The Go files of the source paths are compared, with go.mod and
+// conf/routes.  harness.builddiff.lines (40 by default) limits the length of the
+// diff, and harness.builddiff=false leaves it out.
+
+// defaultBuildDiffLines is the most lines of the diff, unless
+// harness.builddiff.lines says otherwise.
+const defaultBuildDiffLines = 40
+
+// Limits of the comparison of the files: larger files are left out, and
+// the changes of files with more lines than maxDiffCells allows to align are
+// shown as removed and added whole.
+const (
+	maxSnapshotFileSize = 1 << 20
+	maxDiffCells        = 1 << 22
+)
+
+// diffContext is the number of unchanged lines shown around the changes.
+const diffContext = 2
+
+// buildDiff keeps the source of the last successful build.
+type buildDiff struct {
+	maxLines int
+
+	mu   sync.Mutex
+	last *sourceSnapshot // Nil until a build succeeds.
+}
+
+// loadBuildDiff returns the diff of the builds, or nil if harness.builddiff
+// is false.
+func loadBuildDiff() *buildDiff {
+	if !gospf.Config.BoolDefault("harness.builddiff", true) {
+		return nil
+	}
+	return &buildDiff{maxLines: gospf.Config.IntDefault("harness.builddiff.lines", defaultBuildDiffLines)}
+}
+
+// succeeded records the source of a successful build.
+func (d *buildDiff) succeeded() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = snapshotFiles(buildDiffFiles(), d.last)
+}
+
+// failed returns the error of a failed build, with the diff of the source
+// since the last successful build, which it also prints on the console.  The
+// error is returned as is if there is no such build, or no change.
+func (d *buildDiff) failed(err *gospf.Error) *gospf.Error {
+	d.mu.Lock()
+	last := d.last
+	d.mu.Unlock()
+	if last == nil {
+		return err
+	}
+	diff := last.diff(snapshotFiles(buildDiffFiles(), last), d.maxLines)
+	if diff == "" {
+		return err
+	}
+	gospf.INFO.Printf("Changed since the last successful build:\n%s", diff)
+	explained := *err
+	explained.Description += "\n\nChanged since the last successful build:\n" + diff
+	return &explained
+}
+
+// buildDiffFiles returns the files compared between the builds.
+func buildDiffFiles() []string {
+	var files []string
+	roots := SourcePaths()
+	filter := newWatchFilter(roots)
+	for _, root := range roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != root && (strings.HasPrefix(info.Name(), ".") || filter.ignores(path)) || skipSourceDir(root, path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, ".go") && !filter.ignoresFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	// Of conf/, only the routes are compared: the other files, e.g. app.conf,
+	// hold secrets, which the error page would show.
+	return append(files, filepath.Join(gospf.BasePath, "go.mod"), filepath.Join(gospf.BasePath, "conf", "routes"))
+}
+
+// sourceSnapshot is the content of the source files at a time.
+type sourceSnapshot struct {
+	files map[string]snapshotFile // By path.
+}
+
+type snapshotFile struct {
+	modTime time.Time
+	size    int64
+	content []byte
+}
+
+// snapshotFiles returns the snapshot of the files, which reuses the content
+// of the previous snapshot for those whose modification time and size did not
+// change.  The missing files and the large ones are left out.
+func snapshotFiles(paths []string, previous *sourceSnapshot) *sourceSnapshot {
+	snapshot := &sourceSnapshot{files: make(map[string]snapshotFile)}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxSnapshotFileSize {
+			continue
+		}
+		if previous != nil {
+			if file, found := previous.files[path]; found && file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+				snapshot.files[path] = file
+				continue
+			}
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		snapshot.files[path] = snapshotFile{modTime: info.ModTime(), size: info.Size(), content: content}
+	}
+	return snapshot
+}
+
+// diff returns the unified diff of the files from the snapshot to the current
+// one, cut to the given number of lines, or "" if none changed.
+func (s *sourceSnapshot) diff(current *sourceSnapshot, maxLines int) string {
+	var paths []string
+	for path := range s.files {
+		paths = append(paths, path)
+	}
+	for path := range current.files {
+		if _, found := s.files[path]; !found {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var lines []string
+	for _, path := range paths {
+		old, hadOld := s.files[path]
+		cur, hasCur := current.files[path]
+		if hadOld && hasCur && bytes.Equal(old.content, cur.content) {
+			continue
+		}
+		name := displayPath(path)
+		oldName, newName := name, name
+		if !hadOld {
+			oldName = "/dev/null"
+		}
+		if !hasCur {
+			newName = "/dev/null"
+		}
+		lines = append(lines, "--- "+oldName, "+++ "+newName)
+		lines = append(lines, unifiedHunks(editScript(splitLines(old.content), splitLines(cur.content)), diffContext)...)
+	}
+	if maxLines > 0 && len(lines) > maxLines {
+		more := len(lines) - maxLines
+		lines = append(lines[:maxLines], fmt.Sprintf("... %d more line%s", more, plural(more)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// displayPath returns the path relative to the app directory, if it is in it.
+func displayPath(path string) string {
+	if rel, err := filepath.Rel(gospf.BasePath, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// splitLines returns the lines of the content, without their ends.
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// lineEdit is a line of an edit script: unchanged (' '), removed ('-') or
+// added ('+').
+type lineEdit struct {
+	op   byte
+	text string
+}
+
+// editScript returns the edits that turn the lines a into the lines b, with
+// the fewest lines removed and added.
+func editScript(a, b []string) []lineEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []lineEdit
+	for _, line := range a[:prefix] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	edits = append(edits, alignLines(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, lineEdit{' ', line})
+	}
+	return edits
+}
+
+// alignLines returns the edits that turn the lines a into the lines b, along
+// their longest common subsequence.
+func alignLines(a, b []string) []lineEdit {
+	var edits []lineEdit
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			edits = append(edits, lineEdit{'-', line})
+		}
+		for _, line := range b {
+			edits = append(edits, lineEdit{'+', line})
+		}
+		return edits
+	}
+
+	// common[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, lineEdit{' ', a[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			edits = append(edits, lineEdit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, lineEdit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, lineEdit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, lineEdit{'+', b[j]})
+	}
+	return edits
+}
+
+// unifiedHunks returns the hunks of the edits in the unified diff format, with
+// the given number of unchanged lines around the changes.
+func unifiedHunks(edits []lineEdit, context int) []string {
+	// before[k] are the numbers of lines of a and b before the k-th edit.
+	type position struct{ a, b int }
+	before := make([]position, len(edits)+1)
+	for k, edit := range edits {
+		before[k+1] = before[k]
+		if edit.op != '+' {
+			before[k+1].a++
+		}
+		if edit.op != '-' {
+			before[k+1].b++
+		}
+	}
+
+	var lines []string
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// The hunk spans the changes separated by at most twice the context.
+		end := k + 1
+		for j := end; j < len(edits) && j-end < 2*context; j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			}
+		}
+		start, stop := k-context, end+context
+		if start < 0 {
+			start = 0
+		}
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+
+		from, to := before[start], before[stop]
+		lines = append(lines, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(from.a, to.a-from.a), hunkRange(from.b, to.b-from.b)))
+		for _, edit := range edits[start:stop] {
+			lines = append(lines, string(edit.op)+edit.text)
+		}
+		k = end
+	}
+	return lines
+}
+
+// hunkRange formats the range of lines of a hunk, which starts after the
+// given number of lines.
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
@@ -0,0 +1,80 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedHunks(t *testing.T) {
+	a := strings.Split("package app\n\nfunc Index() {\n\tgreeting := \"hello\"\n\trender(greeting)\n}\n\nfunc A() {}\nfunc B() {}\nfunc C() {}\nfunc D() {}\nfunc E() {}", "\n")
+	b := strings.Split("package app\n\nfunc Index() {\n\tgreeting := hello\n\trender(greeting)\n}\n\nfunc A() {}\nfunc B() {}\nfunc C() {}\nfunc D() {}\nfunc E() {}\nfunc F() {}", "\n")
+	expected := []string{
+		"@@ -2,5 +2,5 @@",
+		" ",
+		" func Index() {",
+		"-\tgreeting := \"hello\"",
+		"+\tgreeting := hello",
+		" \trender(greeting)",
+		" }",
+		"@@ -11,2 +11,3 @@",
+		" func D() {}",
+		" func E() {}",
+		"+func F() {}",
+	}
+	if hunks := unifiedHunks(editScript(a, b), 2); !reflect.DeepEqual(hunks, expected) {
+		t.Errorf("Expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(hunks, "\n"))
+	}
+
+	expected = []string{"@@ -0,0 +1,2 @@", "+one", "+two"}
+	if hunks := unifiedHunks(editScript(nil, []string{"one", "two"}), 2); !reflect.DeepEqual(hunks, expected) {
+		t.Errorf("Expected %q for a new file, got %q", expected, hunks)
+	}
+	if hunks := unifiedHunks(editScript(a, a), 2); len(hunks) != 0 {
+		t.Errorf("Expected no hunks for the same lines, got %q", hunks)
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builddiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	app := write("app.go", "package app\n\nvar x = 1\n")
+	old := write("old.go", "package app\n")
+	paths := []string{app, old, filepath.Join(dir, "new.go")}
+
+	last := snapshotFiles(paths, nil)
+	if diff := last.diff(snapshotFiles(paths, last), 0); diff != "" {
+		t.Errorf("Expected no diff without changes, got %q", diff)
+	}
+
+	write("app.go", "package app\n\nvar x = yy\n")
+	write("new.go", "package app\n")
+	os.Remove(old)
+	diff := last.diff(snapshotFiles(paths, last), 0)
+	for _, expected := range []string{
+		"--- " + app + "\n+++ " + app + "\n@@ -1,3 +1,3 @@\n package app\n \n-var x = 1\n+var x = yy",
+		"--- /dev/null\n+++ " + filepath.Join(dir, "new.go") + "\n@@ -0,0 +1,1 @@\n+package app",
+		"--- " + old + "\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-package app",
+	} {
+		if !strings.Contains(diff, expected) {
+			t.Errorf("Expected the diff to contain\n%s\ngot\n%s", expected, diff)
+		}
+	}
+
+	if diff = last.diff(snapshotFiles(paths, last), 4); !strings.HasSuffix(diff, "\n... 11 more lines") {
+		t.Errorf("Expected the diff to be cut, got\n%s", diff)
+	}
+}
@@ -0,0 +1,250 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// The version of the app, APP_VERSION, is that of the first of the providers
+// of build.version.providers that determines one, by default:
+//
+//	env   the APP_VERSION environment variable
+//	git   "git describe" of the app's repository, e.g. "git-v1.2-3-gabc1234",
+//	      or the full commit hash with build.reproducible set
+//	hg    "hg identify" of the app's repository, e.g. "hg-abc123def456+"
+//	file  the content of the VERSION file of the app, or build.version.file
+//	ci    the commit built by CI, from GITHUB_SHA, CI_COMMIT_SHA (GitLab),
+//	      CIRCLE_SHA1, BUILDKITE_COMMIT, TRAVIS_COMMIT, BITBUCKET_COMMIT or
+//	      GIT_COMMIT (Jenkins)
+//
+// The build also writes the app/tmp/buildinfo package, which the app may
+// import for its version, the time of the build and the version of Go that built it.
+// They are set with the -X linker flags, as APP_VERSION is.  In reproducible
+// builds, the build time is that of SOURCE_DATE_EPOCH, if set, and empty
+// otherwise.
+
+// defaultVersionProviders are the version providers tried without
+// build.version.providers.
+const defaultVersionProviders = "env, git, hg, file, ci"
+
+// versionProviders are the functions that determine the version of the app,
+// by name.  They return an empty string if they cannot.
+var versionProviders = map[string]func() string{
+	"env":  envVersion,
+	"git":  gitVersion,
+	"hg":   hgVersion,
+	"file": fileVersion,
+	"ci":   ciVersion,
+}
+
+// ciCommitVariables are the environment variables that hold the commit built,
+// by CI service.
+var ciCommitVariables = []string{
+	"GITHUB_SHA",
+	"CI_COMMIT_SHA",
+	"CIRCLE_SHA1",
+	"BUILDKITE_COMMIT",
+	"TRAVIS_COMMIT",
+	"BITBUCKET_COMMIT",
+	"GIT_COMMIT",
+}
+
+// getAppVersion returns the version of the first provider of
+// build.version.providers that determines it, or an empty string if none
+// does.
+func getAppVersion() string {
+	for _, name := range strings.Split(gospf.Config.StringDefault("build.version.providers", defaultVersionProviders), ",") {
+		name = strings.TrimSpace(name)
+		provider, found := versionProviders[name]
+		if !found {
+			if name != "" {
+				gospf.WARN.Printf("build.version.providers: unknown provider %q", name)
+			}
+			continue
+		}
+		if version := provider(); version != "" {
+			gospf.TRACE.Printf("Version %s, from the %s provider", version, name)
+			return version
+		}
+	}
+	return ""
+}
+
+// AppVersion returns the version of the app that is built into it as
+// APP_VERSION, or an empty string if it cannot be determined.
+func AppVersion() string {
+	return getAppVersion()
+}
+
+func envVersion() string {
+	return os.Getenv("APP_VERSION")
+}
+
+// gitVersion returns the output of "git describe" if the source is in a git
+// repository, which is the full commit hash rather than the nearest tag with
+// build.reproducible set.
+func gitVersion() string {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return ""
+	}
+	gitDir := filepath.Join(gospf.BasePath, ".git")
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		return ""
+	}
+	args := []string{"--git-dir=" + gitDir, "describe", "--always", "--dirty"}
+	if Reproducible() {
+		args = append(args, "--abbrev=40", "--exclude=*")
+	}
+	gitCmd := exec.Command(gitPath, args...)
+	gospf.TRACE.Println("Exec:", gitCmd.Args)
+	output, err := gitCmd.Output()
+	if err != nil {
+		gospf.WARN.Println("Cannot determine git repository version:", err)
+		return ""
+	}
+	return "git-" + strings.TrimSpace(string(output))
+}
+
+// hgVersion returns the output of "hg identify" if the source is in a
+// mercurial repository: the changeset, followed by "+" if the working
+// directory has changes.
+func hgVersion() string {
+	hgPath, err := exec.LookPath("hg")
+	if err != nil {
+		return ""
+	}
+	if info, err := os.Stat(filepath.Join(gospf.BasePath, ".hg")); err != nil || !info.IsDir() {
+		return ""
+	}
+	args := []string{"identify", "--id"}
+	if Reproducible() {
+		args = append(args, "--debug")
+	}
+	hgCmd := exec.Command(hgPath, args...)
+	hgCmd.Dir = gospf.BasePath
+	gospf.TRACE.Println("Exec:", hgCmd.Args)
+	output, err := hgCmd.Output()
+	if err != nil {
+		gospf.WARN.Println("Cannot determine mercurial repository version:", err)
+		return ""
+	}
+	return "hg-" + strings.TrimSpace(string(output))
+}
+
+// fileVersion returns the first line of the version file of the app.
+func fileVersion() string {
+	filename := gospf.Config.StringDefault("build.version.file", "VERSION")
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(gospf.BasePath, filename)
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			gospf.WARN.Println("Cannot read the version file:", err)
+		}
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+}
+
+// ciVersion returns the commit that CI builds, if any.
+func ciVersion() string {
+	for _, name := range ciCommitVariables {
+		if commit := strings.TrimSpace(os.Getenv(name)); commit != "" {
+			return "git-" + commit
+		}
+	}
+	return ""
+}
+
+// buildInfo is what the app knows of its build.
+type buildInfo struct {
+	Version   string // The version of the app, as APP_VERSION.
+	BuildTime string // When the app was built, in RFC 3339.
+	GoVersion string // The version of Go that built it, e.g. "go1.22.1".
+}
+
+// newBuildInfo returns the information of the build of the app with the go
+// command, run in the environment.
+func newBuildInfo(ctx context.Context, goPath string, env []string) buildInfo {
+	info := buildInfo{Version: getAppVersion(), BuildTime: buildTime()}
+	cmd := exec.CommandContext(ctx, goPath, "env", "GOVERSION")
+	cmd.Env = env
+	if output, err := cmd.Output(); err == nil {
+		info.GoVersion = strings.TrimSpace(string(output))
+	} else {
+		gospf.WARN.Println("Cannot determine the version of Go:", err)
+	}
+	return info
+}
+
+// buildTime returns the time of the build, which is SOURCE_DATE_EPOCH if set,
+// and otherwise the current time, except in reproducible builds.
+func buildTime() string {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if seconds, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+		}
+		gospf.WARN.Printf("Invalid SOURCE_DATE_EPOCH %q", epoch)
+	}
+	if Reproducible() {
+		return ""
+	}
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// linkerFlags returns the -X linker flags that set APP_VERSION and the
+// variables of the buildinfo package.
+func (info buildInfo) linkerFlags() string {
+	buildinfoPath := path.Join(gospf.ImportPath, "app", buildInfoDir)
+	flags := []string{
+		linkerVariable(gospf.ImportPath+"/app.APP_VERSION", info.Version),
+		linkerVariable(buildinfoPath+".Version", info.Version),
+		linkerVariable(buildinfoPath+".BuildTime", info.BuildTime),
+		linkerVariable(buildinfoPath+".GoVersion", info.GoVersion),
+	}
+	return strings.Join(flags, " ")
+}
+
+// linkerVariable returns the -X linker flag that sets the variable, quoted
+// for the go command.
+func linkerVariable(name, value string) string {
+	return fmt.Sprintf("-X '%s=%s'", name, strings.Replace(value, "'", "", -1))
+}
+
+// buildInfoDir is the directory of the generated buildinfo package, relative
+// to the app directory.  It is under tmp, with the rest of the generated
+// code, so that a buildinfo package of the app's own is left alone.
+const buildInfoDir = "tmp/buildinfo"
+
+// BUILDINFO is generated into app/tmp/buildinfo, whose variables the linker
+// sets.
+const BUILDINFO = `// GENERATED CODE - DO NOT EDIT
+
+// Package buildinfo describes the build of the app.
+package buildinfo
+
+// The variables are set by the linker as gospf builds the app.
+var (
+	// Version is the version of the app, as APP_VERSION.
+	Version string
+
+	// BuildTime is when the app was built, in RFC 3339, or empty in
+	// reproducible builds without SOURCE_DATE_EPOCH.
+	BuildTime string
+
+	// GoVersion is the version of Go that built the app, e.g. "go1.22.1".
+	GoVersion string
+)
+`
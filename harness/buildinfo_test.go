@@ -0,0 +1,60 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hubply/gospf"
+)
+
+func TestFileVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	basePath := gospf.BasePath
+	gospf.BasePath = dir
+	defer func() { gospf.BasePath = basePath }()
+
+	if version := fileVersion(); version != "" {
+		t.Errorf("Expected no version without a VERSION file, got %q", version)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "VERSION"), []byte("1.4.2\nreleased in May\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if version := fileVersion(); version != "1.4.2" {
+		t.Errorf("Expected 1.4.2, got %q", version)
+	}
+}
+
+func TestCIVersion(t *testing.T) {
+	for _, name := range ciCommitVariables {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+	if version := ciVersion(); version != "" {
+		t.Errorf("Expected no version outside of CI, got %q", version)
+	}
+	os.Setenv("CI_COMMIT_SHA", "0123abcd")
+	if version := ciVersion(); version != "git-0123abcd" {
+		t.Errorf("Expected git-0123abcd, got %q", version)
+	}
+}
+
+func TestBuildTime(t *testing.T) {
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	if buildTime := buildTime(); buildTime != "2023-11-14T22:13:20Z" {
+		t.Errorf("Expected 2023-11-14T22:13:20Z, got %q", buildTime)
+	}
+}
+
+func TestLinkerVariable(t *testing.T) {
+	if flag := linkerVariable("app/buildinfo.Version", "it's 1.0"); flag != "-X 'app/buildinfo.Version=its 1.0'" {
+		t.Errorf("Unexpected flag %q", flag)
+	}
+}
@@ -0,0 +1,128 @@
+package harness
+
+import (
+	"context"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// BuildOptions configures a single build of the app by BuildOnce.
+type BuildOptions struct {
+	Context    context.Context // Cancels the build when done, if set.
+	BuildFlags []string        // Extra flags for go build.
+	Variants   []Variant       // More variants to build along with the app.
+}
+
+// BuildManifest describes a build of the app, for release tooling to read
+// rather than parse the log.  The paths of the generated files are relative
+// to the app's base path.
+type BuildManifest struct {
+	ImportPath   string   `json:"importPath"`
+	RunMode      string   `json:"runMode"`
+	Binary       string   `json:"binary"`
+	Tags         string   `json:"tags"`
+	Profile      string   `json:"profile,omitempty"`
+	Generated    []string `json:"generated"`
+	Controllers  []string `json:"controllers"` // e.g. "github.com/gospf/samples/chat/app/controllers.Application"
+	Dependencies []string `json:"dependencies"`
+	DurationMs   int64    `json:"durationMs"`
+
+	// Variants are the binaries of the other variants built, by name.
+	Variants map[string]string `json:"variants,omitempty"`
+}
+
+// BuildOnce builds the app once, without watching its source, and returns the
+// manifest of the build.  Like Build, it requires that gospf.Init has been
+// called, and prints the error to the terminal if the build fails.
+func BuildOnce(opts BuildOptions) (*BuildManifest, *gospf.Error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	started := time.Now()
+	manifest := &BuildManifest{
+		ImportPath: gospf.ImportPath,
+		RunMode:    gospf.RunMode,
+		Tags:       BuildTags(),
+		Profile:    profileName(),
+	}
+	variants := append([]Variant{DefaultVariant()}, opts.Variants...)
+	apps, err := buildVariants(ctx, manifest, variants, opts.BuildFlags)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Binary = apps[0].BinaryPath
+	for i, variant := range opts.Variants {
+		if manifest.Variants == nil {
+			manifest.Variants = make(map[string]string)
+		}
+		manifest.Variants[variant.Name] = apps[i+1].BinaryPath
+	}
+	manifest.Dependencies = listDependencies()
+	manifest.DurationMs = time.Since(started).Nanoseconds() / int64(time.Millisecond)
+	return manifest, nil
+}
+
+// recordSource adds the controllers found in the source to the manifest.
+func (m *BuildManifest) recordSource(sourceInfo *SourceInfo) {
+	for _, spec := range sourceInfo.ControllerSpecs() {
+		m.Controllers = append(m.Controllers, spec.ImportPath+"."+spec.StructName)
+	}
+	sort.Strings(m.Controllers)
+}
+
+// recordGenerated adds a file generated under the app directory to the
+// manifest.
+func (m *BuildManifest) recordGenerated(dir, filename string) {
+	m.Generated = append(m.Generated, path.Join("app", dir, filename))
+}
+
+// listDependencies returns the import paths of the packages outside the
+// standard library and the app that the built app depends on, or nil if go
+// list fails.
+func listDependencies() []string {
+	lines, err := GoListDeps("{{.ImportPath}}")
+	if err != nil {
+		gospf.WARN.Println("Failed to list the dependencies of the app:", err)
+		return nil
+	}
+	var deps []string
+	for _, importPath := range lines {
+		if importPath != gospf.ImportPath && !strings.HasPrefix(importPath, gospf.ImportPath+"/") {
+			deps = append(deps, importPath)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// GoListDeps lists the packages that the built app depends on with go list,
+// with the app's build tags and Go environment, and returns the lines that
+// the format prints for those outside the standard library, e.g.
+// "{{.ImportPath}}".
+func GoListDeps(format string) ([]string, error) {
+	env, envErr := GoEnv()
+	if envErr != nil {
+		return nil, envErr
+	}
+	cmd := exec.Command("go", "list", "-deps", "-tags", BuildTags(),
+		"-f", "{{if not .Standard}}"+format+"{{end}}",
+		path.Join(gospf.ImportPath, "app", "tmp"))
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,26 @@
+package harness
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildManifestRecords(t *testing.T) {
+	src := &SourceInfo{controllerSpecs: []*TypeInfo{
+		{StructName: "Users", ImportPath: "app/controllers"},
+		{StructName: "Application", ImportPath: "app/controllers"},
+	}}
+	manifest := &BuildManifest{}
+	manifest.recordSource(src)
+	manifest.recordGenerated("tmp", "main.go")
+	manifest.recordGenerated("routes", "routes.go")
+
+	expected := []string{"app/controllers.Application", "app/controllers.Users"}
+	if !reflect.DeepEqual(manifest.Controllers, expected) {
+		t.Errorf("Expected the controllers %v, got %v", expected, manifest.Controllers)
+	}
+	expected = []string{"app/tmp/main.go", "app/routes/routes.go"}
+	if !reflect.DeepEqual(manifest.Generated, expected) {
+		t.Errorf("Expected the generated files %v, got %v", expected, manifest.Generated)
+	}
+}
@@ -0,0 +1,53 @@
+package harness
+
+import (
+	"sync"
+
+	"github.com/hubply/gospf"
+)
+
+// buildQueue runs the rebuilds of the app one at a time.  The triggers that
+// arrive while a rebuild is in progress, from requests or the watch loop,
+// share it rather than start another, and all get its result.
+type buildQueue struct {
+	mu      sync.Mutex
+	current *sharedBuild // The rebuild in progress, if any.
+}
+
+// sharedBuild is a rebuild, shared by the triggers that arrived while it ran.
+type sharedBuild struct {
+	done chan struct{} // Closed when the rebuild is done.
+	err  *gospf.Error  // Set before done is closed.
+}
+
+// join returns the rebuild in progress, or else starts one that runs build.
+func (q *buildQueue) join(build func() *gospf.Error) *sharedBuild {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current != nil {
+		return q.current
+	}
+	shared := &sharedBuild{done: make(chan struct{})}
+	q.current = shared
+	go func() {
+		err := build()
+		q.mu.Lock()
+		shared.err, q.current = err, nil
+		q.mu.Unlock()
+		close(shared.done)
+	}()
+	return shared
+}
+
+// inProgress reports whether a rebuild is in progress.
+func (q *buildQueue) inProgress() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.current != nil
+}
+
+// wait waits for the rebuild, and returns its error.
+func (b *sharedBuild) wait() *gospf.Error {
+	<-b.done
+	return b.err
+}
@@ -0,0 +1,46 @@
+package harness
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/hubply/gospf"
+)
+
+func TestBuildQueueSharesBuild(t *testing.T) {
+	var q buildQueue
+	var builds int32
+	release := make(chan struct{})
+	build := func() *gospf.Error {
+		atomic.AddInt32(&builds, 1)
+		<-release
+		return &gospf.Error{Title: "Go Compilation Error"}
+	}
+
+	first := q.join(build)
+	second := q.join(build)
+	if first != second {
+		t.Fatal("Expected the triggers to share the build in progress")
+	}
+	if !q.inProgress() {
+		t.Error("Expected a build in progress")
+	}
+
+	close(release)
+	if err := first.wait(); err == nil || err.Title != "Go Compilation Error" {
+		t.Errorf("Expected the error of the build, got %v", err)
+	}
+	if second.wait() != first.wait() {
+		t.Error("Expected the waiters to get the same result")
+	}
+	if q.inProgress() {
+		t.Error("Expected no build in progress")
+	}
+
+	if err := q.join(func() *gospf.Error { return nil }).wait(); err != nil {
+		t.Errorf("Expected a new build to succeed, got %v", err)
+	}
+	if n := atomic.LoadInt32(&builds); n != 1 {
+		t.Errorf("Expected the shared build to run once, ran %d times", n)
+	}
+}
@@ -0,0 +1,74 @@
+package harness
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// WatchBuilds serves "gospf watch": it builds the app, and rebuilds it each
+// time its source changes, as the harness would, but never runs it, so that
+// the compile errors of an app that needs unavailable resources to start are
+// still reported as the code is written.
+
+// BuildResult is the result of a build of WatchBuilds.
+type BuildResult struct {
+	Time     time.Time     // When the build finished.
+	Duration time.Duration // How long the build took.
+	Binary   string        // The path of the binary, if the build succeeded.
+	Error    *gospf.Error  // The error of the build, if it failed.
+}
+
+// MarshalJSON encodes the result as the build events of the harness, with
+// their type and time, e.g.
+//
+//	{"type": "build-failed", "time": "...", "durationMs": 830, "error": {...}}
+func (r BuildResult) MarshalJSON() ([]byte, error) {
+	event := struct {
+		Type string    `json:"type"`
+		Time time.Time `json:"time"`
+		buildEvent
+	}{Type: eventBuildSucceeded, Time: r.Time}
+	event.DurationMs = r.Duration.Nanoseconds() / int64(time.Millisecond)
+	event.Binary = r.Binary
+	if r.Error != nil {
+		event.Type = eventBuildFailed
+		event.Error = newAdminError(r.Error)
+	}
+	return json.Marshal(event)
+}
+
+// WatchBuilds builds the app, and then rebuilds it when its source changes,
+// calling report with the result of each build.  It never returns.
+func WatchBuilds(report func(BuildResult)) {
+	paths := watchRoots()
+	h := &Harness{filter: newWatchFilter(paths)}
+	h.filter.scan()
+	watcher := newSourceWatcher()
+	watcher.Listen(sourceChanges{h}, paths...)
+	diff := loadBuildDiff()
+
+	for {
+		started := time.Now()
+		app, err := Build()
+		result := BuildResult{Time: time.Now(), Duration: time.Since(started), Error: err}
+		if diff != nil {
+			if err != nil {
+				result.Error = diff.failed(err)
+			} else {
+				diff.succeeded()
+			}
+		}
+		if app != nil {
+			result.Binary = app.BinaryPath
+		}
+		report(result)
+
+		for atomic.SwapInt32(&h.changed, 0) == 0 {
+			time.Sleep(directNotifyInterval)
+			watcher.Notify()
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package harness
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+func TestBuildResultJSON(t *testing.T) {
+	finished := time.Date(2024, time.March, 5, 14, 2, 9, 0, time.UTC)
+	result := BuildResult{
+		Time:     finished,
+		Duration: 830 * time.Millisecond,
+		Error: &gospf.Error{
+			Title:       "Go Compilation Error",
+			Path:        "app/controllers/app.go",
+			Line:        12,
+			Description: "undefined: hotel",
+		},
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"build-failed","time":"2024-03-05T14:02:09Z","durationMs":830,` +
+		`"error":{"title":"Go Compilation Error","path":"app/controllers/app.go","line":12,"description":"undefined: hotel"}}`
+	if string(encoded) != expected {
+		t.Errorf("Expected %s, got %s", expected, encoded)
+	}
+
+	result = BuildResult{Time: finished, Duration: 1520 * time.Millisecond, Binary: "/go/bin/booking"}
+	encoded, _ = json.Marshal(result)
+	expected = `{"type":"build-succeeded","time":"2024-03-05T14:02:09Z","durationMs":1520,"binary":"/go/bin/booking"}`
+	if string(encoded) != expected {
+		t.Errorf("Expected %s, got %s", expected, encoded)
+	}
+}
@@ -0,0 +1,164 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// Apps that use cgo, or depend on packages that do, need a C toolchain, which
+// go build otherwise reports with opaque errors.  build.cgo sets whether cgo
+// is used:
+//
+//	build.cgo = auto  as go build decides (the default): cgo is disabled for
+//	                  cross builds, and without a C compiler
+//	build.cgo = on    sets CGO_ENABLED=1, and checks the C compiler first
+//	build.cgo = off   sets CGO_ENABLED=0
+//
+// The C compiler is that of CC, e.g. set by env.CC in a build profile for a
+// cross compiler.  The failed builds are checked for the symptoms of a
+// missing compiler, of cgo being disabled, and of a missing C library, which
+// are then reported with what to do.
+
+// The symptoms of the cgo failures in the output of go build.
+var (
+	cgoCompilerPattern = regexp.MustCompile(`cgo: C compiler "([^"]+)" not found`)
+	cgoExcludedPattern = regexp.MustCompile(`build constraints exclude all Go files in (\S+)`)
+	cgoLibraryPattern  = regexp.MustCompile(`cannot find -l(\S+)|library not found for -l(\S+)|fatal error: ([\w./-]+\.h): No such file`)
+)
+
+// The kinds of cgo failures.
+const (
+	cgoNoCompiler = "compiler"
+	cgoDisabled   = "disabled"
+	cgoNoLibrary  = "library"
+)
+
+// cgoEnv returns the environment with CGO_ENABLED set as build.cgo says.  With
+// cgo on, it checks that the C compiler is found.
+func cgoEnv(ctx context.Context, goPath string, env []string) ([]string, *gospf.Error) {
+	switch mode := gospf.Config.StringDefault("build.cgo", "auto"); mode {
+	case "auto":
+		return env, nil
+	case "on":
+		env = overrideEnv(env, "CGO_ENABLED=1")
+		if cc, found := cCompiler(ctx, goPath, env); !found {
+			return nil, newCgoError(cgoNoCompiler, cc, env)
+		}
+		return env, nil
+	case "off":
+		return overrideEnv(env, "CGO_ENABLED=0"), nil
+	default:
+		return nil, &gospf.Error{
+			Title:       "Invalid Configuration",
+			Description: fmt.Sprintf("build.cgo: %q is not one of auto, on and off.", mode),
+		}
+	}
+}
+
+// cCompiler returns the C compiler of the go commands, as "go env CC" says,
+// and whether it is found.
+func cCompiler(ctx context.Context, goPath string, env []string) (string, bool) {
+	cmd := exec.CommandContext(ctx, goPath, "env", "CC")
+	cmd.Env = env
+	output, err := cmd.Output()
+	cc := strings.TrimSpace(string(output))
+	if err != nil || cc == "" {
+		cc = "gcc"
+		if runtime.GOOS == "darwin" || runtime.GOOS == "freebsd" || runtime.GOOS == "openbsd" {
+			cc = "clang"
+		}
+	}
+	if fields := strings.Fields(cc); len(fields) > 0 {
+		cc = fields[0]
+	}
+	_, err = exec.LookPath(cc)
+	return cc, err == nil
+}
+
+// diagnoseCgo returns the error of a build that failed for want of cgo or of
+// a C toolchain, given the output of go build, or nil if it did not.
+func diagnoseCgo(ctx context.Context, goPath string, env []string, output []byte) *gospf.Error {
+	kind, detail := cgoFailure(output)
+	if kind == "" {
+		return nil
+	}
+	// The files may be excluded for another platform rather than for cgo,
+	// which go build disables for cross builds, and without a C compiler.
+	if kind == cgoDisabled && lookupEnv(env, "CGO_ENABLED") != "0" {
+		if cc, found := cCompiler(ctx, goPath, env); !found {
+			return newCgoError(cgoNoCompiler, cc, env)
+		}
+		if !crossBuild(env) {
+			return nil
+		}
+	}
+	return newCgoError(kind, detail, env)
+}
+
+// cgoFailure returns the kind of cgo failure that the output of go build
+// shows, if any, and its detail: the compiler, the package or the library.
+func cgoFailure(output []byte) (kind, detail string) {
+	if match := cgoCompilerPattern.FindSubmatch(output); match != nil {
+		return cgoNoCompiler, string(match[1])
+	}
+	if match := cgoLibraryPattern.FindSubmatch(output); match != nil {
+		return cgoNoLibrary, string(match[1]) + string(match[2]) + string(match[3])
+	}
+	if match := cgoExcludedPattern.FindSubmatch(output); match != nil {
+		return cgoDisabled, string(match[1])
+	}
+	return "", ""
+}
+
+// crossBuild reports whether the environment targets another platform.
+func crossBuild(env []string) bool {
+	goos, goarch := lookupEnv(env, "GOOS"), lookupEnv(env, "GOARCH")
+	return goos != "" && goos != runtime.GOOS || goarch != "" && goarch != runtime.GOARCH
+}
+
+// newCgoError returns the error page of a cgo failure.
+func newCgoError(kind, detail string, env []string) *gospf.Error {
+	switch kind {
+	case cgoNoCompiler:
+		install := `Install gcc, e.g. with "apt-get install build-essential" or "dnf install gcc".`
+		switch runtime.GOOS {
+		case "darwin":
+			install = `Install the Xcode command line tools with "xcode-select --install".`
+		case "windows":
+			install = "Install a MinGW-w64 toolchain, e.g. with MSYS2, and add its bin directory to the PATH."
+		}
+		return &gospf.Error{
+			Title: "C Compiler Not Found",
+			Description: fmt.Sprintf("The app uses cgo, which requires the C compiler %q, not found in the PATH.  %s  "+
+				"Set CC to use another compiler, or build.cgo=off to build without cgo, if the app does not require it.", detail, install),
+		}
+	case cgoDisabled:
+		if lookupEnv(env, "CGO_ENABLED") == "0" {
+			return &gospf.Error{
+				Title: "Cgo Disabled",
+				Description: fmt.Sprintf("The package in %s seems to require cgo, which CGO_ENABLED=0 disables, e.g. with build.cgo=off.  "+
+					"Set build.cgo=on if it does.", detail),
+			}
+		}
+		goos, goarch := gospf.FirstNonEmpty(lookupEnv(env, "GOOS"), runtime.GOOS), gospf.FirstNonEmpty(lookupEnv(env, "GOARCH"), runtime.GOARCH)
+		return &gospf.Error{
+			Title: "Cgo Disabled",
+			Description: fmt.Sprintf("The package in %s seems to require cgo, which go build disables for cross builds, here for %s/%s.  "+
+				"Set build.cgo=on, and CC to a C cross compiler for the platform, e.g. with env.CC in a build profile.", detail, goos, goarch),
+		}
+	default:
+		name := strings.TrimSuffix(path.Base(detail), ".h")
+		return &gospf.Error{
+			Title: "C Library Not Found",
+			Description: fmt.Sprintf("The app uses cgo, and the C library or header %q is missing.  "+
+				"Install its development package, e.g. lib%s-dev or %s-devel, or set CGO_CFLAGS and CGO_LDFLAGS to where it is.", detail, name, name),
+		}
+	}
+}
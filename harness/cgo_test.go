@@ -0,0 +1,41 @@
+package harness
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCgoFailure(t *testing.T) {
+	tests := []struct {
+		output, kind, detail string
+	}{
+		{`# runtime/cgo
+cgo: C compiler "gcc" not found: exec: "gcc": executable file not found in $PATH`, cgoNoCompiler, "gcc"},
+		{`/usr/bin/ld: cannot find -lsqlite3
+collect2: error: ld returned 1 exit status`, cgoNoLibrary, "sqlite3"},
+		{`ld: library not found for -lssl`, cgoNoLibrary, "ssl"},
+		{`./db.go:4:10: fatal error: sqlite3.h: No such file or directory`, cgoNoLibrary, "sqlite3.h"},
+		{`package github.com/mattn/go-sqlite3: build constraints exclude all Go files in /go/src/github.com/mattn/go-sqlite3`, cgoDisabled, "/go/src/github.com/mattn/go-sqlite3"},
+		{`app/controllers/app.go:12:2: undefined: x`, "", ""},
+	}
+	for _, test := range tests {
+		if kind, detail := cgoFailure([]byte(test.output)); kind != test.kind || detail != test.detail {
+			t.Errorf("cgoFailure(%q) = %q, %q, expected %q, %q", test.output, kind, detail, test.kind, test.detail)
+		}
+	}
+}
+
+func TestCgoEnv(t *testing.T) {
+	env := overrideEnv([]string{"HOME=/home/dev", "CGO_ENABLED=0"}, "CGO_ENABLED=1")
+	if expected := []string{"HOME=/home/dev", "CGO_ENABLED=1"}; !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+
+	if crossBuild([]string{"GOOS=" + runtime.GOOS}) {
+		t.Error("Expected a build for this platform not to be a cross build")
+	}
+	if !crossBuild([]string{"GOOS=plan9"}) {
+		t.Error("Expected a build for plan9 to be a cross build")
+	}
+}
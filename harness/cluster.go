@@ -0,0 +1,384 @@
+package harness
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// A cluster is a set of harnesses on different machines, e.g. the staging
+// servers of a team, configured with the base URLs of all of them, in the
+// same order, as harness.cluster.peers:
+//
+//	harness.cluster.peers  = http://staging1:9000, http://staging2:9000
+//	harness.cluster.self   = http://staging1:9000
+//	harness.cluster.secret = <shared secret>
+//
+// Every harness registers with the leader on each heartbeat.  The leader is
+// the first of the peers that answers, so that the peers agree on it without
+// a vote, and another takes over while it is down.  The leader distributes
+// the requests it receives round-robin among the registered harnesses, so
+// that the staging domain should point at it, and fans redeploys out to them.
+
+// clusterPathPrefix is the path under which the harnesses of a cluster talk
+// to each other.  Unlike the pages under devPathPrefix, it is served in
+// every run mode, to the requests with the shared secret.
+const clusterPathPrefix = devPathPrefix + "cluster/"
+
+const (
+	// clusterSecretHeader holds harness.cluster.secret in the requests
+	// between harnesses.
+	clusterSecretHeader = "X-Gospf-Cluster-Secret"
+
+	// clusterNodeHeader marks the requests that the leader forwarded, which
+	// are served by the harness that receives them.
+	clusterNodeHeader = "X-Gospf-Cluster-Node"
+)
+
+// defaultClusterHeartbeat is how often a harness registers with the leader.
+// It is dropped from the cluster after missing three heartbeats.
+const defaultClusterHeartbeat = 2 * time.Second
+
+type cluster struct {
+	harness   *Harness
+	self      string
+	peers     []string // In order of precedence for the leadership.
+	secret    string
+	heartbeat time.Duration
+	client    *http.Client
+
+	mu      sync.Mutex // Protects leader, members and proxies.
+	leader  string
+	members map[string]time.Time // On the leader, the last heartbeat of each harness.
+	proxies map[string]*httputil.ReverseProxy
+	next    uint32 // Incremented to select the next member.
+}
+
+// ClusterRedeploy is the outcome of a redeploy of a cluster, as returned by
+// the leader to "gospf redeploy".
+type ClusterRedeploy struct {
+	Leader string
+	Nodes  []ClusterNodeResult
+}
+
+// ClusterNodeResult is the outcome of a redeploy on one harness.
+type ClusterNodeResult struct {
+	Node  string
+	Error string `json:",omitempty"`
+}
+
+// loadCluster returns the cluster configured by harness.cluster.*, or nil if
+// harness.cluster.peers is not set.
+func loadCluster(h *Harness) *cluster {
+	var peers []string
+	for _, peer := range strings.Split(gospf.Config.StringDefault("harness.cluster.peers", ""), ",") {
+		if peer = strings.TrimRight(strings.TrimSpace(peer), "/"); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+
+	self := strings.TrimRight(gospf.Config.StringDefault("harness.cluster.self", ""), "/")
+	if !gospf.ContainsString(peers, self) {
+		gospf.ERROR.Fatalf("harness.cluster.self (%q) must be one of harness.cluster.peers", self)
+	}
+	secret := gospf.Config.StringDefault("harness.cluster.secret", "")
+	if secret == "" {
+		gospf.ERROR.Fatalln("harness.cluster.secret must be set to join a cluster")
+	}
+	heartbeat := defaultClusterHeartbeat
+	if value, found := gospf.Config.String("harness.cluster.heartbeat"); found {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			heartbeat = d
+		} else {
+			gospf.WARN.Printf("Invalid harness.cluster.heartbeat %q, using %s", value, heartbeat)
+		}
+	}
+
+	return &cluster{
+		harness:   h,
+		self:      self,
+		peers:     peers,
+		secret:    secret,
+		heartbeat: heartbeat,
+		client:    &http.Client{Timeout: heartbeat},
+		members:   make(map[string]time.Time),
+		proxies:   make(map[string]*httputil.ReverseProxy),
+	}
+}
+
+// run registers with the leader on every heartbeat.
+func (c *cluster) run() {
+	for {
+		c.register()
+		time.Sleep(c.heartbeat)
+	}
+}
+
+// register registers with the first of the peers that answers, and makes it
+// the leader.
+func (c *cluster) register() {
+	for _, peer := range c.peers {
+		if peer == c.self {
+			c.addMember(c.self)
+		} else if err := c.post(c.client, peer, "register", url.Values{"node": {c.self}}, nil); err != nil {
+			gospf.TRACE.Printf("Cluster peer %s is unreachable: %s", peer, err)
+			continue
+		}
+		c.setLeader(peer)
+		return
+	}
+}
+
+func (c *cluster) setLeader(leader string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if leader != c.leader {
+		gospf.INFO.Printf("The cluster leader is %s", leader)
+		c.leader = leader
+	}
+	if leader != c.self {
+		c.members = make(map[string]time.Time)
+	}
+}
+
+func (c *cluster) currentLeader() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader
+}
+
+func (c *cluster) addMember(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.members[node]; !ok {
+		gospf.INFO.Printf("%s joined the cluster", node)
+	}
+	c.members[node] = time.Now()
+}
+
+func (c *cluster) dropMember(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.members[node]; ok {
+		gospf.WARN.Printf("%s left the cluster", node)
+		delete(c.members, node)
+	}
+}
+
+// liveMembers returns the harnesses that registered within the last three
+// heartbeats, sorted.
+func (c *cluster) liveMembers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var members []string
+	for node, seen := range c.members {
+		if time.Since(seen) < 3*c.heartbeat {
+			members = append(members, node)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// forward forwards the request to the next member of the cluster, if this
+// harness is the leader and the member is another harness, and reports
+// whether it did.  Websockets are served by the leader.
+func (c *cluster) forward(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get(clusterNodeHeader) != "" || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		c.currentLeader() != c.self {
+		return false
+	}
+	members := c.liveMembers()
+	if len(members) == 0 {
+		return false
+	}
+	node := members[int(atomic.AddUint32(&c.next, 1)-1)%len(members)]
+	if node == c.self {
+		return false
+	}
+	proxy, err := c.proxy(node)
+	if err != nil {
+		gospf.ERROR.Printf("Invalid cluster node %s: %s", node, err)
+		return false
+	}
+	r.Header.Set(clusterNodeHeader, c.self)
+	r.Header.Set(clusterSecretHeader, c.secret)
+	proxy.ServeHTTP(w, r)
+	return true
+}
+
+// forwarded reports whether the leader forwarded the request, with the shared
+// secret, which it removes so that the app does not see it.
+func (c *cluster) forwarded(r *http.Request) bool {
+	if r.Header.Get(clusterNodeHeader) == "" || r.Header.Get(clusterSecretHeader) == "" {
+		return false
+	}
+	ok := subtle.ConstantTimeCompare([]byte(r.Header.Get(clusterSecretHeader)), []byte(c.secret)) == 1
+	r.Header.Del(clusterSecretHeader)
+	return ok
+}
+
+// proxy returns the reverse proxy to the harness at the given base URL.
+func (c *cluster) proxy(node string) (*httputil.ReverseProxy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if proxy, ok := c.proxies[node]; ok {
+		return proxy, nil
+	}
+	nodeUrl, err := url.Parse(node)
+	if err != nil {
+		return nil, err
+	}
+	proxy := httputil.NewSingleHostReverseProxy(nodeUrl)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		gospf.ERROR.Printf("Failed to forward %s to %s: %s", r.URL.Path, node, err)
+		c.dropMember(node)
+		http.Error(w, "The staging server "+node+" is unreachable.", http.StatusBadGateway)
+	}
+	c.proxies[node] = proxy
+	return proxy, nil
+}
+
+// ServeHTTP serves the requests under clusterPathPrefix:
+//
+//	POST register: registers the harness in the node form value.
+//	POST redeploy: redeploys every harness of the cluster, through the leader.
+//	POST rebuild:  redeploys this harness.
+func (c *cluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(clusterSecretHeader)), []byte(c.secret)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, clusterPathPrefix) {
+	case "register":
+		node := strings.TrimRight(r.FormValue("node"), "/")
+		if !gospf.ContainsString(c.peers, node) {
+			http.Error(w, node+" is not in harness.cluster.peers", http.StatusBadRequest)
+			return
+		}
+		c.addMember(node)
+	case "redeploy":
+		result, err := c.redeploy()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	case "rebuild":
+		if err := c.rebuild(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// redeploy rebuilds every live member of the cluster at once, or asks the
+// leader to if this harness is not the leader.
+func (c *cluster) redeploy() (*ClusterRedeploy, error) {
+	// Rebuilds may take a while.
+	client := &http.Client{}
+	leader := c.currentLeader()
+	if leader != c.self {
+		result := &ClusterRedeploy{}
+		if err := c.post(client, leader, "redeploy", nil, result); err != nil {
+			return nil, fmt.Errorf("Failed to reach the cluster leader %s: %s", leader, err)
+		}
+		return result, nil
+	}
+
+	members := c.liveMembers()
+	result := &ClusterRedeploy{Leader: c.self, Nodes: make([]ClusterNodeResult, len(members))}
+	var wg sync.WaitGroup
+	for i, node := range members {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			var err error
+			if node == c.self {
+				err = c.rebuild()
+			} else {
+				err = c.post(client, node, "rebuild", nil, nil)
+			}
+			result.Nodes[i].Node = node
+			if err != nil {
+				result.Nodes[i].Error = err.Error()
+			}
+		}(i, node)
+	}
+	wg.Wait()
+	return result, nil
+}
+
+// rebuild runs harness.cluster.redeploy.command, e.g. "git pull --ff-only",
+// in the app's directory, if set, and then rebuilds and restarts the app.
+func (c *cluster) rebuild() error {
+	gospf.INFO.Println("Redeploying")
+	if command := gospf.Config.StringDefault("harness.cluster.redeploy.command", ""); command != "" {
+		cmd := shellCommand(command)
+		cmd.Dir = gospf.BasePath
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %s\n%s", command, err, out)
+		}
+	}
+	err := c.harness.Refresh()
+	c.harness.setBuildError(err)
+	if err != nil {
+		return errors.New(err.Title + ": " + err.Description)
+	}
+	return nil
+}
+
+// post posts the form to the cluster endpoint of the harness at the given
+// base URL, and decodes the JSON response into result, if not nil.
+func (c *cluster) post(client *http.Client, node, endpoint string, form url.Values, result interface{}) error {
+	return PostCluster(client, node, c.secret, endpoint, form, result)
+}
+
+// PostCluster posts the form to the cluster endpoint of the harness at the
+// given base URL, with the shared secret, and decodes the JSON response into
+// result, if not nil.
+func PostCluster(client *http.Client, node, secret, endpoint string, form url.Values, result interface{}) error {
+	req, err := http.NewRequest("POST", node+clusterPathPrefix+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(clusterSecretHeader, secret)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package harness
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestNode returns a cluster node served by an httptest server, which
+// answers the requests outside the cluster path with its name, and forbids
+// those that claim to be forwarded without the shared secret, as a harness
+// with -remote does.
+func newTestNode(name string) (*cluster, *httptest.Server) {
+	c := &cluster{
+		secret:    "s3cret",
+		heartbeat: time.Minute,
+		client:    &http.Client{Timeout: time.Second},
+		members:   make(map[string]time.Time),
+		proxies:   make(map[string]*httputil.ReverseProxy),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, clusterPathPrefix) {
+			c.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get(clusterNodeHeader) != "" && (!c.forwarded(r) || r.Header.Get(clusterSecretHeader) != "") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if c.forward(w, r) {
+			return
+		}
+		w.Write([]byte(name + " " + r.Header.Get(clusterNodeHeader)))
+	}))
+	c.self = server.URL
+	return c, server
+}
+
+func TestClusterLeader(t *testing.T) {
+	a, serverA := newTestNode("a")
+	b, serverB := newTestNode("b")
+	defer serverB.Close()
+	a.peers = []string{serverA.URL, serverB.URL}
+	b.peers = a.peers
+
+	b.register()
+	a.register()
+	if leader := b.currentLeader(); leader != serverA.URL {
+		t.Errorf("Expected %s to lead, got %s", serverA.URL, leader)
+	}
+	if members := a.liveMembers(); len(members) != 2 {
+		t.Errorf("Expected both nodes to be members, got %v", members)
+	}
+
+	// Requests to the leader are distributed among both nodes.
+	served := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(serverA.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		served[string(body)] = true
+	}
+	if !served["a "] || !served["b "+serverA.URL] {
+		t.Errorf("Expected a request served by each node, got %v", served)
+	}
+
+	// Without the leader, the next peer takes over.
+	serverA.Close()
+	b.register()
+	if leader := b.currentLeader(); leader != serverB.URL {
+		t.Errorf("Expected %s to take over, got %s", serverB.URL, leader)
+	}
+}
+
+func TestClusterSecret(t *testing.T) {
+	c, server := newTestNode("a")
+	defer server.Close()
+	c.peers = []string{server.URL}
+
+	err := PostCluster(http.DefaultClient, server.URL, "wrong", "register", url.Values{"node": {server.URL}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a wrong secret to be forbidden, got %v", err)
+	}
+	if err = c.post(http.DefaultClient, server.URL, "register", url.Values{"node": {"http://intruder"}}, nil); err == nil {
+		t.Error("Expected a node outside the peers to be refused")
+	}
+	if members := c.liveMembers(); len(members) != 0 {
+		t.Errorf("Expected no members, got %v", members)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/", nil)
+	req.Header.Set(clusterNodeHeader, "http://intruder")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a request forwarded without the secret to be forbidden, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,151 @@
+package harness
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// Besides app/, an app may keep code in the directories listed by
+// codepaths.extra, relative to the app directory unless absolute, which may
+// be globs, e.g. for the controllers of its plugins:
+//
+//	codepaths.extra = plugins/*/controllers, lib/admin
+//
+// The extra code paths are processed as app/ is: their controllers, test
+// suites, jobs and interceptors are registered in main.go, and they are
+// watched.  Each of them also gets the reverse routes of its own controllers,
+// in routes/routes.go, so that its code need not import the app's.  The other
+// files of its routes directory are left as they are, and a routes.go that was
+// not generated is an error rather than replaced.  The
+// controllers are routed by name, so their names must be unique across the
+// code paths.  The extra code paths must be in the app's module, or in a
+// module of its workspace.
+
+// ExtraCodePaths returns the directories of codepaths.extra, without those of
+// the app's code paths.
+func ExtraCodePaths() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, root := range gospf.CodePaths {
+		seen[realPath(root)] = true
+	}
+	for _, pattern := range configList("codepaths.extra") {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(gospf.BasePath, filepath.FromSlash(pattern))
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil || len(matches) == 0 {
+			gospf.WARN.Printf("codepaths.extra: %s matches no directory", pattern)
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil || !info.IsDir() {
+				continue
+			}
+			dir := realPath(match)
+			if seen[dir] || insideCodePath(dir) {
+				continue
+			}
+			seen[dir] = true
+			paths = append(paths, match)
+		}
+	}
+	return paths
+}
+
+// insideCodePath reports whether the directory is processed with one of the
+// app's code paths already.
+func insideCodePath(dir string) bool {
+	for _, root := range gospf.CodePaths {
+		if rel, err := filepath.Rel(realPath(root), dir); err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// genCodePathRoutes generates the reverse routes of the controllers of each
+// extra code path into its routes directory.
+func genCodePathRoutes(sourceInfo *SourceInfo, aliases map[string]string, manifest *BuildManifest) *gospf.Error {
+	for _, root := range ExtraCodePaths() {
+		importPath := importPathFromPath(root)
+		if importPath == "" {
+			continue
+		}
+		var controllers []*TypeInfo
+		for _, spec := range sourceInfo.ControllerSpecs() {
+			if spec.ImportPath == importPath || strings.HasPrefix(spec.ImportPath, importPath+"/") {
+				controllers = append(controllers, spec)
+			}
+		}
+		if len(controllers) == 0 {
+			continue
+		}
+
+		dir, err := filepath.Rel(gospf.AppPath, filepath.Join(root, "routes"))
+		if err != nil {
+			return newConfigError(filepath.Join("conf", "app.conf"), fmt.Sprintf("codepaths.extra: %s", err))
+		}
+		dir = filepath.ToSlash(dir)
+		filename := filepath.Join(gospf.AppPath, dir, "routes.go")
+		if _, err := os.Stat(filename); err == nil && !isGeneratedSource(filename) {
+			return &gospf.Error{
+				Title:       codeGenerationErrorTitle,
+				Path:        filename,
+				Description: "The reverse routes of the extra code path would replace this file, which was not generated.  Move it out of the routes directory.",
+			}
+		}
+		src := &SourceInfo{controllerSpecs: controllers}
+		templateArgs := map[string]interface{}{
+			"Controllers":      controllers,
+			"RouteImportPaths": calcRouteImportAliases(src, aliases),
+		}
+		if compileError := writeSource(dir, "routes.go", "routes.go", ROUTES, templateArgs); compileError != nil {
+			return compileError
+		}
+		if manifest != nil {
+			manifest.recordGenerated(dir, "routes.go")
+		}
+	}
+	return nil
+}
+
+// cleanCodePathRoutes removes the reverse routes generated into the extra
+// code paths, so that they are not processed as source.  The files that were
+// not generated are kept.
+func cleanCodePathRoutes() {
+	for _, root := range ExtraCodePaths() {
+		routes := filepath.Join(root, "routes", "routes.go")
+		if !isGeneratedSource(routes) {
+			continue
+		}
+		if err := os.Remove(routes); err != nil && !os.IsNotExist(err) {
+			gospf.WARN.Println("Failed to remove the generated routes:", err)
+		}
+	}
+}
+
+// checkControllerNames returns an error if two controllers have the same
+// name, since the routes refer to the controllers by name.
+func checkControllerNames(controllers []*TypeInfo) *gospf.Error {
+	declared := make(map[string]*TypeInfo)
+	for _, spec := range controllers {
+		if other, found := declared[spec.StructName]; found {
+			err := &gospf.Error{
+				Title: codeGenerationErrorTitle,
+				Description: fmt.Sprintf("The controller %s is declared in both %s and %s, and the routes refer to controllers by name.  Rename one of them.",
+					spec.StructName, other.ImportPath, spec.ImportPath),
+			}
+			if len(spec.MethodSpecs) > 0 {
+				err.Path = spec.MethodSpecs[0].File
+			}
+			return err
+		}
+		declared[spec.StructName] = spec
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package harness
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckControllerNames(t *testing.T) {
+	controllers := []*TypeInfo{
+		{StructName: "App", ImportPath: "example.com/shop/app/controllers"},
+		{StructName: "Posts", ImportPath: "example.com/shop/plugins/blog/controllers"},
+	}
+	if err := checkControllerNames(controllers); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Description)
+	}
+
+	controllers = append(controllers, &TypeInfo{
+		StructName:  "App",
+		ImportPath:  "example.com/shop/plugins/blog/controllers",
+		MethodSpecs: []*MethodSpec{{Name: "Index", File: "plugins/blog/controllers/app.go"}},
+	})
+	err := checkControllerNames(controllers)
+	if err == nil {
+		t.Fatal("Expected an error for the controllers named App")
+	}
+	if !strings.Contains(err.Description, "example.com/shop/plugins/blog/controllers") || err.Path != "plugins/blog/controllers/app.go" {
+		t.Errorf("Unexpected error: %s: %s", err.Path, err.Description)
+	}
+}
+
+func TestPackageAliasesAvoidMainImports(t *testing.T) {
+	aliases := make(map[string]string)
+	addAlias(aliases, "example.com/shop/app/controllers", "controllers")
+	addAlias(aliases, "example.com/shop/plugins/blog/controllers", "controllers")
+	addAlias(aliases, "example.com/shop/plugins/clock/time", "time")
+
+	expected := map[string]string{
+		"example.com/shop/app/controllers":          "controllers",
+		"example.com/shop/plugins/blog/controllers": "controllers0",
+		"example.com/shop/plugins/clock/time":       "time0",
+	}
+	for importPath, alias := range expected {
+		if aliases[importPath] != alias {
+			t.Errorf("Expected the alias %s for %s, got %s", alias, importPath, aliases[importPath])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package harness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hubply/gospf"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigSchemaFile is the path of the config schema, relative to the app.
+// It declares the app.conf keys the app reads, e.g.
+//
+//	db.host:
+//	  type: string
+//	  required: true
+//	  doc: Host name of the database server.
+//	db.pool:
+//	  type: int
+//	  default: 10
+//
+// The types are string, int, float, bool and duration.
+var ConfigSchemaFile = filepath.Join("conf", "schema.yml")
+
+// ConfigSchema describes the app.conf keys read by an app.
+type ConfigSchema struct {
+	Keys []*ConfigKey // Sorted by name.
+}
+
+// ConfigKey describes an app.conf key.
+type ConfigKey struct {
+	Name     string `yaml:"-"` // e.g. "db.host"
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+	Default  string `yaml:"default"`
+	Doc      string `yaml:"doc"`
+}
+
+// configTypes maps the type of a config key to its Go type.
+var configTypes = map[string]string{
+	"string":   "string",
+	"int":      "int",
+	"float":    "float64",
+	"bool":     "bool",
+	"duration": "time.Duration",
+}
+
+const configErrorTitle = "Configuration Error"
+
+// LoadConfigSchema reads the app's config schema.  It returns nil if the app
+// does not have one.
+func LoadConfigSchema() (*ConfigSchema, *gospf.Error) {
+	filename := filepath.Join(gospf.BasePath, ConfigSchemaFile)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newConfigError(ConfigSchemaFile, err.Error())
+	}
+
+	var keys map[string]*ConfigKey
+	if err = yaml.Unmarshal(data, &keys); err != nil {
+		return nil, newConfigError(ConfigSchemaFile, err.Error())
+	}
+
+	schema := &ConfigSchema{}
+	for name, key := range keys {
+		if key == nil {
+			key = &ConfigKey{}
+		}
+		key.Name = name
+		if key.Type == "" {
+			key.Type = "string"
+		}
+		if _, ok := configTypes[key.Type]; !ok {
+			return nil, newConfigError(ConfigSchemaFile,
+				fmt.Sprintf("%s has unknown type %q", name, key.Type))
+		}
+		if key.Default != "" {
+			if err = key.check(key.Default); err != nil {
+				return nil, newConfigError(ConfigSchemaFile,
+					fmt.Sprintf("Invalid default for %s: %s", name, err))
+			}
+		}
+		schema.Keys = append(schema.Keys, key)
+	}
+	sort.Sort(configKeysByName(schema.Keys))
+	return schema, nil
+}
+
+// Check verifies the app's configuration for the current run mode against
+// the schema: that the required keys are set, and that the values are of the
+// declared types.
+func (s *ConfigSchema) Check() *gospf.Error {
+	var problems []string
+	for _, key := range s.Keys {
+		value, found := gospf.Config.String(key.Name)
+		if !found {
+			if key.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", key.Name))
+			}
+			continue
+		}
+		if err := key.check(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", key.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return newConfigError(filepath.Join("conf", "app.conf"), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Field returns the name of the Go struct field for the key, e.g. "DbHost"
+// for "db.host".
+func (k *ConfigKey) Field() string {
+	var field string
+	for _, word := range strings.FieldsFunc(k.Name, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-'
+	}) {
+		field += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return field
+}
+
+// GoType returns the Go type of the key's value.
+func (k *ConfigKey) GoType() string {
+	return configTypes[k.Type]
+}
+
+// check returns an error if the value is not of the key's type.
+func (k *ConfigKey) check(value string) (err error) {
+	switch k.Type {
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	}
+	return err
+}
+
+func newConfigError(path, description string) *gospf.Error {
+	return &gospf.Error{
+		Title:       configErrorTitle,
+		Path:        path,
+		Description: description,
+	}
+}
+
+// IsConfigError reports whether an error returned by Build arose from the
+// app's configuration.
+func IsConfigError(err *gospf.Error) bool {
+	return err != nil && err.Title == configErrorTitle
+}
+
+type configKeysByName []*ConfigKey
+
+func (k configKeysByName) Len() int           { return len(k) }
+func (k configKeysByName) Less(i, j int) bool { return k[i].Name < k[j].Name }
+func (k configKeysByName) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
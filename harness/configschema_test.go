@@ -0,0 +1,36 @@
+package harness
+
+import "testing"
+
+func TestConfigKeyField(t *testing.T) {
+	for name, expected := range map[string]string{
+		"db.host":           "DbHost",
+		"feature_x.enabled": "FeatureXEnabled",
+		"mail-from":         "MailFrom",
+	} {
+		if field := (&ConfigKey{Name: name}).Field(); field != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, name, field)
+		}
+	}
+}
+
+func TestConfigKeyCheck(t *testing.T) {
+	for _, test := range []struct {
+		typ, value string
+		valid      bool
+	}{
+		{"string", "anything", true},
+		{"int", "10", true},
+		{"int", "ten", false},
+		{"float", "0.5", true},
+		{"bool", "true", true},
+		{"bool", "yes", false},
+		{"duration", "1h30m", true},
+		{"duration", "90", false},
+	} {
+		err := (&ConfigKey{Name: "key", Type: test.typ}).check(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("Checking %q as %s: unexpected error %v", test.value, test.typ, err)
+		}
+	}
+}
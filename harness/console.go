@@ -0,0 +1,94 @@
+package harness
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// NoColor disables the ANSI colors in errors printed to the terminal.
+var NoColor bool
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+	ansiGray   = "\x1b[90m"
+)
+
+// errorContextLines is the number of source lines shown before and after the
+// offending line.
+const errorContextLines = 2
+
+func colorize(color, s string) string {
+	if NoColor {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// PrintError writes a terminal-friendly description of the given error: its
+// title and location, the description, the link or command that opens it in
+// the editor of error.editor, and the offending source line with a little
+// context and a caret under the column, if known.
+func PrintError(w io.Writer, err *gospf.Error) {
+	location := err.Path
+	if err.Line > 0 {
+		location += fmt.Sprintf(":%d", err.Line)
+		if err.Column > 0 {
+			location += fmt.Sprintf(":%d", err.Column)
+		}
+	}
+	fmt.Fprintf(w, "%s %s\n", colorize(ansiBold+ansiRed, err.Title+":"), colorize(ansiCyan, location))
+	fmt.Fprintf(w, "    %s\n", err.Description)
+	if hint := editorHint(err); hint != "" {
+		fmt.Fprintf(w, "    %s\n", colorize(ansiGray, hint))
+	}
+
+	if err.Line <= 0 || err.Line > len(err.SourceLines) {
+		return
+	}
+
+	fmt.Fprintln(w)
+	first, last := err.Line-errorContextLines, err.Line+errorContextLines
+	if first < 1 {
+		first = 1
+	}
+	if last > len(err.SourceLines) {
+		last = len(err.SourceLines)
+	}
+	width := len(fmt.Sprint(last))
+	for line := first; line <= last; line++ {
+		source := err.SourceLines[line-1]
+		gutter := fmt.Sprintf("%*d | ", width, line)
+		if line != err.Line {
+			fmt.Fprintf(w, "  %s%s\n", colorize(ansiGray, gutter), source)
+			continue
+		}
+
+		fmt.Fprintf(w, "%s %s%s\n", colorize(ansiRed, ">"), colorize(ansiGray, gutter), source)
+		if err.Column > 0 && err.Column <= len(source)+1 {
+			fmt.Fprintf(w, "  %s%s%s\n",
+				colorize(ansiGray, strings.Repeat(" ", width)+" | "),
+				caretIndent(source[:err.Column-1]),
+				colorize(ansiBold+ansiRed, "^"))
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// caretIndent returns whitespace as wide as the given source prefix, keeping
+// its tabs so that a caret following it lines up in the terminal.
+func caretIndent(prefix string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, prefix)
+}
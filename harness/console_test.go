@@ -0,0 +1,45 @@
+package harness
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hubply/gospf"
+)
+
+func TestPrintError(t *testing.T) {
+	NoColor = true
+	defer func() { NoColor = false }()
+
+	var buf bytes.Buffer
+	PrintError(&buf, &gospf.Error{
+		Title:       "Go Compilation Error",
+		Path:        "app/controllers/app.go",
+		Description: "undefined: foo",
+		Line:        3,
+		Column:      7,
+		SourceLines: []string{
+			"package controllers",
+			"",
+			"\tx := foo",
+			"\treturn x",
+			"}",
+			"// unused",
+		},
+	})
+
+	expected := `Go Compilation Error: app/controllers/app.go:3:7
+    undefined: foo
+
+  1 | package controllers
+  2 | 
+> 3 | 	x := foo
+    | 	     ^
+  4 | 	return x
+  5 | }
+
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected error output.  Expected:\n%s\nActual:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,94 @@
+package harness
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// devAuth rewrites the auth headers and cookies of proxied requests in dev
+// mode, so that an API may be explored with curl without logging in, or
+// tested without credentials from a logged-in browser.  It is configured by:
+//
+//	harness.dev_auth               "inject", "strip" or "off" (the default)
+//	harness.dev_auth.header.NAME   value of the header NAME
+//	harness.dev_auth.cookie.NAME   value of the cookie NAME
+//
+// With inject, the configured headers and cookies are set on every request,
+// replacing those sent.  With strip, they are removed from every request.
+type devAuth struct {
+	inject  bool
+	headers map[string]string
+	cookies map[string]string
+}
+
+// loadDevAuth returns the configured devAuth, or nil if it is off.
+func loadDevAuth() *devAuth {
+	mode := gospf.Config.StringDefault("harness.dev_auth", "off")
+	if !gospf.DevMode || mode == "off" {
+		return nil
+	}
+	if mode != "inject" && mode != "strip" {
+		gospf.WARN.Printf("Invalid harness.dev_auth %q, using off", mode)
+		return nil
+	}
+
+	auth := &devAuth{
+		inject:  mode == "inject",
+		headers: configValues("harness.dev_auth.header."),
+		cookies: configValues("harness.dev_auth.cookie."),
+	}
+	gospf.INFO.Printf("Rewriting the auth of requests (harness.dev_auth=%s)", mode)
+	return auth
+}
+
+// apply rewrites the request.
+func (a *devAuth) apply(r *http.Request) {
+	for name, value := range a.headers {
+		r.Header.Del(name)
+		if a.inject {
+			r.Header.Set(name, value)
+		}
+	}
+
+	if len(a.cookies) == 0 {
+		return
+	}
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if _, ok := a.cookies[cookie.Name]; !ok {
+			r.AddCookie(cookie)
+		}
+	}
+	if a.inject {
+		for name, value := range a.cookies {
+			r.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+	}
+}
+
+// configValues returns the values of the config keys with the given prefix,
+// keyed by the rest of the key.
+func configValues(prefix string) map[string]string {
+	return configValuesIn(gospf.Config, prefix)
+}
+
+// configValuesIn is like configValues, for the given config.
+func configValuesIn(config *gospf.MergedConfig, prefix string) map[string]string {
+	values := make(map[string]string)
+	raw := config.Raw()
+	for _, section := range raw.Sections() {
+		options, _ := raw.SectionOptions(section)
+		for _, key := range options {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if value, found := config.String(key); found {
+				values[key[len(prefix):]] = value
+			}
+		}
+	}
+	return values
+}
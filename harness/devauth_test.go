@@ -0,0 +1,40 @@
+package harness
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDevAuth(t *testing.T) {
+	newRequest := func() *http.Request {
+		r, _ := http.NewRequest("GET", "/api/users", nil)
+		r.Header.Set("Authorization", "Bearer expired")
+		r.AddCookie(&http.Cookie{Name: "GOSPF_SESSION", Value: "old"})
+		r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+		return r
+	}
+	auth := &devAuth{
+		headers: map[string]string{"Authorization": "Bearer dev"},
+		cookies: map[string]string{"GOSPF_SESSION": "dev"},
+	}
+
+	auth.inject = true
+	r := newRequest()
+	auth.apply(r)
+	if header := r.Header.Get("Authorization"); header != "Bearer dev" {
+		t.Errorf("Expected the injected Authorization header, got %q", header)
+	}
+	if cookie, err := r.Cookie("GOSPF_SESSION"); err != nil || cookie.Value != "dev" || len(r.Cookies()) != 2 {
+		t.Errorf("Expected the injected session cookie, got %v", r.Cookies())
+	}
+
+	auth.inject = false
+	r = newRequest()
+	auth.apply(r)
+	if header := r.Header.Get("Authorization"); header != "" {
+		t.Errorf("Expected the Authorization header to be stripped, got %q", header)
+	}
+	if cookies := r.Cookies(); len(cookies) != 1 || cookies[0].Name != "theme" {
+		t.Errorf("Expected only the theme cookie, got %v", cookies)
+	}
+}
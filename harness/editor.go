@@ -0,0 +1,236 @@
+package harness
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// With error.editor set, the location of a build error links to the file in
+// the editor, on the error page and on the console:
+//
+//	error.editor = vscode         opens vscode://file/<path>:<line>:<column>
+//	error.editor = goland         opens goland://open?file=<path>&line=<line>
+//	error.editor = vim --remote   runs "vim --remote +<line> <path>"
+//
+// Other values are commands, run with "+<line> <path>" as arguments, or
+// templates of a URL or a command with {{Path}}, {{Line}} and {{Column}}, e.g.
+// "subl {{Path}}:{{Line}}".  The commands are run by the harness, in dev mode,
+// when the link under /_gospf/open is followed: it shows a button that posts
+// the location back, and only a POST from the harness's own pages runs the
+// command, so that another site can't run it.  error.link, if set, takes
+// precedence.
+
+const editorPath = devPathPrefix + "open"
+
+// editorPresets are the templates of the known editors.
+var editorPresets = map[string]string{
+	"vscode": "vscode://file{{Path}}:{{Line}}:{{Column}}",
+	"goland": "goland://open?file={{Path}}&line={{Line}}",
+	"idea":   "idea://open?file={{Path}}&line={{Line}}",
+	"vim":    "vim --remote +{{Line}} {{Path}}",
+}
+
+// editorTemplate returns the template of error.editor, or "" if it is not
+// set or the configuration is not loaded.
+func editorTemplate() string {
+	if gospf.Config == nil {
+		return ""
+	}
+	editor := strings.TrimSpace(gospf.Config.StringDefault("error.editor", ""))
+	if preset, found := editorPresets[editor]; found {
+		return preset
+	}
+	if editor != "" && !strings.Contains(editor, "{{") {
+		editor += " +{{Line}} {{Path}}"
+	}
+	return editor
+}
+
+// isEditorURL reports whether the template is of a URL rather than a command.
+func isEditorURL(tmpl string) bool {
+	return strings.Contains(tmpl, "://")
+}
+
+// expandEditor fills in the template with the location.  The values are
+// escaped for URLs, as a path or as a query value according to where they
+// are, and left as is for commands, which are split into arguments before
+// they are expanded.
+func expandEditor(tmpl, path string, line, column int) string {
+	if column < 1 {
+		column = 1
+	}
+	if !isEditorURL(tmpl) {
+		return expandEditorValues(tmpl, path, line, column)
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// A Windows path, e.g. /C:/src/app.go.
+		path = "/" + path
+	}
+	query := ""
+	if i := strings.Index(tmpl, "?"); i >= 0 {
+		tmpl, query = tmpl[:i], tmpl[i:]
+	}
+	return expandEditorValues(tmpl, (&url.URL{Path: path}).EscapedPath(), line, column) +
+		expandEditorValues(query, url.QueryEscape(path), line, column)
+}
+
+// expandEditorValues replaces the placeholders of the template with the
+// values.
+func expandEditorValues(tmpl, path string, line, column int) string {
+	return strings.NewReplacer(
+		"{{Path}}", path,
+		"{{Line}}", strconv.Itoa(line),
+		"{{Column}}", strconv.Itoa(column),
+	).Replace(tmpl)
+}
+
+// errorFile returns the absolute path of the file of the error, whose path may
+// be relative to the app's root or to the working directory.
+func errorFile(err *gospf.Error) string {
+	if err.Path == "" || filepath.IsAbs(err.Path) {
+		return err.Path
+	}
+	if gospf.BasePath != "" {
+		path := filepath.Join(gospf.BasePath, err.Path)
+		if _, statErr := os.Stat(path); statErr == nil {
+			return path
+		}
+	}
+	path, _ := filepath.Abs(err.Path)
+	return path
+}
+
+// editorHint returns the URL or the command that opens the location of the
+// error in the editor, for the console, or "" if error.editor is not set.
+func editorHint(err *gospf.Error) string {
+	tmpl := editorTemplate()
+	if tmpl == "" || err.Path == "" || err.Line < 1 {
+		return ""
+	}
+	return expandEditor(tmpl, errorFile(err), err.Line, err.Column)
+}
+
+// setErrorLink links the location of the error on the error page, to
+// error.link or to the editor of error.editor.
+func setErrorLink(err *gospf.Error) {
+	if errorLink := gospf.Config.StringDefault("error.link", ""); errorLink != "" {
+		err.SetLink(errorLink)
+		return
+	}
+	tmpl := editorTemplate()
+	if tmpl == "" || err.Path == "" || err.Line < 1 {
+		return
+	}
+	if isEditorURL(tmpl) {
+		err.SetLink(expandEditor(tmpl, errorFile(err), err.Line, err.Column))
+	} else if gospf.DevMode {
+		err.SetLink(editorPath + "?" + url.Values{
+			"path":   {errorFile(err)},
+			"line":   {strconv.Itoa(err.Line)},
+			"column": {strconv.Itoa(err.Column)},
+		}.Encode())
+	}
+}
+
+// registerEditor serves the links that run the editor's command: a GET shows
+// the button that posts the location, and a POST from the same origin runs
+// the command.
+func registerEditor(mux *http.ServeMux) {
+	mux.HandleFunc(editorPath, func(w http.ResponseWriter, r *http.Request) {
+		tmpl := editorTemplate()
+		if tmpl == "" || isEditorURL(tmpl) {
+			http.NotFound(w, r)
+			return
+		}
+		path := filepath.Clean(r.FormValue("path"))
+		line, _ := strconv.Atoi(r.FormValue("line"))
+		column, _ := strconv.Atoi(r.FormValue("column"))
+		if info, err := os.Stat(path); err != nil || info.IsDir() || !editablePath(path) {
+			http.Error(w, "Not a file of the app: "+path, http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case "GET", "HEAD":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			editorTemplateHTML.Execute(w, map[string]interface{}{
+				"Path":   path,
+				"Line":   line,
+				"Column": column,
+			})
+			return
+		case "POST":
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "The editor is only run from the pages of the harness", http.StatusForbidden)
+			return
+		}
+
+		var args []string
+		for _, arg := range strings.Fields(tmpl) {
+			args = append(args, expandEditor(arg, path, line, column))
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			http.Error(w, "Failed to run the editor: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		go cmd.Wait()
+		// Keep the error page.
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// sameOrigin reports whether the request comes from a page of the host it is
+// sent to, as its Origin header tells, or else its Referer.  A request with
+// neither is not.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Referer()
+	}
+	sourceUrl, err := url.Parse(source)
+	return err == nil && source != "" && strings.EqualFold(sourceUrl.Host, r.Host)
+}
+
+var editorTemplateHTML = template.Must(template.New("editor").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Open {{.Path}}</title>
+</head>
+<body>
+<form method="post">
+<input type="hidden" name="path" value="{{.Path}}">
+<input type="hidden" name="line" value="{{.Line}}">
+<input type="hidden" name="column" value="{{.Column}}">
+<button type="submit" autofocus>Open {{.Path}}:{{.Line}} in the editor</button>
+</form>
+</body>
+</html>
+`))
+
+// editablePath reports whether the file is under the app's root or a watched
+// path, so that the links only open the app's source.
+func editablePath(path string) bool {
+	for _, root := range append([]string{gospf.BasePath}, WatchPaths()...) {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package harness
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpandEditor(t *testing.T) {
+	tests := []struct {
+		tmpl, path   string
+		line, column int
+		expected     string
+	}{
+		{editorPresets["vscode"], "/src/my app/app.go", 12, 3, "vscode://file/src/my%20app/app.go:12:3"},
+		{editorPresets["vscode"], "/src/app/app.go", 12, 0, "vscode://file/src/app/app.go:12:1"},
+		{editorPresets["goland"], "/src/app/app.go", 12, 3, "goland://open?file=%2Fsrc%2Fapp%2Fapp.go&line=12"},
+		{editorPresets["goland"], "/src/a&b/app.go", 12, 3, "goland://open?file=%2Fsrc%2Fa%26b%2Fapp.go&line=12"},
+		{"+{{Line}}", "/src/my app/app.go", 12, 3, "+12"},
+		{"{{Path}}", "/src/my app/app.go", 12, 3, "/src/my app/app.go"},
+		{"{{Path}}:{{Line}}:{{Column}}", "/src/app/app.go", 7, 2, "/src/app/app.go:7:2"},
+	}
+	for _, test := range tests {
+		if actual := expandEditor(test.tmpl, test.path, test.line, test.column); actual != test.expected {
+			t.Errorf("expandEditor(%q, %q, %d, %d) = %q, expected %q", test.tmpl, test.path, test.line, test.column, actual, test.expected)
+		}
+	}
+
+	if !isEditorURL(editorPresets["goland"]) || isEditorURL(editorPresets["vim"]) {
+		t.Error("Expected goland to be opened by URL, and vim by command")
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin, referer string
+		expected        bool
+	}{
+		{"http://localhost:9000", "", true},
+		{"", "http://localhost:9000/hotels", true},
+		{"http://evil.example", "http://localhost:9000/hotels", false},
+		{"", "http://evil.example/", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "http://localhost:9000/_gospf/open", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.referer != "" {
+			r.Header.Set("Referer", test.referer)
+		}
+		if actual := sameOrigin(r); actual != test.expected {
+			t.Errorf("sameOrigin with Origin %q and Referer %q = %v, expected %v", test.origin, test.referer, actual, test.expected)
+		}
+	}
+}
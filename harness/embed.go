@@ -0,0 +1,68 @@
+package harness
+
+import (
+	"github.com/hubply/gospf"
+)
+
+// Other programs, such as IDE plugins and custom dev runners, may embed the
+// harness rather than run "gospf run":
+//
+//	h := harness.New(harness.Config{ImportPath: "github.com/acme/shop"})
+//	h.Start()
+//	defer h.Stop()
+//	http.ListenAndServe(":9000", h)
+//
+// The Harness is an http.Handler, which builds the app on the first request,
+// rebuilds it when its source changes, and proxies the requests to it.  Each
+// Harness keeps its own state, but the app's configuration is still loaded
+// into the gospf package by gospf.Init, so a program runs a single app at a
+// time.  The build options, such as OutputPath and TrimPath, remain package
+// variables.
+
+// Config configures a Harness made with New.  Its zero values leave the app's
+// configuration in effect.
+type Config struct {
+	// ImportPath is the import path of the app, which is initialized with
+	// gospf.Init unless it is already.
+	ImportPath string
+
+	// RunMode is the run mode of the app, "dev" by default.
+	RunMode string
+
+	// Port and Addr, if set, override http.port and http.addr, which Run
+	// listens on.
+	Port int
+	Addr string
+
+	// Instances, if set, overrides harness.instances as the number of copies
+	// of the app to run behind the proxy.
+	Instances int
+
+	// Modes, if set, are the run modes to run the app in at once, as Modes.
+	Modes []string
+
+	// NoProxy, if set, overrides harness.proxy=true, as NoProxy.
+	NoProxy bool
+
+	// Remote, if set, overrides harness.remote=false, as Remote.
+	Remote bool
+}
+
+// New returns a harness for the app, configured by config rather than by the
+// package variables.
+func New(config Config) *Harness {
+	if !gospf.Initialized {
+		mode := config.RunMode
+		if mode == "" {
+			mode = "dev"
+		}
+		initRunMode(mode, config.ImportPath, "")
+	}
+	if config.Port != 0 {
+		gospf.HttpPort = config.Port
+	}
+	if config.Addr != "" {
+		gospf.HttpAddr = config.Addr
+	}
+	return newHarness(config)
+}
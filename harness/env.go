@@ -0,0 +1,84 @@
+package harness
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// Env, if set, are variables to set in the app's environment, as KEY=VALUE.
+// They take precedence over all others.
+var Env []string
+
+// appEnv returns the environment of the app process.  The variables are
+// merged from, in increasing order of precedence:
+//
+//  1. the env.NAME keys of app.conf, for the run mode,
+//  2. the .env file in the app's root directory,
+//  3. the environment of the gospf command,
+//  4. Env (the -e flags of gospf run).
+func appEnv() []string {
+	vars := configValues("env.")
+
+	dotEnvPath := filepath.Join(gospf.BasePath, ".env")
+	if f, err := os.Open(dotEnvPath); err == nil {
+		dotEnv, err := parseDotEnv(f)
+		f.Close()
+		if err != nil {
+			gospf.WARN.Printf("Failed to read %s: %s", dotEnvPath, err)
+		}
+		for key, value := range dotEnv {
+			vars[key] = value
+		}
+	}
+
+	for _, list := range [][]string{os.Environ(), Env} {
+		for _, keyValue := range list {
+			if i := strings.Index(keyValue, "="); i > 0 {
+				vars[keyValue[:i]] = keyValue[i+1:]
+			}
+		}
+	}
+
+	env := make([]string, 0, len(vars))
+	for key, value := range vars {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// parseDotEnv reads variables in the .env file format: a KEY=VALUE per line,
+// optionally preceded by "export".  Values may be quoted.  Blank lines and
+// lines starting with # are ignored.
+func parseDotEnv(r io.Reader) (map[string]string, error) {
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return vars, fmt.Errorf("line %d: expected KEY=VALUE", lineNum)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
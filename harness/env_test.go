@@ -0,0 +1,35 @@
+package harness
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const dotEnvSource = `
+# Database
+DATABASE_URL=postgres://localhost/app
+export SECRET = "two\nlines"
+GREETING='hello, $USER'
+EMPTY=
+`
+
+func TestParseDotEnv(t *testing.T) {
+	vars, err := parseDotEnv(strings.NewReader(dotEnvSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"DATABASE_URL": "postgres://localhost/app",
+		"SECRET":       "two\nlines",
+		"GREETING":     "hello, $USER",
+		"EMPTY":        "",
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("Expected %v, got %v", expected, vars)
+	}
+
+	if _, err = parseDotEnv(strings.NewReader("NOT A VARIABLE\n")); err == nil {
+		t.Error("Expected an error for a line without =")
+	}
+}
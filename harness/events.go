@@ -0,0 +1,151 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The harness streams its events as Server-Sent Events at /@gospf/events, for
+// browsers to reload the page, and for editor plugins and dashboards to follow
+// the builds without polling:
+//
+//	build-started    {}
+//	build-succeeded  {"durationMs": 1520, "binary": "/home/dev/go/bin/..."}
+//	build-failed     {"durationMs": 830, "error": {"title": "Go Compilation Error", ...}}
+//	app-restarted    {"binary": "...", "reason": "rebuild"}
+//
+// The reason of a restart is "rebuild", "restart" for the admin API, or
+// "crash" with harness.restart, in which case the run mode of the instance is
+// given as "mode".  The error has the fields of that of the admin API.  The
+// stream is served by the proxy, and is read-only: unlike the admin API, it
+// does not require harness.admin.token.  For example, in a page:
+//
+//	new EventSource("/@gospf/events").addEventListener("app-restarted",
+//		function() { location.reload(); });
+
+const eventsPath = adminPathPrefix + "events"
+
+// The types of the events.
+const (
+	eventBuildStarted   = "build-started"
+	eventBuildSucceeded = "build-succeeded"
+	eventBuildFailed    = "build-failed"
+	eventAppRestarted   = "app-restarted"
+)
+
+// eventKeepAlive is how often a comment is sent on an idle stream, so that
+// the proxies in between keep it open.
+const eventKeepAlive = 30 * time.Second
+
+// eventBuffer is the number of events kept for a slow stream.  The events
+// that do not fit are dropped.
+const eventBuffer = 16
+
+// harnessEvent is an event of the stream, whose data is encoded as JSON.
+type harnessEvent struct {
+	ID   int64
+	Type string
+	Data []byte
+}
+
+// buildEvent is the data of the events of a build.
+type buildEvent struct {
+	DurationMs int64       `json:"durationMs,omitempty"`
+	Binary     string      `json:"binary,omitempty"`
+	Error      *adminError `json:"error,omitempty"`
+}
+
+// restartEvent is the data of app-restarted.
+type restartEvent struct {
+	Binary string `json:"binary"`
+	Mode   string `json:"mode,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// eventBroker sends the events of the harness to the open streams.  A nil
+// broker drops them.
+type eventBroker struct {
+	mu     sync.Mutex
+	lastID int64
+	subs   map[chan harnessEvent]bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: make(map[chan harnessEvent]bool)}
+}
+
+// publish sends the event to the open streams.
+func (e *eventBroker) publish(eventType string, data interface{}) {
+	if e == nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil || data == nil {
+		encoded = []byte("{}")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastID++
+	event := harnessEvent{ID: e.lastID, Type: eventType, Data: encoded}
+	for ch := range e.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// subscribe returns the channel of the events published from now on, until
+// unsubscribed.
+func (e *eventBroker) subscribe() chan harnessEvent {
+	ch := make(chan harnessEvent, eventBuffer)
+	e.mu.Lock()
+	e.subs[ch] = true
+	e.mu.Unlock()
+	return ch
+}
+
+func (e *eventBroker) unsubscribe(ch chan harnessEvent) {
+	e.mu.Lock()
+	delete(e.subs, ch)
+	e.mu.Unlock()
+}
+
+// ServeHTTP streams the events until the client goes away.
+func (e *eventBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	ch := e.subscribe()
+	defer e.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Reconnect quickly once the harness is back.
+	fmt.Fprint(w, "retry: 1000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,66 @@
+package harness
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+func TestEventBroker(t *testing.T) {
+	events := newEventBroker()
+	server := httptest.NewServer(events)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", contentType)
+	}
+	lines := bufio.NewReader(resp.Body)
+	if line, _ := lines.ReadString('\n'); line != "retry: 1000\n" {
+		t.Errorf("Expected the retry delay first, got %q", line)
+	}
+	lines.ReadString('\n')
+
+	// The stream is subscribed once the first lines are sent.
+	deadline := time.Now().Add(time.Second)
+	for {
+		events.mu.Lock()
+		subscribed := len(events.subs) > 0
+		events.mu.Unlock()
+		if subscribed || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	events.publish(eventBuildStarted, nil)
+	events.publish(eventBuildFailed, buildEvent{DurationMs: 12, Error: newAdminError(&gospf.Error{Title: "Go Compilation Error", Line: 3})})
+
+	var got []string
+	for len(got) < 8 {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.TrimSuffix(line, "\n"))
+	}
+	expected := []string{
+		"id: 1", "event: build-started", "data: {}", "",
+		"id: 2", "event: build-failed", `data: {"durationMs":12,"error":{"title":"Go Compilation Error","line":3}}`, "",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the events:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	// A nil broker drops the events.
+	var none *eventBroker
+	none.publish(eventBuildStarted, nil)
+}
@@ -0,0 +1,207 @@
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hubply/gospf"
+)
+
+// eventWatcher detects changes with filesystem events.  Unlike a watcher that
+// only watches the directories found when it starts, it also watches those
+// created under the roots while it runs, such as a new package under
+// app/controllers, and a new directory that already holds watched files, e.g.
+// one moved or checked out into place, counts as a change.  Like pollWatcher,
+// it refreshes every listener on the first Notify, and a listener whose
+// refresh failed on every Notify until it succeeds.
+type eventWatcher struct {
+	mu           sync.Mutex // Serializes Notify.
+	listeners    []*eventListener
+	forceRefresh bool
+	lastError    int // The index of the listener whose refresh failed, or -1.
+}
+
+type eventListener struct {
+	listener gospf.Listener
+	watcher  *fsnotify.Watcher
+
+	mu      sync.Mutex // Protects dirs and changed.
+	dirs    map[string]bool
+	changed bool
+}
+
+func newEventWatcher() *eventWatcher {
+	return &eventWatcher{
+		forceRefresh: true,
+		lastError:    -1,
+	}
+}
+
+// Listen starts watching the given roots for changes relevant to the
+// listener.
+func (w *eventWatcher) Listen(listener gospf.Listener, roots ...string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		gospf.ERROR.Fatal("Failed to create the watcher: ", err)
+	}
+	l := &eventListener{
+		listener: listener,
+		watcher:  watcher,
+		dirs:     make(map[string]bool),
+	}
+	for _, root := range roots {
+		l.watchTree(root)
+	}
+
+	w.mu.Lock()
+	w.listeners = append(w.listeners, l)
+	w.mu.Unlock()
+
+	go l.run()
+}
+
+// Notify refreshes the listeners whose files changed since the last call.
+func (w *eventWatcher) Notify() *gospf.Error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, l := range w.listeners {
+		l.mu.Lock()
+		changed := l.changed
+		l.changed = false
+		l.mu.Unlock()
+
+		if w.forceRefresh || changed || w.lastError == i {
+			if err := l.listener.Refresh(); err != nil {
+				w.lastError = i
+				return err
+			}
+		}
+	}
+
+	w.forceRefresh = false
+	w.lastError = -1
+	return nil
+}
+
+// watchTree watches the root, and the directories under it that the listener
+// watches.  It reports whether they hold files that the listener watches.
+func (l *eventListener) watchTree(root string) (found bool) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if !l.watchDir(path, info) {
+				return filepath.SkipDir
+			}
+			l.add(path, true)
+			return nil
+		}
+		if path == root {
+			l.add(path, false)
+		}
+		if l.watchFile(path) {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+func (l *eventListener) add(path string, dir bool) {
+	if err := l.watcher.Add(path); err != nil {
+		gospf.ERROR.Println("Failed to watch", path+":", err)
+		return
+	}
+	if dir {
+		l.mu.Lock()
+		l.dirs[path] = true
+		l.mu.Unlock()
+	}
+}
+
+// run handles the events of the listener's directories until the watcher is
+// closed.
+func (l *eventListener) run() {
+	for {
+		select {
+		case event, ok := <-l.watcher.Events:
+			if !ok {
+				return
+			}
+			if l.relevant(event) {
+				l.mu.Lock()
+				l.changed = true
+				l.mu.Unlock()
+			}
+		case err, ok := <-l.watcher.Errors:
+			if !ok {
+				return
+			}
+			gospf.ERROR.Println("Watcher error:", err)
+		}
+	}
+}
+
+// relevant reports whether the event changes the files the listener watches.
+// A directory created under a watched one is watched in turn.
+func (l *eventListener) relevant(event fsnotify.Event) bool {
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			return l.watchDir(event.Name, info) && l.watchTree(event.Name)
+		}
+	}
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		l.mu.Lock()
+		removed := l.forgetDir(event.Name)
+		l.mu.Unlock()
+		if removed {
+			return true
+		}
+	}
+	// Only the permissions changed.
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	return l.watchFile(event.Name)
+}
+
+// forgetDir stops watching the directory, and those under it, if they were
+// watched.  It reports whether the directory was.  The caller must hold l.mu.
+func (l *eventListener) forgetDir(dir string) bool {
+	if !l.dirs[dir] {
+		return false
+	}
+	for path := range l.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			delete(l.dirs, path)
+			// The watch is gone already if the directory was removed.
+			l.watcher.Remove(path)
+		}
+	}
+	return true
+}
+
+func (l *eventListener) watchDir(path string, info os.FileInfo) bool {
+	if pathListener, ok := l.listener.(dirPathListener); ok {
+		return pathListener.WatchDirPath(path)
+	}
+	if discerning, ok := l.listener.(gospf.DiscerningListener); ok {
+		return discerning.WatchDir(info)
+	}
+	return true
+}
+
+func (l *eventListener) watchFile(path string) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return false
+	}
+	if discerning, ok := l.listener.(gospf.DiscerningListener); ok {
+		return discerning.WatchFile(path)
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventWatcherNewDirectories(t *testing.T) {
+	root, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	listener := &countingListener{}
+	watcher := newEventWatcher()
+	watcher.Listen(listener, root)
+
+	notify := func(expected int, event string) {
+		time.Sleep(100 * time.Millisecond)
+		if err := watcher.Notify(); err != nil {
+			t.Fatal(err)
+		}
+		if listener.refreshes != expected {
+			t.Errorf("Expected %d refreshes %s, got %d", expected, event, listener.refreshes)
+		}
+	}
+
+	notify(1, "initially")
+	controllers := filepath.Join(root, "controllers")
+	os.Mkdir(controllers, 0777)
+	notify(1, "after adding an empty directory")
+	admin := filepath.Join(controllers, "admin")
+	os.Mkdir(admin, 0777)
+	notify(1, "after adding a nested directory")
+	ioutil.WriteFile(filepath.Join(admin, "admin.go"), []byte("package admin"), 0666)
+	notify(2, "after adding a file to a new directory")
+
+	// A directory moved into place with its files.
+	staging, err := ioutil.TempDir("", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(staging)
+	ioutil.WriteFile(filepath.Join(staging, "api.go"), []byte("package api"), 0666)
+	if err := os.Rename(staging, filepath.Join(root, "api")); err != nil {
+		t.Skip("Cannot move a directory into the watched root:", err)
+	}
+	notify(3, "after moving in a directory with files")
+	ioutil.WriteFile(filepath.Join(root, "api", "api.go"), []byte("package api // changed"), 0666)
+	notify(4, "after changing a file of a moved directory")
+
+	os.Mkdir(filepath.Join(root, "tmp"), 0777)
+	ioutil.WriteFile(filepath.Join(root, "tmp", "main.go"), []byte("package main"), 0666)
+	notify(4, "after adding an ignored directory")
+
+	os.RemoveAll(admin)
+	notify(5, "after removing a directory")
+}
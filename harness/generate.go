@@ -0,0 +1,149 @@
+package harness
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// generate runs "go generate" on the app's packages that declare directives,
+// if build.generate is set, in the given environment.  Packages whose files
+// have not changed since their last run are skipped, as the source cache
+// records, even across runs of the harness.
+func generate(ctx context.Context, env []string) *gospf.Error {
+	if !gospf.Config.BoolDefault("build.generate", false) {
+		return nil
+	}
+
+	goPath, err := exec.LookPath("go")
+	if err != nil {
+		gospf.ERROR.Fatalf("Go executable not found in PATH.")
+	}
+
+	dirs, err := generateDirs(gospf.AppPath)
+	if err != nil {
+		return &gospf.Error{
+			Title:       codeGenerationErrorTitle,
+			Description: "Failed to find go:generate directives: " + err.Error(),
+		}
+	}
+
+	cache := loadSourceCache()
+	for _, dir := range dirs {
+		hash, err := hashGoFiles(dir)
+		if err == nil && hash == cache.Generated[dir] {
+			continue
+		}
+
+		generateCmd := exec.CommandContext(ctx, goPath, "generate")
+		generateCmd.Dir = dir
+		generateCmd.Env = env
+		gospf.TRACE.Println("Exec:", generateCmd.Args, "in", dir)
+		output, err := generateCmd.CombinedOutput()
+		if ctx.Err() != nil {
+			return newCanceledError(ctx, "go generate")
+		}
+		if err != nil {
+			delete(cache.Generated, dir)
+			cache.save()
+			return &gospf.Error{
+				Title:       codeGenerationErrorTitle,
+				Path:        dir,
+				Description: fmt.Sprintf("go generate failed: %s\n%s", err, output),
+			}
+		}
+
+		// Hash the files again, now including the generated ones.
+		if hash, err = hashGoFiles(dir); err == nil {
+			cache.Generated[dir] = hash
+			cache.save()
+		}
+	}
+	return nil
+}
+
+// generateDirs returns the directories under root that contain Go files with
+// go:generate directives, skipping those that are not watched.
+func generateDirs(root string) ([]string, error) {
+	var dirs []string
+	filter := newWatchFilter([]string{root})
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filter.ignores(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		dir := filepath.Dir(path)
+		if len(dirs) > 0 && dirs[len(dirs)-1] == dir {
+			return nil
+		}
+		found, err := hasGenerateDirective(path)
+		if found {
+			dirs = append(dirs, dir)
+		}
+		return err
+	})
+	return dirs, err
+}
+
+func hasGenerateDirective(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if bytes.HasPrefix(scanner.Bytes(), []byte("//go:generate ")) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// hashGoFiles returns a hash of the names and contents of the Go files in
+// the given directory.
+func hashGoFiles(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".go") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(hash, info.Name()+"\x00")
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
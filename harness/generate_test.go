@@ -0,0 +1,49 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateDirs(t *testing.T) {
+	root, err := ioutil.TempDir("", "generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"controllers/app.go":  "package controllers\n",
+		"models/kind.go":      "package models\n\n//go:generate stringer -type=Kind\ntype Kind int\n",
+		"models/user.go":      "package models\n",
+		"services/mock.go":    "package services\n\n// go:generate is only a directive without the space.\n",
+		"services/service.go": "package services\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(filename), 0777)
+		if err = ioutil.WriteFile(filename, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs, err := generateDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.Join(root, "models")}; !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Expected %v, got %v", expected, dirs)
+	}
+
+	hash, err := hashGoFiles(filepath.Join(root, "models"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(root, "models", "kind_string.go"), []byte("package models\n"), 0666)
+	if newHash, _ := hashGoFiles(filepath.Join(root, "models")); newHash == hash {
+		t.Error("Expected the hash to change with the generated file")
+	}
+}
@@ -0,0 +1,162 @@
+package harness
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hubply/gospf"
+)
+
+// The go commands that build the app, go build, go get, go generate and go
+// list, may be set up for a module proxy, e.g. in a corporate network:
+//
+//	build.goflags    flags added to GOFLAGS, e.g. "-mod=mod"
+//	build.goproxy    GOPROXY, e.g. "https://goproxy.corp.example,direct"
+//	build.goprivate  GOPRIVATE, e.g. "*.corp.example,github.com/acme"
+//	build.gonosumdb  GONOSUMDB, e.g. "*.corp.example"
+//
+// The configured values replace those of the environment of gospf, except
+// build.goflags, which is added to the inherited GOFLAGS.  An inherited value
+// that differs is reported once, since it is a likely cause of surprises: a
+// shell that points GOPROXY at a mirror is overridden by the app.
+
+// goEnvKeys maps the configuration keys to the variables they set.
+var goEnvKeys = []struct{ key, name string }{
+	{"build.goproxy", "GOPROXY"},
+	{"build.goprivate", "GOPRIVATE"},
+	{"build.gonosumdb", "GONOSUMDB"},
+}
+
+var (
+	goEnvWarnedMu sync.Mutex
+	goEnvWarned   = make(map[string]bool) // The conflicts reported, by variable.
+)
+
+// GoEnv returns the environment of the go commands that build the app: that
+// of gospf, with the variables configured by build.goflags, build.goproxy,
+// build.goprivate and build.gonosumdb.  It returns an error if one of them is
+// invalid.
+func GoEnv() ([]string, *gospf.Error) {
+	return goEnv(os.Environ(), gospf.Config.StringDefault)
+}
+
+// goEnv returns the environment with the variables set by the configuration,
+// whose values are looked up with config.
+func goEnv(environ []string, config func(key, def string) string) ([]string, *gospf.Error) {
+	overrides := make(map[string]string)
+	for _, ev := range goEnvKeys {
+		value := strings.TrimSpace(config(ev.key, ""))
+		if value == "" {
+			continue
+		}
+		if err := checkGoEnv(ev.name, value); err != nil {
+			return nil, &gospf.Error{
+				Title:       "Invalid Configuration",
+				Description: fmt.Sprintf("%s: %s", ev.key, err),
+			}
+		}
+		if inherited := lookupEnv(environ, ev.name); inherited != "" && inherited != value {
+			warnGoEnv(ev.name, "%s=%s in the environment is overridden by %s=%s", ev.name, inherited, ev.key, value)
+		}
+		overrides[ev.name] = value
+	}
+
+	if flags := strings.TrimSpace(config("build.goflags", "")); flags != "" {
+		for _, flag := range strings.Fields(flags) {
+			if !strings.HasPrefix(flag, "-") {
+				return nil, &gospf.Error{
+					Title:       "Invalid Configuration",
+					Description: fmt.Sprintf("build.goflags: %q is not a flag", flag),
+				}
+			}
+		}
+		if inherited := strings.TrimSpace(lookupEnv(environ, "GOFLAGS")); inherited != "" {
+			warnGoEnv("GOFLAGS", "build.goflags is added to GOFLAGS=%s in the environment", inherited)
+			flags = inherited + " " + flags
+		}
+		overrides["GOFLAGS"] = flags
+	}
+
+	var vars []string
+	for _, name := range []string{"GOFLAGS", "GOPROXY", "GOPRIVATE", "GONOSUMDB"} {
+		if value := overrides[name]; value != "" {
+			vars = append(vars, name+"="+value)
+		}
+	}
+	return overrideEnv(environ, vars...), nil
+}
+
+// overrideEnv returns the environment with the variables, given as
+// KEY=VALUE, replacing those of the same name.
+func overrideEnv(environ []string, vars ...string) []string {
+	if len(vars) == 0 {
+		return environ
+	}
+	overridden := make(map[string]bool)
+	for _, kv := range vars {
+		overridden[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+	env := make([]string, 0, len(environ)+len(vars))
+	for _, kv := range environ {
+		if !overridden[strings.SplitN(kv, "=", 2)[0]] {
+			env = append(env, kv)
+		}
+	}
+	return append(env, vars...)
+}
+
+// checkGoEnv returns an error if the value of the variable would be refused
+// by the go command, or silently ignored.
+func checkGoEnv(name, value string) error {
+	if name != "GOPROXY" {
+		// A comma-separated list of glob patterns of module paths.
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+		return nil
+	}
+
+	// A list of proxy URLs, "direct" and "off", separated by commas or by
+	// pipes.
+	for _, proxy := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '|' }) {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "direct" || proxy == "off" {
+			continue
+		}
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" && u.Scheme != "file" || u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file" {
+			return fmt.Errorf("invalid proxy %q: expected an http, https or file URL, direct or off", proxy)
+		}
+	}
+	return nil
+}
+
+// lookupEnv returns the value of the variable in the environment.
+func lookupEnv(environ []string, name string) string {
+	value := ""
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, name+"=") {
+			value = kv[len(name)+1:]
+		}
+	}
+	return value
+}
+
+// warnGoEnv reports a conflict with the environment, once per variable.
+func warnGoEnv(name, format string, args ...interface{}) {
+	goEnvWarnedMu.Lock()
+	defer goEnvWarnedMu.Unlock()
+	if !goEnvWarned[name] {
+		goEnvWarned[name] = true
+		gospf.WARN.Printf(format, args...)
+	}
+}
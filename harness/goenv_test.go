@@ -0,0 +1,48 @@
+package harness
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGoEnv(t *testing.T) {
+	environ := []string{"HOME=/home/dev", "GOPROXY=https://proxy.golang.org", "GOFLAGS=-mod=vendor"}
+	config := func(values map[string]string) func(key, def string) string {
+		return func(key, def string) string {
+			if value, found := values[key]; found {
+				return value
+			}
+			return def
+		}
+	}
+
+	env, err := goEnv(environ, config(nil))
+	if err != nil || !reflect.DeepEqual(env, environ) {
+		t.Errorf("Expected the environment as is, got %v %v", env, err)
+	}
+
+	env, err = goEnv(environ, config(map[string]string{
+		"build.goproxy":   "https://goproxy.corp.example|direct",
+		"build.goprivate": "*.corp.example, github.com/acme",
+		"build.goflags":   "-trimpath",
+	}))
+	expected := []string{
+		"HOME=/home/dev",
+		"GOFLAGS=-mod=vendor -trimpath",
+		"GOPROXY=https://goproxy.corp.example|direct",
+		"GOPRIVATE=*.corp.example, github.com/acme",
+	}
+	if err != nil || !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v %v", expected, env, err)
+	}
+
+	for key, value := range map[string]string{
+		"build.goproxy":   "goproxy.corp.example",
+		"build.gonosumdb": "[corp",
+		"build.goflags":   "-mod mod",
+	} {
+		if _, err := goEnv(environ, config(map[string]string{key: value})); err == nil {
+			t.Errorf("Expected %s=%s to be invalid", key, value)
+		}
+	}
+}
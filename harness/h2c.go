@@ -0,0 +1,28 @@
+//go:build go1.24
+// +build go1.24
+
+package harness
+
+import "net/http"
+
+// The protocols of net/http, which h2c needs, are new in Go 1.24.  The
+// harness built with an earlier Go serves and forwards HTTP/2 over TLS only:
+// see h2c_other.go.
+
+// proxyH2C makes the transport forward requests to the app over HTTP/2
+// without TLS (h2c).
+func proxyH2C(transport *http.Transport) {
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+}
+
+// serveProtocols makes the server serve HTTP/1.1, and HTTP/2 over TLS, or
+// also without TLS (h2c) if h2c is set.
+func serveProtocols(server *http.Server, h2c bool) {
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	if h2c {
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+}
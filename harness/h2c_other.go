@@ -0,0 +1,24 @@
+//go:build !go1.24
+// +build !go1.24
+
+package harness
+
+import (
+	"net/http"
+
+	"github.com/hubply/gospf"
+)
+
+// proxyH2C warns that the harness can't forward requests over h2c, as it was
+// built with a Go earlier than 1.24.  The transport keeps to HTTP/1.1.
+func proxyH2C(transport *http.Transport) {
+	gospf.WARN.Println("harness.proxy.h2c requires a harness built with Go 1.24 or later, and is ignored.")
+}
+
+// serveProtocols leaves the server to its default protocols, HTTP/1.1 and
+// HTTP/2 over TLS, and warns that h2c can't be served before Go 1.24.
+func serveProtocols(server *http.Server, h2c bool) {
+	if h2c {
+		gospf.WARN.Println("harness.h2c requires a harness built with Go 1.24 or later, and is ignored.")
+	}
+}
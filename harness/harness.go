@@ -11,6 +11,7 @@
 package harness
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/hubply/gospf"
@@ -24,24 +25,167 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 var (
-	watcher    *gospf.Watcher
-	doNotWatch = []string{"tmp", "views", "routes"}
+	// Instances, if set, overrides the harness.instances configuration as the
+	// number of copies of the app to run behind the proxy.
+	Instances int
 
-	lastRequestHadError int32
+	// Modes, if set, are the run modes to run the app in at once, each on
+	// its own port.  Requests are routed to a mode according to
+	// harness.routing, and the first mode serves the rest.
+	Modes []string
+
+	// NoProxy, if set, overrides harness.proxy=true to run the app on the
+	// public port directly.
+	NoProxy bool
 )
 
 // Harness reverse proxies requests to the application server.
 // It builds / runs / rebuilds / restarts the server when code is changed.
+// Requests are distributed round-robin when several app instances are run.
 type Harness struct {
-	app        *App
+	pools   []*pool // One per run mode.  The first is the default.
+	routing string  // "prefix" or "host"
+	direct  bool    // Whether the app listens on the public port, without the proxy.
+	changed int32   // Set when the source changed, without the proxy.
+
+	filter  *watchFilter // The directories that are not watched.
+	watcher sourceWatcher
+	watched int // The number of paths watched.
+
+	lastRequestHadError int32 // Set while the requests are served an error page.
+
+	dev  *http.ServeMux // Serves the harness's own pages in dev mode.
+	mail *mailPreview
+	auth *devAuth // Rewrites the auth of requests in dev mode, if set.
+
+	replay *replayRecorder // Records the requests to replay after rebuilds, if set.
+	access *accessLog      // Logs the requests served, if set.
+	diff   *buildDiff      // Describes the changes since the last successful build, if set.
+
+	assets  *assetBuilders
+	cluster *cluster      // The harnesses on other machines, if harness.cluster.peers is set.
+	remote  *remoteAccess // Checks the access token of other machines, if exposed to the network.
+	admin   *adminAPI     // Serves the admin API, if harness.admin.token is set.
+	events  *eventBroker  // Streams the builds and the restarts.
+
+	refreshMu sync.Mutex // Held while the app is rebuilt and restarted.
+
+	rebuilding  int32        // Set while the app is rebuilt in the background.
+	waitRebuild bool         // Whether requests wait for rebuilds, with harness.rebuild=wait.
+	markStale   bool         // Whether responses served during a rebuild are marked.
+	skipRebuild []string     // The path prefixes of the requests that skip the rebuild check.
+	swap        sync.RWMutex // Held for writing while the app instances are replaced.
+	builds      buildQueue   // Shares each rebuild between its triggers.
+	buildMu     sync.Mutex   // Protects buildErr.
+	buildErr    *gospf.Error // The error of the last rebuild, if it failed.
+
+	handoffMu sync.Mutex
+	handoffs  map[*App]bool // The app instances handed off, and still running.
+}
+
+// staleHeader is set to "rebuilding" on the responses that the previous build
+// of the app served while it was rebuilt, with harness.rebuild.header set.
+const staleHeader = "X-Gospf-Stale"
+
+// devPathPrefix is the path under which the harness serves its own pages in
+// dev mode, rather than proxying the requests to the app.
+const devPathPrefix = "/_gospf/"
+
+// pool is the app instances running in one run mode.
+type pool struct {
+	mode     string
+	backends []*backend
+	next     uint32 // Incremented to select the next backend.
+}
+
+// backend is one running instance of the app, and the proxy to it.
+type backend struct {
+	mu    sync.Mutex // Protects app, crash, ready, usage, port, socket, listener, serverHost and proxy.
+	app   *App
+	crash *gospf.Error    // Set if the app exited on its own.
+	ready chan struct{}   // Closed once the app is ready for requests.
+	usage *resourceSample // The last sample of the resources of the app, if any.
+
+	mode       string // The run mode of the app.
+	scheme     string
+	addr       string
 	serverHost string
 	port       int
+	socket     string   // The unix socket the app listens on instead of the port, if set.
+	listener   *os.File // The listener of the port, handed to the app with harness.port.handoff.
 	proxy      *httputil.ReverseProxy
+	transport  *http.Transport
+	restarting int32        // Set while the app is being restarted.
+	events     *eventBroker // Told of the restarts after a crash.
+}
+
+// Defaults for the proxy transport, which may be tuned with the
+// harness.proxy.* configuration.  Go's default of two idle connections per
+// host makes load tests through the harness churn through ephemeral ports.
+const (
+	defaultProxyMaxIdleConns        = 100
+	defaultProxyMaxIdleConnsPerHost = 32
+	defaultProxyIdleTimeout         = 90 * time.Second
+)
+
+// newProxyTransport returns the transport used to forward requests to an app
+// instance.
+func newProxyTransport() *http.Transport {
+	transport := &http.Transport{
+		Proxy: nil, // Never proxy requests to the app on localhost.
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        gospf.Config.IntDefault("harness.proxy.maxidleconns", defaultProxyMaxIdleConns),
+		MaxIdleConnsPerHost: gospf.Config.IntDefault("harness.proxy.maxidleconnsperhost", defaultProxyMaxIdleConnsPerHost),
+		IdleConnTimeout:     defaultProxyIdleTimeout,
+		DisableKeepAlives:   !gospf.Config.BoolDefault("harness.proxy.keepalive", true),
+	}
+	if value, found := gospf.Config.String("harness.proxy.idletimeout"); found {
+		if timeout, err := time.ParseDuration(value); err == nil {
+			transport.IdleConnTimeout = timeout
+		} else {
+			gospf.WARN.Printf("Invalid harness.proxy.idletimeout %q: %s", value, err)
+		}
+	}
+	if gospf.HttpSsl {
+		// The custom dialer disables HTTP/2 unless it is forced.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		transport.ForceAttemptHTTP2 = true
+	} else if gospf.Config.BoolDefault("harness.proxy.h2c", false) {
+		// The app must serve HTTP/2 without TLS (h2c) as well.
+		proxyH2C(transport)
+	}
+	return transport
+}
+
+// newProxy returns a reverse proxy to the app instance at the given URL.
+// Connections to the instance are not kept alive while it restarts, since
+// they would be left pointing at the old process.
+func (b *backend) newProxy(serverUrl *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(serverUrl)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		if atomic.LoadInt32(&b.restarting) != 0 {
+			req.Close = true
+		}
+	}
+	proxy.Transport = b.transport
+	if rewriter := newResponseRewriter(serverUrl); rewriter != nil {
+		proxy.ModifyResponse = rewriter.ModifyResponse
+	}
+	return proxy
 }
 
 func renderError(w http.ResponseWriter, r *http.Request, err error) {
@@ -53,32 +197,254 @@ func renderError(w http.ResponseWriter, r *http.Request, err error) {
 // ServeHTTP handles all requests.
 // It checks for changes to app, rebuilds if necessary, and forwards the request.
 func (hp *Harness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if hp.access != nil {
+		aw := hp.access.wrap(w, r)
+		defer hp.access.log(aw, r)
+		w = aw
+	}
+
 	// Don't rebuild the app for favicon requests.
-	if lastRequestHadError > 0 && r.URL.Path == "/favicon.ico" {
+	if atomic.LoadInt32(&hp.lastRequestHadError) > 0 && r.URL.Path == "/favicon.ico" {
 		return
 	}
 
-	// Flush any change events and rebuild app if necessary.
-	// Render an error page if the rebuild / restart failed.
-	err := watcher.Notify()
-	if err != nil {
-		atomic.CompareAndSwapInt32(&lastRequestHadError, 0, 1)
+	// The admin API checks its own token.
+	if hp.admin != nil && strings.HasPrefix(r.URL.Path, adminPathPrefix) && r.URL.Path != eventsPath {
+		hp.admin.ServeHTTP(w, r)
+		return
+	}
+
+	// The cluster endpoints, and the requests that the leader forwards, check
+	// the shared secret instead of the token.
+	forwarded := hp.cluster != nil && hp.cluster.forwarded(r)
+	if hp.remote != nil && !forwarded && !strings.HasPrefix(r.URL.Path, clusterPathPrefix) && !hp.remote.allow(w, r) {
+		return
+	}
+
+	if r.URL.Path == eventsPath {
+		hp.events.ServeHTTP(w, r)
+		return
+	}
+
+	if hp.cluster != nil {
+		if strings.HasPrefix(r.URL.Path, clusterPathPrefix) {
+			hp.cluster.ServeHTTP(w, r)
+			return
+		}
+		if hp.cluster.forward(w, r) {
+			return
+		}
+	}
+
+	if hp.dev != nil && strings.HasPrefix(r.URL.Path, devPathPrefix) {
+		hp.dev.ServeHTTP(w, r)
+		return
+	}
+
+	// Flush any change events and rebuild app if necessary, unless the
+	// request skips the check.
+	// Render an error page if the rebuild / restart failed, and the building
+	// page to browsers if it takes a while.
+	if !hp.skipsRebuild(r) {
+		err, done := hp.notify(isPageLoad(r))
+		if !done {
+			if prompt := hp.prompt(); prompt != "" {
+				renderPrompt(w, prompt)
+				return
+			}
+			elapsed, output, _ := progress.status()
+			renderProgress(w, elapsed, output)
+			return
+		}
+		if err != nil {
+			atomic.CompareAndSwapInt32(&hp.lastRequestHadError, 0, 1)
+			renderError(w, r, err)
+			return
+		}
+		atomic.CompareAndSwapInt32(&hp.lastRequestHadError, 1, 0)
+	}
+
+	if err := hp.assets.failure(); err != nil {
 		renderError(w, r, err)
 		return
 	}
-	atomic.CompareAndSwapInt32(&lastRequestHadError, 1, 0)
+
+	// Wait for the app instances to be replaced, if a rebuild just finished.
+	hp.swap.RLock()
+	stale := atomic.LoadInt32(&hp.rebuilding) != 0
+	hp.swap.RUnlock()
+	if stale && hp.markStale {
+		w.Header().Set(staleHeader, "rebuilding")
+	}
 
 	// Reverse proxy the request.
 	// (Need special code for websockets, courtesy of bradfitz)
-	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
-		proxyWebsocket(w, r, hp.serverHost)
+	p := hp.route(r)
+	b := p.backends[int(atomic.AddUint32(&p.next, 1)-1)%len(p.backends)]
+	if crash := b.crashError(); crash != nil {
+		renderError(w, r, crash)
+		return
+	}
+	if !b.waitReady(w, r) {
+		return
+	}
+	if hp.auth != nil {
+		hp.auth.apply(r)
+	}
+	// Count the request, so that the app is kept running until it is served
+	// when it is replaced, or until the socket closes when the app is handed
+	// off.  It is counted while the app may not be replaced.
+	websocket := strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+	hp.swap.RLock()
+	app, network, address, proxy := b.target()
+	if app != nil {
+		counter := &app.requests
+		if websocket {
+			counter = &app.sockets
+		}
+		atomic.AddInt32(counter, 1)
+		defer atomic.AddInt32(counter, -1)
+	}
+	hp.swap.RUnlock()
+	markUpstream(w, stale)
+	if websocket {
+		proxyWebsocket(w, r, network, address)
+	} else if hp.replay != nil {
+		rw, record := hp.replay.wrap(w, r)
+		proxy.ServeHTTP(rw, r)
+		record()
 	} else {
-		hp.proxy.ServeHTTP(w, r)
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// notify rebuilds the app if its source changed.  While the app is running
+// and its last build succeeded, it is rebuilt in the background, and the
+// running app keeps serving requests until the new build replaces it: it is
+// stale while rebuilding.  Otherwise, as on the first request, while fixing a
+// build error or with harness.rebuild=wait, the request waits for the rebuild.
+// If impatient, it only waits for progressDelay while a build is in progress,
+// or the app waits for input as it starts, and reports that the rebuild is not
+// done.
+func (hp *Harness) notify(impatient bool) (*gospf.Error, bool) {
+	if hp.waitRebuild || hp.lastBuildError() != nil || !hp.running() {
+		return hp.wait(impatient)
+	}
+
+	if atomic.CompareAndSwapInt32(&hp.rebuilding, 0, 1) {
+		build := hp.builds.join(hp.rebuild)
+		go func() {
+			defer atomic.StoreInt32(&hp.rebuilding, 0)
+			if err := build.wait(); err != nil {
+				gospf.ERROR.Printf("Rebuild failed: %s", err.Title)
+			}
+		}()
+	}
+	return nil, true
+}
+
+// wait rebuilds the app if its source changed, and waits for it.  The
+// requests that wait at the same time share the rebuild, so that a request
+// that stops waiting, when impatient, may come back for its result.
+func (hp *Harness) wait(impatient bool) (*gospf.Error, bool) {
+	build := hp.builds.join(hp.rebuild)
+	for impatient {
+		select {
+		case <-build.done:
+			return build.err, true
+		case <-time.After(progressDelay):
+			if _, _, building := progress.status(); building || hp.prompt() != "" {
+				return nil, false
+			}
+		}
+	}
+	return build.wait(), true
+}
+
+// rebuild flushes the change events, which rebuild the app if necessary, and
+// keeps the error of the rebuild.  It runs in the build queue, so that the
+// triggers that arrive meanwhile share its result.
+func (hp *Harness) rebuild() *gospf.Error {
+	err := hp.watcher.Notify()
+	hp.setBuildError(err)
+	return err
+}
+
+func (hp *Harness) lastBuildError() *gospf.Error {
+	hp.buildMu.Lock()
+	defer hp.buildMu.Unlock()
+	return hp.buildErr
+}
+
+func (hp *Harness) setBuildError(err *gospf.Error) {
+	hp.buildMu.Lock()
+	defer hp.buildMu.Unlock()
+	hp.buildErr = err
+}
+
+// running reports whether the app instances have been started.
+func (hp *Harness) running() bool {
+	for _, b := range hp.backends() {
+		if b.currentApp() == nil {
+			return false
+		}
 	}
+	return true
 }
 
-// Return a reverse proxy that forwards requests to the given port.
+// route returns the pool of app instances that serves the request.  With
+// prefix routing, a mode serves the paths under /<mode>, which the request is
+// stripped of; with host routing, it serves the host names starting with
+// "<mode>.".
+func (hp *Harness) route(r *http.Request) *pool {
+	for _, p := range hp.pools[1:] {
+		switch hp.routing {
+		case "host":
+			if strings.HasPrefix(r.Host, p.mode+".") {
+				return p
+			}
+		default:
+			prefix := "/" + p.mode
+			if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+				stripPathPrefix(r, prefix)
+				return p
+			}
+		}
+	}
+	return hp.pools[0]
+}
+
+// stripPathPrefix removes the prefix from the path of the request, and tells
+// the app of it with the X-Forwarded-Prefix header, e.g. for its links.
+func stripPathPrefix(r *http.Request, prefix string) {
+	r.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	r.URL.RawPath = ""
+	r.Header.Set("X-Forwarded-Prefix", prefix)
+}
+
+// backends returns the app instances of every run mode.
+func (hp *Harness) backends() []*backend {
+	var backends []*backend
+	for _, p := range hp.pools {
+		backends = append(backends, p.backends...)
+	}
+	return backends
+}
+
+// Return a reverse proxy that forwards requests to the app instances.
+// Each instance is given its own port: harness.port, harness.port+1, etc., or
+// a free port each if harness.port is not set.  It is configured by the
+// package variables, such as Instances and Modes.
 func NewHarness() *Harness {
+	return newHarness(Config{
+		Instances: Instances,
+		Modes:     Modes,
+		NoProxy:   NoProxy,
+		Remote:    Remote,
+	})
+}
+
+func newHarness(config Config) *Harness {
 	// Get a template loader to render errors.
 	// Prefer the app's views/errors directory, and fall back to the stock error pages.
 	gospf.MainTemplateLoader = gospf.NewTemplateLoader(
@@ -97,97 +463,685 @@ func NewHarness() *Harness {
 		addr = "localhost"
 	}
 
-	if port == 0 {
-		port = getFreePort()
+	instances := config.Instances
+	if instances == 0 {
+		instances = gospf.Config.IntDefault("harness.instances", 1)
+	}
+	if instances < 1 {
+		gospf.WARN.Printf("Invalid number of instances %d, running 1", instances)
+		instances = 1
+	}
+
+	modes := config.Modes
+	if len(modes) == 0 {
+		modes = []string{gospf.RunMode}
+	}
+
+	sockets := gospf.Config.BoolDefault("harness.socket", false)
+
+	if Interactive && instances*len(modes) > 1 {
+		gospf.WARN.Println("Running several instances with -interactive: they share the input of the console.")
 	}
 
-	serverUrl, _ := url.ParseRequestURI(fmt.Sprintf(scheme+"://%s:%d", addr, port))
+	// Without the proxy, a single instance of the app listens on the public
+	// port.
+	direct := config.NoProxy || !gospf.Config.BoolDefault("harness.proxy", true)
+	if direct {
+		if instances > 1 || len(modes) > 1 || sockets {
+			gospf.WARN.Println("Running a single instance on the public port: -n, -modes and harness.socket require the proxy.")
+		}
+		instances, modes, sockets = 1, modes[:1], false
+		addr, port = gospf.HttpAddr, gospf.HttpPort
+	}
 
 	harness := &Harness{
-		port:       port,
-		serverHost: serverUrl.String()[len(scheme+"://"):],
-		proxy:      httputil.NewSingleHostReverseProxy(serverUrl),
+		routing:     gospf.Config.StringDefault("harness.routing", "prefix"),
+		direct:      direct,
+		assets:      newAssetBuilders(),
+		events:      newEventBroker(),
+		markStale:   gospf.Config.BoolDefault("harness.rebuild.header", false),
+		skipRebuild: loadSkipRebuild(),
+		diff:        loadBuildDiff(),
+	}
+	switch mode := gospf.Config.StringDefault("harness.rebuild", "stale"); mode {
+	case "stale":
+	case "wait":
+		harness.waitRebuild = true
+	default:
+		gospf.WARN.Printf("Invalid harness.rebuild %q, using stale", mode)
+	}
+	for _, mode := range modes {
+		p := &pool{mode: mode}
+		for i := 0; i < instances; i++ {
+			b := &backend{
+				mode:      mode,
+				scheme:    scheme,
+				addr:      addr,
+				transport: newProxyTransport(),
+				events:    harness.events,
+			}
+			if sockets {
+				b.transport.DialContext = b.dialSocket
+				b.setSocket(newSocketPath())
+			} else {
+				// Each instance gets a free port without harness.port.
+				instancePort := 0
+				if port != 0 {
+					instancePort = port + len(harness.backends()) + i
+				}
+				b.bindPort(instancePort)
+			}
+			p.backends = append(p.backends, b)
+		}
+		harness.pools = append(harness.pools, p)
 	}
 
-	if gospf.HttpSsl {
-		harness.proxy.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	if gospf.DevMode {
+		harness.dev = http.NewServeMux()
+		harness.mail = newMailPreview()
+		harness.mail.register(harness.dev)
+		registerSessionInspector(harness.dev)
+		registerEditor(harness.dev)
+		registerStatus(harness.dev, harness)
+		harness.auth = loadDevAuth()
+		if harness.replay = loadReplayRecorder(); harness.replay != nil {
+			harness.replay.register(harness.dev, harness)
 		}
 	}
+	if !direct {
+		harness.cluster = loadCluster(harness)
+		harness.remote = loadRemoteAccess(config.Remote)
+		harness.admin = loadAdminAPI(harness)
+		harness.access = loadAccessLog()
+	} else if config.Remote || gospf.Config.BoolDefault("harness.remote", false) {
+		gospf.WARN.Println("Not exposing the app to the network: harness.remote requires the proxy.")
+	}
 	return harness
 }
 
-// Rebuild the Revel application and run it on the given port.
+// bindPort points the backend at the given port of the app, or at a free port
+// if 0.  With harness.port.handoff set, the harness listens on the port for
+// the app.  The caller must hold b.mu, unless the backend is not in use yet.
+func (b *backend) bindPort(port int) {
+	if !listenerHandoff() {
+		if port == 0 {
+			port = getFreePort()
+		}
+		b.setPort(port)
+		return
+	}
+	listener, port, err := reservePort(b.addr, port)
+	if err != nil {
+		gospf.ERROR.Fatalln("Failed to listen on a port for the app:", err)
+	}
+	b.setPort(port)
+	b.listener = listener
+}
+
+// setPort points the backend at the given port of the app.  The caller must
+// hold b.mu, unless the backend is not in use yet.
+func (b *backend) setPort(port int) {
+	b.closeListener()
+	serverUrl, _ := url.ParseRequestURI(fmt.Sprintf(b.scheme+"://%s:%d", b.addr, port))
+	b.port = port
+	b.serverHost = serverUrl.String()[len(b.scheme+"://"):]
+	b.proxy = b.newProxy(serverUrl)
+}
+
+// setSocket points the backend at the app listening on the given unix
+// socket.  The caller must hold b.mu, unless the backend is not in use yet.
+func (b *backend) setSocket(socket string) {
+	// The host only names the app in the requests; connections are made
+	// to the socket by dialSocket.
+	serverUrl, _ := url.ParseRequestURI(b.scheme + "://localhost")
+	b.closeListener()
+	b.port = 0
+	b.socket = socket
+	b.serverHost = serverUrl.Host
+	b.proxy = b.newProxy(serverUrl)
+}
+
+// rebind points the backend at a new port, or a new socket if it uses
+// sockets, for a new app instance to run next to the current one.  The
+// caller must hold b.mu.
+func (b *backend) rebind() {
+	if b.socket != "" {
+		b.setSocket(newSocketPath())
+	} else {
+		b.bindPort(0)
+	}
+}
+
+// closeListener closes the listener of the port of the backend, if any.  The
+// app instances that were handed the listener keep serving it.  The caller
+// must hold b.mu, unless the backend is not in use yet.
+func (b *backend) closeListener() {
+	if b.listener != nil {
+		b.listener.Close()
+		b.listener = nil
+	}
+}
+
+// dialSocket connects to the unix socket of the app, whatever the address.
+func (b *backend) dialSocket(ctx context.Context, network, addr string) (net.Conn, error) {
+	b.mu.Lock()
+	socket := b.socket
+	b.mu.Unlock()
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", socket)
+}
+
+// socketCount numbers the sockets of the app instances.
+var socketCount int32
+
+// newSocketPath returns a path for the unix socket of a new app instance.
+func newSocketPath() string {
+	n := atomic.AddInt32(&socketCount, 1)
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gospf-%d-%d.sock", os.Getpid(), n))
+}
+
+// target returns the running app instance, if any, and the network and
+// address to reach it.
+func (b *backend) target() (*App, string, string, *httputil.ReverseProxy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.socket != "" {
+		return b.app, "unix", b.socket, b.proxy
+	}
+	return b.app, "tcp", b.serverHost, b.proxy
+}
+
+// defaultHandoffTimeout is how long an app instance that was handed off is
+// kept running for its open websockets, unless harness.handoff.timeout says
+// otherwise.
+const defaultHandoffTimeout = 30 * time.Second
+
+// handoffTimeout returns how long to keep a handed off app instance running,
+// or 0 if harness.handoff is not set.
+func handoffTimeout() time.Duration {
+	if !gospf.Config.BoolDefault("harness.handoff", false) {
+		return 0
+	}
+	timeout := defaultHandoffTimeout
+	if value, found := gospf.Config.String("harness.handoff.timeout"); found {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			gospf.WARN.Printf("Invalid harness.handoff.timeout %q, using %s", value, defaultHandoffTimeout)
+		} else {
+			timeout = parsed
+		}
+	}
+	return timeout
+}
+
+// handOff kills the app instance once its requests are served and its
+// websockets are closed, or the timeout elapses.  It is not sent any new
+// requests meanwhile.
+func (h *Harness) handOff(app *App, timeout time.Duration) {
+	h.handoffMu.Lock()
+	if h.handoffs == nil {
+		h.handoffs = make(map[*App]bool)
+	}
+	h.handoffs[app] = true
+	h.handoffMu.Unlock()
+	go h.drain(app, timeout)
+}
+
+func (h *Harness) drain(app *App, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt32(&app.sockets)+atomic.LoadInt32(&app.requests) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if sockets := atomic.LoadInt32(&app.sockets); sockets > 0 {
+		gospf.INFO.Printf("Closing %d websockets of the previous build", sockets)
+	}
+
+	h.handoffMu.Lock()
+	defer h.handoffMu.Unlock()
+	if h.handoffs[app] {
+		delete(h.handoffs, app)
+		app.Kill()
+	}
+}
+
+// drainTimeout is how long the requests to an app instance that a rebuild
+// replaces may take to be served before it is stopped.
+const drainTimeout = 10 * time.Second
+
+// stop kills the running app instances once the requests they serve are
+// done, or the timeout elapses.  The caller must hold h.swap, so that they
+// are not sent any new requests meanwhile.
+func (h *Harness) stop(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, app := range h.detach() {
+		for atomic.LoadInt32(&app.requests) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if requests := atomic.LoadInt32(&app.requests); requests > 0 {
+			gospf.WARN.Printf("Stopping the app with %d requests in flight", requests)
+		}
+		app.Kill()
+	}
+}
+
+// killHandoffs terminates the app instances that were handed off.
+func (h *Harness) killHandoffs() {
+	h.handoffMu.Lock()
+	defer h.handoffMu.Unlock()
+	for app := range h.handoffs {
+		delete(h.handoffs, app)
+		app.Kill()
+	}
+}
+
+// Rebuild the Revel application and run each instance on its port.  The
+// running instances are only replaced once the build succeeds, except on
+// Windows, where the binary of a running program cannot be overwritten, and
+// once they served the requests in flight, for up to drainTimeout.
+//
+// With harness.handoff set, the replaced instances are handed off: they are
+// kept running until their websockets close, while the new instances run on
+// new ports and serve all new requests.
 func (h *Harness) Refresh() (err *gospf.Error) {
-	if h.app != nil {
-		h.app.Kill()
+	h.refreshMu.Lock()
+	defer h.refreshMu.Unlock()
+
+	// Without the proxy, the source is checked continuously, and a failed
+	// build is only retried once the source changes.
+	if h.direct && atomic.SwapInt32(&h.changed, 0) == 0 {
+		if err = h.lastBuildError(); err != nil {
+			return
+		}
+	}
+	if runtime.GOOS == "windows" {
+		h.kill()
 	}
 
 	gospf.TRACE.Println("Rebuild")
-	h.app, err = Build()
+	h.events.publish(eventBuildStarted, nil)
+	started := time.Now()
+	app, err := Build()
+	duration := time.Since(started).Nanoseconds() / int64(time.Millisecond)
 	if err != nil {
+		h.events.publish(eventBuildFailed, buildEvent{DurationMs: duration, Error: newAdminError(err)})
+		if h.diff != nil {
+			err = h.diff.failed(err)
+		}
 		return
 	}
+	h.events.publish(eventBuildSucceeded, buildEvent{DurationMs: duration, Binary: app.BinaryPath})
+	if h.diff != nil {
+		h.diff.succeeded()
+	}
+
+	h.swap.Lock()
+	defer h.swap.Unlock()
+	if timeout := handoffTimeout(); timeout > 0 && !h.direct {
+		for _, old := range h.detach() {
+			h.handOff(old, timeout)
+		}
+		for _, b := range h.backends() {
+			b.mu.Lock()
+			b.rebind()
+			b.mu.Unlock()
+		}
+	} else {
+		h.stop(drainTimeout)
+	}
+	for _, b := range h.backends() {
+		if err = b.start(app.BinaryPath); err != nil {
+			return
+		}
+		atomic.StoreInt32(&b.restarting, 0)
+	}
+	h.events.publish(eventAppRestarted, restartEvent{Binary: app.BinaryPath, Reason: "rebuild"})
+	if app.Summary != nil && gospf.Config.BoolDefault("harness.summary", true) {
+		gospf.INFO.Println(app.Summary.Banner(appURL(), h.watched))
+	}
+	h.assets.rerun()
+	if h.replay != nil && h.replay.auto {
+		go h.replay.replay(h)
+	}
 
-	h.app.Port = h.port
-	if err2 := h.app.Cmd().Start(); err2 != nil {
+	return
+}
+
+// Defaults for restarting apps that exit on their own, with harness.restart
+// set.  The delay doubles with each exit, up to the maximum, unless the app
+// ran for long enough to be considered healthy again.
+const (
+	minRestartDelay = 1 * time.Second
+	maxRestartDelay = 30 * time.Second
+	healthyRunTime  = 1 * time.Minute
+)
+
+// start runs a new instance of the app binary, and monitors it.
+func (b *backend) start(binaryPath string) *gospf.Error {
+	app := NewApp(binaryPath)
+	app.RunMode = b.mode
+	b.mu.Lock()
+	app.Port, app.Socket, app.Listener = b.port, b.socket, b.listener
+	// The command is made before the app is shared, for its prompt to be
+	// read while it starts.
+	cmd := app.Cmd()
+	b.app = app
+	b.mu.Unlock()
+
+	if err := cmd.Start(); err != nil {
 		return &gospf.Error{
 			Title:       "App failed to start up",
-			Description: err2.Error(),
+			Description: err.Error(),
 		}
 	}
 
-	return
+	b.started(app)
+	go b.monitor(app)
+	return nil
+}
+
+// monitor waits for the app to exit.  Unless it was killed by the harness,
+// the exit is reported on the following requests, and with harness.restart
+// set, the app is restarted after a delay.
+func (b *backend) monitor(app *App) {
+	delay := minRestartDelay
+	for {
+		started := time.Now()
+		<-app.Exited()
+		if app.Killed() {
+			return
+		}
+
+		crash := app.ExitError()
+		gospf.ERROR.Println(crash.Description)
+		b.mu.Lock()
+		current := b.app == app
+		if current {
+			b.crash = crash
+		}
+		b.mu.Unlock()
+		if !current || !gospf.Config.BoolDefault("harness.restart", false) {
+			return
+		}
+
+		if time.Since(started) > healthyRunTime {
+			delay = minRestartDelay
+		}
+		gospf.INFO.Printf("Restarting the app in %s", delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+
+		// The app may have been rebuilt meanwhile.
+		b.mu.Lock()
+		if b.app != app {
+			b.mu.Unlock()
+			return
+		}
+		app = NewApp(app.BinaryPath)
+		app.Port, app.Socket, app.Listener = b.port, b.socket, b.listener
+		app.RunMode = b.mode
+		cmd := app.Cmd()
+		b.app = app
+		b.mu.Unlock()
+
+		if err := cmd.Start(); err != nil {
+			gospf.ERROR.Println("Failed to restart the app:", err)
+			if app.Killed() {
+				return
+			}
+			continue
+		}
+		b.started(app)
+		b.events.publish(eventAppRestarted, restartEvent{Binary: app.BinaryPath, Mode: b.mode, Reason: "crash"})
+	}
+}
+
+// crashError returns the description of the app's exit, if it exited on
+// its own.
+func (b *backend) crashError() *gospf.Error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.crash
+}
+
+// currentApp returns the running app instance, if any.
+func (b *backend) currentApp() *App {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.app
+}
+
+// kill terminates every running app instance, and drops the idle connections
+// to them.
+func (h *Harness) kill() {
+	for _, app := range h.detach() {
+		app.Kill()
+	}
+}
+
+// detach removes the running app instances from the backends, dropping the
+// idle connections to them, and returns them.
+func (h *Harness) detach() []*App {
+	var apps []*App
+	for _, b := range h.backends() {
+		atomic.StoreInt32(&b.restarting, 1)
+		b.mu.Lock()
+		if b.app != nil {
+			apps = append(apps, b.app)
+		}
+		b.app, b.crash = nil, nil
+		b.mu.Unlock()
+		b.transport.CloseIdleConnections()
+	}
+	return apps
+}
+
+// templateRefresher asks the running app to reload its templates when the
+// views change, since those do not require a rebuild.
+type templateRefresher struct {
+	harness *Harness
+}
+
+func (t templateRefresher) Refresh() *gospf.Error {
+	for _, b := range t.harness.backends() {
+		app := b.currentApp()
+		if app == nil {
+			continue
+		}
+		if err := app.ReloadTemplates(); err != nil {
+			gospf.WARN.Println("Failed to reload templates:", err)
+		}
+	}
+	return nil
 }
 
 func (h *Harness) WatchDir(info os.FileInfo) bool {
-	return !gospf.ContainsString(doNotWatch, info.Name())
+	return h.filter == nil || !h.filter.ignoresInfo(info)
+}
+
+func (h *Harness) WatchDirPath(dir string) bool {
+	return h.filter == nil || !h.filter.ignores(dir)
 }
 
+// WatchFile reports whether a change to the file requires a rebuild: that of
+// a Go file, or of a template of the generated code in conf/templates.  The
+// files ignored by the app's .gospfignore do not.
 func (h *Harness) WatchFile(filename string) bool {
-	return strings.HasSuffix(filename, ".go")
+	if filepath.Dir(filename) == userTemplatesDir() {
+		return strings.HasSuffix(filename, ".tmpl")
+	}
+	return strings.HasSuffix(filename, ".go") && (h.filter == nil || !h.filter.ignoresFile(filename))
 }
 
 // Run the harness, which listens for requests and proxies them to the app
-// server, which it runs and rebuilds as necessary.
+// server, which it runs and rebuilds as necessary.  It stops the app and
+// exits on SIGINT or SIGTERM.
 func (h *Harness) Run() {
+	h.Start()
+
+	if h.direct {
+		go h.watchDirect()
+	} else {
+		go func() {
+			addr := fmt.Sprintf("%s:%d", gospf.HttpAddr, gospf.HttpPort)
+			if h.remote != nil {
+				addr = fmt.Sprintf(":%d", gospf.HttpPort)
+				h.remote.announce(gospf.HttpPort)
+			}
+			gospf.INFO.Printf("Listening on %s", addr)
+
+			server := &http.Server{Addr: addr, Handler: h}
+			serveProtocols(server, gospf.Config.BoolDefault("harness.h2c", false))
+			var err error
+			if gospf.HttpSsl {
+				err = server.ListenAndServeTLS(gospf.HttpSslCert, gospf.HttpSslKey)
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil {
+				gospf.ERROR.Fatalln("Failed to start reverse proxy:", err)
+			}
+		}()
+	}
+
+	// Kill the app on signal.
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	<-ch
+	h.Stop()
+	os.Exit(1)
+}
+
+// Start watches the source of the app, and starts the asset builders and the
+// other services of the harness, but neither listens for requests nor builds
+// the app: the app is built on the first request served by ServeHTTP, or
+// without the proxy, by Run.
+func (h *Harness) Start() {
+	paths := watchRoots()
+	h.watched = len(paths)
+	h.filter = newWatchFilter(paths)
+	h.filter.scan()
+	h.watcher = newSourceWatcher()
+
+	// The templates of the generated code are in conf/templates, outside the
+	// code paths: conf is watched too, but WatchFile only takes the templates.
+	sources := append(append([]string{}, paths...), filepath.Dir(userTemplatesDir()))
+	if h.direct {
+		h.watcher.Listen(sourceChanges{h}, sources...)
+	}
+	h.watcher.Listen(h, sources...)
+
+	// Unless the app watches its own templates, tell it when they change.
+	if !gospf.Config.BoolDefault("watch.templates", true) {
+		h.watcher.Listen(templateRefresher{h}, viewsPaths()...)
+	}
+
+	// Run the asset builders, and restart them when their commands change.
+	h.assets.update(configValues("assets."))
+	h.watcher.Listen(assetsConfig{h.assets}, path.Join(gospf.BasePath, "conf"))
+
+	if h.mail != nil && gospf.Config.BoolDefault("harness.mail.capture", false) {
+		go h.mail.listenSMTP(gospf.Config.StringDefault("harness.mail.addr", "localhost:2525"))
+	}
+
+	if h.cluster != nil {
+		go h.cluster.run()
+	}
+}
+
+// watchRoots returns the directories watched for changes to the app: those
+// of WatchPaths, and the GOPATH with watch.gopath set.
+func watchRoots() []string {
 	var paths []string
 	if gospf.Config.BoolDefault("watch.gopath", false) {
 		gopaths := filepath.SplitList(build.Default.GOPATH)
 		paths = append(paths, gopaths...)
 	}
-	paths = append(paths, gospf.CodePaths...)
-	watcher = gospf.NewWatcher()
-	watcher.Listen(h, paths...)
+	return append(paths, WatchPaths()...)
+}
 
-	go func() {
-		addr := fmt.Sprintf("%s:%d", gospf.HttpAddr, gospf.HttpPort)
-		gospf.INFO.Printf("Listening on %s", addr)
+// viewsPaths returns the views directories of the code paths and the modules
+// of the workspace, whose templates the app loads.
+func viewsPaths() []string {
+	var paths []string
+	for _, dir := range SourcePaths() {
+		paths = append(paths, filepath.Join(dir, "views"))
+	}
+	return paths
+}
 
-		var err error
-		if gospf.HttpSsl {
-			err = http.ListenAndServeTLS(addr, gospf.HttpSslCert,
-				gospf.HttpSslKey, h)
-		} else {
-			err = http.ListenAndServe(addr, h)
-		}
-		if err != nil {
-			gospf.ERROR.Fatalln("Failed to start reverse proxy:", err)
+// Stop kills the app instances, including those handed off, and the asset
+// builders.
+func (h *Harness) Stop() {
+	h.kill()
+	h.killHandoffs()
+	h.assets.stopAll()
+	if h.access != nil {
+		h.access.close()
+	}
+	for _, b := range h.backends() {
+		if b.socket != "" {
+			os.Remove(b.socket)
 		}
-	}()
+		b.mu.Lock()
+		b.closeListener()
+		b.mu.Unlock()
+	}
+}
 
-	// Kill the app on signal.
-	ch := make(chan os.Signal)
-	signal.Notify(ch, os.Interrupt, os.Kill)
-	<-ch
-	if h.app != nil {
-		h.app.Kill()
+// sourceChanges records the changes to the source, for the harness to tell
+// them from the retries of a failed build.  It must listen before the harness.
+type sourceChanges struct {
+	harness *Harness
+}
+
+func (c sourceChanges) Refresh() *gospf.Error {
+	atomic.StoreInt32(&c.harness.changed, 1)
+	return nil
+}
+
+func (c sourceChanges) WatchDir(info os.FileInfo) bool {
+	return c.harness.WatchDir(info)
+}
+
+func (c sourceChanges) WatchDirPath(dir string) bool {
+	return c.harness.WatchDirPath(dir)
+}
+
+func (c sourceChanges) WatchFile(filename string) bool {
+	return c.harness.WatchFile(filename)
+}
+
+// directNotifyInterval is how often the source is checked for changes
+// without the proxy, whose requests otherwise trigger the rebuilds.
+const directNotifyInterval = 500 * time.Millisecond
+
+// watchDirect runs the app on the public port, and rebuilds and restarts it
+// as soon as its source changes.  The app is down while it restarts, and
+// keeps running the previous build if the rebuild fails.
+func (h *Harness) watchDirect() {
+	gospf.INFO.Printf("Running the app on port %d, without the proxy", gospf.HttpPort)
+	for {
+		// Build errors are printed to the console by Build.
+		h.builds.join(h.rebuild).wait()
+		time.Sleep(directNotifyInterval)
 	}
-	os.Exit(1)
 }
 
-// Find an unused port
+// Find an unused port, from the range of harness.port.min and
+// harness.port.max if set.
 func getFreePort() (port int) {
+	if min, max := portRange(); max != 0 {
+		portsMu.Lock()
+		defer portsMu.Unlock()
+		port, err := freePortInRange(min, max, portsTaken)
+		if err != nil {
+			gospf.ERROR.Fatalf("No free port for the app with harness.port.min and harness.port.max: %s", err)
+		}
+		return port
+	}
+
 	conn, err := net.Listen("tcp", ":0")
 	if err != nil {
 		gospf.ERROR.Fatal(err)
@@ -203,11 +1157,11 @@ func getFreePort() (port int) {
 
 // proxyWebsocket copies data between websocket client and server until one side
 // closes the connection.  (ReverseProxy doesn't work with websocket requests.)
-func proxyWebsocket(w http.ResponseWriter, r *http.Request, host string) {
-	d, err := net.Dial("tcp", host)
+func proxyWebsocket(w http.ResponseWriter, r *http.Request, network, address string) {
+	d, err := net.Dial(network, address)
 	if err != nil {
 		http.Error(w, "Error contacting backend server.", 500)
-		gospf.ERROR.Printf("Error dialing websocket backend %s: %v", host, err)
+		gospf.ERROR.Printf("Error dialing websocket backend %s: %v", address, err)
 		return
 	}
 	hj, ok := w.(http.Hijacker)
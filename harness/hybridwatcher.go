@@ -0,0 +1,95 @@
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// hybridWatcher detects changes by comparing snapshots of the watched files,
+// like pollWatcher, and uses filesystem events to take a new snapshot as soon
+// as something changes, rather than at the next poll.
+//
+// It is the default on macOS and Windows, where events alone lead to stale
+// builds: FSEvents delivers them late and coalesced, and Windows reports the
+// rename that completes an atomic save (an editor writing a temporary file,
+// then renaming it over the source file) under the temporary name only.
+// Since the snapshot is the source of truth, a missed or misnamed event
+// delays a rebuild until the next poll at worst.
+type hybridWatcher struct {
+	*pollWatcher
+	events sourceWatcher
+}
+
+func newHybridWatcher(events sourceWatcher, interval time.Duration) *hybridWatcher {
+	return &hybridWatcher{newPollWatcher(interval), events}
+}
+
+// Listen watches the given roots for changes relevant to the listener.
+func (w *hybridWatcher) Listen(listener gospf.Listener, roots ...string) {
+	l := w.pollWatcher.listen(listener, roots)
+	w.events.Listen(eventTrigger{l}, roots...)
+}
+
+// Notify refreshes the listeners whose files changed since the last call.
+func (w *hybridWatcher) Notify() *gospf.Error {
+	// The triggers never fail.
+	w.events.Notify()
+	return w.pollWatcher.Notify()
+}
+
+// eventTrigger marks a pollListener as changed when the event watcher has
+// events for it, even if its snapshot looks the same, since the modification
+// times of some filesystems are too coarse to tell quick saves apart.
+type eventTrigger struct {
+	l *pollListener
+}
+
+func (t eventTrigger) Refresh() *gospf.Error {
+	t.l.poll()
+	t.l.mu.Lock()
+	t.l.changed = true
+	t.l.mu.Unlock()
+	return nil
+}
+
+func (t eventTrigger) WatchDir(info os.FileInfo) bool {
+	if discerning, ok := t.l.listener.(gospf.DiscerningListener); ok {
+		return discerning.WatchDir(info)
+	}
+	return true
+}
+
+// WatchFile also takes the temporary files of atomic saves for the source
+// files they replace, since their renames may be the only events for them.
+func (t eventTrigger) WatchFile(filename string) bool {
+	if discerning, ok := t.l.listener.(gospf.DiscerningListener); ok {
+		return discerning.WatchFile(filename) || discerning.WatchFile(saveTargetFile(filename))
+	}
+	return true
+}
+
+// saveTempSuffixes are the suffixes editors add to the name of a source file
+// for the temporary and backup files of an atomic save.
+var saveTempSuffixes = []string{
+	"~",            // Vim and Emacs backups.
+	".tmp",         // Many editors, e.g. VS Code on some filesystems.
+	"___jb_tmp___", // JetBrains IDEs.
+	"___jb_old___", // JetBrains IDEs.
+}
+
+// saveTargetFile returns the name of the source file that the given
+// temporary file of an atomic save replaces, e.g. "app.go" for "app.go~", or
+// the name itself if it is not such a file.
+func saveTargetFile(filename string) string {
+	dir, name := filepath.Split(filename)
+	for _, suffix := range saveTempSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return dir + strings.TrimSuffix(name, suffix)
+		}
+	}
+	return filename
+}
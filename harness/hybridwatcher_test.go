@@ -0,0 +1,100 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// fakeEvents delivers the events it is given on the next Notify, as
+// eventWatcher does.
+type fakeEvents struct {
+	listeners []gospf.DiscerningListener
+	pending   []string
+}
+
+func (w *fakeEvents) Listen(listener gospf.Listener, roots ...string) {
+	w.listeners = append(w.listeners, listener.(gospf.DiscerningListener))
+}
+
+func (w *fakeEvents) Notify() *gospf.Error {
+	for _, l := range w.listeners {
+		for _, filename := range w.pending {
+			if l.WatchFile(filename) {
+				l.Refresh()
+				break
+			}
+		}
+	}
+	w.pending = nil
+	return nil
+}
+
+func TestHybridWatcherAtomicSave(t *testing.T) {
+	root, err := ioutil.TempDir("", "hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	filename := filepath.Join(root, "app.go")
+	ioutil.WriteFile(filename, []byte("package app // 1"), 0666)
+	info, _ := os.Stat(filename)
+
+	listener := &countingListener{}
+	events := &fakeEvents{}
+	// Poll rarely, to tell the changes found through events apart.
+	watcher := newHybridWatcher(events, time.Hour)
+	watcher.Listen(listener, root)
+
+	notify := func(expected int, event string) {
+		if err := watcher.Notify(); err != nil {
+			t.Fatal(err)
+		}
+		if listener.refreshes != expected {
+			t.Errorf("Expected %d refreshes %s, got %d", expected, event, listener.refreshes)
+		}
+	}
+
+	notify(1, "initially")
+	notify(1, "without changes")
+
+	// Save atomically, keeping the size and modification time, with the
+	// rename only reported under the temporary name.
+	tmpName := filename + "___jb_tmp___"
+	ioutil.WriteFile(tmpName, []byte("package app // 2"), 0666)
+	os.Chtimes(tmpName, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmpName, filename); err != nil {
+		t.Fatal(err)
+	}
+	events.pending = []string{tmpName}
+	notify(2, "after an atomic save")
+
+	// Missed events are caught at the next poll.
+	ioutil.WriteFile(filename, []byte("package app // changed"), 0666)
+	watcher.listeners[0].poll()
+	notify(3, "after a change without events")
+
+	events.pending = []string{filepath.Join(root, "notes.txt")}
+	notify(3, "after an event for an ignored file")
+}
+
+func TestSaveTargetFile(t *testing.T) {
+	for name, expected := range map[string]string{
+		"app/app.go":              "app/app.go",
+		"app/app.go~":             "app/app.go",
+		"app/app.go.tmp":          "app/app.go",
+		"app/app.go___jb_old___":  "app/app.go",
+		"app/notes.txt~":          "app/notes.txt",
+		"app/~":                   "app/~",
+		"app/views/index.html.tm": "app/views/index.html.tm",
+	} {
+		if target := saveTargetFile(name); target != expected {
+			t.Errorf("saveTargetFile(%q) = %q, expected %q", name, target, expected)
+		}
+	}
+}
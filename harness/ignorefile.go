@@ -0,0 +1,121 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An app may also list the files and directories that the harness ignores in
+// a .gospfignore file at its root, in the syntax of .gitignore, e.g.
+//
+//	# Generated code and fixtures
+//	*_gen.go
+//	/app/models/fixtures/
+//	public/vendor/*
+//	!public/vendor/gospf.js
+//
+// A pattern without a slash matches a name at any depth, and one with a
+// slash, e.g. a leading one, matches the path relative to the app's root.  A
+// trailing slash matches directories only, and a leading "!" includes again
+// what an earlier pattern ignores.  As with git, a file can't be included
+// again if its directory is ignored: "public/vendor/" would ignore gospf.js
+// too, where "public/vendor/*" only ignores what is in the directory.  The rules are merged with those of
+// watch.ignore and the directories that are never watched, and are read when
+// the harness starts.
+
+// ignoreFileName is the name of the ignore file at the root of the app.
+const ignoreFileName = ".gospfignore"
+
+// ignoreFile is the rules of an ignore file, relative to its directory.
+type ignoreFile struct {
+	root  string
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern string // A glob for MatchGlob.
+	negate  bool   // Whether the rule includes what it matches again.
+	dirOnly bool   // Whether the rule only matches directories.
+}
+
+// loadIgnoreFile returns the rules of the ignore file in the directory, or nil
+// if there is none.
+func loadIgnoreFile(root string) *ignoreFile {
+	if root == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, ignoreFileName))
+	if err != nil {
+		return nil
+	}
+	return &ignoreFile{root: root, rules: parseIgnoreRules(string(data))}
+}
+
+// parseIgnoreRules parses the lines of an ignore file, skipping the blank
+// lines and the comments.
+func parseIgnoreRules(data string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignores reports whether the file or directory is ignored, by itself or by
+// one of the directories it is in.
+func (f *ignoreFile) ignores(path string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return false
+	}
+	elements := strings.Split(filepath.ToSlash(rel), "/")
+	for i := 1; i < len(elements); i++ {
+		if f.match(strings.Join(elements[:i], "/"), true) {
+			return true
+		}
+	}
+	return f.match(filepath.ToSlash(rel), isDir)
+}
+
+// match returns whether the last rule that matches the path ignores it.
+func (f *ignoreFile) match(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range f.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if MatchGlob(rule.pattern, rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
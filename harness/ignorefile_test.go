@@ -0,0 +1,68 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const ignoreFileSource = `# Generated code and fixtures
+*_gen.go
+/app/models/fixtures/
+public/vendor/*
+!public/vendor/gospf.js
+build/
+\#notes.go
+`
+
+func TestIgnoreFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "ignorefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if loadIgnoreFile(root) != nil {
+		t.Error("Expected no rules without an ignore file")
+	}
+	ioutil.WriteFile(filepath.Join(root, ignoreFileName), []byte(ignoreFileSource), 0666)
+	f := loadIgnoreFile(root)
+	if f == nil || len(f.rules) != 6 {
+		t.Fatalf("Expected 6 rules, got %v", f)
+	}
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"app/models/user_gen.go", false, true},
+		{"user_gen.go", false, true},
+		{"app/models/user.go", false, false},
+		{"app/models/fixtures", true, true},
+		{"app/models/fixtures/users.go", false, true},
+		{"lib/app/models/fixtures", true, false},
+		{"public/vendor/jquery.js", false, true},
+		{"public/vendor/lib", true, true},
+		{"public/vendor/gospf.js", false, false},
+		{"build", false, false},
+		{"assets/build", true, true},
+		{"#notes.go", false, true},
+		{"../other/user_gen.go", false, false},
+	}
+	for _, test := range tests {
+		path := filepath.Join(root, filepath.FromSlash(test.path))
+		if actual := f.ignores(path, test.isDir); actual != test.expected {
+			t.Errorf("Expected ignores(%q, %v) to be %v", test.path, test.isDir, test.expected)
+		}
+	}
+
+	f.rules = parseIgnoreRules("*.go\n!main.go\n")
+	if f.ignores(filepath.Join(root, "app", "main.go"), false) || !f.ignores(filepath.Join(root, "app", "init.go"), false) {
+		t.Error("Expected the later rule to include main.go again")
+	}
+	f.rules = parseIgnoreRules("public/vendor/\n!public/vendor/gospf.js\n")
+	if !f.ignores(filepath.Join(root, "public", "vendor", "gospf.js"), false) {
+		t.Error("Expected gospf.js to stay ignored with its directory")
+	}
+}
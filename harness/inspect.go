@@ -0,0 +1,125 @@
+package harness
+
+import "sort"
+
+// SourceDescription is the structure of the app's source, as "gospf inspect"
+// prints it for the tools that read it, e.g. the autocompletion of an editor.
+// The paths of the files are relative to the app's root when under it.
+type SourceDescription struct {
+	Controllers    []TypeDescription        `json:"controllers"`
+	Interceptors   []InterceptorDescription `json:"interceptors"` // The functions that intercept all the controllers.
+	ValidationKeys []ValidationKey          `json:"validationKeys"`
+	TestSuites     []TypeDescription        `json:"testSuites"`
+	Jobs           []TypeDescription        `json:"jobs"`
+}
+
+// TypeDescription describes a controller, a test suite or a job.
+type TypeDescription struct {
+	Name         string                   `json:"name"` // e.g. "App"
+	ImportPath   string                   `json:"importPath"`
+	Package      string                   `json:"package"`
+	Actions      []ActionDescription      `json:"actions,omitempty"`
+	Interceptors []InterceptorDescription `json:"interceptors,omitempty"`
+}
+
+// ActionDescription describes an action of a controller.
+type ActionDescription struct {
+	Name       string           `json:"name"` // e.g. "Index"
+	File       string           `json:"file"`
+	Line       int              `json:"line"`
+	Args       []ArgDescription `json:"args"`
+	RenderArgs []string         `json:"renderArgs"` // The names passed to c.Render, in order.
+	Deprecated string           `json:"deprecated,omitempty"`
+}
+
+// ArgDescription describes an argument of an action.
+type ArgDescription struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"` // e.g. "*models.User"
+	ImportPath string `json:"importPath,omitempty"`
+}
+
+// InterceptorDescription describes an interceptor method or function.
+type InterceptorDescription struct {
+	Name       string `json:"name"` // "Before", "After", "Panic" or "Finally"
+	ImportPath string `json:"importPath"`
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+}
+
+// ValidationKey is the name of the value validated on a line of a function.
+type ValidationKey struct {
+	Function string `json:"function"` // e.g. "myapp/app/controllers.(*App).Save"
+	Line     int    `json:"line"`
+	Key      string `json:"key"` // e.g. "user.Name"
+}
+
+// DescribeSource returns the description of the processed source, in the
+// order of the generated code.
+func DescribeSource(sourceInfo *SourceInfo) *SourceDescription {
+	description := &SourceDescription{
+		Controllers:    describeTypes(sourceInfo.ControllerSpecs()),
+		Interceptors:   describeInterceptors(sourceInfo.InterceptorFuncs),
+		ValidationKeys: []ValidationKey{},
+		TestSuites:     describeTypes(sourceInfo.TestSuites()),
+		Jobs:           describeTypes(sourceInfo.JobSpecs),
+	}
+	for function, keys := range sourceInfo.ValidationKeys {
+		for line, key := range keys {
+			description.ValidationKeys = append(description.ValidationKeys, ValidationKey{function, line, key})
+		}
+	}
+	sort.Slice(description.ValidationKeys, func(i, j int) bool {
+		a, b := description.ValidationKeys[i], description.ValidationKeys[j]
+		if a.Function != b.Function {
+			return a.Function < b.Function
+		}
+		return a.Line < b.Line
+	})
+	return description
+}
+
+func describeTypes(specs []*TypeInfo) []TypeDescription {
+	descriptions := make([]TypeDescription, 0, len(specs))
+	for _, spec := range specs {
+		description := TypeDescription{
+			Name:         spec.StructName,
+			ImportPath:   spec.ImportPath,
+			Package:      spec.PackageName,
+			Interceptors: describeInterceptors(spec.Interceptors),
+		}
+		for _, method := range spec.MethodSpecs {
+			action := ActionDescription{
+				Name:       method.Name,
+				File:       displayPath(method.File),
+				Line:       method.Line,
+				Args:       []ArgDescription{},
+				RenderArgs: []string{},
+				Deprecated: method.Deprecated,
+			}
+			for _, arg := range method.Args {
+				action.Args = append(action.Args, ArgDescription{arg.Name, arg.TypeExpr.TypeName(""), arg.ImportPath})
+			}
+			seen := make(map[string]bool)
+			for _, call := range method.RenderCalls {
+				for _, name := range call.Names {
+					if !seen[name] {
+						seen[name] = true
+						action.RenderArgs = append(action.RenderArgs, name)
+					}
+				}
+			}
+			description.Actions = append(description.Actions, action)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+func describeInterceptors(specs []*InterceptorSpec) []InterceptorDescription {
+	descriptions := make([]InterceptorDescription, 0, len(specs))
+	for _, spec := range specs {
+		descriptions = append(descriptions, InterceptorDescription{spec.Name, spec.ImportPath, displayPath(spec.File), spec.Line})
+	}
+	return descriptions
+}
@@ -0,0 +1,53 @@
+package harness
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDescribeSource(t *testing.T) {
+	sourceInfo := &SourceInfo{
+		ValidationKeys: map[string]map[int]string{
+			"myapp/app/controllers.(*App).Save": {32: "user.Name", 30: "user.Email"},
+			"myapp/app/models.(*User).Validate": {12: "user.Password"},
+		},
+		controllerSpecs: []*TypeInfo{{
+			StructName:  "App",
+			ImportPath:  "myapp/app/controllers",
+			PackageName: "controllers",
+			MethodSpecs: []*MethodSpec{{
+				Name: "Save",
+				Args: []*MethodArg{
+					{Name: "id", TypeExpr: TypeExpr{Expr: "int", Valid: true}},
+					{Name: "user", TypeExpr: TypeExpr{Expr: "*User", PkgName: "models", pkgIndex: 1, Valid: true}, ImportPath: "myapp/app/models"},
+				},
+				RenderCalls: []*methodCall{{Names: []string{"user", "id"}}, {Names: []string{"user"}}},
+				File:        "app.go",
+				Line:        28,
+				Deprecated:  "Use Update.",
+			}},
+			Interceptors: []*InterceptorSpec{{Name: "Before", ImportPath: "myapp/app/controllers", File: "app.go", Line: 10}},
+		}},
+		testSuites: []*TypeInfo{{StructName: "AppTest", ImportPath: "myapp/tests", PackageName: "tests"}},
+	}
+
+	encoded, err := json.Marshal(DescribeSource(sourceInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"controllers":[{"name":"App","importPath":"myapp/app/controllers","package":"controllers",` +
+		`"actions":[{"name":"Save","file":"app.go","line":28,` +
+		`"args":[{"name":"id","type":"int"},{"name":"user","type":"*models.User","importPath":"myapp/app/models"}],` +
+		`"renderArgs":["user","id"],"deprecated":"Use Update."}],` +
+		`"interceptors":[{"name":"Before","importPath":"myapp/app/controllers","file":"app.go","line":10}]}],` +
+		`"interceptors":[],` +
+		`"validationKeys":[{"function":"myapp/app/controllers.(*App).Save","line":30,"key":"user.Email"},` +
+		`{"function":"myapp/app/controllers.(*App).Save","line":32,"key":"user.Name"},` +
+		`{"function":"myapp/app/models.(*User).Validate","line":12,"key":"user.Password"}],` +
+		`"testSuites":[{"name":"AppTest","importPath":"myapp/tests","package":"tests"}],` +
+		`"jobs":[]}`
+	if string(encoded) != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", strings.Replace(expected, "},{", "},\n{", -1), strings.Replace(string(encoded), "},{", "},\n{", -1))
+	}
+}
@@ -0,0 +1,130 @@
+package harness
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// Interactive, if set, gives the app the input of the console, for it to
+// prompt for a passphrase or credentials on startup.  On Unix, the app then
+// runs in the process group of gospf, since a process in the background is
+// stopped when it reads the terminal.
+var Interactive bool
+
+// promptIdle is how long the output of the app must end with an unfinished
+// line before it is taken for a prompt.
+const promptIdle = time.Second
+
+// maxPromptSize bounds the unfinished line that is kept.
+const maxPromptSize = 1 << 10
+
+// promptWriter keeps the last unfinished line of the output of the app while
+// it starts, which is a prompt if the app stops writing after it.
+type promptWriter struct {
+	mu      sync.Mutex
+	line    []byte
+	written time.Time
+	started bool // Set once the app is ready, after which there are no prompts.
+}
+
+func (w *promptWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return len(p), nil
+	}
+	if i := bytes.LastIndexAny(p, "\r\n"); i >= 0 {
+		w.line = append(w.line[:0], p[i+1:]...)
+	} else {
+		w.line = append(w.line, p...)
+	}
+	if len(w.line) > maxPromptSize {
+		w.line = append([]byte(nil), w.line[len(w.line)-maxPromptSize:]...)
+	}
+	w.written = time.Now()
+	return len(p), nil
+}
+
+// prompt returns the unfinished last line of the output, e.g. "Passphrase: ",
+// if the app has not written since for promptIdle, or "" if it does not seem
+// to wait for input.
+func (w *promptWriter) prompt() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started || len(bytes.TrimSpace(w.line)) == 0 || time.Since(w.written) < promptIdle {
+		return ""
+	}
+	return string(w.line)
+}
+
+// start records that the app is ready.
+func (w *promptWriter) start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.started = true
+	w.line = nil
+}
+
+// Prompt returns the prompt that the last app command returned is waiting on,
+// as it starts, or "" if there is none.
+func (a *App) Prompt() string {
+	if a.cmd.state == nil {
+		return ""
+	}
+	return a.cmd.state.prompts.prompt()
+}
+
+// reportPrompt tells the console that the app waits for input.
+func reportPrompt(prompt string) {
+	if Interactive {
+		gospf.WARN.Printf("The app is waiting for input on the console: %q", prompt)
+	} else {
+		gospf.WARN.Printf("The app may be waiting for input: %q.  Run it with -interactive to answer.", prompt)
+	}
+}
+
+// prompt returns the prompt that an app instance is waiting on as it starts,
+// or "" if there is none.
+func (hp *Harness) prompt() string {
+	for _, b := range hp.backends() {
+		if app := b.currentApp(); app != nil {
+			if prompt := app.Prompt(); prompt != "" {
+				return prompt
+			}
+		}
+	}
+	return ""
+}
+
+// renderPrompt serves the page telling that the app waits for input on the
+// console, which reloads itself until the app is started.
+func renderPrompt(w http.ResponseWriter, prompt string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	promptTemplate.Execute(w, map[string]interface{}{
+		"AppName":     gospf.AppName,
+		"Prompt":      prompt,
+		"Interactive": Interactive,
+	})
+}
+
+var promptTemplate = template.Must(template.New("prompt").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="refresh" content="1">
+<title>{{.AppName}} is waiting for input</title>
+</head>
+<body>
+<h1>{{.AppName}} is waiting for input</h1>
+<p>The app prompts for input on the console of gospf run{{if not .Interactive}}, which it is not given: run it with -interactive to answer{{end}}.  This page reloads when it is started.</p>
+<pre>{{.Prompt}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,33 @@
+package harness
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPromptWriter(t *testing.T) {
+	w := &promptWriter{}
+	w.Write([]byte("Loading the keys\nPass"))
+	w.Write([]byte("phrase: "))
+	if prompt := w.prompt(); prompt != "" {
+		t.Errorf("Expected no prompt while the app writes, got %q", prompt)
+	}
+
+	w.written = time.Now().Add(-promptIdle)
+	if prompt := w.prompt(); prompt != "Passphrase: " {
+		t.Errorf("Expected the prompt \"Passphrase: \", got %q", prompt)
+	}
+
+	w.Write([]byte("\nListening on :9000\n"))
+	w.written = time.Now().Add(-promptIdle)
+	if prompt := w.prompt(); prompt != "" {
+		t.Errorf("Expected no prompt after a finished line, got %q", prompt)
+	}
+
+	w.Write([]byte("Progress: 50%"))
+	w.start()
+	w.written = time.Now().Add(-promptIdle)
+	if prompt := w.prompt(); prompt != "" {
+		t.Errorf("Expected no prompt once the app is ready, got %q", prompt)
+	}
+}
@@ -0,0 +1,282 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// The mail preview, served in dev mode under /_gospf/mail, renders the app's
+// email templates in app/views/mail with sample data.  The sample data for a
+// template is read from the JSON file of the same name, e.g. welcome.json for
+// welcome.html and welcome.txt.
+//
+// With harness.mail.capture set, the harness also listens for SMTP on
+// harness.mail.addr (localhost:2525 by default), and the preview lists the
+// messages sent to it instead of delivering them.  Point the app's mailer at
+// that address in its dev configuration to use it.
+
+const mailPreviewPath = devPathPrefix + "mail"
+
+// maxCapturedMail is the number of captured messages kept.
+const maxCapturedMail = 100
+
+type mailPreview struct {
+	mu     sync.Mutex
+	outbox []*capturedMail // Oldest first.
+	nextID int
+}
+
+type capturedMail struct {
+	ID       int
+	Received time.Time
+	From     string
+	To       []string
+	Subject  string
+	Data     []byte
+}
+
+func newMailPreview() *mailPreview {
+	return &mailPreview{nextID: 1}
+}
+
+func (m *mailPreview) register(mux *http.ServeMux) {
+	mux.HandleFunc(mailPreviewPath, m.serveIndex)
+	mux.HandleFunc(mailPreviewPath+"/render", m.serveTemplate)
+	mux.HandleFunc(mailPreviewPath+"/outbox", m.serveMessage)
+}
+
+func (m *mailPreview) serveIndex(w http.ResponseWriter, r *http.Request) {
+	var templates []string
+	mailDir := filepath.Join(gospf.AppPath, "views", "mail")
+	filepath.Walk(mailDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Ext(path) != ".json" {
+			rel, _ := filepath.Rel(filepath.Dir(mailDir), path)
+			templates = append(templates, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	m.mu.Lock()
+	outbox := make([]*capturedMail, len(m.outbox))
+	for i, captured := range m.outbox {
+		outbox[len(outbox)-1-i] = captured
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mailIndexTemplate.Execute(w, map[string]interface{}{
+		"Path":      mailPreviewPath,
+		"Templates": templates,
+		"Outbox":    outbox,
+	})
+}
+
+// serveTemplate renders the template named by the "template" parameter,
+// e.g. "mail/welcome.html".
+func (m *mailPreview) serveTemplate(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("template")
+	if !strings.HasPrefix(name, "mail/") || strings.Contains(name, "..") {
+		http.NotFound(w, r)
+		return
+	}
+
+	loader := gospf.NewTemplateLoader([]string{filepath.Join(gospf.AppPath, "views")})
+	if err := loader.Refresh(); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	tmpl, err := loader.Template(name)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	samplePath := filepath.Join(gospf.AppPath, "views",
+		filepath.FromSlash(strings.TrimSuffix(name, filepath.Ext(name))+".json"))
+	if sample, err := ioutil.ReadFile(samplePath); err == nil {
+		if err = json.Unmarshal(sample, &data); err != nil {
+			renderError(w, r, err)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Render(&buf, data); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	if filepath.Ext(name) == ".html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(buf.Bytes())
+}
+
+// serveMessage shows the captured message with the ID given by the "id"
+// parameter, as it was sent.
+func (m *mailPreview) serveMessage(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, captured := range m.outbox {
+		if captured.ID == id {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(captured.Data)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (m *mailPreview) capture(captured *capturedMail) {
+	if msg, err := mail.ReadMessage(bytes.NewReader(captured.Data)); err == nil {
+		captured.Subject = msg.Header.Get("Subject")
+	}
+	captured.Received = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	captured.ID = m.nextID
+	m.nextID++
+	m.outbox = append(m.outbox, captured)
+	if len(m.outbox) > maxCapturedMail {
+		m.outbox = m.outbox[1:]
+	}
+	gospf.INFO.Printf("Captured mail %q from %s to %s", captured.Subject, captured.From, strings.Join(captured.To, ", "))
+}
+
+// listenSMTP captures the mail sent to the given address.
+func (m *mailPreview) listenSMTP(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		gospf.ERROR.Println("Failed to capture mail:", err)
+		return
+	}
+	gospf.INFO.Printf("Capturing mail sent to %s", addr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			gospf.ERROR.Println("Failed to capture mail:", err)
+			return
+		}
+		go m.serveSMTP(conn)
+	}
+}
+
+// serveSMTP speaks just enough SMTP to accept messages.  Any credentials
+// are accepted.
+func (m *mailPreview) serveSMTP(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 gospf mail capture")
+
+	captured := &capturedMail{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			text.PrintfLine("250 gospf")
+		case "EHLO":
+			text.PrintfLine("250-gospf")
+			text.PrintfLine("250 AUTH PLAIN LOGIN")
+		case "AUTH":
+			fields := strings.Fields(arg)
+			switch {
+			case len(fields) == 1 && strings.EqualFold(fields[0], "LOGIN"):
+				text.PrintfLine("334 VXNlcm5hbWU6")
+				text.ReadLine()
+				text.PrintfLine("334 UGFzc3dvcmQ6")
+				text.ReadLine()
+			case len(fields) == 1:
+				text.PrintfLine("334 ")
+				text.ReadLine()
+			}
+			text.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			captured = &capturedMail{From: smtpAddress(arg)}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			captured.To = append(captured.To, smtpAddress(arg))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			captured.Data = data
+			m.capture(captured)
+			captured = &capturedMail{}
+			text.PrintfLine("250 OK: captured")
+		case "RSET":
+			captured = &capturedMail{}
+			text.PrintfLine("250 OK")
+		case "NOOP":
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// smtpAddress returns the address in a MAIL or RCPT argument, e.g.
+// "a@example.com" for "FROM:<a@example.com> SIZE=100".
+func smtpAddress(arg string) string {
+	if i := strings.Index(arg, ":"); i >= 0 {
+		arg = strings.TrimSpace(arg[i+1:])
+	}
+	if i := strings.Index(arg, " "); i >= 0 {
+		arg = arg[:i]
+	}
+	return strings.Trim(arg, "<>")
+}
+
+var mailIndexTemplate = template.Must(template.New("mail").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mail preview</title></head>
+<body>
+<h1>Templates</h1>
+<ul>{{range .Templates}}
+<li><a href="{{$.Path}}/render?template={{.}}">{{.}}</a></li>{{else}}
+<li>No templates in app/views/mail.</li>{{end}}
+</ul>
+<h1>Outbox</h1>
+<table>
+<tr><th>Received</th><th>From</th><th>To</th><th>Subject</th></tr>{{range .Outbox}}
+<tr>
+<td>{{.Received.Format "15:04:05"}}</td>
+<td>{{.From}}</td>
+<td>{{range $i, $to := .To}}{{if $i}}, {{end}}{{$to}}{{end}}</td>
+<td><a href="{{$.Path}}/outbox?id={{.ID}}">{{.Subject}}</a></td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,44 @@
+package harness
+
+import (
+	"net"
+	"net/smtp"
+	"testing"
+)
+
+func TestCaptureMail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	preview := newMailPreview()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			preview.serveSMTP(conn)
+		}
+	}()
+
+	auth := smtp.PlainAuth("", "user", "password", "127.0.0.1")
+	msg := []byte("Subject: Welcome\r\n\r\nHello,\r\n.hidden dot\r\n")
+	err = smtp.SendMail(listener.Addr().String(), auth, "app@example.com", []string{"user@example.com"}, msg)
+	if err != nil {
+		t.Fatal("Failed to send mail:", err)
+	}
+
+	if len(preview.outbox) != 1 {
+		t.Fatalf("Expected 1 captured message, got %d", len(preview.outbox))
+	}
+	captured := preview.outbox[0]
+	if captured.From != "app@example.com" || len(captured.To) != 1 || captured.To[0] != "user@example.com" {
+		t.Errorf("Unexpected envelope: %s to %v", captured.From, captured.To)
+	}
+	if captured.Subject != "Welcome" {
+		t.Errorf("Expected subject Welcome, got %q", captured.Subject)
+	}
+	if expected := "Subject: Welcome\n\nHello,\n.hidden dot\n"; string(captured.Data) != expected {
+		t.Errorf("Expected %q, got %q", expected, captured.Data)
+	}
+}
@@ -0,0 +1,213 @@
+package harness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hubply/gospf"
+	"gopkg.in/yaml.v2"
+)
+
+// Several apps may be developed together behind one proxy, e.g. a suite of
+// services or micro-frontends, with "gospf run-multi".  Each app is run by
+// its own harness, which watches and rebuilds it independently, and the
+// proxy routes the requests to them by host or path prefix.  The apps are
+// listed in a YAML file, e.g.
+//
+//	port: 9000
+//	apps:
+//	  - name: shop
+//	    path: ./shop
+//	    prefix: /shop
+//	  - name: admin
+//	    path: github.com/acme/admin
+//	    mode: staging
+//	    host: admin.localhost
+//	  - name: www
+//	    path: ./www
+
+// MultiConfig lists the apps run together by RunMulti.
+type MultiConfig struct {
+	Addr string      `yaml:"addr"` // The address the proxy listens on, all interfaces if empty.
+	Port int         `yaml:"port"` // The port the proxy listens on, 9000 by default.
+	Apps []*MultiApp `yaml:"apps"`
+}
+
+// MultiApp is an app run by RunMulti.
+type MultiApp struct {
+	Name   string `yaml:"name"`   // Prefixes the output of the app.
+	Path   string `yaml:"path"`   // The import path or directory of the app.
+	Mode   string `yaml:"mode"`   // The run mode, "dev" by default.
+	Port   int    `yaml:"port"`   // The port of the app's harness, a free port by default.
+	Host   string `yaml:"host"`   // Routes the requests for the host name to the app, if set.
+	Prefix string `yaml:"prefix"` // Routes the requests under the path prefix to the app, if set.
+	Strip  bool   `yaml:"strip"`  // Strips the prefix from the paths of the requests.
+
+	proxy  *httputil.ReverseProxy
+	cmd    *exec.Cmd
+	exited chan struct{} // Closed when the harness of the app exits.
+}
+
+// defaultMultiPort is the port of the proxy of RunMulti, unless configured.
+const defaultMultiPort = 9000
+
+// LoadMultiConfig reads the apps to run together from the YAML file.
+func LoadMultiConfig(filename string) (*MultiConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := parseMultiConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return conf, nil
+}
+
+// parseMultiConfig parses the apps file.  Unknown keys are errors, as they
+// are likely typos.
+func parseMultiConfig(data []byte) (*MultiConfig, error) {
+	conf := &MultiConfig{Port: defaultMultiPort}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, err
+	}
+
+	if len(conf.Apps) == 0 {
+		return nil, fmt.Errorf("no apps listed")
+	}
+	for i, app := range conf.Apps {
+		if app == nil || app.Path == "" {
+			return nil, fmt.Errorf("app %d has no path", i+1)
+		}
+		if app.Name == "" {
+			app.Name = app.Path
+		}
+		if app.Mode == "" {
+			app.Mode = "dev"
+		}
+		if app.Prefix != "" {
+			app.Prefix = "/" + strings.Trim(app.Prefix, "/")
+		}
+	}
+	return conf, nil
+}
+
+// route returns the app that serves the request: the one for its host, or
+// else the one with the longest prefix of its path, or else the first app
+// without a host or a prefix.
+func (conf *MultiConfig) route(r *http.Request) *MultiApp {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var found, fallback *MultiApp
+	for _, app := range conf.Apps {
+		switch {
+		case app.Host != "":
+			if strings.EqualFold(app.Host, host) {
+				return app
+			}
+		case app.Prefix != "":
+			if (r.URL.Path == app.Prefix || strings.HasPrefix(r.URL.Path, app.Prefix+"/")) &&
+				(found == nil || len(app.Prefix) > len(found.Prefix)) {
+				found = app
+			}
+		case fallback == nil:
+			fallback = app
+		}
+	}
+	if found != nil {
+		return found
+	}
+	return fallback
+}
+
+func (conf *MultiConfig) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app := conf.route(r)
+	if app == nil {
+		http.Error(w, "No app serves "+r.Host+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	if app.Strip && app.Prefix != "" {
+		stripPathPrefix(r, app.Prefix)
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		proxyWebsocket(w, r, "tcp", fmt.Sprintf("localhost:%d", app.Port))
+		return
+	}
+	app.proxy.ServeHTTP(w, r)
+}
+
+// multiStopTimeout is how long RunMulti waits for the harnesses to stop their
+// apps on exit.
+const multiStopTimeout = 5 * time.Second
+
+// RunMulti runs each app with its own harness, started by running the
+// command with the arguments "run <path> <mode> <port>", and routes the
+// requests to them.  It never returns.
+func RunMulti(conf *MultiConfig, command string) {
+	for _, app := range conf.Apps {
+		if app.Port == 0 {
+			app.Port = getFreePort()
+		}
+		appUrl, _ := url.Parse(fmt.Sprintf("http://localhost:%d", app.Port))
+		app.proxy = httputil.NewSingleHostReverseProxy(appUrl)
+		name := app.Name
+		app.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, fmt.Sprintf("The harness of %s is not up yet: %s", name, err), http.StatusBadGateway)
+		}
+
+		app.cmd = exec.Command(command, "run", app.Path, app.Mode, strconv.Itoa(app.Port))
+		app.cmd.Stdout = &prefixWriter{w: os.Stdout, prefix: "[" + app.Name + "] "}
+		app.cmd.Stderr = &prefixWriter{w: os.Stderr, prefix: "[" + app.Name + "] "}
+		gospf.INFO.Printf("Running %s on port %d", app.Name, app.Port)
+		if err := app.cmd.Start(); err != nil {
+			gospf.ERROR.Fatalf("Failed to run %s: %s", app.Name, err)
+		}
+		app.exited = make(chan struct{})
+		go func(app *MultiApp) {
+			if err := app.cmd.Wait(); err != nil {
+				gospf.ERROR.Printf("%s exited: %s", app.Name, err)
+			}
+			close(app.exited)
+		}(app)
+	}
+
+	go func() {
+		addr := fmt.Sprintf("%s:%d", conf.Addr, conf.Port)
+		gospf.INFO.Printf("Listening on %s", addr)
+		server := &http.Server{Addr: addr, Handler: conf}
+		if err := server.ListenAndServe(); err != nil {
+			gospf.ERROR.Fatalln("Failed to start the proxy:", err)
+		}
+	}()
+
+	// Stop the harnesses on signal, which stop their apps.
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	<-ch
+	for _, app := range conf.Apps {
+		if err := app.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			app.cmd.Process.Kill()
+		}
+	}
+	timeout := time.After(multiStopTimeout)
+	for _, app := range conf.Apps {
+		select {
+		case <-app.exited:
+		case <-timeout:
+		}
+	}
+	os.Exit(1)
+}
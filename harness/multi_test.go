@@ -0,0 +1,73 @@
+package harness
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+const multiConfigSource = `
+# The apps of the shop.
+port: 8000
+apps:
+  - name: shop
+    path: ./shop
+    prefix: /shop/
+  - name: checkout
+    path: ./checkout
+    prefix: /shop/checkout  # Takes precedence over /shop.
+    strip: true
+  - name: admin
+    path: "github.com/acme/admin"
+    mode: staging
+    host: admin.localhost
+  - path: ./www
+`
+
+func TestParseMultiConfig(t *testing.T) {
+	conf, err := parseMultiConfig([]byte(multiConfigSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 8000 || len(conf.Apps) != 4 {
+		t.Fatalf("Expected 4 apps on port 8000, got %d on %d", len(conf.Apps), conf.Port)
+	}
+	admin := conf.Apps[2]
+	if admin.Path != "github.com/acme/admin" || admin.Mode != "staging" || admin.Host != "admin.localhost" {
+		t.Errorf("Unexpected admin app %+v", admin)
+	}
+	if www := conf.Apps[3]; www.Name != "./www" || www.Mode != "dev" {
+		t.Errorf("Expected the defaults of the www app, got %+v", www)
+	}
+	if !conf.Apps[1].Strip || conf.Apps[0].Prefix != "/shop" {
+		t.Errorf("Unexpected prefixes %+v %+v", conf.Apps[0], conf.Apps[1])
+	}
+
+	tests := []struct {
+		host, path, app string
+	}{
+		{"localhost:8000", "/shop", "shop"},
+		{"localhost:8000", "/shop/cart", "shop"},
+		{"localhost:8000", "/shop/checkout/pay", "checkout"},
+		{"admin.localhost:8000", "/shop", "github.com/acme/admin"},
+		{"localhost:8000", "/shopping", "./www"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://"+test.host+test.path, nil)
+		if app := conf.route(r); app == nil || app.Name != test.app && app.Path != test.app {
+			t.Errorf("Expected %s%s to be routed to %s, got %+v", test.host, test.path, test.app, app)
+		}
+	}
+}
+
+func TestParseMultiConfigErrors(t *testing.T) {
+	for _, source := range []string{
+		"apps:\n  - name: shop\n",
+		"- path: ./shop\n",
+		"apps:\n  - path: ./shop\n    color: blue\n",
+		"port: many\napps:\n  - path: ./shop\n",
+	} {
+		if _, err := parseMultiConfig([]byte(source)); err == nil {
+			t.Errorf("Expected an error parsing %q", source)
+		}
+	}
+}
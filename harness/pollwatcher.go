@@ -0,0 +1,208 @@
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// sourceWatcher notifies listeners of changes to the files they watch.  It
+// is implemented by eventWatcher, which relies on filesystem events, and by
+// pollWatcher.
+type sourceWatcher interface {
+	Listen(listener gospf.Listener, roots ...string)
+	Notify() *gospf.Error
+}
+
+// dirPathListener is a listener that tells the directories to watch by their
+// path, which pollWatcher asks rather than WatchDir.
+type dirPathListener interface {
+	WatchDirPath(dir string) bool
+}
+
+// defaultPollInterval is how often the files are checked with
+// watch.mode=poll, unless watch.poll.interval says otherwise.
+const defaultPollInterval = 1 * time.Second
+
+// WatchMode returns the watch.mode of the app, or the default of the
+// platform.
+func WatchMode() string {
+	return gospf.Config.StringDefault("watch.mode", defaultWatchMode)
+}
+
+// newSourceWatcher returns the watcher selected by watch.mode: "poll" for a
+// pollWatcher, for filesystems that do not deliver events (such as network
+// filesystems and some Docker volume mounts), "hybrid" for a hybridWatcher,
+// or "events" for an eventWatcher.  The default depends on the platform.
+func newSourceWatcher() sourceWatcher {
+	mode := WatchMode()
+	switch mode {
+	case "poll", "hybrid":
+	case "events":
+		return newEventWatcher()
+	default:
+		gospf.WARN.Printf("Unknown watch.mode %q, using %q", mode, defaultWatchMode)
+		if mode = defaultWatchMode; mode == "events" {
+			return newEventWatcher()
+		}
+	}
+
+	interval := defaultPollInterval
+	if value, found := gospf.Config.String("watch.poll.interval"); found {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			gospf.WARN.Printf("Invalid watch.poll.interval %q, using %s", value, defaultPollInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	if mode == "hybrid" {
+		return newHybridWatcher(newEventWatcher(), interval)
+	}
+	gospf.INFO.Printf("Polling for changes every %s", interval)
+	return newPollWatcher(interval)
+}
+
+// pollWatcher detects changes by periodically comparing the modification
+// times and sizes of the watched files.  Like eventWatcher, it refreshes
+// every listener on the first Notify, and a listener whose refresh failed
+// on every Notify until it succeeds.
+type pollWatcher struct {
+	interval time.Duration
+
+	mu           sync.Mutex // Serializes Notify.
+	listeners    []*pollListener
+	forceRefresh bool
+	lastError    int // The index of the listener whose refresh failed, or -1.
+}
+
+type pollListener struct {
+	listener gospf.Listener
+	roots    []string
+
+	mu       sync.Mutex // Protects snapshot and changed.
+	snapshot map[string]fileStamp
+	changed  bool
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	return &pollWatcher{
+		interval:     interval,
+		forceRefresh: true,
+		lastError:    -1,
+	}
+}
+
+// Listen starts polling the given roots for changes relevant to the listener.
+func (w *pollWatcher) Listen(listener gospf.Listener, roots ...string) {
+	w.listen(listener, roots)
+}
+
+func (w *pollWatcher) listen(listener gospf.Listener, roots []string) *pollListener {
+	l := &pollListener{listener: listener, roots: roots}
+	l.snapshot = l.scan()
+
+	w.mu.Lock()
+	w.listeners = append(w.listeners, l)
+	w.mu.Unlock()
+
+	go func() {
+		for range time.Tick(w.interval) {
+			l.poll()
+		}
+	}()
+	return l
+}
+
+// Notify refreshes the listeners whose files changed since the last call.
+func (w *pollWatcher) Notify() *gospf.Error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, l := range w.listeners {
+		l.mu.Lock()
+		changed := l.changed
+		l.changed = false
+		l.mu.Unlock()
+
+		if w.forceRefresh || changed || w.lastError == i {
+			if err := l.listener.Refresh(); err != nil {
+				w.lastError = i
+				return err
+			}
+		}
+	}
+
+	w.forceRefresh = false
+	w.lastError = -1
+	return nil
+}
+
+// poll marks the listener as changed if its files differ from the snapshot.
+func (l *pollListener) poll() {
+	snapshot := l.scan()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !sameSnapshot(snapshot, l.snapshot) {
+		l.snapshot = snapshot
+		l.changed = true
+	}
+}
+
+// scan returns the stamps of the files under the roots that the listener
+// watches.
+func (l *pollListener) scan() map[string]fileStamp {
+	discerning, _ := l.listener.(gospf.DiscerningListener)
+	pathListener, _ := l.listener.(dirPathListener)
+	snapshot := make(map[string]fileStamp)
+	for _, root := range l.roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if pathListener != nil {
+					if !pathListener.WatchDirPath(path) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if discerning != nil && !discerning.WatchDir(info) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(info.Name(), ".") {
+				return nil
+			}
+			if discerning != nil && !discerning.WatchFile(path) {
+				return nil
+			}
+			snapshot[path] = fileStamp{info.ModTime(), info.Size()}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
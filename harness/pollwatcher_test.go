@@ -0,0 +1,64 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+type countingListener struct {
+	refreshes int
+}
+
+func (l *countingListener) Refresh() *gospf.Error {
+	l.refreshes++
+	return nil
+}
+
+func (l *countingListener) WatchDir(info os.FileInfo) bool {
+	return info.Name() != "tmp"
+}
+
+func (l *countingListener) WatchFile(filename string) bool {
+	return strings.HasSuffix(filename, ".go")
+}
+
+func TestPollWatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "poll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.Mkdir(filepath.Join(root, "tmp"), 0777)
+
+	listener := &countingListener{}
+	watcher := newPollWatcher(10 * time.Millisecond)
+	watcher.Listen(listener, root)
+
+	notify := func(expected int, event string) {
+		time.Sleep(50 * time.Millisecond)
+		if err := watcher.Notify(); err != nil {
+			t.Fatal(err)
+		}
+		if listener.refreshes != expected {
+			t.Errorf("Expected %d refreshes %s, got %d", expected, event, listener.refreshes)
+		}
+	}
+
+	notify(1, "initially")
+	notify(1, "without changes")
+	ioutil.WriteFile(filepath.Join(root, "tmp", "main.go"), []byte("package main"), 0666)
+	ioutil.WriteFile(filepath.Join(root, "notes.txt"), []byte("notes"), 0666)
+	notify(1, "after changing ignored files")
+	ioutil.WriteFile(filepath.Join(root, "app.go"), []byte("package app"), 0666)
+	notify(2, "after adding a file")
+	ioutil.WriteFile(filepath.Join(root, "app.go"), []byte("package app // changed"), 0666)
+	notify(3, "after changing a file")
+	os.Remove(filepath.Join(root, "app.go"))
+	notify(4, "after removing a file")
+}
@@ -0,0 +1,116 @@
+package harness
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/hubply/gospf"
+)
+
+// The app instances listen on free ports, picked by the system unless
+// harness.port.min and harness.port.max set the range they are picked from,
+// e.g. the ports a firewall or a container lets through.
+//
+// A free port is only free until another process listens on it, which may
+// happen between the harness picking it and the app listening on it.  With
+// harness.port.handoff=true, the harness keeps listening on the port instead,
+// and hands the listener to the app instances, which serve it: the app gets
+// it as its file descriptor 3, with -listenerFd=3.  The port is then reserved
+// for as long as the harness runs, across the restarts of the app.  This is
+// not supported on Windows.
+
+var (
+	portsMu    sync.Mutex
+	portsTaken = make(map[int]bool) // The ports picked from the range so far.
+)
+
+// portRange returns the range set by harness.port.min and harness.port.max,
+// or zeros if it is not set.
+func portRange() (min, max int) {
+	if gospf.Config == nil {
+		return 0, 0
+	}
+	min = gospf.Config.IntDefault("harness.port.min", 0)
+	max = gospf.Config.IntDefault("harness.port.max", 0)
+	if min != 0 && max == 0 {
+		max = 65535
+	}
+	if max != 0 && min == 0 {
+		min = 1024
+	}
+	return min, max
+}
+
+// listenerHandoff reports whether the harness hands the listeners of their
+// ports to the app instances, with harness.port.handoff set.
+func listenerHandoff() bool {
+	return runtime.GOOS != "windows" && gospf.Config != nil && gospf.Config.BoolDefault("harness.port.handoff", false)
+}
+
+// freePortInRange returns a port of the range that is free, starting from a
+// random one, so that harnesses sharing the range rarely race for the same
+// port.  The ports already taken are tried last, since they may be in use by
+// the app instances this harness started.
+func freePortInRange(min, max int, taken map[int]bool) (int, error) {
+	listener, err := listenInRange("", min, max, taken)
+	if err != nil {
+		return 0, err
+	}
+	listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// listenInRange listens on a free port of the range on the host, as
+// freePortInRange picks it.
+func listenInRange(host string, min, max int, taken map[int]bool) (net.Listener, error) {
+	if min < 1 || max > 65535 || min > max {
+		return nil, fmt.Errorf("invalid port range %d-%d", min, max)
+	}
+	size := max - min + 1
+	start := rand.Intn(size)
+	for _, retake := range []bool{false, true} {
+		for i := 0; i < size; i++ {
+			port := min + (start+i)%size
+			if taken[port] != retake {
+				continue
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			if err != nil {
+				continue
+			}
+			taken[port] = true
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("all the ports from %d to %d are in use", min, max)
+}
+
+// reservePort listens on the port of the host for the app, or on a free port
+// if 0, picked from the range of harness.port.min and harness.port.max if set.
+// It returns the listener as a file to hand to the app, and its port.
+func reservePort(host string, port int) (*os.File, int, error) {
+	var listener net.Listener
+	var err error
+	if min, max := portRange(); port == 0 && max != 0 {
+		portsMu.Lock()
+		listener, err = listenInRange(host, min, max, portsTaken)
+		portsMu.Unlock()
+	} else {
+		listener, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// The file is a copy of the listener, which keeps listening once closed.
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,69 @@
+package harness
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestFreePortInRange(t *testing.T) {
+	// Find a range of free ports, and hold one of them.
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	held := listener.Addr().(*net.TCPAddr).Port
+	min, max := held, held+1
+	if max > 65535 {
+		min, max = held-1, held
+	}
+
+	taken := make(map[int]bool)
+	port, err := freePortInRange(min, max, taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port == held || port < min || port > max {
+		t.Errorf("Expected the free port of %d-%d, got %d", min, max, port)
+	}
+	if !taken[port] {
+		t.Errorf("Expected port %d to be taken", port)
+	}
+
+	// The taken port is picked again once the range is exhausted.
+	if again, err := freePortInRange(min, max, taken); err != nil || again != port {
+		t.Errorf("Expected port %d again, got %d: %v", port, again, err)
+	}
+
+	if _, err := freePortInRange(held, held, taken); err == nil {
+		t.Errorf("Expected an error for the held port %d", held)
+	}
+	for _, r := range [][2]int{{0, 10}, {20, 10}, {65535, 65536}} {
+		if _, err := freePortInRange(r[0], r[1], taken); err == nil {
+			t.Errorf("Expected an error for %s", fmt.Sprint(r))
+		}
+	}
+}
+
+func TestReservePort(t *testing.T) {
+	file, port, err := reservePort("localhost", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// The port stays reserved once the listener it was taken from is closed.
+	if listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port)); err == nil {
+		listener.Close()
+		t.Errorf("Expected port %d to be reserved", port)
+	}
+	listener, err := net.FileListener(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if actual := listener.Addr().(*net.TCPAddr).Port; actual != port {
+		t.Errorf("Expected the listener of port %d, got %d", port, actual)
+	}
+}
@@ -0,0 +1,155 @@
+package harness
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// A build profile is a named set of build settings, in a section of app.conf,
+// which spares long command lines:
+//
+//	[build.profile.fastdev]
+//	gcflags = all=-N -l
+//
+//	[build.profile.ci]
+//	race = true
+//	vet = true
+//	tags = integration
+//	env.CGO_ENABLED = 1
+//
+// Its keys are:
+//
+//	tags     more build tags, separated by commas or spaces
+//	gcflags  the -gcflags of go build, e.g. "all=-N -l" to debug the app
+//	ldflags  more linker flags, added to those of the build
+//	race     whether the app is built with the race detector
+//	vet      whether the app's code is analyzed, as with build.vet
+//	flags    more flags for go build, e.g. "-p 2"
+//	env.X    the value of X in the environment of the go commands
+//
+// The profile is selected by the -profile flag of "gospf run" and "gospf
+// build", or by build.profile, e.g. in the section of a run mode.
+
+// Profile, if set, overrides build.profile as the name of the build profile.
+var Profile string
+
+// profileSectionPrefix precedes the name of a profile in its section.
+const profileSectionPrefix = "build.profile."
+
+// buildProfile holds the settings of a build profile.
+type buildProfile struct {
+	Name    string
+	Tags    string
+	GCFlags string
+	LDFlags string
+	Race    bool
+	Vet     bool
+	Flags   []string
+	Env     []string // KEY=VALUE, sorted by key.
+}
+
+// profileName returns the name of the selected build profile, or "" if none
+// is.
+func profileName() string {
+	return gospf.FirstNonEmpty(Profile, gospf.Config.StringDefault("build.profile", ""))
+}
+
+// currentProfile returns the selected build profile, or nil if none is.  It
+// returns an error if the profile is not defined in app.conf, or is invalid.
+func currentProfile() (*buildProfile, *gospf.Error) {
+	name := profileName()
+	if name == "" {
+		return nil, nil
+	}
+	raw := gospf.Config.Raw()
+	section := profileSectionPrefix + name
+	var defined []string
+	for _, s := range raw.Sections() {
+		if s != section {
+			if strings.HasPrefix(s, profileSectionPrefix) {
+				defined = append(defined, strings.TrimPrefix(s, profileSectionPrefix))
+			}
+			continue
+		}
+		values := make(map[string]string)
+		options, _ := raw.SectionOptions(section)
+		for _, key := range options {
+			values[key], _ = raw.String(section, key)
+		}
+		return parseProfile(name, values)
+	}
+
+	description := fmt.Sprintf("app.conf has no [%s] section.", section)
+	if len(defined) > 0 {
+		sort.Strings(defined)
+		description += "  The profiles defined are: " + strings.Join(defined, ", ") + "."
+	}
+	return nil, &gospf.Error{
+		Title:       "Unknown Build Profile",
+		Description: description,
+	}
+}
+
+// parseProfile returns the named profile, given the values of its section.
+// The keys that are not those of a profile are ignored, since the section
+// also has the options of the app.conf's defaults.
+func parseProfile(name string, values map[string]string) (*buildProfile, *gospf.Error) {
+	profile := &buildProfile{
+		Name:    name,
+		Tags:    strings.TrimSpace(values["tags"]),
+		GCFlags: strings.TrimSpace(values["gcflags"]),
+		LDFlags: strings.TrimSpace(values["ldflags"]),
+		Flags:   strings.Fields(values["flags"]),
+	}
+	for key, value := range map[string]*bool{"race": &profile.Race, "vet": &profile.Vet} {
+		s := strings.TrimSpace(values[key])
+		if s == "" {
+			continue
+		}
+		var err error
+		if *value, err = strconv.ParseBool(s); err != nil {
+			return nil, &gospf.Error{
+				Title:       "Invalid Build Profile",
+				Description: fmt.Sprintf("%s%s: %s=%q is not a boolean.", profileSectionPrefix, name, key, s),
+			}
+		}
+	}
+	for key, value := range values {
+		if strings.HasPrefix(key, "env.") && len(key) > len("env.") {
+			profile.Env = append(profile.Env, key[len("env."):]+"="+value)
+		}
+	}
+	sort.Strings(profile.Env)
+	return profile, nil
+}
+
+// goBuildFlags returns the flags of go build set by the profile, except the
+// linker flags, which are added to those of the build.
+func (p *buildProfile) goBuildFlags() []string {
+	var flags []string
+	if p.Race {
+		flags = append(flags, "-race")
+	}
+	if p.GCFlags != "" {
+		flags = append(flags, "-gcflags", p.GCFlags)
+	}
+	return append(flags, p.Flags...)
+}
+
+// environ returns the environment with the variables set by the profile.
+func (p *buildProfile) environ(environ []string) []string {
+	return overrideEnv(environ, p.Env...)
+}
+
+// profileTags returns the build tags of the selected profile, or "" if there
+// is none, or it is invalid, which the build reports.
+func profileTags() string {
+	if profile, _ := currentProfile(); profile != nil {
+		return profile.Tags
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package harness
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	profile, err := parseProfile("ci", map[string]string{
+		"race":            "true",
+		"vet":             "1",
+		"tags":            "integration",
+		"gcflags":         "all=-N -l",
+		"flags":           "-p 2",
+		"env.CGO_ENABLED": "1",
+		"env.GOOS":        "linux",
+		"http.port":       "9000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFlags := []string{"-race", "-gcflags", "all=-N -l", "-p", "2"}
+	if flags := profile.goBuildFlags(); !reflect.DeepEqual(flags, expectedFlags) {
+		t.Errorf("Expected the flags %v, got %v", expectedFlags, flags)
+	}
+	if profile.Tags != "integration" || !profile.Vet {
+		t.Errorf("Expected the tags integration and vet, got %q %v", profile.Tags, profile.Vet)
+	}
+
+	env := profile.environ([]string{"HOME=/home/dev", "GOOS=darwin"})
+	expectedEnv := []string{"HOME=/home/dev", "CGO_ENABLED=1", "GOOS=linux"}
+	if !reflect.DeepEqual(env, expectedEnv) {
+		t.Errorf("Expected the environment %v, got %v", expectedEnv, env)
+	}
+
+	if _, err := parseProfile("ci", map[string]string{"race": "sometimes"}); err == nil {
+		t.Error("Expected race=sometimes to be invalid")
+	}
+}
@@ -0,0 +1,92 @@
+package harness
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// progressDelay is how long a page load waits for the build before the
+// harness shows the building page instead.
+const progressDelay = 500 * time.Millisecond
+
+// buildProgress is the state of the build in progress, if any.
+type buildProgress struct {
+	mu      sync.Mutex
+	started time.Time // Zero unless a build is in progress.
+	output  *tailWriter
+}
+
+// progress is the progress of the current build.
+var progress = &buildProgress{output: &tailWriter{max: stderrTailSize}}
+
+func (p *buildProgress) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = time.Now()
+	p.output = &tailWriter{max: stderrTailSize}
+}
+
+func (p *buildProgress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = time.Time{}
+}
+
+// Write records the output of the build.
+func (p *buildProgress) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	output := p.output
+	p.mu.Unlock()
+	return output.Write(data)
+}
+
+// status returns how long the build in progress has been running, and its
+// last output, or false if no build is in progress.
+func (p *buildProgress) status() (time.Duration, string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started.IsZero() {
+		return 0, "", false
+	}
+	return time.Since(p.started), p.output.String(), true
+}
+
+// isPageLoad reports whether the request is a browser loading a page, which
+// may be shown the building page rather than wait for the build.
+func isPageLoad(r *http.Request) bool {
+	return r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") &&
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// renderProgress serves the building page, which reloads itself until the
+// build is done.
+func renderProgress(w http.ResponseWriter, elapsed time.Duration, output string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	progressTemplate.Execute(w, map[string]interface{}{
+		"AppName": gospf.AppName,
+		"Elapsed": elapsed.Round(time.Second),
+		"Output":  output,
+	})
+}
+
+var progressTemplate = template.Must(template.New("progress").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="refresh" content="1">
+<title>Building {{.AppName}}…</title>
+</head>
+<body>
+<h1>Building {{.AppName}}…</h1>
+<p>The build has been running for {{.Elapsed}}.  This page reloads when it is done.</p>
+{{if .Output}}<pre>{{.Output}}</pre>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,54 @@
+package harness
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hubply/gospf"
+)
+
+// blockedWatcher is a sourceWatcher whose rebuilds wait for release.
+type blockedWatcher struct {
+	release chan struct{}
+}
+
+func (w blockedWatcher) Listen(listener gospf.Listener, roots ...string) {}
+
+func (w blockedWatcher) Notify() *gospf.Error {
+	progress.start()
+	defer progress.finish()
+	progress.Write([]byte("github.com/hubply/app/app/controllers\n"))
+	<-w.release
+	return &gospf.Error{Title: "Go Compilation Error"}
+}
+
+func TestWaitForBuild(t *testing.T) {
+	blocked := blockedWatcher{make(chan struct{})}
+	hp := &Harness{watcher: blocked}
+	if _, done := hp.wait(true); done {
+		t.Fatal("Expected an impatient request to stop waiting for the build")
+	}
+	elapsed, output, building := progress.status()
+	if !building || !strings.Contains(output, "controllers") {
+		t.Errorf("Expected the build in progress with its output, got %v %q", building, output)
+	}
+
+	w := httptest.NewRecorder()
+	renderProgress(w, elapsed, output)
+	if w.Code != 503 || !strings.Contains(w.Body.String(), "app/controllers") {
+		t.Errorf("Expected the building page, got %d %q", w.Code, w.Body.String())
+	}
+
+	close(blocked.release)
+	err, done := hp.wait(false)
+	if !done || err == nil || err.Title != "Go Compilation Error" {
+		t.Errorf("Expected the result of the shared build, got %v %v", done, err)
+	}
+	if hp.lastBuildError() != err {
+		t.Error("Expected the error of the build to be kept")
+	}
+	if _, _, building = progress.status(); building {
+		t.Error("Expected no build in progress")
+	}
+}
@@ -0,0 +1,154 @@
+package harness
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// The app prints that it is listening before its server accepts connections,
+// and it may not serve requests until its own initialization is done.  So
+// once an app instance is started, the harness probes it until it is ready
+// before proxying requests to it: with harness.ready.path set, e.g.
+// "/healthz", until a GET of the path answers with a status below 500, and
+// otherwise until its port or socket accepts a connection.  With
+// harness.port.handoff, the port is the harness's own listener, which accepts
+// the connections at once, so the socket the app forwards them to is probed
+// instead.  It gives up after
+// harness.ready.timeout (30s by default), and proxies the requests anyway.
+// Meanwhile, page loads are shown the starting page, which reloads until the
+// app is ready, and the other requests wait.
+
+// defaultReadyTimeout is how long an app instance is probed, unless
+// harness.ready.timeout says otherwise.
+const defaultReadyTimeout = 30 * time.Second
+
+// How long to wait between probes, and how long a single probe may take.
+const (
+	readyProbeInterval = 50 * time.Millisecond
+	readyProbeTimeout  = 1 * time.Second
+)
+
+func readyTimeout() time.Duration {
+	timeout := defaultReadyTimeout
+	if value, found := gospf.Config.String("harness.ready.timeout"); found {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			gospf.WARN.Printf("Invalid harness.ready.timeout %q, using %s", value, defaultReadyTimeout)
+		} else {
+			timeout = parsed
+		}
+	}
+	return timeout
+}
+
+// started records that the app instance started, and probes it until it is
+// ready.
+func (b *backend) started(app *App) {
+	ready := make(chan struct{})
+	b.mu.Lock()
+	b.crash = nil
+	b.ready = ready
+	b.usage = nil
+	b.mu.Unlock()
+	go b.probe(app, app.forwardTo, ready)
+	go b.sample(app)
+}
+
+// probe closes ready once the app instance is ready, or the timeout expired,
+// or the instance is gone.  The app's connections are probed on the socket
+// it forwards them to, if any.
+func (b *backend) probe(app *App, forwardTo string, ready chan struct{}) {
+	defer close(ready)
+	timeout := readyTimeout()
+	path := gospf.Config.StringDefault("harness.ready.path", "")
+	client := &http.Client{Transport: b.transport, Timeout: readyProbeTimeout}
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(readyProbeInterval) {
+		select {
+		case <-app.Exited():
+			return
+		default:
+		}
+		current, network, address, _ := b.target()
+		if current != app {
+			return
+		}
+		if path == "" {
+			if forwardTo != "" {
+				network, address = "unix", forwardTo
+			}
+			if conn, err := net.DialTimeout(network, address, readyProbeTimeout); err == nil {
+				conn.Close()
+				return
+			}
+			continue
+		}
+		b.mu.Lock()
+		probeUrl := b.scheme + "://" + b.serverHost + path
+		b.mu.Unlock()
+		if resp, err := client.Get(probeUrl); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				return
+			}
+		}
+	}
+	gospf.WARN.Printf("The app is not ready after %s, proxying the requests to it anyway", timeout)
+}
+
+// waitReady waits for the app instance to be ready for the request, or serves
+// the starting page if it is a page load.  It reports whether to proxy the
+// request.
+func (b *backend) waitReady(w http.ResponseWriter, r *http.Request) bool {
+	b.mu.Lock()
+	ready := b.ready
+	b.mu.Unlock()
+	if ready == nil {
+		return true
+	}
+	select {
+	case <-ready:
+		return true
+	default:
+	}
+
+	if isPageLoad(r) {
+		renderStarting(w)
+		return false
+	}
+	select {
+	case <-ready:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// renderStarting serves the starting page, which reloads itself until the
+// app is ready.
+func renderStarting(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	startingTemplate.Execute(w, map[string]interface{}{
+		"AppName": gospf.AppName,
+	})
+}
+
+var startingTemplate = template.Must(template.New("starting").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="refresh" content="1">
+<title>Starting {{.AppName}}…</title>
+</head>
+<body>
+<h1>Starting {{.AppName}}…</h1>
+<p>The app is built, and starting up.  This page reloads when it is ready.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,40 @@
+package harness
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitReady(t *testing.T) {
+	b := &backend{ready: make(chan struct{})}
+
+	page := httptest.NewRequest("GET", "/", nil)
+	page.Header.Set("Accept", "text/html")
+	resp := httptest.NewRecorder()
+	if b.waitReady(resp, page) {
+		t.Error("Expected a page load not to be proxied while the app starts")
+	}
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the starting page, got status %d", resp.Code)
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- b.waitReady(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected a request to wait while the app starts")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(b.ready)
+	if !<-done {
+		t.Error("Expected the request to be proxied once the app is ready")
+	}
+
+	if !b.waitReady(httptest.NewRecorder(), page) {
+		t.Error("Expected a page load to be proxied once the app is ready")
+	}
+}
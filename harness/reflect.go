@@ -11,7 +11,9 @@ import (
 	"go/token"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/hubply/gospf"
@@ -33,7 +35,21 @@ type SourceInfo struct {
 	// A list of import paths.
 	// Revel notices files with an init() function and imports that package.
 	InitImportPaths []string
-
+	// JobSpecs lists type info for the structs found under app/jobs/... that
+	// have a Run() method.  They are run by the worker instead of the server.
+	JobSpecs []*TypeInfo
+	// InterceptorFuncs lists the interceptor functions found under
+	// app/controllers/..., which intercept all the controllers.
+	InterceptorFuncs []*InterceptorSpec
+	// RegisteredInterceptors lists the interceptors that the app registers
+	// itself, in any of its packages, e.g. "myapp/app/controllers.App.Before",
+	// which are not registered again.
+	RegisteredInterceptors []string
+
+	// embeddedSpecs lists type info for the structs of the packages outside of
+	// the code paths, or outside of app/controllers/..., whose types those of
+	// StructSpecs embed, for the methods they promote.
+	embeddedSpecs []*TypeInfo
 	// controllerSpecs lists type info for all structs found under
 	// app/controllers/... that embed (directly or indirectly) gospf.Controller
 	controllerSpecs []*TypeInfo
@@ -47,6 +63,8 @@ type TypeInfo struct {
 	ImportPath  string // e.g. "github.com/gospf/samples/chat/app/controllers"
 	PackageName string // e.g. "controllers"
 	MethodSpecs []*MethodSpec
+	// Interceptors lists the interceptor methods of a controller.
+	Interceptors []*InterceptorSpec
 
 	// Used internally to identify controllers that indirectly embed *gospf.Controller.
 	embeddedTypes []*embeddedTypeName
@@ -64,6 +82,35 @@ type MethodSpec struct {
 	Name        string        // Name of the method, e.g. "Index"
 	Args        []*MethodArg  // Argument descriptors
 	RenderCalls []*methodCall // Descriptions of Render() invocations from this Method.
+	File        string        // The file declaring the method.
+	Line        int           // The line of the declaration.
+	Deprecated  string        // The "Deprecated:" notice of its doc comment, if any.
+}
+
+// InterceptorSpec describes an interceptor found in a controllers package:
+// a method of a controller, e.g. "func (c App) Before() gospf.Result", or a
+// function, e.g. "func Before(c *gospf.Controller) gospf.Result", named for
+// when it runs.
+type InterceptorSpec struct {
+	Name        string // "Before", "After", "Panic" or "Finally"
+	ImportPath  string // The import path of the package declaring it.
+	PackageName string
+	File        string // The file declaring it.
+	Line        int    // The line of the declaration.
+}
+
+// interceptorNames maps the names of the interceptors to when they run.
+var interceptorNames = map[string]string{
+	"Before":  "BEFORE",
+	"After":   "AFTER",
+	"Panic":   "PANIC",
+	"Finally": "FINALLY",
+}
+
+// When returns the name of the gospf constant for when the interceptor runs,
+// e.g. "BEFORE".
+func (s *InterceptorSpec) When() string {
+	return interceptorNames[s.Name]
 }
 
 type MethodArg struct {
@@ -86,6 +133,7 @@ func ProcessSource(roots []string) (*SourceInfo, *gospf.Error) {
 	var (
 		srcInfo      *SourceInfo
 		compileError *gospf.Error
+		cache        = loadSourceCache()
 	)
 
 	for _, root := range roots {
@@ -105,6 +153,9 @@ func ProcessSource(roots []string) (*SourceInfo, *gospf.Error) {
 			if !info.IsDir() || info.Name() == "tmp" {
 				return nil
 			}
+			if skipSourceDir(root, path) {
+				return filepath.SkipDir
+			}
 
 			// Get the import path of the package.
 			pkgImportPath := rootImportPath
@@ -112,12 +163,21 @@ func ProcessSource(roots []string) (*SourceInfo, *gospf.Error) {
 				pkgImportPath = rootImportPath + "/" + filepath.ToSlash(path[len(root)+1:])
 			}
 
+			// Reuse the result of processing the package if its files are unchanged.
+			cached, files, found := cache.lookup(path)
+			if found {
+				if cached != nil {
+					srcInfo = appendSourceInfo(srcInfo, cached)
+				}
+				return nil
+			}
+
 			// Parse files within the path.
 			var pkgs map[string]*ast.Package
 			fset := token.NewFileSet()
 			pkgs, err = parser.ParseDir(fset, path, func(f os.FileInfo) bool {
 				return !f.IsDir() && !strings.HasPrefix(f.Name(), ".") && strings.HasSuffix(f.Name(), ".go")
-			}, 0)
+			}, parser.ParseComments)
 			if err != nil {
 				if errList, ok := err.(scanner.ErrorList); ok {
 					var pos token.Position = errList[0].Pos
@@ -131,12 +191,7 @@ func ProcessSource(roots []string) (*SourceInfo, *gospf.Error) {
 						SourceLines: gospf.MustReadLines(pos.Filename),
 					}
 
-					errorLink := gospf.Config.StringDefault("error.link", "")
-
-					if errorLink != "" {
-						compileError.SetLink(errorLink)
-					}
-
+					setErrorLink(compileError)
 					return compileError
 				}
 				ast.Print(nil, err)
@@ -148,6 +203,7 @@ func ProcessSource(roots []string) (*SourceInfo, *gospf.Error) {
 
 			// If there is no code in this directory, skip it.
 			if len(pkgs) == 0 {
+				cache.store(path, files, nil)
 				return nil
 			}
 
@@ -161,14 +217,79 @@ func ProcessSource(roots []string) (*SourceInfo, *gospf.Error) {
 				pkg = v
 			}
 
-			srcInfo = appendSourceInfo(srcInfo, processPackage(fset, pkgImportPath, path, pkg))
+			pkgInfo := processPackage(fset, pkgImportPath, path, pkg)
+			cache.store(path, files, pkgInfo)
+			srcInfo = appendSourceInfo(srcInfo, pkgInfo)
 			return nil
 		})
 	}
 
+	if compileError == nil {
+		cache.save()
+		if srcInfo != nil {
+			processEmbeddedPackages(srcInfo)
+			dropRegisteredInterceptors(srcInfo)
+		}
+	}
 	return srcInfo, compileError
 }
 
+// processEmbeddedPackages follows the types that the structs embed to the
+// packages that were not scanned for them, e.g. a base controller of a shared
+// package, and adds their structs to the embedded specs, as well as those of
+// the packages their own structs embed, and so on.  The packages of gospf are
+// left out, as is a package that is not found or fails to parse, which go
+// build then reports.
+func processEmbeddedPackages(srcInfo *SourceInfo) {
+	scanned := make(map[string]bool)
+	for _, spec := range srcInfo.StructSpecs {
+		scanned[spec.ImportPath] = true
+	}
+	var queue []string
+	addEmbedded := func(specs []*TypeInfo) {
+		for _, spec := range specs {
+			for _, embedded := range spec.embeddedTypes {
+				importPath := embedded.ImportPath
+				if !scanned[importPath] && importPath != gospf.REVEL_IMPORT_PATH && !strings.HasPrefix(importPath, gospf.REVEL_IMPORT_PATH+"/") {
+					scanned[importPath] = true
+					queue = append(queue, importPath)
+				}
+			}
+		}
+	}
+	addEmbedded(srcInfo.StructSpecs)
+
+	for len(queue) > 0 {
+		importPath := queue[0]
+		queue = queue[1:]
+		buildPkg, err := build.Import(importPath, "", build.FindOnly)
+		if err != nil {
+			gospf.TRACE.Println("Could not find the package of embedded types:", importPath)
+			continue
+		}
+		fset := token.NewFileSet()
+		pkgs, err := parser.ParseDir(fset, buildPkg.Dir, func(f os.FileInfo) bool {
+			return !f.IsDir() && !strings.HasPrefix(f.Name(), ".") && strings.HasSuffix(f.Name(), ".go") && !strings.HasSuffix(f.Name(), "_test.go")
+		}, 0)
+		if err != nil {
+			gospf.TRACE.Println("Could not parse the package of embedded types:", err)
+			continue
+		}
+		delete(pkgs, "main")
+		for _, pkg := range pkgs {
+			pkgInfo := processPackageAs(fset, importPath, buildPkg.Dir, pkg, true)
+			srcInfo.embeddedSpecs = append(srcInfo.embeddedSpecs, pkgInfo.StructSpecs...)
+			srcInfo.RegisteredInterceptors = append(srcInfo.RegisteredInterceptors, pkgInfo.RegisteredInterceptors...)
+			for k, v := range pkgInfo.ValidationKeys {
+				if _, ok := srcInfo.ValidationKeys[k]; !ok {
+					srcInfo.ValidationKeys[k] = v
+				}
+			}
+			addEmbedded(pkgInfo.StructSpecs)
+		}
+	}
+}
+
 func appendSourceInfo(srcInfo1, srcInfo2 *SourceInfo) *SourceInfo {
 	if srcInfo1 == nil {
 		return srcInfo2
@@ -176,6 +297,9 @@ func appendSourceInfo(srcInfo1, srcInfo2 *SourceInfo) *SourceInfo {
 
 	srcInfo1.StructSpecs = append(srcInfo1.StructSpecs, srcInfo2.StructSpecs...)
 	srcInfo1.InitImportPaths = append(srcInfo1.InitImportPaths, srcInfo2.InitImportPaths...)
+	srcInfo1.JobSpecs = append(srcInfo1.JobSpecs, srcInfo2.JobSpecs...)
+	srcInfo1.InterceptorFuncs = append(srcInfo1.InterceptorFuncs, srcInfo2.InterceptorFuncs...)
+	srcInfo1.RegisteredInterceptors = append(srcInfo1.RegisteredInterceptors, srcInfo2.RegisteredInterceptors...)
 	for k, v := range srcInfo2.ValidationKeys {
 		if _, ok := srcInfo1.ValidationKeys[k]; ok {
 			log.Println("Key conflict when scanning validation calls:", k)
@@ -187,20 +311,40 @@ func appendSourceInfo(srcInfo1, srcInfo2 *SourceInfo) *SourceInfo {
 }
 
 func processPackage(fset *token.FileSet, pkgImportPath, pkgPath string, pkg *ast.Package) *SourceInfo {
+	return processPackageAs(fset, pkgImportPath, pkgPath, pkg, false)
+}
+
+// processPackageAs processes the package, scanning its structs and their
+// methods as those of controllers wherever it is, if it declares types that
+// the app's embed.
+func processPackageAs(fset *token.FileSet, pkgImportPath, pkgPath string, pkg *ast.Package, embedded bool) *SourceInfo {
 	var (
 		structSpecs     []*TypeInfo
 		initImportPaths []string
 
 		methodSpecs     = make(methodMap)
 		validationKeys  = make(map[string]map[int]string)
-		scanControllers = strings.HasSuffix(pkgImportPath, "/controllers") ||
+		scanControllers = embedded || strings.HasSuffix(pkgImportPath, "/controllers") ||
 			strings.Contains(pkgImportPath, "/controllers/")
 		scanTests = strings.HasSuffix(pkgImportPath, "/tests") ||
 			strings.Contains(pkgImportPath, "/tests/")
+		scanJobs = strings.HasSuffix(pkgImportPath, "/jobs") ||
+			strings.Contains(pkgImportPath, "/jobs/")
+
+		jobSpecs []*TypeInfo
+		runners  = make(map[string]bool) // Names of the types with a Run() method.
+
+		interceptorMethods = make(map[string][]*InterceptorSpec) // By receiver type.
+		interceptorFuncs   []*InterceptorSpec
+		registered         = make(map[string]bool) // The interceptors registered by the app.
+
+		validationHelpers = getValidationHelpers(pkg, pkgPath)
 	)
 
-	// For each source file in the package...
-	for _, file := range pkg.Files {
+	// For each source file in the package, in the order of their names so
+	// that the generated code is the same from one build to the next...
+	for _, filename := range sortedFilenames(pkg) {
+		file := pkg.Files[filename]
 
 		// Imports maps the package key to the full import path.
 		// e.g. import "sample/app/models" => "models": "sample/app/models"
@@ -211,17 +355,35 @@ func processPackage(fset *token.FileSet, pkgImportPath, pkgPath string, pkg *ast
 			addImports(imports, decl, pkgPath)
 
 			if scanControllers {
-				// Match and add both structs and methods
+				// Match and add structs, and methods that are either
+				// interceptors or actions.
 				structSpecs = appendStruct(structSpecs, pkgImportPath, pkg, decl, imports, fset)
-				appendAction(fset, methodSpecs, decl, pkgImportPath, pkg.Name, imports)
+				if spec, recvTypeName, ok := getInterceptor(fset, decl, pkgImportPath, pkg.Name, imports); ok {
+					if recvTypeName == "" {
+						interceptorFuncs = append(interceptorFuncs, spec)
+					} else {
+						interceptorMethods[recvTypeName] = append(interceptorMethods[recvTypeName], spec)
+					}
+				} else {
+					appendAction(fset, methodSpecs, decl, pkgImportPath, pkg.Name, imports)
+				}
 			} else if scanTests {
 				structSpecs = appendStruct(structSpecs, pkgImportPath, pkg, decl, imports, fset)
+			} else if scanJobs {
+				jobSpecs = appendStruct(jobSpecs, pkgImportPath, pkg, decl, imports, fset)
+				if typeName, ok := runMethodReceiver(decl); ok {
+					runners[typeName] = true
+				}
 			}
 
+			// The interceptors may be registered in any package, e.g. in
+			// app/init.go.
+			addRegisteredInterceptors(registered, decl, pkgImportPath, imports)
+
 			// If this is a func...
 			if funcDecl, ok := decl.(*ast.FuncDecl); ok {
 				// Scan it for validation calls
-				lineKeys := getValidationKeys(fset, funcDecl, imports)
+				lineKeys := getValidationKeys(fset, funcDecl, imports, validationHelpers)
 				if len(lineKeys) > 0 {
 					validationKeys[pkgImportPath+"."+getFuncName(funcDecl)] = lineKeys
 				}
@@ -234,16 +396,209 @@ func processPackage(fset *token.FileSet, pkgImportPath, pkgPath string, pkg *ast
 		}
 	}
 
-	// Add the method specs to the struct specs.
+	// Add the method specs and the interceptors to the struct specs.  Those
+	// that the app registers itself are left out once all the packages are
+	// processed, by dropRegisteredInterceptors.
 	for _, spec := range structSpecs {
 		spec.MethodSpecs = methodSpecs[spec.StructName]
+		spec.Interceptors = interceptorMethods[spec.StructName]
+	}
+
+	// Only the structs that may be run are jobs.
+	var runnableJobSpecs []*TypeInfo
+	for _, spec := range jobSpecs {
+		if runners[spec.StructName] {
+			runnableJobSpecs = append(runnableJobSpecs, spec)
+		}
 	}
 
+	var registeredInterceptors []string
+	for key := range registered {
+		registeredInterceptors = append(registeredInterceptors, key)
+	}
+	sort.Strings(registeredInterceptors)
+
 	return &SourceInfo{
-		StructSpecs:     structSpecs,
-		ValidationKeys:  validationKeys,
-		InitImportPaths: initImportPaths,
+		StructSpecs:      structSpecs,
+		ValidationKeys:   validationKeys,
+		InitImportPaths:  initImportPaths,
+		JobSpecs:         runnableJobSpecs,
+		InterceptorFuncs: interceptorFuncs,
+
+		RegisteredInterceptors: registeredInterceptors,
+	}
+}
+
+// getInterceptor returns the description of the interceptor declared by decl,
+// if it is one, and the name of its receiver type if it is a method.  An
+// interceptor is named Before, After, Panic or Finally, and returns a
+// gospf.Result.  A method takes no arguments, and a function takes the
+// *gospf.Controller.
+func getInterceptor(fset *token.FileSet, decl ast.Decl, pkgImportPath, pkgName string, imports map[string]string) (*InterceptorSpec, string, bool) {
+	funcDecl, ok := decl.(*ast.FuncDecl)
+	if !ok || interceptorNames[funcDecl.Name.Name] == "" {
+		return nil, "", false
+	}
+	if !isGospfType(funcDecl.Type.Results, "Result", false, imports) {
+		return nil, "", false
+	}
+
+	var recvTypeName string
+	if funcDecl.Recv != nil {
+		if funcDecl.Type.Params.NumFields() != 0 {
+			return nil, "", false
+		}
+		recvType := funcDecl.Recv.List[0].Type
+		if starExpr, ok := recvType.(*ast.StarExpr); ok {
+			recvType = starExpr.X
+		}
+		ident, ok := recvType.(*ast.Ident)
+		if !ok {
+			return nil, "", false
+		}
+		recvTypeName = ident.Name
+	} else if !isGospfType(funcDecl.Type.Params, "Controller", true, imports) {
+		return nil, "", false
+	}
+
+	pos := fset.Position(funcDecl.Pos())
+	return &InterceptorSpec{
+		Name:        funcDecl.Name.Name,
+		ImportPath:  pkgImportPath,
+		PackageName: pkgName,
+		File:        pos.Filename,
+		Line:        pos.Line,
+	}, recvTypeName, true
+}
+
+// isGospfType reports whether the field list holds a single field of the
+// named gospf type, or a pointer to it.
+func isGospfType(fields *ast.FieldList, typeName string, pointer bool, imports map[string]string) bool {
+	if fields.NumFields() != 1 {
+		return false
+	}
+	fieldType := fields.List[0].Type
+	if pointer {
+		starExpr, ok := fieldType.(*ast.StarExpr)
+		if !ok {
+			return false
+		}
+		fieldType = starExpr.X
+	}
+	selExpr, ok := fieldType.(*ast.SelectorExpr)
+	if !ok || selExpr.Sel.Name != typeName {
+		return false
+	}
+	pkgIdent, ok := selExpr.X.(*ast.Ident)
+	return ok && imports[pkgIdent.Name] == gospf.REVEL_IMPORT_PATH
+}
+
+// addRegisteredInterceptors records the interceptors that the declaration
+// registers with InterceptMethod or InterceptFunc, qualified by their import
+// path, e.g. "myapp/app/controllers.App.Before" for
+// "gospf.InterceptMethod((*controllers.App).Before, gospf.BEFORE)", so that
+// they are not registered twice.
+func addRegisteredInterceptors(registered map[string]bool, decl ast.Decl, pkgImportPath string, imports map[string]string) {
+	ast.Inspect(decl, func(node ast.Node) bool {
+		callExpr, ok := node.(*ast.CallExpr)
+		if !ok || len(callExpr.Args) == 0 {
+			return true
+		}
+		selExpr, ok := callExpr.Fun.(*ast.SelectorExpr)
+		if !ok || selExpr.Sel.Name != "InterceptMethod" && selExpr.Sel.Name != "InterceptFunc" {
+			return true
+		}
+
+		switch arg := callExpr.Args[0].(type) {
+		case *ast.Ident: // e.g. Before
+			registered[pkgImportPath+"."+arg.Name] = true
+		case *ast.SelectorExpr: // e.g. (*App).Before, App.Before or controllers.Before
+			recvType := arg.X
+			if ident, ok := recvType.(*ast.Ident); ok && imports[ident.Name] != "" {
+				registered[imports[ident.Name]+"."+arg.Sel.Name] = true
+				break
+			}
+			if parenExpr, ok := recvType.(*ast.ParenExpr); ok {
+				recvType = parenExpr.X
+			}
+			if starExpr, ok := recvType.(*ast.StarExpr); ok {
+				recvType = starExpr.X
+			}
+			switch recvType := recvType.(type) {
+			case *ast.Ident: // e.g. App
+				registered[pkgImportPath+"."+recvType.Name+"."+arg.Sel.Name] = true
+			case *ast.SelectorExpr: // e.g. controllers.App
+				if pkgIdent, ok := recvType.X.(*ast.Ident); ok && imports[pkgIdent.Name] != "" {
+					registered[imports[pkgIdent.Name]+"."+recvType.Sel.Name+"."+arg.Sel.Name] = true
+				}
+			}
+		}
+		return true
+	})
+}
+
+// dropRegisteredInterceptors leaves out of the source info the interceptors
+// that the app registers itself, in whichever of its packages.  The specs
+// that change are copied, since the source cache shares them.
+func dropRegisteredInterceptors(srcInfo *SourceInfo) {
+	if len(srcInfo.RegisteredInterceptors) == 0 {
+		return
+	}
+	registered := make(map[string]bool, len(srcInfo.RegisteredInterceptors))
+	for _, key := range srcInfo.RegisteredInterceptors {
+		registered[key] = true
+	}
+
+	unregistered := func(interceptors []*InterceptorSpec, prefix string) []*InterceptorSpec {
+		var kept []*InterceptorSpec
+		for _, interceptor := range interceptors {
+			if !registered[prefix+interceptor.Name] {
+				kept = append(kept, interceptor)
+			}
+		}
+		return kept
+	}
+	dropFromSpecs := func(specs []*TypeInfo) {
+		for i, spec := range specs {
+			kept := unregistered(spec.Interceptors, spec.ImportPath+"."+spec.StructName+".")
+			if len(kept) != len(spec.Interceptors) {
+				copied := *spec
+				copied.Interceptors = kept
+				specs[i] = &copied
+			}
+		}
 	}
+	dropFromSpecs(srcInfo.StructSpecs)
+	dropFromSpecs(srcInfo.embeddedSpecs)
+
+	var funcs []*InterceptorSpec
+	for _, interceptor := range srcInfo.InterceptorFuncs {
+		if !registered[interceptor.ImportPath+"."+interceptor.Name] {
+			funcs = append(funcs, interceptor)
+		}
+	}
+	srcInfo.InterceptorFuncs = funcs
+}
+
+// runMethodReceiver returns the name of the receiver type if the declaration
+// is of a Run() method, without arguments or results.
+func runMethodReceiver(decl ast.Decl) (string, bool) {
+	funcDecl, ok := decl.(*ast.FuncDecl)
+	if !ok || funcDecl.Recv == nil || funcDecl.Name.Name != "Run" {
+		return "", false
+	}
+	if funcDecl.Type.Params.NumFields() != 0 || funcDecl.Type.Results.NumFields() != 0 {
+		return "", false
+	}
+
+	recvType := funcDecl.Recv.List[0].Type
+	if starExpr, ok := recvType.(*ast.StarExpr); ok {
+		recvType = starExpr.X
+	}
+	if ident, ok := recvType.(*ast.Ident); ok {
+		return ident.Name, true
+	}
+	return "", false
 }
 
 // getFuncName returns a name for this func or method declaration.
@@ -423,8 +778,12 @@ func appendAction(fset *token.FileSet, mm methodMap, decl ast.Decl, pkgImportPat
 		return
 	}
 
+	pos := fset.Position(funcDecl.Pos())
 	method := &MethodSpec{
-		Name: funcDecl.Name.Name,
+		Name:       funcDecl.Name.Name,
+		File:       pos.Filename,
+		Line:       pos.Line,
+		Deprecated: deprecationNotice(funcDecl.Doc),
 	}
 
 	// Add a description of the arguments to the method.
@@ -502,10 +861,14 @@ func appendAction(fset *token.FileSet, mm methodMap, decl ast.Decl, pkgImportPat
 // Scan app source code for calls to X.Y(), where X is of type *Validation.
 //
 // Recognize these scenarios:
-// - "Y" = "Validation" and is a member of the receiver.
-//   (The common case for inline validation)
-// - "X" is passed in to the func as a parameter.
-//   (For structs implementing Validated)
+//   - "Y" = "Validation" and is a member of the receiver.
+//     (The common case for inline validation)
+//   - "X" is passed in to the func as a parameter.
+//     (For structs implementing Validated, and helper funcs)
+//   - "X" is a local variable holding one of the above, e.g. v := c.Validation.
+//   - "X" is a call to a helper of the package that returns the *Validation,
+//     e.g. c.validation().Required(name).
+//   - X.Y itself is held in a local variable, e.g. required := v.Required.
 //
 // The line number to which a validation call is attributed is that of the
 // surrounding ExprStmt.  This is so that it matches what runtime.Callers()
@@ -513,35 +876,53 @@ func appendAction(fset *token.FileSet, mm methodMap, decl ast.Decl, pkgImportPat
 //
 // The end result is that we can set the default validation key for each call to
 // be the same as the local variable.
-func getValidationKeys(fset *token.FileSet, funcDecl *ast.FuncDecl, imports map[string]string) map[int]string {
+func getValidationKeys(fset *token.FileSet, funcDecl *ast.FuncDecl, imports map[string]string, helpers map[string]bool) map[int]string {
 	var (
 		lineKeys = make(map[int]string)
 
 		// Check the func parameters and the receiver's members for the *gospf.Validation type.
-		validationParam = getValidationParameter(funcDecl, imports)
+		scope = &validationScope{
+			helpers: helpers,
+			vars:    getValidationParameters(funcDecl, imports),
+			methods: make(map[*ast.Object]bool),
+		}
 	)
 
 	ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
-		// e.g. c.Validation.Required(arg) or v.Required(arg)
-		callExpr, ok := node.(*ast.CallExpr)
-		if !ok {
+		switch node := node.(type) {
+		case *ast.AssignStmt: // e.g. v := c.Validation
+			if len(node.Lhs) == len(node.Rhs) {
+				for i, lhs := range node.Lhs {
+					scope.assign(lhs, node.Rhs[i])
+				}
+			}
+			return true
+
+		case *ast.ValueSpec: // e.g. var v = c.Validation
+			for i, name := range node.Names {
+				if i < len(node.Values) {
+					scope.assign(name, node.Values[i])
+				} else if name.Obj != nil && isValidationType(node.Type, imports) {
+					scope.vars[name.Obj] = true
+				}
+			}
 			return true
 		}
 
-		// e.g. c.Validation.Required or v.Required
-		funcSelector, ok := callExpr.Fun.(*ast.SelectorExpr)
+		// e.g. c.Validation.Required(arg) or v.Required(arg)
+		callExpr, ok := node.(*ast.CallExpr)
 		if !ok {
 			return true
 		}
 
-		switch x := funcSelector.X.(type) {
-		case *ast.SelectorExpr: // e.g. c.Validation
-			if x.Sel.Name != "Validation" {
+		switch fun := callExpr.Fun.(type) {
+		case *ast.SelectorExpr: // e.g. c.Validation.Required or v.Required
+			if !scope.isValidation(fun.X) {
 				return true
 			}
 
-		case *ast.Ident: // e.g. v
-			if validationParam == nil || x.Obj != validationParam {
+		case *ast.Ident: // e.g. required
+			if fun.Obj == nil || !scope.methods[fun.Obj] {
 				return true
 			}
 
@@ -578,29 +959,102 @@ func getValidationKeys(fset *token.FileSet, funcDecl *ast.FuncDecl, imports map[
 	return lineKeys
 }
 
-// Check to see if there is a *gospf.Validation as an argument.
-func getValidationParameter(funcDecl *ast.FuncDecl, imports map[string]string) *ast.Object {
-	for _, field := range funcDecl.Type.Params.List {
-		starExpr, ok := field.Type.(*ast.StarExpr) // e.g. *gospf.Validation
-		if !ok {
-			continue
-		}
+// validationScope tracks what holds the *gospf.Validation in a func.
+type validationScope struct {
+	helpers map[string]bool      // The funcs of the package returning it, by name.
+	vars    map[*ast.Object]bool // The params and local variables holding it.
+	methods map[*ast.Object]bool // The local variables holding its methods.
+}
 
-		selExpr, ok := starExpr.X.(*ast.SelectorExpr) // e.g. gospf.Validation
-		if !ok {
-			continue
+// isValidation reports whether the expression is the *gospf.Validation.
+func (s *validationScope) isValidation(expr ast.Expr) bool {
+	switch x := expr.(type) {
+	case *ast.SelectorExpr: // e.g. c.Validation
+		return x.Sel.Name == "Validation"
+	case *ast.Ident: // e.g. v
+		return x.Obj != nil && s.vars[x.Obj]
+	case *ast.ParenExpr:
+		return s.isValidation(x.X)
+	case *ast.CallExpr: // e.g. c.validation() or validationOf(c)
+		switch fun := x.Fun.(type) {
+		case *ast.Ident:
+			return s.helpers[fun.Name]
+		case *ast.SelectorExpr:
+			return s.helpers[fun.Sel.Name]
 		}
+	}
+	return false
+}
 
-		xIdent, ok := selExpr.X.(*ast.Ident) // e.g. rev
-		if !ok {
+// assign records that the variable holds the *gospf.Validation or one of its
+// methods, if the value does.
+func (s *validationScope) assign(lhs, value ast.Expr) {
+	ident, ok := lhs.(*ast.Ident)
+	if !ok || ident.Obj == nil {
+		return
+	}
+	if s.isValidation(value) {
+		s.vars[ident.Obj] = true
+	} else if selExpr, ok := value.(*ast.SelectorExpr); ok && s.isValidation(selExpr.X) {
+		s.methods[ident.Obj] = true
+	}
+}
+
+// Check to see if there are *gospf.Validation arguments.
+func getValidationParameters(funcDecl *ast.FuncDecl, imports map[string]string) map[*ast.Object]bool {
+	params := make(map[*ast.Object]bool)
+	for _, field := range funcDecl.Type.Params.List {
+		if !isValidationType(field.Type, imports) {
 			continue
 		}
+		for _, name := range field.Names {
+			if name.Obj != nil {
+				params[name.Obj] = true
+			}
+		}
+	}
+	return params
+}
 
-		if selExpr.Sel.Name == "Validation" && imports[xIdent.Name] == gospf.REVEL_IMPORT_PATH {
-			return field.Names[0].Obj
+// getValidationHelpers returns the names of the funcs and methods of the
+// package that return the *gospf.Validation, e.g. to share it between
+// actions.
+func getValidationHelpers(pkg *ast.Package, pkgPath string) map[string]bool {
+	helpers := make(map[string]bool)
+	for _, file := range pkg.Files {
+		imports := map[string]string{}
+		for _, decl := range file.Decls {
+			addImports(imports, decl, pkgPath)
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Type.Results == nil || len(funcDecl.Type.Results.List) != 1 {
+				continue
+			}
+			if isValidationType(funcDecl.Type.Results.List[0].Type, imports) {
+				helpers[funcDecl.Name.Name] = true
+			}
 		}
 	}
-	return nil
+	return helpers
+}
+
+// isValidationType reports whether the type expression is *gospf.Validation.
+func isValidationType(expr ast.Expr, imports map[string]string) bool {
+	starExpr, ok := expr.(*ast.StarExpr) // e.g. *gospf.Validation
+	if !ok {
+		return false
+	}
+
+	selExpr, ok := starExpr.X.(*ast.SelectorExpr) // e.g. gospf.Validation
+	if !ok {
+		return false
+	}
+
+	xIdent, ok := selExpr.X.(*ast.Ident) // e.g. rev
+	if !ok {
+		return false
+	}
+
+	return selExpr.Sel.Name == "Validation" && imports[xIdent.Name] == gospf.REVEL_IMPORT_PATH
 }
 
 func (s *TypeInfo) String() string {
@@ -639,9 +1093,12 @@ func getStructTypeDecl(decl ast.Decl, fset *token.FileSet) (spec *ast.TypeSpec,
 // e.g. "github.com/gospf/gospf.Controller"
 func (s *SourceInfo) TypesThatEmbed(targetType string) (filtered []*TypeInfo) {
 	// Do a search in the "embedded type graph", starting with the target type.
+	// The embedded specs link the types of the app to the target type, but are
+	// not among those returned.
 	var (
 		nodeQueue = []string{targetType}
 		processed []string
+		specs     = append(append([]*TypeInfo(nil), s.StructSpecs...), s.embeddedSpecs...)
 	)
 	for len(nodeQueue) > 0 {
 		controllerSimpleName := nodeQueue[0]
@@ -649,7 +1106,7 @@ func (s *SourceInfo) TypesThatEmbed(targetType string) (filtered []*TypeInfo) {
 		processed = append(processed, controllerSimpleName)
 
 		// Look through all known structs.
-		for _, spec := range s.StructSpecs {
+		for i, spec := range specs {
 			// If this one has been processed or is already in nodeQueue, then skip it.
 			if gospf.ContainsString(processed, spec.String()) ||
 				gospf.ContainsString(nodeQueue, spec.String()) {
@@ -663,7 +1120,9 @@ func (s *SourceInfo) TypesThatEmbed(targetType string) (filtered []*TypeInfo) {
 				// the filtered list.
 				if controllerSimpleName == embeddedType.String() {
 					nodeQueue = append(nodeQueue, spec.String())
-					filtered = append(filtered, spec)
+					if i < len(s.StructSpecs) {
+						filtered = append(filtered, spec)
+					}
 					break
 				}
 			}
@@ -674,18 +1133,138 @@ func (s *SourceInfo) TypesThatEmbed(targetType string) (filtered []*TypeInfo) {
 
 func (s *SourceInfo) ControllerSpecs() []*TypeInfo {
 	if s.controllerSpecs == nil {
-		s.controllerSpecs = s.TypesThatEmbed(gospf.REVEL_IMPORT_PATH + ".Controller")
+		s.controllerSpecs = sortTypes(s.withPromotedMethods(s.TypesThatEmbed(gospf.REVEL_IMPORT_PATH + ".Controller")))
 	}
 	return s.controllerSpecs
 }
 
+// withPromotedMethods returns copies of the controllers with the actions that
+// the types they embed promote, as Go does: those of the shallowest embedded
+// types that declare a method of the name, unless two of them at that depth
+// do.  The interceptors of the embedded types that are not controllers are
+// promoted as well, since they are not registered otherwise.
+func (s *SourceInfo) withPromotedMethods(controllers []*TypeInfo) []*TypeInfo {
+	types := make(map[string]*TypeInfo)
+	for _, spec := range s.embeddedSpecs {
+		types[spec.String()] = spec
+	}
+	for _, spec := range s.StructSpecs {
+		types[spec.String()] = spec
+	}
+	isController := make(map[string]bool)
+	for _, spec := range controllers {
+		isController[spec.String()] = true
+	}
+
+	promoted := make([]*TypeInfo, 0, len(controllers))
+	for _, controller := range controllers {
+		spec := *controller
+		spec.MethodSpecs = append([]*MethodSpec(nil), controller.MethodSpecs...)
+		spec.Interceptors = append([]*InterceptorSpec(nil), controller.Interceptors...)
+		declared := make(map[string]bool)
+		for _, method := range spec.MethodSpecs {
+			declared[method.Name] = true
+		}
+		for _, interceptor := range spec.Interceptors {
+			declared[interceptor.Name] = true
+		}
+
+		visited := map[string]bool{controller.String(): true}
+		for depth := controller.embeddedTypes; len(depth) > 0; {
+			var (
+				methods      []*MethodSpec
+				interceptors []*InterceptorSpec
+				count        = make(map[string]int)
+				next         []*embeddedTypeName
+			)
+			for _, embedded := range depth {
+				embeddedSpec := types[embedded.String()]
+				if embeddedSpec == nil || visited[embedded.String()] {
+					continue
+				}
+				visited[embedded.String()] = true
+				for _, method := range embeddedSpec.MethodSpecs {
+					count[method.Name]++
+					methods = append(methods, promotedMethod(method, embeddedSpec, controller))
+				}
+				for _, interceptor := range embeddedSpec.Interceptors {
+					count[interceptor.Name]++
+					if !isController[embeddedSpec.String()] {
+						interceptors = append(interceptors, interceptor)
+					}
+				}
+				next = append(next, embeddedSpec.embeddedTypes...)
+			}
+			for _, method := range methods {
+				if !declared[method.Name] && count[method.Name] == 1 {
+					spec.MethodSpecs = append(spec.MethodSpecs, method)
+				}
+			}
+			for _, interceptor := range interceptors {
+				if !declared[interceptor.Name] && count[interceptor.Name] == 1 {
+					spec.Interceptors = append(spec.Interceptors, interceptor)
+				}
+			}
+			// The methods of this depth, even ambiguous, hide the deeper ones.
+			for name := range count {
+				declared[name] = true
+			}
+			depth = next
+		}
+		promoted = append(promoted, &spec)
+	}
+	return promoted
+}
+
+// promotedMethod returns the method of the embedded type as promoted to the
+// controller.  The types of its arguments that are declared in the package of
+// the embedded type are given its import path, for main.go to import it.
+func promotedMethod(method *MethodSpec, embedded, controller *TypeInfo) *MethodSpec {
+	if embedded.ImportPath == controller.ImportPath {
+		return method
+	}
+	promoted := *method
+	promoted.Args = make([]*MethodArg, len(method.Args))
+	for i, arg := range method.Args {
+		if arg.ImportPath == "" && arg.TypeExpr.PkgName != "" {
+			local := *arg
+			local.ImportPath = embedded.ImportPath
+			arg = &local
+		}
+		promoted.Args[i] = arg
+	}
+	return &promoted
+}
+
 func (s *SourceInfo) TestSuites() []*TypeInfo {
 	if s.testSuites == nil {
-		s.testSuites = s.TypesThatEmbed(gospf.REVEL_IMPORT_PATH + "/testing.TestSuite")
+		s.testSuites = sortTypes(s.TypesThatEmbed(gospf.REVEL_IMPORT_PATH + "/testing.TestSuite"))
 	}
 	return s.testSuites
 }
 
+// sortTypes sorts the types by import path and name, so that they are
+// generated in the same order by every build.
+func sortTypes(specs []*TypeInfo) []*TypeInfo {
+	sort.SliceStable(specs, func(i, j int) bool {
+		if specs[i].ImportPath != specs[j].ImportPath {
+			return specs[i].ImportPath < specs[j].ImportPath
+		}
+		return specs[i].StructName < specs[j].StructName
+	})
+	return specs
+}
+
+// sortedFilenames returns the names of the files of the package in order.
+func sortedFilenames(pkg *ast.Package) []string {
+	filenames := make([]string, 0, len(pkg.Files))
+	for filename := range pkg.Files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
 // TypeExpr provides a type name that may be rewritten to use a package name.
 type TypeExpr struct {
 	Expr     string // The unqualified type expression, e.g. "[]*MyType"
@@ -759,6 +1338,15 @@ func IsBuiltinType(name string) bool {
 }
 
 func importPathFromPath(root string) string {
+	if importPath := workspaceImportPath(root); importPath != "" {
+		return importPath
+	}
+
+	// The directories of the app, e.g. those of codepaths.extra.
+	if rel, err := filepath.Rel(realPath(gospf.BasePath), realPath(root)); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path.Join(gospf.ImportPath, filepath.ToSlash(rel))
+	}
+
 	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
 		srcPath := filepath.Join(gopath, "src")
 		if strings.HasPrefix(root, srcPath) {
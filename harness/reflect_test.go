@@ -1,6 +1,7 @@
 package harness
 
 import (
+	"fmt"
 	"github.com/hubply/gospf"
 	"go/ast"
 	"go/parser"
@@ -43,6 +44,18 @@ func (m Model) Validate(v *gospf.Validation) {
 		Message("Error Message")
 	v.Required(!m.bool)
 }
+
+func (c *Application) aliases(user models.User) {
+	// Line 34
+	v := c.Validation
+	v.Required(user.Name)
+	var w = v
+	w.MinSize(user.Password, 8)
+	c.validation().Required(user.Email)
+	required := c.Validation.Required
+	required(user.Age)
+	checkUser(c.Validation, user)
+}
 `
 
 var expectedValidationKeys = []map[int]string{
@@ -60,6 +73,11 @@ var expectedValidationKeys = []map[int]string{
 		27: "m.name",
 		28: "m.name",
 		30: "m.bool",
+	}, {
+		36: "user.Name",
+		38: "user.Password",
+		39: "user.Email",
+		41: "user.Age",
 	},
 }
 
@@ -72,12 +90,13 @@ func TestGetValidationKeys(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(file.Decls) != 2 {
-		t.Fatal("Expected 2 decl in the source, found", len(file.Decls))
+	if len(file.Decls) != 3 {
+		t.Fatal("Expected 3 decl in the source, found", len(file.Decls))
 	}
 
+	helpers := map[string]bool{"validation": true}
 	for i, decl := range file.Decls {
-		lineKeys := getValidationKeys(fset, decl.(*ast.FuncDecl), map[string]string{"gospf": gospf.REVEL_IMPORT_PATH})
+		lineKeys := getValidationKeys(fset, decl.(*ast.FuncDecl), map[string]string{"gospf": gospf.REVEL_IMPORT_PATH}, helpers)
 		for k, v := range expectedValidationKeys[i] {
 			if lineKeys[k] != v {
 				t.Errorf("Not found - %d: %v - Actual Map: %v", k, v, lineKeys)
@@ -147,9 +166,9 @@ func TestProcessBookingSource(t *testing.T) {
 
 	CONTROLLER_PKG := "github.com/huply/samples/booking/app/controllers"
 	expectedControllerSpecs := []*TypeInfo{
-		{"GorpController", CONTROLLER_PKG, "controllers", nil, nil},
-		{"Application", CONTROLLER_PKG, "controllers", nil, nil},
-		{"Hotels", CONTROLLER_PKG, "controllers", nil, nil},
+		{StructName: "GorpController", ImportPath: CONTROLLER_PKG, PackageName: "controllers"},
+		{StructName: "Application", ImportPath: CONTROLLER_PKG, PackageName: "controllers"},
+		{StructName: "Hotels", ImportPath: CONTROLLER_PKG, PackageName: "controllers"},
 	}
 	if len(sourceInfo.ControllerSpecs()) != len(expectedControllerSpecs) {
 		t.Errorf("Unexpected number of controllers found.  Expected %d, Found %d",
@@ -188,3 +207,305 @@ func BenchmarkProcessBookingSource(b *testing.B) {
 		}
 	}
 }
+
+const jobsSource = `
+package jobs
+
+type Newsletter struct{}
+
+func (j *Newsletter) Run() {}
+
+type Cleanup struct{}
+
+func (j Cleanup) Run() {}
+
+type helper struct{}
+
+type Report struct{}
+
+func (r Report) Run(when string) {}
+`
+
+func TestProcessJobs(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "jobs.go", jobsSource, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &ast.Package{Name: "jobs", Files: map[string]*ast.File{"jobs.go": file}}
+
+	const importPath = "github.com/hubply/samples/booking/app/jobs"
+	sourceInfo := processPackage(fset, importPath, "", pkg)
+
+	var names []string
+	for _, spec := range sourceInfo.JobSpecs {
+		if spec.ImportPath != importPath {
+			t.Errorf("%s expected to have import path %s, actual %s", spec.StructName, importPath, spec.ImportPath)
+		}
+		names = append(names, spec.StructName)
+	}
+	if expected := []string{"Newsletter", "Cleanup"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected jobs %v, got %v", expected, names)
+	}
+	if len(sourceInfo.StructSpecs) != 0 {
+		t.Errorf("Expected no struct specs, got %d", len(sourceInfo.StructSpecs))
+	}
+}
+
+const interceptorsSource = `
+package controllers
+
+import gospf %q
+
+type App struct {
+	*gospf.Controller
+}
+
+func (c App) Before() gospf.Result { return nil }
+
+func (c *App) Finally() gospf.Result { return nil }
+
+func (c App) After() gospf.Result { return nil }
+
+func (c App) Index() gospf.Result { return nil }
+
+func (c App) Panic(message string) gospf.Result { return nil }
+
+func Before(c *gospf.Controller) gospf.Result { return nil }
+
+func After(c *gospf.Controller) gospf.Result { return nil }
+
+func init() {
+	gospf.InterceptMethod(App.After, gospf.AFTER)
+	gospf.InterceptFunc(After, gospf.AFTER, &App{})
+}
+`
+
+const initInterceptorsSource = `
+package app
+
+import (
+	gospf %q
+	controllers "%s"
+)
+
+func init() {
+	gospf.InterceptMethod((*controllers.App).Finally, gospf.FINALLY)
+	gospf.InterceptFunc(controllers.Before, gospf.BEFORE, &controllers.App{})
+}
+`
+
+func TestProcessInterceptors(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "app.go", fmt.Sprintf(interceptorsSource, gospf.REVEL_IMPORT_PATH), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &ast.Package{Name: "controllers", Files: map[string]*ast.File{"app.go": file}}
+
+	const importPath = "github.com/hubply/samples/booking/app/controllers"
+	sourceInfo := processPackage(fset, importPath, "", pkg)
+
+	// The app's init package registers the Finally method and the Before
+	// function of the controllers package as well.
+	initFile, err := parser.ParseFile(fset, "init.go", fmt.Sprintf(initInterceptorsSource, gospf.REVEL_IMPORT_PATH, importPath), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initPkg := &ast.Package{Name: "app", Files: map[string]*ast.File{"init.go": initFile}}
+	sourceInfo = appendSourceInfo(sourceInfo, processPackage(fset, "github.com/hubply/samples/booking/app", "", initPkg))
+	dropRegisteredInterceptors(sourceInfo)
+	if len(sourceInfo.StructSpecs) != 1 {
+		t.Fatalf("Expected one struct spec, got %d", len(sourceInfo.StructSpecs))
+	}
+	spec := sourceInfo.StructSpecs[0]
+
+	var interceptors []string
+	for _, interceptor := range spec.Interceptors {
+		interceptors = append(interceptors, interceptor.When())
+	}
+	if expected := []string{"BEFORE"}; !reflect.DeepEqual(interceptors, expected) {
+		t.Errorf("Expected the interceptor methods %v, got %v", expected, interceptors)
+	}
+
+	var actions []string
+	for _, method := range spec.MethodSpecs {
+		actions = append(actions, method.Name)
+	}
+	if expected := []string{"Index", "Panic"}; !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected the actions %v, got %v", expected, actions)
+	}
+
+	if funcs := sourceInfo.InterceptorFuncs; len(funcs) != 0 {
+		t.Errorf("Expected no interceptor functions, got %v", funcs)
+	}
+}
+
+// This tests that every kind of interceptor is collected, as a method and as
+// a function, and that the declarations with other signatures are not.
+func TestGetInterceptor(t *testing.T) {
+	const source = `
+package controllers
+
+import gospf %q
+
+func (c App) Before() gospf.Result { return nil }
+func (c *App) After() gospf.Result { return nil }
+func (c App) Panic() gospf.Result { return nil }
+func (c App) Finally() gospf.Result { return nil }
+func Before(c *gospf.Controller) gospf.Result { return nil }
+func After(c *gospf.Controller) gospf.Result { return nil }
+func Panic(c *gospf.Controller) gospf.Result { return nil }
+func Finally(c *gospf.Controller) gospf.Result { return nil }
+
+func (c App) Index() gospf.Result { return nil }
+func (c App) Setup() gospf.Result { return nil }
+func (c App) Before(id int) gospf.Result { return nil }
+func Before(c gospf.Controller) gospf.Result { return nil }
+func After(c *gospf.Controller) {}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "app.go", fmt.Sprintf(source, gospf.REVEL_IMPORT_PATH), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := map[string]string{"gospf": gospf.REVEL_IMPORT_PATH}
+
+	var found []string
+	for _, decl := range file.Decls {
+		interceptor, recvTypeName, ok := getInterceptor(fset, decl, "myapp/app/controllers", "controllers", imports)
+		if !ok {
+			continue
+		}
+		if interceptor.PackageName != "controllers" || interceptor.File != "app.go" || interceptor.Line == 0 {
+			t.Errorf("Unexpected description of %s: %+v", interceptor.Name, interceptor)
+		}
+		found = append(found, recvTypeName+"."+interceptor.When())
+	}
+	expected := []string{"App.BEFORE", "App.AFTER", "App.PANIC", "App.FINALLY", ".BEFORE", ".AFTER", ".PANIC", ".FINALLY"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected the interceptors %v, got %v", expected, found)
+	}
+}
+
+// This tests that the specs are in the same order however the files of the
+// package are iterated.
+func TestProcessPackageOrder(t *testing.T) {
+	const header = "package controllers\n\nimport gospf %q\n\n"
+	sources := map[string]string{
+		"b.go": header + "type Users struct{}\n\nfunc (c Users) List() gospf.Result { return nil }\n",
+		"a.go": header + "type Application struct{}\n\nfunc (c Users) Show() gospf.Result { return nil }\n",
+		"c.go": header + "type Admin struct{}\n",
+	}
+	fset := token.NewFileSet()
+	pkg := &ast.Package{Name: "controllers", Files: map[string]*ast.File{}}
+	for filename, source := range sources {
+		file, err := parser.ParseFile(fset, filename, fmt.Sprintf(source, gospf.REVEL_IMPORT_PATH), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg.Files[filename] = file
+	}
+
+	for i := 0; i < 10; i++ {
+		sourceInfo := processPackage(fset, "app/controllers", "", pkg)
+		var structs []string
+		for _, spec := range sourceInfo.StructSpecs {
+			structs = append(structs, spec.StructName)
+		}
+		if expected := []string{"Application", "Users", "Admin"}; !reflect.DeepEqual(structs, expected) {
+			t.Fatalf("Expected the structs %v, got %v", expected, structs)
+		}
+		var methods []string
+		for _, method := range sourceInfo.StructSpecs[1].MethodSpecs {
+			methods = append(methods, method.Name)
+		}
+		if expected := []string{"Show", "List"}; !reflect.DeepEqual(methods, expected) {
+			t.Fatalf("Expected the methods %v, got %v", expected, methods)
+		}
+	}
+
+	specs := sortTypes([]*TypeInfo{
+		{ImportPath: "app/controllers/admin", StructName: "Users"},
+		{ImportPath: "app/controllers", StructName: "Users"},
+		{ImportPath: "app/controllers", StructName: "Application"},
+	})
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.String())
+	}
+	expected := []string{"app/controllers.Application", "app/controllers.Users", "app/controllers/admin.Users"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected the types in the order %v, got %v", expected, names)
+	}
+}
+
+const baseControllerSource = `
+package base
+
+import gospf %q
+
+type Base struct {
+	*gospf.Controller
+}
+
+func (c Base) Before() gospf.Result { return nil }
+
+func (c Base) Health() gospf.Result { return nil }
+
+func (c Base) Index() gospf.Result { return nil }
+
+func (c Base) Search(f Filter) gospf.Result { return nil }
+`
+
+const derivedControllerSource = `
+package controllers
+
+import (
+	base "shared/base"
+	gospf %q
+)
+
+type App struct {
+	base.Base
+}
+
+func (c App) Index() gospf.Result { return nil }
+`
+
+// This tests that a controller embedding a base controller of another package
+// is found, and registered with the actions it inherits.
+func TestPromotedActions(t *testing.T) {
+	parse := func(importPath, name, source string) *SourceInfo {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, name+".go", source, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := &ast.Package{Name: name, Files: map[string]*ast.File{name + ".go": file}}
+		return processPackageAs(fset, importPath, "", pkg, importPath == "shared/base")
+	}
+	sourceInfo := parse("app/controllers", "controllers", fmt.Sprintf(derivedControllerSource, gospf.REVEL_IMPORT_PATH))
+	sourceInfo.embeddedSpecs = parse("shared/base", "base", fmt.Sprintf(baseControllerSource, gospf.REVEL_IMPORT_PATH)).StructSpecs
+
+	controllers := sourceInfo.ControllerSpecs()
+	if len(controllers) != 1 || controllers[0].String() != "app/controllers.App" {
+		t.Fatalf("Expected the controller app/controllers.App, got %v", controllers)
+	}
+	var actions []string
+	for _, method := range controllers[0].MethodSpecs {
+		actions = append(actions, method.Name)
+		if method.Name == "Search" && method.Args[0].ImportPath != "shared/base" {
+			t.Errorf("Expected the argument of Search to be of a type of shared/base, got %q", method.Args[0].ImportPath)
+		}
+	}
+	if expected := []string{"Index", "Health", "Search"}; !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected the actions %v, got %v", expected, actions)
+	}
+	if interceptors := controllers[0].Interceptors; len(interceptors) != 1 || interceptors[0].Name != "Before" {
+		t.Errorf("Expected the interceptor Before to be promoted, got %v", interceptors)
+	}
+	if methods := sourceInfo.embeddedSpecs[0].MethodSpecs; len(methods) != 3 {
+		t.Errorf("Expected the base controller to be unchanged, got %d methods", len(methods))
+	}
+}
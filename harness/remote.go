@@ -0,0 +1,158 @@
+package harness
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// Remote dev mode exposes the harness to the network, e.g. to try the app
+// from a phone.  It is turned on by -remote or:
+//
+//	harness.remote        true to listen on all interfaces
+//	harness.remote.token  the access token, generated on each run by default
+//
+// The harness then listens on all interfaces, and only serves the clients on
+// other machines that have the access token: once opened with the token in
+// the gospf_token query parameter, a browser keeps it in a cookie.  The
+// harness prints the URLs to open, with a QR code if qrencode is installed.
+// The harnesses of a cluster must share the token.
+
+// Remote, if set, overrides harness.remote=false to expose the harness to the
+// network.
+var Remote bool
+
+// remoteTokenName is the name of the query parameter and cookie that hold the
+// access token.
+const remoteTokenName = "gospf_token"
+
+// remoteAccess checks that the requests of other machines have the access
+// token.
+type remoteAccess struct {
+	token string
+}
+
+// loadRemoteAccess returns the remote access of the harness, or nil if it is
+// not exposed to the network.
+func loadRemoteAccess(remote bool) *remoteAccess {
+	if !remote && !gospf.Config.BoolDefault("harness.remote", false) {
+		return nil
+	}
+	token := gospf.Config.StringDefault("harness.remote.token", "")
+	if token == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			gospf.ERROR.Fatalln("Failed to generate the remote access token:", err)
+		}
+		token = hex.EncodeToString(random)
+	}
+	return &remoteAccess{token: token}
+}
+
+// allow reports whether the request may be served.  Otherwise, it has
+// responded: with a redirect that sets the cookie to a request with the
+// token, or with an error.
+func (a *remoteAccess) allow(w http.ResponseWriter, r *http.Request) bool {
+	if isLoopback(r.RemoteAddr) {
+		return true
+	}
+
+	query := r.URL.Query()
+	if token := query.Get(remoteTokenName); token != "" && a.valid(token) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     remoteTokenName,
+			Value:    a.token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		// Take the token out of the address bar and the browser history.
+		query.Del(remoteTokenName)
+		target := *r.URL
+		target.RawQuery = query.Encode()
+		http.Redirect(w, r, target.RequestURI(), http.StatusFound)
+		return false
+	}
+	if cookie, err := r.Cookie(remoteTokenName); err == nil && a.valid(cookie.Value) {
+		stripCookie(r, remoteTokenName)
+		return true
+	}
+
+	http.Error(w, "Forbidden: open the URL that gospf run printed, with the access token.", http.StatusForbidden)
+	return false
+}
+
+func (a *remoteAccess) valid(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// announce prints the URLs of the harness on the network, with the token.
+func (a *remoteAccess) announce(port int) {
+	scheme := "http"
+	if gospf.HttpSsl {
+		scheme = "https"
+	}
+	urls := remoteURLs(scheme, port, a.token)
+	if len(urls) == 0 {
+		gospf.WARN.Println("Found no network address to reach the harness from other machines")
+		return
+	}
+	gospf.INFO.Println("Open the app from other machines at:")
+	for _, url := range urls {
+		gospf.INFO.Println("   ", url)
+	}
+	if qrencode, err := exec.LookPath("qrencode"); err == nil {
+		cmd := exec.Command(qrencode, "-t", "ansiutf8", urls[0])
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		cmd.Run()
+	}
+}
+
+// remoteURLs returns the URLs of the harness at the IPv4 addresses of the
+// machine, other than loopback ones.
+func remoteURLs(scheme string, port int, token string) []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var urls []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		urls = append(urls, fmt.Sprintf("%s://%s:%d/?%s=%s", scheme, ipNet.IP, port, remoteTokenName, token))
+	}
+	return urls
+}
+
+// isLoopback reports whether the remote address of a request is on this
+// machine.
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// stripCookie removes the named cookie from the request, so that the app
+// does not see it.
+func stripCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
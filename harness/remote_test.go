@@ -0,0 +1,53 @@
+package harness
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteAccess(t *testing.T) {
+	access := &remoteAccess{token: "s3cret"}
+	serve := func(remoteAddr, target string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Request, bool) {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = remoteAddr
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		return w, r, access.allow(w, r)
+	}
+
+	if _, _, ok := serve("127.0.0.1:5000", "/", nil); !ok {
+		t.Error("Expected requests from this machine to be served without the token")
+	}
+	if _, _, ok := serve("[::1]:5000", "/", nil); !ok {
+		t.Error("Expected requests from this machine over IPv6 to be served without the token")
+	}
+
+	w, _, ok := serve("192.168.1.20:5000", "/", nil)
+	if ok || w.Code != http.StatusForbidden {
+		t.Errorf("Expected a request without the token to be forbidden, got %d", w.Code)
+	}
+	w, _, ok = serve("192.168.1.20:5000", "/?gospf_token=wrong", nil)
+	if ok || w.Code != http.StatusForbidden {
+		t.Errorf("Expected a request with a wrong token to be forbidden, got %d", w.Code)
+	}
+
+	w, _, ok = serve("192.168.1.20:5000", "/chat?room=1&gospf_token=s3cret", nil)
+	if ok || w.Code != http.StatusFound || w.Header().Get("Location") != "/chat?room=1" {
+		t.Errorf("Expected a redirect without the token, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != remoteTokenName || cookies[0].Value != "s3cret" {
+		t.Fatalf("Expected the token cookie to be set, got %v", cookies)
+	}
+
+	_, r, ok := serve("192.168.1.20:5000", "/chat", cookies[0])
+	if !ok {
+		t.Error("Expected a request with the token cookie to be served")
+	}
+	if _, err := r.Cookie(remoteTokenName); err == nil {
+		t.Error("Expected the token cookie to be stripped from the request to the app")
+	}
+}
@@ -0,0 +1,326 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// With harness.replay=true in dev mode, the harness records the last
+// harness.replay.size (20 by default) requests it proxies to the app, with
+// their responses, and replays them against the app once it is rebuilt: a
+// quick smoke test of each hot reload.  The requests whose status code or
+// body changed are reported on the console, and on the page served under
+// /_gospf/replay, which also replays them on demand.
+//
+// Only GET and HEAD requests are recorded, since replaying the others may
+// change the app's data, unless harness.replay.methods lists more, e.g.
+// "GET, HEAD, POST".  The bodies of the requests and of the responses are
+// recorded up to harness.replay.maxbody bytes (64KB by default): a request
+// with a longer body is not recorded, and only the beginning of a longer
+// response is compared.  With harness.replay.auto=false, the requests are
+// only replayed on demand.
+
+const replayPath = devPathPrefix + "replay"
+
+// Defaults of the harness.replay.* configuration.
+const (
+	defaultReplaySize    = 20
+	defaultReplayMaxBody = 64 << 10
+)
+
+// replayRecorder keeps the last requests proxied to the app, and the report of
+// their last replay.
+type replayRecorder struct {
+	size    int
+	maxBody int64
+	methods map[string]bool
+	auto    bool // Whether the requests are replayed after each rebuild.
+
+	mu       sync.Mutex
+	recorded []*recordedRequest // Oldest first.
+	report   *replayReport      // Of the last replay, if any.
+}
+
+// recordedRequest is a proxied request, and the response of the app to its
+// last run.
+type recordedRequest struct {
+	Method string
+	URL    string // The path and query.
+	Host   string
+	Header http.Header
+	Body   []byte
+
+	Status    int
+	Response  []byte
+	Truncated bool // Whether the response was longer than Response.
+}
+
+// replayResult is the outcome of replaying one request.
+type replayResult struct {
+	Method, URL string
+	Status      int    // The status of the rebuilt app.
+	Diff        string // What changed, if anything.
+}
+
+// replayReport is the outcome of a replay.
+type replayReport struct {
+	Time    time.Time
+	Results []replayResult
+	Changed int
+}
+
+// loadReplayRecorder returns the recorder configured by harness.replay.*, or
+// nil if it is not enabled.
+func loadReplayRecorder() *replayRecorder {
+	if !gospf.Config.BoolDefault("harness.replay", false) {
+		return nil
+	}
+	r := &replayRecorder{
+		size:    gospf.Config.IntDefault("harness.replay.size", defaultReplaySize),
+		maxBody: int64(gospf.Config.IntDefault("harness.replay.maxbody", defaultReplayMaxBody)),
+		methods: make(map[string]bool),
+		auto:    gospf.Config.BoolDefault("harness.replay.auto", true),
+	}
+	for _, method := range strings.Split(gospf.Config.StringDefault("harness.replay.methods", "GET, HEAD"), ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			r.methods[method] = true
+		}
+	}
+	return r
+}
+
+func (r *replayRecorder) register(mux *http.ServeMux, h *Harness) {
+	mux.HandleFunc(replayPath, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			r.replay(h)
+			http.Redirect(w, req, replayPath, http.StatusSeeOther)
+			return
+		}
+		r.serveIndex(w, req)
+	})
+}
+
+// wrap records the request, if its method is recorded, and returns the
+// writer that records the response of the app to it, and the function that
+// keeps the response once the app served it.  It returns w as is if the
+// request is not recorded.
+func (r *replayRecorder) wrap(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, func()) {
+	if !r.methods[req.Method] {
+		return w, func() {}
+	}
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(req.Body, r.maxBody+1))
+		// Pass on the body, read or not, to the app.
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		if err != nil || int64(len(body)) > r.maxBody {
+			return w, func() {}
+		}
+	}
+
+	rec := &recordedRequest{
+		Method: req.Method,
+		URL:    req.URL.RequestURI(),
+		Host:   req.Host,
+		Header: req.Header.Clone(),
+		Body:   body,
+	}
+	rw := &recordingWriter{ResponseWriter: w, maxBody: r.maxBody}
+	return rw, func() {
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		rec.Status, rec.Response, rec.Truncated = status, rw.body.Bytes(), rw.truncated
+		r.recorded = append(r.recorded, rec)
+		if len(r.recorded) > r.size {
+			r.recorded = r.recorded[len(r.recorded)-r.size:]
+		}
+	}
+}
+
+// recordingWriter records the status and the beginning of the body of a
+// response, as the app writes it.
+type recordingWriter struct {
+	http.ResponseWriter
+	maxBody   int64
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if room := w.maxBody - int64(w.body.Len()); int64(len(b)) > room {
+		w.body.Write(b[:room])
+		w.truncated = true
+	} else {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// replay runs the recorded requests against the app, through the proxy,
+// compares their responses with the recorded ones, and keeps the new ones
+// for the next replay.  The changes are logged, and kept for the page.
+func (r *replayRecorder) replay(h *Harness) *replayReport {
+	r.mu.Lock()
+	recorded := make([]*recordedRequest, len(r.recorded))
+	copy(recorded, r.recorded)
+	r.mu.Unlock()
+
+	report := &replayReport{Time: time.Now()}
+	for _, rec := range recorded {
+		req := httptest.NewRequest(rec.Method, rec.URL, bytes.NewReader(rec.Body))
+		req.Host = rec.Host
+		req.Header = rec.Header.Clone()
+		req.RemoteAddr = "127.0.0.1:0"
+
+		resp := httptest.NewRecorder()
+		b := h.route(req).backends[0]
+		b.mu.Lock()
+		ready := b.ready
+		b.mu.Unlock()
+		if ready != nil {
+			<-ready
+		}
+		_, _, _, proxy := b.target()
+		proxy.ServeHTTP(resp, req)
+
+		body := resp.Body.Bytes()
+		truncated := int64(len(body)) > r.maxBody
+		if truncated {
+			body = body[:r.maxBody]
+		}
+		r.mu.Lock()
+		diff := diffResponses(rec.Status, rec.Response, rec.Truncated, resp.Code, body, truncated)
+		rec.Status, rec.Response, rec.Truncated = resp.Code, body, truncated
+		r.mu.Unlock()
+
+		report.Results = append(report.Results, replayResult{
+			Method: rec.Method,
+			URL:    rec.URL,
+			Status: resp.Code,
+			Diff:   diff,
+		})
+		if diff != "" {
+			report.Changed++
+			gospf.WARN.Printf("Replay: %s %s: %s", rec.Method, rec.URL, diff)
+		}
+	}
+	if len(recorded) > 0 {
+		gospf.INFO.Printf("Replayed %d requests: %d changed", len(recorded), report.Changed)
+	}
+
+	r.mu.Lock()
+	r.report = report
+	r.mu.Unlock()
+	return report
+}
+
+// diffResponses describes how a response changed from the recorded one: its
+// status code, or else the first line of its body that differs.  Truncated
+// bodies are only compared up to the limit.  It returns "" if the response
+// did not change.
+func diffResponses(oldStatus int, oldBody []byte, oldTruncated bool, newStatus int, newBody []byte, newTruncated bool) string {
+	if oldStatus != newStatus {
+		return fmt.Sprintf("status %d, was %d", newStatus, oldStatus)
+	}
+	if bytes.Equal(oldBody, newBody) {
+		switch {
+		case oldTruncated == newTruncated:
+			return ""
+		case newTruncated:
+			return "body is longer"
+		default:
+			return "body is shorter"
+		}
+	}
+
+	oldLines, newLines := strings.Split(string(oldBody), "\n"), strings.Split(string(newBody), "\n")
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(newLines):
+			return fmt.Sprintf("body ends at line %d, was %d lines", i, len(oldLines))
+		case i >= len(oldLines):
+			return fmt.Sprintf("body has %d lines, was %d", len(newLines), len(oldLines))
+		case oldLines[i] != newLines[i]:
+			return fmt.Sprintf("body line %d is %q, was %q", i+1, shorten(newLines[i]), shorten(oldLines[i]))
+		}
+	}
+}
+
+// shorten returns the line, cut to a length that fits a log line.
+func shorten(line string) string {
+	const max = 80
+	if len(line) > max {
+		return line[:max] + "..."
+	}
+	return line
+}
+
+func (r *replayRecorder) serveIndex(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	var recorded []recordedRequest
+	for i := len(r.recorded) - 1; i >= 0; i-- {
+		recorded = append(recorded, *r.recorded[i])
+	}
+	report := r.report
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	replayTemplate.Execute(w, map[string]interface{}{
+		"Path":     replayPath,
+		"Recorded": recorded,
+		"Report":   report,
+	})
+}
+
+var replayTemplate = template.Must(template.New("replay").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Request replay</title></head>
+<body>
+<h1>Last replay</h1>
+{{with .Report}}<p>{{.Time.Format "15:04:05"}}: {{len .Results}} requests, {{.Changed}} changed.</p>
+<table>
+<tr><th>Request</th><th>Status</th><th>Change</th></tr>{{range .Results}}
+<tr><td>{{.Method}} {{.URL}}</td><td>{{.Status}}</td><td>{{.Diff}}</td></tr>{{end}}
+</table>{{else}}<p>No replay yet.</p>{{end}}
+<form method="post" action="{{.Path}}"><button>Replay now</button></form>
+<h1>Recorded requests</h1>
+<table>
+<tr><th>Request</th><th>Status</th></tr>{{range .Recorded}}
+<tr><td>{{.Method}} {{.URL}}</td><td>{{.Status}}</td></tr>{{else}}
+<tr><td colspan="2">No requests recorded yet.</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
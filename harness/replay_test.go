@@ -0,0 +1,78 @@
+package harness
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordRequests(t *testing.T) {
+	r := &replayRecorder{
+		size:    2,
+		maxBody: 8,
+		methods: map[string]bool{"GET": true, "POST": true},
+	}
+	app := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("got " + string(body)))
+	})
+	serve := func(method, url, body string) string {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		w, record := r.wrap(resp, req)
+		app.ServeHTTP(w, req)
+		record()
+		return resp.Body.String()
+	}
+
+	if got := serve("POST", "/a", "1234"); got != "got 1234" {
+		t.Errorf("Expected the app to get the body, got %q", got)
+	}
+	if got := serve("POST", "/long", "123456789"); got != "got 123456789" {
+		t.Errorf("Expected the app to get the long body, got %q", got)
+	}
+	serve("DELETE", "/b", "")
+	serve("GET", "/c?x=1", "")
+	serve("GET", "/d", "")
+
+	if len(r.recorded) != 2 {
+		t.Fatalf("Expected the last 2 requests, got %d", len(r.recorded))
+	}
+	if rec := r.recorded[0]; rec.URL != "/c?x=1" || rec.Status != http.StatusCreated || string(rec.Response) != "got " || rec.Truncated {
+		t.Errorf("Unexpected recording: %s %d %q %v", rec.URL, rec.Status, rec.Response, rec.Truncated)
+	}
+	if rec := r.recorded[1]; rec.URL != "/d" {
+		t.Errorf("Expected /d to be recorded last, got %s", rec.URL)
+	}
+}
+
+func TestDiffResponses(t *testing.T) {
+	tests := []struct {
+		oldStatus    int
+		oldBody      string
+		oldTruncated bool
+		newStatus    int
+		newBody      string
+		newTruncated bool
+		expected     string
+	}{
+		{200, "a\nb", false, 200, "a\nb", false, ""},
+		{200, "a\nb", false, 500, "a\nb", false, "status 500, was 200"},
+		{200, "a\nb\nc", false, 200, "a\nx\nc", false, `body line 2 is "x", was "b"`},
+		{200, "a\nb", false, 200, "a\nb\nc", false, "body has 3 lines, was 2"},
+		{200, "a\nb\nc", false, 200, "a\nb", false, "body ends at line 2, was 3 lines"},
+		{200, "abcd", true, 200, "abcd", true, ""},
+		{200, "abcd", false, 200, "abcd", true, "body is longer"},
+	}
+	for _, test := range tests {
+		diff := diffResponses(test.oldStatus, []byte(test.oldBody), test.oldTruncated,
+			test.newStatus, []byte(test.newBody), test.newTruncated)
+		if diff != test.expected {
+			t.Errorf("%d %q to %d %q: expected %q, got %q",
+				test.oldStatus, test.oldBody, test.newStatus, test.newBody, test.expected, diff)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// The resources of the app instances may be limited, so that a runaway build,
+// e.g. one that leaks memory or files in a loop, does not take the machine
+// down with it:
+//
+//	run.maxmem = 512MB   the most memory an instance may use
+//	run.maxfds = 1024    the most files an instance may have open
+//
+// The harness samples the memory resident of each instance, its CPU usage and
+// its open files every run.sample.interval (2s by default), and kills the
+// instances above run.maxmem, whose requests are then shown why.  The app is
+// given GOMEMLIMIT of run.maxmem, unless set, so that it collects its garbage
+// harder before it gets there.  run.maxfds is set as the limit of open files
+// of the app, which then fails to open more; it is only supported on Linux.
+//
+// In dev mode, the last samples are served as JSON under /_gospf/status.
+
+const statusPath = devPathPrefix + "status"
+
+// defaultSampleInterval is how often the resources of the app instances are
+// sampled, unless run.sample.interval says otherwise.
+const defaultSampleInterval = 2 * time.Second
+
+// runLimits are the limits of the resources of the app instances.
+type runLimits struct {
+	MaxMem int64 `json:"maxmemBytes,omitempty"` // The most memory resident, in bytes, or 0.
+	MaxFDs int   `json:"maxfds,omitempty"`      // The most open files, or 0.
+}
+
+// loadRunLimits returns the limits set by run.maxmem and run.maxfds.
+func loadRunLimits() runLimits {
+	var limits runLimits
+	if gospf.Config == nil {
+		return limits
+	}
+	if value := gospf.Config.StringDefault("run.maxmem", ""); value != "" {
+		size, err := parseSize(value)
+		if err != nil || size <= 0 {
+			gospf.WARN.Printf("Invalid run.maxmem %q: the memory of the app is not limited", value)
+		} else {
+			limits.MaxMem = size
+		}
+	}
+	limits.MaxFDs = gospf.Config.IntDefault("run.maxfds", 0)
+	return limits
+}
+
+// sampleInterval returns how often the resources of the app instances are
+// sampled.
+func sampleInterval() time.Duration {
+	interval := defaultSampleInterval
+	if value, found := gospf.Config.String("run.sample.interval"); found {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			gospf.WARN.Printf("Invalid run.sample.interval %q, using %s", value, defaultSampleInterval)
+		} else {
+			interval = parsed
+		}
+	}
+	return interval
+}
+
+// parseSize parses a number of bytes, with an optional unit of 1024 bytes,
+// e.g. "512MB", "1.5G" or "65536".
+func parseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			multiplier = 1 << (10 * uint(i+1))
+			s = strings.TrimSpace(s[:n-1])
+		}
+	}
+	number, err := strconv.ParseFloat(s, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// withMemoryLimit returns the environment of the app, with GOMEMLIMIT set to
+// run.maxmem unless the environment sets it.
+func withMemoryLimit(env []string, limits runLimits) []string {
+	if limits.MaxMem == 0 {
+		return env
+	}
+	for _, keyValue := range env {
+		if strings.HasPrefix(keyValue, "GOMEMLIMIT=") {
+			return env
+		}
+	}
+	return append(env, "GOMEMLIMIT="+strconv.FormatInt(limits.MaxMem, 10))
+}
+
+// applyRunLimits sets run.maxfds as the limit of open files of the started
+// app process.
+func applyRunLimits(pid int, limits runLimits) {
+	if limits.MaxFDs <= 0 {
+		return
+	}
+	if err := setFileLimit(pid, limits.MaxFDs); err != nil {
+		gospf.WARN.Println("Failed to set run.maxfds:", err)
+	}
+}
+
+// resourceSample is the usage of the resources of an app instance.
+type resourceSample struct {
+	Time       time.Time     `json:"time"`
+	RSS        int64         `json:"rssBytes"`      // The memory resident.
+	CPUPercent float64       `json:"cpuPercent"`    // Since the previous sample, of one core.
+	FDs        int           `json:"fds,omitempty"` // The open files, or 0 if unknown.
+	PeakRSS    int64         `json:"peakRssBytes"`  // The most memory resident sampled.
+	cpu        time.Duration // The CPU time used so far.
+}
+
+// processUsage is the usage of the resources of a process, as sampled by
+// sampleProcess.
+type processUsage struct {
+	RSS int64         // The memory resident, in bytes.
+	CPU time.Duration // The CPU time used, by the user and the system.
+	FDs int           // The open files, or 0 if unknown.
+}
+
+// next returns the sample following the previous one, if any.
+func (u processUsage) next(previous *resourceSample, now time.Time) *resourceSample {
+	sample := &resourceSample{Time: now, RSS: u.RSS, FDs: u.FDs, PeakRSS: u.RSS, cpu: u.CPU}
+	if previous != nil {
+		if elapsed := now.Sub(previous.Time); elapsed > 0 {
+			sample.CPUPercent = 100 * float64(u.CPU-previous.cpu) / float64(elapsed)
+		}
+		if previous.PeakRSS > sample.PeakRSS {
+			sample.PeakRSS = previous.PeakRSS
+		}
+	}
+	return sample
+}
+
+// exceeded returns the error that describes how the sample exceeds the
+// limits, or nil if it does not.
+func (s *resourceSample) exceeded(limits runLimits) *gospf.Error {
+	if limits.MaxMem == 0 || s.RSS <= limits.MaxMem {
+		return nil
+	}
+	return &gospf.Error{
+		Title: "App Exceeded Its Memory Limit",
+		Description: fmt.Sprintf("The app used %s of memory, more than run.maxmem (%s), and was killed.  It may leak memory, e.g. in a loop, or need a higher run.maxmem.",
+			FormatSize(s.RSS), FormatSize(limits.MaxMem)),
+	}
+}
+
+// sample samples the resources of the app instance until it exits or is
+// replaced, and kills it if it exceeds the limits.  They are only sampled in
+// dev mode, or to enforce run.maxmem.
+func (b *backend) sample(app *App) {
+	limits := loadRunLimits()
+	pid := app.pid()
+	if pid == 0 || (limits.MaxMem == 0 && !gospf.DevMode) {
+		return
+	}
+	ticker := time.NewTicker(sampleInterval())
+	defer ticker.Stop()
+	var previous *resourceSample
+	for {
+		select {
+		case <-app.Exited():
+			return
+		case <-ticker.C:
+		}
+		if b.currentApp() != app {
+			return
+		}
+		usage, err := sampleProcess(pid)
+		if err != nil {
+			gospf.TRACE.Println("Failed to sample the resources of the app:", err)
+			return
+		}
+		sample := usage.next(previous, time.Now())
+		previous = sample
+		b.mu.Lock()
+		if b.app == app {
+			b.usage = sample
+		}
+		b.mu.Unlock()
+
+		// The monitor reports the exit, with the error.
+		if err := sample.exceeded(limits); err != nil {
+			app.killOverLimit(err)
+			return
+		}
+	}
+}
+
+// instanceStatus is the status of an app instance under /_gospf/status.
+type instanceStatus struct {
+	Mode    string          `json:"mode"`
+	Port    int             `json:"port,omitempty"`
+	Socket  string          `json:"socket,omitempty"`
+	PID     int             `json:"pid,omitempty"`
+	Running bool            `json:"running"`
+	Usage   *resourceSample `json:"usage,omitempty"`
+}
+
+// registerStatus serves the status of the app instances of the harness, with
+// the last samples of their resources.
+func registerStatus(mux *http.ServeMux, h *Harness) {
+	mux.HandleFunc(statusPath, func(w http.ResponseWriter, r *http.Request) {
+		status := struct {
+			Limits    runLimits        `json:"limits"`
+			Instances []instanceStatus `json:"instances"`
+		}{Limits: loadRunLimits(), Instances: []instanceStatus{}}
+		for _, b := range h.backends() {
+			b.mu.Lock()
+			instance := instanceStatus{Mode: b.mode, Port: b.port, Socket: b.socket, Usage: b.usage}
+			if b.app != nil {
+				instance.PID = b.app.pid()
+				instance.Running = instance.PID != 0 && b.crash == nil
+			}
+			b.mu.Unlock()
+			status.Instances = append(status.Instances, instance)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+	})
+}
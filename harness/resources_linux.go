@@ -0,0 +1,86 @@
+package harness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// clockTicks is the unit of the CPU times of /proc, USER_HZ, which is 100 on
+// all the architectures Go supports.
+const clockTicks = 100
+
+// sampleProcess returns the usage of the resources of the process, from
+// /proc.
+func sampleProcess(pid int) (processUsage, error) {
+	var usage processUsage
+	dir := fmt.Sprintf("/proc/%d", pid)
+
+	// statm holds the sizes in pages: the total, then the resident.
+	statm, err := ioutil.ReadFile(dir + "/statm")
+	if err != nil {
+		return usage, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return usage, fmt.Errorf("unexpected %s/statm: %q", dir, statm)
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return usage, err
+	}
+	usage.RSS = pages * int64(os.Getpagesize())
+
+	stat, err := ioutil.ReadFile(dir + "/stat")
+	if err != nil {
+		return usage, err
+	}
+	if usage.CPU, err = parseProcStatCPU(string(stat)); err != nil {
+		return usage, err
+	}
+
+	if fds, err := ioutil.ReadDir(dir + "/fd"); err == nil {
+		usage.FDs = len(fds)
+	}
+	return usage, nil
+}
+
+// parseProcStatCPU returns the CPU time of the process of /proc/PID/stat: its
+// utime and stime, the 14th and 15th fields.  The fields are counted from the
+// end of the name of the command, in parentheses, which may hold spaces.
+func parseProcStatCPU(stat string) (time.Duration, error) {
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("unexpected /proc stat: %q", stat)
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected /proc stat: %q", stat)
+	}
+	var ticks int64
+	for _, field := range fields[11:13] {
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += n
+	}
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
+
+// setFileLimit sets the limit of open files of the process, with prlimit.
+// The hard limit is set as well, so that the app cannot raise it.
+func setFileLimit(pid, max int) error {
+	limit := syscall.Rlimit{Cur: uint64(max), Max: uint64(max)}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), syscall.RLIMIT_NOFILE,
+		uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
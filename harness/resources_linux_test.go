@@ -0,0 +1,31 @@
+package harness
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseProcStatCPU(t *testing.T) {
+	stat := "4242 (my app (dev)) S 1 4242 4242 0 -1 4194560 1234 0 0 0 250 75 0 0 20 0 12 0 100 0 0\n"
+	cpu, err := parseProcStatCPU(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 3250 * time.Millisecond; cpu != expected {
+		t.Errorf("Expected %s, got %s", expected, cpu)
+	}
+	if _, err = parseProcStatCPU("4242 (app) S 1"); err == nil {
+		t.Error("Expected an error for a short stat")
+	}
+}
+
+func TestSampleProcess(t *testing.T) {
+	usage, err := sampleProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.RSS <= 0 || usage.FDs <= 0 {
+		t.Errorf("Unexpected usage of the test: %+v", usage)
+	}
+}
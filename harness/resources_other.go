@@ -0,0 +1,72 @@
+//go:build !linux
+// +build !linux
+
+package harness
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sampleProcess returns the usage of the resources of the process, from ps,
+// without its open files.  It fails where there is no ps, e.g. on Windows.
+func sampleProcess(pid int) (processUsage, error) {
+	var usage processUsage
+	output, err := exec.Command("ps", "-o", "rss=", "-o", "time=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return usage, err
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) < 2 {
+		return usage, fmt.Errorf("unexpected output of ps: %q", output)
+	}
+	kilobytes, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return usage, err
+	}
+	usage.RSS = kilobytes << 10
+	usage.CPU, err = parsePSTime(fields[1])
+	return usage, err
+}
+
+// parsePSTime parses the CPU time of ps, [[dd-]hh:]mm:ss, whose seconds may
+// have a fraction.
+func parsePSTime(value string) (time.Duration, error) {
+	var cpu time.Duration
+	if i := strings.IndexByte(value, '-'); i >= 0 {
+		days, err := strconv.Atoi(value[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		cpu = time.Duration(days) * 24 * time.Hour
+		value = value[i+1:]
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	cpu += time.Duration(seconds * float64(time.Second))
+	unit := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		cpu += time.Duration(n) * unit
+		unit *= 60
+	}
+	return cpu, nil
+}
+
+// setFileLimit is only supported on Linux.
+func setFileLimit(pid, max int) error {
+	return errors.New("run.maxfds is only supported on Linux")
+}
@@ -0,0 +1,66 @@
+package harness
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"65536":  65536,
+		"512MB":  512 << 20,
+		"512 mb": 512 << 20,
+		"1.5G":   3 << 29,
+		"2GiB":   2 << 30,
+		"64k":    64 << 10,
+	} {
+		if size, err := parseSize(value); err != nil || size != expected {
+			t.Errorf("parseSize(%q) = %d, %v, expected %d", value, size, err, expected)
+		}
+	}
+	for _, value := range []string{"", "MB", "lots", "-1GB"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestWithMemoryLimit(t *testing.T) {
+	env := []string{"HOME=/home/me"}
+	if actual := withMemoryLimit(env, runLimits{}); !reflect.DeepEqual(actual, env) {
+		t.Errorf("Expected %v without run.maxmem, got %v", env, actual)
+	}
+	expected := []string{"HOME=/home/me", "GOMEMLIMIT=536870912"}
+	if actual := withMemoryLimit(env, runLimits{MaxMem: 512 << 20}); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+	env = []string{"GOMEMLIMIT=100MiB"}
+	if actual := withMemoryLimit(env, runLimits{MaxMem: 512 << 20}); !reflect.DeepEqual(actual, env) {
+		t.Errorf("Expected the GOMEMLIMIT of the environment, got %v", actual)
+	}
+}
+
+func TestResourceSample(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	first := processUsage{RSS: 300 << 20, CPU: time.Second}.next(nil, start)
+	if first.CPUPercent != 0 || first.PeakRSS != 300<<20 {
+		t.Errorf("Unexpected first sample: %+v", first)
+	}
+	second := processUsage{RSS: 200 << 20, CPU: 2 * time.Second}.next(first, start.Add(4*time.Second))
+	if second.CPUPercent != 25 || second.PeakRSS != 300<<20 || second.RSS != 200<<20 {
+		t.Errorf("Unexpected second sample: %+v", second)
+	}
+
+	if err := second.exceeded(runLimits{}); err != nil {
+		t.Errorf("Expected no error without run.maxmem, got %s", err.Description)
+	}
+	if err := second.exceeded(runLimits{MaxMem: 256 << 20}); err != nil {
+		t.Errorf("Expected no error under run.maxmem, got %s", err.Description)
+	}
+	err := second.exceeded(runLimits{MaxMem: 100 << 20})
+	if err == nil || !strings.Contains(err.Description, "200.0 MB") || !strings.Contains(err.Description, "100.0 MB") {
+		t.Errorf("Unexpected error over run.maxmem: %v", err)
+	}
+}
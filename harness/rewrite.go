@@ -0,0 +1,121 @@
+package harness
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// Behind the proxy, the app listens on an internal port, which it may put in
+// the absolute URLs of its redirects, e.g. "http://localhost:41237/login", and
+// in the domain of its cookies, which then break in the browser.  The proxy
+// rewrites them for the public host of the request:
+//
+//	Location, Content-Location, Refresh  the URLs of the app's internal host
+//	                                     and port get the public host
+//	Set-Cookie                           a Domain of the app's internal host
+//	                                     is set to the public host, or removed
+//	                                     if that is not a domain name
+//
+// harness.proxy.rewrite=false forwards the responses as they are.
+
+// rewrittenHeaders are the headers whose URLs are rewritten.
+var rewrittenHeaders = []string{"Location", "Content-Location", "Refresh"}
+
+// loopbackHosts are the names of the local host, which the app may use for
+// itself whatever its address.
+var loopbackHosts = []string{"localhost", "127.0.0.1", "::1", "0.0.0.0", "::", ""}
+
+// responseRewriter rewrites the responses of an app instance.
+type responseRewriter struct {
+	internal *url.URL // The URL of the app instance.
+}
+
+// newResponseRewriter returns the rewriter of the responses of the app at the
+// URL, or nil if harness.proxy.rewrite is false.
+func newResponseRewriter(internal *url.URL) *responseRewriter {
+	if !gospf.Config.BoolDefault("harness.proxy.rewrite", true) {
+		return nil
+	}
+	return &responseRewriter{internal: internal}
+}
+
+// ModifyResponse rewrites the response for the public host of its request.
+func (rw *responseRewriter) ModifyResponse(resp *http.Response) error {
+	public := resp.Request.Host
+	if public == "" || public == rw.internal.Host {
+		return nil
+	}
+	for _, name := range rewrittenHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			resp.Header.Set(name, rw.rewriteURL(value, public))
+		}
+	}
+	if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		resp.Header.Del("Set-Cookie")
+		for _, cookie := range cookies {
+			resp.Header.Add("Set-Cookie", rw.rewriteCookie(cookie, public))
+		}
+	}
+	return nil
+}
+
+// rewriteURL returns the header value with the URL of the internal host
+// given the public host.  Refresh holds the URL after "url=".
+func (rw *responseRewriter) rewriteURL(value, public string) string {
+	prefix, rawURL := "", value
+	if i := strings.Index(strings.ToLower(value), "url="); i >= 0 {
+		prefix, rawURL = value[:i+len("url=")], value[i+len("url="):]
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" || !rw.isInternal(u.Host) {
+		return value
+	}
+	u.Host = public
+	return prefix + u.String()
+}
+
+// rewriteCookie returns the Set-Cookie value with a Domain of the internal
+// host given the name of the public host, or removed if it is not a domain
+// name, e.g. an IP address or "localhost".
+func (rw *responseRewriter) rewriteCookie(cookie, public string) string {
+	publicName := public
+	if host, _, err := net.SplitHostPort(public); err == nil {
+		publicName = host
+	}
+	attrs := strings.Split(cookie, ";")
+	kept := attrs[:1]
+	for _, attr := range attrs[1:] {
+		name, value := strings.TrimSpace(attr), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+		}
+		if !strings.EqualFold(name, "Domain") || !rw.isInternalName(strings.TrimPrefix(value, ".")) ||
+			strings.EqualFold(strings.TrimPrefix(value, "."), publicName) {
+			kept = append(kept, attr)
+			continue
+		}
+		if strings.Contains(publicName, ".") && net.ParseIP(publicName) == nil {
+			kept = append(kept, " Domain="+publicName)
+		}
+	}
+	return strings.Join(kept, ";")
+}
+
+// isInternal reports whether the host and port are those of the app
+// instance: its port, on its address or a name of the local host.
+func (rw *responseRewriter) isInternal(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport == rw.internal.Host
+	}
+	return port == rw.internal.Port() && rw.isInternalName(host)
+}
+
+// isInternalName reports whether the host name is that of the app instance.
+func (rw *responseRewriter) isInternalName(host string) bool {
+	return strings.EqualFold(host, rw.internal.Hostname()) || gospf.ContainsString(loopbackHosts, strings.ToLower(host))
+}
@@ -0,0 +1,42 @@
+package harness
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteResponse(t *testing.T) {
+	internal, _ := url.Parse("http://localhost:41237")
+	rw := &responseRewriter{internal: internal}
+	req, _ := http.NewRequest("GET", "http://dev.example.com:9000/login", nil)
+	resp := &http.Response{Request: req, Header: http.Header{}}
+	resp.Header.Set("Location", "http://127.0.0.1:41237/app?x=1")
+	resp.Header.Set("Content-Location", "https://other.example.com:41237/")
+	resp.Header.Set("Refresh", "5; url=http://localhost:41237/done")
+	resp.Header.Add("Set-Cookie", "session=abc; Path=/; Domain=localhost; HttpOnly")
+	resp.Header.Add("Set-Cookie", "lang=en; Domain=example.com")
+
+	if err := rw.ModifyResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	expected := http.Header{
+		"Location":         {"http://dev.example.com:9000/app?x=1"},
+		"Content-Location": {"https://other.example.com:41237/"},
+		"Refresh":          {"5; url=http://dev.example.com:9000/done"},
+		"Set-Cookie":       {"session=abc; Path=/; Domain=dev.example.com; HttpOnly", "lang=en; Domain=example.com"},
+	}
+	if !reflect.DeepEqual(resp.Header, expected) {
+		t.Errorf("Expected %v, got %v", expected, resp.Header)
+	}
+}
+
+func TestRewriteCookieWithoutDomainName(t *testing.T) {
+	internal, _ := url.Parse("http://localhost:41237")
+	rw := &responseRewriter{internal: internal}
+	cookie := rw.rewriteCookie("session=abc; Domain=.localhost; Path=/", "192.168.1.5:9000")
+	if expected := "session=abc; Path=/"; cookie != expected {
+		t.Errorf("Expected %q, got %q", expected, cookie)
+	}
+}
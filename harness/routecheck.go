@@ -0,0 +1,274 @@
+package harness
+
+import (
+	"fmt"
+	"go/ast"
+	"path/filepath"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// When the routes are generated, conf/routes is checked against the actions
+// of the controllers, so that a route that would answer 404 is caught at
+// build time.  The console warns of:
+//
+//	routes to actions that no controller declares
+//	exported actions of the app that no route refers to
+//	routes to actions whose doc comment has a "Deprecated:" paragraph
+//
+// With routes.strict=true, the routes to missing controllers or actions fail
+// the build instead.  The actions without a route are only warned of, as they
+// may be exported for other reasons, e.g. as helpers of the other actions or
+// of the tests.  A route whose controller is a parameter, e.g.
+// ":controller.:action", refers to all the actions, and one whose action is,
+// e.g. "App.:action", to all those of the controller.  The actions of the
+// modules, which have their own routes, are not required to have one in
+// conf/routes.  gospf doctor checks the routes the same way.
+
+// routeLine is a route of the routes file.
+type routeLine struct {
+	Line         int
+	Method, Path string
+	Controller   string // e.g. "App", or "" if it is a parameter.
+	Action       string // e.g. "Index", or "" if it is a parameter.
+}
+
+func (r routeLine) String() string {
+	return r.Method + " " + r.Path
+}
+
+// RouteFinding is a mismatch between the routes and the actions.
+type RouteFinding struct {
+	Path    string // The source file of the action, or "" for a line of the routes file.
+	Line    int
+	Message string
+	Strict  bool // Whether it fails the build with routes.strict=true.
+}
+
+func (f RouteFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s", f.Path, f.Line, f.Message)
+}
+
+// checkRoutes checks conf/routes against the actions of the controllers,
+// logs the mismatches, and returns the first one that fails the build with
+// routes.strict=true as an error.  There is nothing to check without the
+// routes file.
+func checkRoutes(controllers []*TypeInfo) *gospf.Error {
+	routesPath := filepath.Join(gospf.BasePath, "conf", "routes")
+	lines, err := gospf.ReadLines(routesPath)
+	if err != nil {
+		return nil
+	}
+
+	var moduleImportPaths []string
+	for _, module := range gospf.Modules {
+		moduleImportPaths = append(moduleImportPaths, module.ImportPath)
+	}
+	strict := gospf.Config.BoolDefault("routes.strict", false)
+
+	var failed []RouteFinding
+	findings, _ := MatchRoutes(lines, controllers, moduleImportPaths)
+	for _, finding := range findings {
+		if finding.Path == "" {
+			finding.Path = "conf/routes"
+		} else {
+			finding.Path = displayPath(finding.Path)
+		}
+		if strict && finding.Strict {
+			gospf.ERROR.Println(finding)
+			failed = append(failed, finding)
+		} else {
+			gospf.WARN.Println(finding)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return newRouteError(failed[0], len(failed))
+}
+
+// newRouteError returns the error of the finding, among count.
+func newRouteError(finding RouteFinding, count int) *gospf.Error {
+	description := finding.Message + "."
+	if count > 1 {
+		description += fmt.Sprintf("  %d more mismatches are listed on the console.", count-1)
+	}
+	routeError := &gospf.Error{
+		Title:       "Route Mismatch",
+		Path:        finding.Path,
+		Line:        finding.Line,
+		Description: description + "  Set routes.strict=false to only warn of them.",
+	}
+	if finding.Path == "conf/routes" {
+		routeError.SourceType = "routes"
+	} else {
+		routeError.SourceType = "Go code"
+	}
+	absPath := filepath.FromSlash(finding.Path)
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(gospf.BasePath, absPath)
+	}
+	if lines, err := gospf.ReadLines(absPath); err == nil {
+		routeError.SourceLines = lines
+	}
+	setErrorLink(routeError)
+	return routeError
+}
+
+// parseRouteLines returns the routes of the lines of a routes file, other than
+// the modules' routes included and those that answer 404.
+func parseRouteLines(lines []string) []routeLine {
+	var routes []routeLine
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "module:") {
+			continue
+		}
+		action := strings.Join(fields[2:], " ")
+		if j := strings.Index(action, "("); j >= 0 {
+			action = action[:j]
+		}
+		action = strings.TrimSpace(action)
+		if action == "404" {
+			continue
+		}
+		route := routeLine{Line: i + 1, Method: strings.ToUpper(fields[0]), Path: fields[1]}
+		dot := strings.LastIndex(action, ".")
+		if dot < 0 {
+			route.Controller = action
+		} else {
+			route.Controller, route.Action = action[:dot], action[dot+1:]
+		}
+		if isRouteParam(route.Controller) {
+			route.Controller = ""
+		}
+		if isRouteParam(route.Action) {
+			route.Action = ""
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// isRouteParam reports whether the name of a controller or action is a
+// parameter of the route, e.g. ":action" or "{action}".
+func isRouteParam(name string) bool {
+	return strings.ContainsAny(name, ":{*")
+}
+
+// MatchRoutes returns the mismatches between the lines of a routes file and
+// the actions of the controllers, in the order of the routes and then of the
+// actions, and the actions that the routes refer to, e.g. "App.Index", or nil
+// if a route refers to all of them.  The actions of the controllers of the
+// given modules' import paths need no route.  The routes to the Static module
+// are left out, since the module may not be loaded in every run mode.
+// Controllers and actions are matched without regard to case, as the router
+// does.
+func MatchRoutes(lines []string, controllers []*TypeInfo, moduleImportPaths []string) ([]RouteFinding, map[string]bool) {
+	routes := parseRouteLines(lines)
+	byName := make(map[string]*TypeInfo, len(controllers))
+	for _, spec := range controllers {
+		byName[strings.ToLower(spec.StructName)] = spec
+	}
+
+	var findings []RouteFinding
+	routed := make(map[string]bool) // By controller and action, e.g. "App.Index".
+	allRouted := false
+	for _, route := range routes {
+		if route.Controller == "" {
+			allRouted = true
+			continue
+		}
+		if strings.EqualFold(route.Controller, "Static") {
+			continue
+		}
+		spec := byName[strings.ToLower(route.Controller)]
+		if spec == nil {
+			findings = append(findings, RouteFinding{
+				Line:    route.Line,
+				Message: fmt.Sprintf("%s refers to the controller %s, which does not exist", route, route.Controller),
+				Strict:  true,
+			})
+			continue
+		}
+		if route.Action == "" {
+			for _, method := range spec.MethodSpecs {
+				routed[spec.StructName+"."+method.Name] = true
+			}
+			continue
+		}
+		method := findMethodSpec(spec, route.Action)
+		if method == nil {
+			findings = append(findings, RouteFinding{
+				Line:    route.Line,
+				Message: fmt.Sprintf("%s refers to %s.%s, which is not an action of the controller", route, spec.StructName, route.Action),
+				Strict:  true,
+			})
+			continue
+		}
+		routed[spec.StructName+"."+method.Name] = true
+		if method.Deprecated != "" {
+			findings = append(findings, RouteFinding{
+				Line:    route.Line,
+				Message: fmt.Sprintf("%s refers to %s.%s, which is deprecated: %s", route, spec.StructName, method.Name, method.Deprecated),
+			})
+		}
+	}
+
+	if allRouted {
+		return findings, nil
+	}
+	for _, spec := range controllers {
+		if isModuleImportPath(spec.ImportPath, moduleImportPaths) {
+			continue
+		}
+		for _, method := range spec.MethodSpecs {
+			if routed[spec.StructName+"."+method.Name] || method.Deprecated != "" {
+				continue
+			}
+			findings = append(findings, RouteFinding{
+				Path:    method.File,
+				Line:    method.Line,
+				Message: fmt.Sprintf("no route refers to the action %s.%s", spec.StructName, method.Name),
+			})
+		}
+	}
+	return findings, routed
+}
+
+// findMethodSpec returns the action of the controller of the name, regardless
+// of case, or nil if there is none.
+func findMethodSpec(spec *TypeInfo, name string) *MethodSpec {
+	for _, method := range spec.MethodSpecs {
+		if strings.EqualFold(method.Name, name) {
+			return method
+		}
+	}
+	return nil
+}
+
+// isModuleImportPath reports whether the import path is that of one of the
+// modules, or of one of their packages.
+func isModuleImportPath(importPath string, moduleImportPaths []string) bool {
+	for _, modulePath := range moduleImportPaths {
+		if importPath == modulePath || strings.HasPrefix(importPath, modulePath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// deprecationNotice returns the paragraph of the doc comment that starts with
+// "Deprecated:", without it, or "" if there is none.
+func deprecationNotice(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	for _, paragraph := range strings.Split(doc.Text(), "\n\n") {
+		if notice := strings.TrimPrefix(paragraph, "Deprecated:"); notice != paragraph {
+			return strings.Join(strings.Fields(notice), " ")
+		}
+	}
+	return ""
+}
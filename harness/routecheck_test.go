@@ -0,0 +1,106 @@
+package harness
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestParseRouteLines(t *testing.T) {
+	routes := parseRouteLines([]string{
+		"# Routes",
+		"module:testrunner",
+		"",
+		"GET     /                       App.Index",
+		"post    /hotels/:id             Hotels.Save(id)",
+		"GET     /favicon.ico            404",
+		"GET     /app/:action            App.:action",
+		"*       /:controller/:action    :controller.:action",
+	})
+	expected := []routeLine{
+		{Line: 4, Method: "GET", Path: "/", Controller: "App", Action: "Index"},
+		{Line: 5, Method: "POST", Path: "/hotels/:id", Controller: "Hotels", Action: "Save"},
+		{Line: 7, Method: "GET", Path: "/app/:action", Controller: "App"},
+		{Line: 8, Method: "*", Path: "/:controller/:action"},
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, routes)
+	}
+}
+
+func TestMatchRoutes(t *testing.T) {
+	controllers := []*TypeInfo{{
+		StructName: "App",
+		ImportPath: "myapp/app/controllers",
+		MethodSpecs: []*MethodSpec{
+			{Name: "Index", File: "app.go", Line: 10},
+			{Name: "Login", File: "app.go", Line: 20},
+			{Name: "Old", File: "app.go", Line: 30, Deprecated: "Use Index."},
+			{Name: "Unused", File: "app.go", Line: 40},
+		},
+	}, {
+		StructName:  "TestRunner",
+		ImportPath:  "github.com/gospf/modules/testrunner/app/controllers",
+		MethodSpecs: []*MethodSpec{{Name: "Index", File: "testrunner.go", Line: 5}},
+	}}
+	lines := []string{
+		"GET  /          App.Index",
+		"POST /login     app.login",
+		"GET  /old       App.Old",
+		"GET  /missing   App.Missing",
+		"GET  /hotels    Hotels.List",
+		"GET  /public/*  Static.Serve(\"public\")",
+	}
+	modules := []string{"github.com/gospf/modules/testrunner"}
+
+	expected := []RouteFinding{
+		{Line: 3, Message: "GET /old refers to App.Old, which is deprecated: Use Index."},
+		{Line: 4, Message: "GET /missing refers to App.Missing, which is not an action of the controller", Strict: true},
+		{Line: 5, Message: "GET /hotels refers to the controller Hotels, which does not exist", Strict: true},
+		{Path: "app.go", Line: 40, Message: "no route refers to the action App.Unused"},
+	}
+	findings, routed := MatchRoutes(lines, controllers, modules)
+	if !reflect.DeepEqual(findings, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, findings)
+	}
+	if expected := map[string]bool{"App.Index": true, "App.Login": true, "App.Old": true}; !reflect.DeepEqual(routed, expected) {
+		t.Errorf("Expected the routed actions %v, got %v", expected, routed)
+	}
+
+	lines = append(lines, "GET /app/:action App.:action")
+	if findings, _ := MatchRoutes(lines, controllers, modules); len(findings) != 3 {
+		t.Errorf("Expected no unrouted action with App.:action, got %+v", findings)
+	}
+	findings, routed = MatchRoutes([]string{"* /:controller/:action :controller.:action"}, controllers, nil)
+	if len(findings) != 0 || routed != nil {
+		t.Errorf("Expected no findings and all the actions routed with a catch-all route, got %+v and %v", findings, routed)
+	}
+}
+
+func TestDeprecationNotice(t *testing.T) {
+	src := `package controllers
+
+// Old shows the old page.
+//
+// Deprecated: use Index,
+// which is faster.
+func (c App) Old() {}
+
+// Index shows the page.
+func (c App) Index() {}
+
+func (c App) Bare() {}
+`
+	file, err := parser.ParseFile(token.NewFileSet(), "app.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"use Index, which is faster.", "", ""}
+	for i, decl := range file.Decls {
+		if notice := deprecationNotice(decl.(*ast.FuncDecl).Doc); notice != expected[i] {
+			t.Errorf("Expected %q for declaration %d, got %q", expected[i], i, notice)
+		}
+	}
+}
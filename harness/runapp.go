@@ -0,0 +1,70 @@
+package harness
+
+import (
+	"github.com/hubply/gospf"
+)
+
+// RunOptions are the options of running an app, as set by the flags and the
+// arguments of "gospf run".  Programmatic callers may set them as well, along
+// with the package variables such as Instances and Modes.
+type RunOptions struct {
+	ImportPath string // The import path of the app.
+	Mode       string // The run mode, "dev" by default.
+	Port       int    // The port to listen on, overriding http.port, if set.
+	Addr       string // The address to listen on, overriding http.addr, if set.
+	NoWatch    bool   // Builds and runs the app once, without the harness.
+}
+
+// RunApp runs the app as "gospf run" does: with the harness, which watches
+// the source and rebuilds the app, unless NoWatch is set or the app's
+// configuration sets watch or watch.code to false, in which case it builds
+// the app once and runs it.  It never returns in watched mode, and returns
+// once the app exits otherwise.
+func RunApp(opts RunOptions) *gospf.Error {
+	mode := opts.Mode
+	if mode == "" {
+		mode = "dev"
+	}
+
+	// Find and parse app.conf
+	if err := InitApp(mode, opts.ImportPath); err != nil {
+		return err
+	}
+	for _, other := range Modes {
+		if _, err := ModeChain(other); err != nil {
+			return err
+		}
+	}
+	gospf.LoadMimeConfig()
+	if opts.Port != 0 {
+		gospf.HttpPort = opts.Port
+	}
+	if opts.Addr != "" {
+		gospf.HttpAddr = opts.Addr
+	}
+
+	gospf.INFO.Printf("Running %s (%s) in %s mode\n", gospf.AppName, gospf.ImportPath, mode)
+	gospf.TRACE.Println("Base path:", gospf.BasePath)
+
+	// If the app is run in "watched" mode, use the harness to run it.
+	if !opts.NoWatch && gospf.Config.BoolDefault("watch", true) && gospf.Config.BoolDefault("watch.code", true) {
+		gospf.TRACE.Println("Running in watched mode.")
+		NewHarness().Run() // Never returns.
+	}
+
+	// Else, just build and run the app.
+	gospf.TRACE.Println("Running in live build mode.")
+	if Instances > 1 {
+		gospf.WARN.Println("Running a single instance: -n requires watched mode.")
+	}
+	if len(Modes) > 1 {
+		gospf.WARN.Printf("Running in %s mode only: -modes requires watched mode.", mode)
+	}
+	app, err := Build()
+	if err != nil {
+		return err
+	}
+	app.Port, app.Addr = gospf.HttpPort, opts.Addr
+	app.Cmd().Run()
+	return nil
+}
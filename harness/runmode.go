@@ -0,0 +1,88 @@
+package harness
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// The run modes of an app are the sections of its app.conf, other than those
+// of the build profiles and of the command line.  A mode may extend another
+// with mode.extends, taking the options that it does not set from it, and
+// from the modes that one extends in turn, e.g.
+//
+//	[prod]
+//	db.host = db.internal
+//	http.port = 80
+//
+//	[staging]
+//	mode.extends = prod
+//	http.port = 8080
+//
+// Only the mode's own section may set mode.extends.  The commands check the
+// run mode as they load the app, and fail with the modes defined if it is
+// unknown, rather than run the app with the options of no mode.  The app
+// inherits the options as the harness does, with the same code: see
+// initRunMode.
+
+// The sections of app.conf that are not run modes: the options outside of any
+// section, and the defaults of the command line.
+var nonModeSections = []string{"DEFAULT", "cli"}
+
+// IsModeSection reports whether the section of app.conf is a run mode.
+func IsModeSection(section string) bool {
+	return !gospf.ContainsString(nonModeSections, section) && !strings.HasPrefix(section, profileSectionPrefix)
+}
+
+// RunModes returns the run modes defined in app.conf, sorted.
+func RunModes() []string {
+	var modes []string
+	for _, section := range gospf.Config.Raw().Sections() {
+		if IsModeSection(section) {
+			modes = append(modes, section)
+		}
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+// ModeChain returns the run mode followed by the modes it extends, nearest
+// first.  It returns an error if a mode of the chain is not defined, or if
+// the modes extend each other.
+func ModeChain(mode string) ([]string, *gospf.Error) {
+	modes := RunModes()
+	var chain []string
+	for mode != "" {
+		if gospf.ContainsString(chain, mode) {
+			return nil, newModeError(fmt.Sprintf("The run modes extend each other: %s, %s.", strings.Join(chain, ", "), mode))
+		}
+		if !gospf.ContainsString(modes, mode) {
+			defined := "none"
+			if len(modes) > 0 {
+				defined = strings.Join(modes, ", ")
+			}
+			if len(chain) == 0 {
+				return nil, newModeError(fmt.Sprintf("Unknown run mode %q: app.conf has no [%s] section.  The run modes defined are: %s.", mode, mode, defined))
+			}
+			return nil, newModeError(fmt.Sprintf("[%s] extends %q, which app.conf does not define.  The run modes defined are: %s.", chain[len(chain)-1], mode, defined))
+		}
+		chain = append(chain, mode)
+		mode = extendedMode(gospf.Config, mode)
+	}
+	return chain, nil
+}
+
+// InitApp initializes gospf for the app in the run mode, with the options of
+// the modes it extends, and checks the mode.
+func InitApp(mode, importPath string) *gospf.Error {
+	initRunMode(mode, importPath, "")
+	_, err := ModeChain(mode)
+	return err
+}
+
+func newModeError(description string) *gospf.Error {
+	return newConfigError(filepath.Join("conf", "app.conf"), description)
+}
@@ -0,0 +1,104 @@
+package harness
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hubply/gospf"
+)
+
+func TestIsModeSection(t *testing.T) {
+	for section, expected := range map[string]bool{
+		"dev":                 true,
+		"staging":             true,
+		"DEFAULT":             false,
+		"cli":                 false,
+		"build.profile.debug": false,
+	} {
+		if IsModeSection(section) != expected {
+			t.Errorf("IsModeSection(%q) = %v, expected %v", section, !expected, expected)
+		}
+	}
+}
+
+func TestInheritRunMode(t *testing.T) {
+	dir := t.TempDir()
+	conf := `mode.extends = prod
+db.host = localhost
+
+[prod]
+db.host = db.internal
+http.port = 80
+
+[staging]
+mode.extends = prod
+http.port = 8080
+
+[loop]
+mode.extends = loop
+
+[orphan]
+mode.extends = missing
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.conf"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(paths []string) { gospf.ConfPaths = paths }(gospf.ConfPaths)
+	gospf.ConfPaths = []string{dir}
+
+	for mode, expected := range map[string][]string{
+		"prod":    {"prod"},
+		"staging": {"staging", "prod"},
+		"loop":    {"loop"},
+		"orphan":  {"orphan"},
+	} {
+		config, err := gospf.LoadConfig("app.conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.SetSection(mode)
+		if chain, _ := inheritRunMode(config, mode); !reflect.DeepEqual(chain, expected) {
+			t.Errorf("Expected the chain %v for [%s], got %v", expected, mode, chain)
+		}
+	}
+
+	config, _ := gospf.LoadConfig("app.conf")
+	config.SetSection("staging")
+	if _, inherited := inheritRunMode(config, "staging"); !reflect.DeepEqual(inherited, []string{"db.host"}) {
+		t.Errorf("Expected [staging] to inherit db.host, got %v", inherited)
+	}
+	for key, expected := range map[string]string{"db.host": "db.internal", "http.port": "8080"} {
+		if value, _ := config.String(key); value != expected {
+			t.Errorf("Expected %s = %s in [staging], got %s", key, expected, value)
+		}
+	}
+}
+
+func TestRouteModes(t *testing.T) {
+	dev, admin := &pool{mode: "dev"}, &pool{mode: "admin"}
+	hp := &Harness{pools: []*pool{dev, admin}, routing: "prefix"}
+	tests := []struct {
+		path         string
+		expected     *pool
+		strippedPath string
+		prefix       string
+	}{
+		{"/admin/users?page=2", admin, "/users", "/admin"},
+		{"/admin", admin, "/", "/admin"},
+		{"/administrators", dev, "/administrators", ""},
+		{"/", dev, "/", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if p := hp.route(r); p != test.expected {
+			t.Errorf("Expected %s to be routed to the mode %s, got %s", test.path, test.expected.mode, p.mode)
+		}
+		if r.URL.Path != test.strippedPath || r.Header.Get("X-Forwarded-Prefix") != test.prefix {
+			t.Errorf("Expected %s to be proxied as %s with the prefix %q, got %s with %q",
+				test.path, test.strippedPath, test.prefix, r.URL.Path, r.Header.Get("X-Forwarded-Prefix"))
+		}
+	}
+}
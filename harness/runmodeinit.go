@@ -0,0 +1,89 @@
+package harness
+
+import (
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// This file is compiled into the harness, and into the generated main package
+// of the app as runmode.go, so that the app and the harness initialize the run
+// mode the same way.  It may only use gospf and the standard library.
+
+// modeExtendsKey is the option of a run mode that names the mode it extends.
+const modeExtendsKey = "mode.extends"
+
+// initKeys are the options that gospf.Init reads into its own state, which
+// can't be read again once the options of the modes extended are inherited.
+// The prefixes end with a dot.
+var initKeys = []string{"app.root", "app.secret", "cookie.httponly", "cookie.secure", "log.", "module.", "template.delimiters"}
+
+// initRunMode initializes gospf for the app in the run mode, as gospf.Init,
+// with the options of the modes it extends, and returns the run mode followed
+// by the modes it extends.  gospf.Init reads app.conf itself, so the settings
+// it takes from the options are set again once they are inherited, and the
+// inherited options it keeps to itself are reported.
+func initRunMode(mode, importPath, srcPath string) []string {
+	gospf.Init(mode, importPath, srcPath)
+	chain, inherited := inheritRunMode(gospf.Config, mode)
+	if len(chain) < 2 {
+		return chain
+	}
+	gospf.AppName = gospf.Config.StringDefault("app.name", gospf.AppName)
+	gospf.CookiePrefix = gospf.Config.StringDefault("cookie.prefix", gospf.CookiePrefix)
+	gospf.DevMode = gospf.Config.BoolDefault("mode.dev", gospf.DevMode)
+	gospf.HttpAddr = gospf.Config.StringDefault("http.addr", gospf.HttpAddr)
+	gospf.HttpPort = gospf.Config.IntDefault("http.port", gospf.HttpPort)
+	gospf.HttpSsl = gospf.Config.BoolDefault("http.ssl", gospf.HttpSsl)
+	gospf.HttpSslCert = gospf.Config.StringDefault("http.sslcert", gospf.HttpSslCert)
+	gospf.HttpSslKey = gospf.Config.StringDefault("http.sslkey", gospf.HttpSslKey)
+	for _, key := range inherited {
+		for _, initKey := range initKeys {
+			if key == initKey || strings.HasSuffix(initKey, ".") && strings.HasPrefix(key, initKey) {
+				gospf.WARN.Printf("[%s] inherits %s, which gospf reads before the modes extended are applied.  Set it in [%s] itself.", mode, key, mode)
+			}
+		}
+	}
+	return chain
+}
+
+// inheritRunMode gives the run mode the options of the modes it extends that
+// it does not set, those of the nearest mode first.  It returns the run mode
+// followed by the modes it extends, up to the first that repeats or is not
+// defined, and the options inherited.
+func inheritRunMode(config *gospf.MergedConfig, mode string) (chain, inherited []string) {
+	raw := config.Raw()
+	set := make(map[string]bool)
+	for extended := mode; extended != "" && !gospf.ContainsString(chain, extended); extended = extendedMode(config, extended) {
+		options, err := raw.SectionOptions(extended)
+		if err != nil {
+			break
+		}
+		chain = append(chain, extended)
+		for _, key := range options {
+			if set[key] {
+				continue
+			}
+			set[key] = true
+			if extended != mode {
+				value, _ := raw.String(extended, key)
+				raw.AddOption(mode, key, value)
+				inherited = append(inherited, key)
+			}
+		}
+	}
+	return chain, inherited
+}
+
+// extendedMode returns the mode that the run mode extends, or "" if it extends
+// none.  Only the mode's own section is read: a mode.extends of the DEFAULT
+// section is not that of every mode.
+func extendedMode(config *gospf.MergedConfig, mode string) string {
+	raw := config.Raw()
+	options, _ := raw.SectionOptions(mode)
+	if !gospf.ContainsString(options, modeExtendsKey) {
+		return ""
+	}
+	extended, _ := raw.String(mode, modeExtendsKey)
+	return strings.TrimSpace(extended)
+}
@@ -0,0 +1,101 @@
+package harness
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// The session inspector, served in dev mode under /_gospf/session, decodes a
+// session cookie of the app with the app.secret, to show what it holds.  It
+// defaults to the session cookie sent with the request itself.
+
+const sessionInspectorPath = devPathPrefix + "session"
+
+// sessionEntry is a key and value of a decoded session.
+type sessionEntry struct {
+	Key, Value string
+}
+
+func registerSessionInspector(mux *http.ServeMux) {
+	mux.HandleFunc(sessionInspectorPath, serveSessionInspector)
+}
+
+func serveSessionInspector(w http.ResponseWriter, r *http.Request) {
+	cookieName := gospf.CookiePrefix + "_SESSION"
+	value := strings.TrimSpace(r.FormValue("cookie"))
+	if value == "" {
+		if cookie, err := r.Cookie(cookieName); err == nil {
+			value = cookie.Value
+		}
+	}
+
+	data := map[string]interface{}{
+		"Path":       sessionInspectorPath,
+		"CookieName": cookieName,
+		"Cookie":     value,
+	}
+	if value != "" {
+		entries, err := decodeSession(value)
+		if err != "" {
+			data["Error"] = err
+		}
+		data["Entries"] = entries
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	sessionTemplate.Execute(w, data)
+}
+
+// decodeSession returns the entries of the given session cookie value, sorted
+// by key, and a description of the problem if it is not valid.  The entries
+// of a cookie with a bad signature are returned nonetheless.
+func decodeSession(value string) ([]sessionEntry, string) {
+	// Pasted values may still be URL-encoded.
+	if unescaped, err := url.QueryUnescape(value); err == nil && strings.Contains(value, "%") {
+		value = unescaped
+	}
+
+	hyphen := strings.Index(value, "-")
+	if hyphen == -1 || hyphen >= len(value)-1 {
+		return nil, "The value is not a session cookie: it should be the signature and the data, separated by a hyphen."
+	}
+	sig, data := value[:hyphen], value[hyphen+1:]
+
+	var entries []sessionEntry
+	gospf.ParseKeyValueCookie(data, func(key, val string) {
+		entries = append(entries, sessionEntry{key, val})
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	if !gospf.Verify(data, sig) {
+		return entries, "The signature does not match the data with the app.secret of this app."
+	}
+	return entries, ""
+}
+
+var sessionTemplate = template.Must(template.New("session").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Session inspector</title></head>
+<body>
+<h1>Session inspector</h1>
+<form method="post" action="{{.Path}}">
+<p>Paste the value of the {{.CookieName}} cookie:</p>
+<textarea name="cookie" rows="4" cols="80">{{.Cookie}}</textarea>
+<p><input type="submit" value="Decode"></p>
+</form>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{if .Cookie}}
+<table>
+<tr><th>Key</th><th>Value</th></tr>{{range .Entries}}
+<tr><td>{{.Key}}</td><td>{{.Value}}</td></tr>{{else}}
+<tr><td colspan="2">The session is empty.</td></tr>{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,142 @@
+package harness
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// With build.sizereport=true, the packages that take the most space in the
+// binary are listed after each build, with the size of their symbols as
+// listed by "go tool nm", e.g.
+//
+//	Binary size: 18.3 MB, by package:
+//	   4.1 MB  22.4%  runtime
+//	   2.0 MB  10.9%  net/http
+//	   ...
+//
+// build.sizereport.top sets the number of packages listed, 10 by default.
+// The data of the types, itabs, strings and constants of all the packages is
+// counted apart, as "(type and runtime data)".
+
+// defaultSizeReportTop is the number of packages listed by default.
+const defaultSizeReportTop = 10
+
+// runtimeDataSymbols is the package of the symbols that belong to no package.
+const runtimeDataSymbols = "(type and runtime data)"
+
+// packageSize is the size of the symbols of a package in a binary.
+type packageSize struct {
+	Package string
+	Size    int64
+}
+
+// FormatSize formats a number of bytes for humans, e.g. "1.5 MB".
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// reportSize prints the size of the binary by package, if build.sizereport
+// is set.  A failure is only logged, since the binary was built.
+func reportSize(ctx context.Context, goPath string, env []string, binary string) {
+	if !gospf.Config.BoolDefault("build.sizereport", false) {
+		return
+	}
+	info, err := os.Stat(binary)
+	if err != nil {
+		gospf.WARN.Println("Failed to report the size of the binary:", err)
+		return
+	}
+	cmd := exec.CommandContext(ctx, goPath, "tool", "nm", "-size", binary)
+	cmd.Env = env
+	gospf.TRACE.Println("Exec:", cmd.Args)
+	output, err := cmd.Output()
+	if err != nil {
+		gospf.WARN.Println("Failed to report the size of the binary: go tool nm:", err)
+		return
+	}
+
+	top := topPackages(parseSymbolSizes(output), gospf.Config.IntDefault("build.sizereport.top", defaultSizeReportTop))
+	lines := []string{fmt.Sprintf("Binary size: %s, by package:", FormatSize(info.Size()))}
+	for _, pkg := range top {
+		lines = append(lines, fmt.Sprintf("%9s %5.1f%%  %s", FormatSize(pkg.Size), 100*float64(pkg.Size)/float64(info.Size()), pkg.Package))
+	}
+	gospf.INFO.Println(strings.Join(lines, "\n"))
+}
+
+// parseSymbolSizes returns the total size of the symbols by package, given
+// the output of "go tool nm -size", whose lines are the address, the size,
+// the type and the name of a symbol.
+func parseSymbolSizes(output []byte) map[string]int64 {
+	sizes := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			// An undefined symbol, without an address.
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size == 0 {
+			continue
+		}
+		sizes[symbolPackage(strings.Join(fields[3:], " "))] += size
+	}
+	return sizes
+}
+
+// symbolPackage returns the import path of the package of the symbol, e.g.
+// "gopkg.in/yaml.v2" for "gopkg.in/yaml%2ev2.(*decoder).unmarshal".  The
+// linker escapes the dots of the last element of the path.
+func symbolPackage(name string) string {
+	for _, prefix := range []string{"type:", "type.", "go:", "go.", "$"} {
+		if strings.HasPrefix(name, prefix) {
+			return runtimeDataSymbols
+		}
+	}
+	if bracket := strings.Index(name, "["); bracket >= 0 {
+		// The type arguments of a generic function.
+		name = name[:bracket]
+	}
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return name
+	}
+	return strings.Replace(name[:slash+1+dot], "%2e", ".", -1)
+}
+
+// topPackages returns the n packages of the largest sizes, largest first.
+func topPackages(sizes map[string]int64, n int) []packageSize {
+	packages := make([]packageSize, 0, len(sizes))
+	for pkg, size := range sizes {
+		packages = append(packages, packageSize{pkg, size})
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Size != packages[j].Size {
+			return packages[i].Size > packages[j].Size
+		}
+		return packages[i].Package < packages[j].Package
+	})
+	if n >= 0 && len(packages) > n {
+		packages = packages[:n]
+	}
+	return packages
+}
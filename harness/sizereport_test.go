@@ -0,0 +1,50 @@
+package harness
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSymbolSizes(t *testing.T) {
+	output := []byte(`  4a5f60       2440 T runtime.mallocgc
+  4a6900        560 T runtime.newobject
+  6a0000       1200 T net/http.(*conn).serve
+  6b0000        300 T gopkg.in/yaml%2ev2.(*decoder).unmarshal
+  6b1000         40 T slices.Sort[go.shape.[]net/http.Cookie]
+  6c0000        800 T github.com/gospf/samples/chat/app/controllers.Application.Index
+  7d0000       4000 R type:*net/http.Request
+  7e0000        120 R go:string.*
+  7e1000          8 R $f64.3eb0000000000000
+  7f0000          0 T runtime.text
+                    U _cgo_init
+`)
+	sizes := parseSymbolSizes(output)
+	expected := map[string]int64{
+		"runtime":          3000,
+		"net/http":         1200,
+		"slices":           40,
+		"gopkg.in/yaml.v2": 300,
+		"github.com/gospf/samples/chat/app/controllers": 800,
+		runtimeDataSymbols: 4128,
+	}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("Expected %v, got %v", expected, sizes)
+	}
+
+	top := topPackages(sizes, 2)
+	if !reflect.DeepEqual(top, []packageSize{{runtimeDataSymbols, 4128}, {"runtime", 3000}}) {
+		t.Errorf("Expected the two largest packages, got %v", top)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, expected := range map[int64]string{
+		512:              "512 B",
+		1536:             "1.5 KB",
+		18 * 1024 * 1024: "18.0 MB",
+	} {
+		if actual := FormatSize(size); actual != expected {
+			t.Errorf("FormatSize(%d) = %q, expected %q", size, actual, expected)
+		}
+	}
+}
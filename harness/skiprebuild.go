@@ -0,0 +1,47 @@
+package harness
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// Requests for static files and health checks need not wait for a rebuild,
+// or trigger one: a page load with many assets would otherwise check the
+// source once per asset.  The requests whose paths start with one of the
+// prefixes of harness.rebuild.skip, "/public/, /favicon.ico" by default, and
+// those with the header "X-Gospf-Rebuild: skip", go straight to the running
+// app.  Until the app is first built, they wait for it like the others.
+
+// skipRebuildHeader, set to "skip", lets a request skip the rebuild check.
+const skipRebuildHeader = "X-Gospf-Rebuild"
+
+const defaultSkipRebuild = "/public/, /favicon.ico"
+
+// loadSkipRebuild returns the path prefixes of harness.rebuild.skip.
+func loadSkipRebuild() []string {
+	var prefixes []string
+	for _, prefix := range strings.Split(gospf.Config.StringDefault("harness.rebuild.skip", defaultSkipRebuild), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// skipsRebuild reports whether the request is proxied without checking the
+// source for changes.  The header is removed from the request.
+func (hp *Harness) skipsRebuild(r *http.Request) bool {
+	skip := strings.EqualFold(r.Header.Get(skipRebuildHeader), "skip")
+	r.Header.Del(skipRebuildHeader)
+	if !skip {
+		for _, prefix := range hp.skipRebuild {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				skip = true
+				break
+			}
+		}
+	}
+	return skip && hp.running()
+}
@@ -0,0 +1,46 @@
+package harness
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSkipsRebuild(t *testing.T) {
+	b := &backend{}
+	hp := &Harness{
+		pools:       []*pool{{backends: []*backend{b}}},
+		skipRebuild: []string{"/public/", "/favicon.ico", "/health"},
+	}
+	tests := []struct {
+		path, header string
+		expected     bool
+	}{
+		{"/public/css/app.css", "", true},
+		{"/favicon.ico", "", true},
+		{"/healthz", "", true},
+		{"/", "", false},
+		{"/users/public/1", "", false},
+		{"/users", "skip", true},
+		{"/users", "always", false},
+	}
+
+	// Until the app runs, every request checks for a rebuild.
+	r := httptest.NewRequest("GET", "/public/css/app.css", nil)
+	if hp.skipsRebuild(r) {
+		t.Error("Expected the request to wait for the first build")
+	}
+
+	b.app = &App{}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.header != "" {
+			r.Header.Set(skipRebuildHeader, test.header)
+		}
+		if actual := hp.skipsRebuild(r); actual != test.expected {
+			t.Errorf("Expected skipsRebuild(%q, %q) to be %v", test.path, test.header, test.expected)
+		}
+		if r.Header.Get(skipRebuildHeader) != "" {
+			t.Errorf("Expected the header to be removed from the request")
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package harness
+
+// This file caches the results of processing the app source, so that only the
+// packages that changed are parsed again, even across runs of the harness.
+// The hashes of the packages that go generate ran on are cached as well, so
+// that it does not run again on the unchanged ones.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// sourceCacheFile is the name of the cache file in app/tmp, which is kept
+// when the directory is cleaned.
+const sourceCacheFile = "sourcecache.gob"
+
+// sourceCacheVersion is increased whenever the cached data changes form, to
+// discard the caches written by previous versions.
+const sourceCacheVersion = 7
+
+type sourceCache struct {
+	Version  int
+	Packages map[string]*cachedPackage // By directory.
+	// Generated maps the directory of each package that declares
+	// go:generate directives to a hash of its Go files as of the last time
+	// "go generate" ran on it.
+	Generated map[string]string
+}
+
+// cachedPackage is the result of processing the package in a directory.
+type cachedPackage struct {
+	Files map[string]fileDigest // By file name.
+	Info  *SourceInfo           // Nil if the directory has no package to process.
+}
+
+// fileDigest identifies the content of a file.  The hash is only computed
+// when the modification time or size changed.
+type fileDigest struct {
+	ModTime time.Time
+	Size    int64
+	Hash    string
+}
+
+// cache is loaded on first use and kept for the subsequent rebuilds.
+var cache *sourceCache
+
+func sourceCachePath() string {
+	return filepath.Join(gospf.AppPath, "tmp", sourceCacheFile)
+}
+
+// loadSourceCache returns the cache, reading it from app/tmp the first time.
+func loadSourceCache() *sourceCache {
+	if cache != nil {
+		return cache
+	}
+
+	cache = &sourceCache{Version: sourceCacheVersion, Packages: make(map[string]*cachedPackage), Generated: make(map[string]string)}
+	data, err := ioutil.ReadFile(sourceCachePath())
+	if err != nil {
+		return cache
+	}
+	var loaded sourceCache
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&loaded); err != nil {
+		gospf.WARN.Println("Ignoring the source cache:", err)
+		return cache
+	}
+	if loaded.Version == sourceCacheVersion && loaded.Packages != nil {
+		if loaded.Generated == nil {
+			loaded.Generated = make(map[string]string)
+		}
+		cache = &loaded
+	}
+	return cache
+}
+
+// save writes the cache to app/tmp.
+func (c *sourceCache) save() {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		gospf.WARN.Println("Failed to encode the source cache:", err)
+		return
+	}
+	os.MkdirAll(filepath.Dir(sourceCachePath()), 0777)
+	if err := ioutil.WriteFile(sourceCachePath(), buf.Bytes(), 0666); err != nil {
+		gospf.WARN.Println("Failed to write the source cache:", err)
+	}
+}
+
+// lookup returns the cached result of processing the package in the
+// directory, and the digests of its files.  The result is only found if the
+// files are unchanged since it was cached.
+func (c *sourceCache) lookup(dir string) (info *SourceInfo, files map[string]fileDigest, found bool) {
+	cached := c.Packages[dir]
+	var previous map[string]fileDigest
+	if cached != nil {
+		previous = cached.Files
+	}
+
+	files, err := digestGoFiles(dir, previous)
+	if err != nil || cached == nil || len(files) != len(cached.Files) {
+		return nil, files, false
+	}
+	for name, digest := range files {
+		if cached.Files[name].Hash != digest.Hash {
+			return nil, files, false
+		}
+	}
+	return copySourceInfo(cached.Info), files, true
+}
+
+// store caches the result of processing the package in the directory.
+func (c *sourceCache) store(dir string, files map[string]fileDigest, info *SourceInfo) {
+	if files == nil {
+		return
+	}
+	c.Packages[dir] = &cachedPackage{Files: files, Info: copySourceInfo(info)}
+}
+
+// digestGoFiles returns the digests of the Go files in the directory.  The
+// hashes of the files whose modification time and size are unchanged from
+// the previous digests are reused.
+func digestGoFiles(dir string, previous map[string]fileDigest) (map[string]fileDigest, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]fileDigest)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+
+		digest := fileDigest{ModTime: info.ModTime(), Size: info.Size()}
+		if prev, ok := previous[name]; ok && prev.ModTime.Equal(digest.ModTime) && prev.Size == digest.Size {
+			digest.Hash = prev.Hash
+		} else {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(data)
+			digest.Hash = hex.EncodeToString(sum[:])
+		}
+		files[name] = digest
+	}
+	return files, nil
+}
+
+// copySourceInfo returns a copy of the info that may be appended to without
+// modifying the original.
+func copySourceInfo(info *SourceInfo) *SourceInfo {
+	if info == nil {
+		return nil
+	}
+	validationKeys := make(map[string]map[int]string, len(info.ValidationKeys))
+	for k, v := range info.ValidationKeys {
+		validationKeys[k] = v
+	}
+	return &SourceInfo{
+		StructSpecs:      append([]*TypeInfo(nil), info.StructSpecs...),
+		ValidationKeys:   validationKeys,
+		InitImportPaths:  append([]string(nil), info.InitImportPaths...),
+		JobSpecs:         append([]*TypeInfo(nil), info.JobSpecs...),
+		InterceptorFuncs: append([]*InterceptorSpec(nil), info.InterceptorFuncs...),
+
+		RegisteredInterceptors: append([]string(nil), info.RegisteredInterceptors...),
+	}
+}
+
+// typeInfoGob is the encoded form of a TypeInfo, including the unexported
+// fields.
+type typeInfoGob struct {
+	StructName, ImportPath, PackageName string
+	MethodSpecs                         []*MethodSpec
+	Interceptors                        []*InterceptorSpec
+	EmbeddedTypes                       []*embeddedTypeName
+}
+
+func (t *TypeInfo) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(typeInfoGob{
+		t.StructName, t.ImportPath, t.PackageName, t.MethodSpecs, t.Interceptors, t.embeddedTypes,
+	})
+	return buf.Bytes(), err
+}
+
+func (t *TypeInfo) GobDecode(data []byte) error {
+	var decoded typeInfoGob
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+	*t = TypeInfo{decoded.StructName, decoded.ImportPath, decoded.PackageName, decoded.MethodSpecs, decoded.Interceptors, decoded.EmbeddedTypes}
+	return nil
+}
+
+// typeExprGob is the encoded form of a TypeExpr, including the unexported
+// fields.
+type typeExprGob struct {
+	Expr, PkgName string
+	PkgIndex      int
+	Valid         bool
+}
+
+func (e TypeExpr) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(typeExprGob{e.Expr, e.PkgName, e.pkgIndex, e.Valid})
+	return buf.Bytes(), err
+}
+
+func (e *TypeExpr) GobDecode(data []byte) error {
+	var decoded typeExprGob
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+	*e = TypeExpr{decoded.Expr, decoded.PkgName, decoded.PkgIndex, decoded.Valid}
+	return nil
+}
@@ -0,0 +1,94 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/gob"
+	"go/ast"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSourceCacheEncoding(t *testing.T) {
+	info := &SourceInfo{
+		StructSpecs: []*TypeInfo{{
+			StructName:  "Application",
+			ImportPath:  "example/app/controllers",
+			PackageName: "controllers",
+			MethodSpecs: []*MethodSpec{{
+				Name: "Show",
+				Args: []*MethodArg{{Name: "ids", TypeExpr: NewTypeExpr("models", &ast.ArrayType{Elt: ast.NewIdent("Id")})}},
+				RenderCalls: []*methodCall{{
+					Path: "example/app/controllers.Application.Show", Line: 12, Names: []string{"ids"},
+				}},
+			}},
+			embeddedTypes: []*embeddedTypeName{{"github.com/gospf/gospf", "Controller"}},
+		}},
+		ValidationKeys:  map[string]map[int]string{"example.Show": {14: "ids"}},
+		InitImportPaths: []string{"example/app/models"},
+	}
+	c := &sourceCache{
+		Version:   sourceCacheVersion,
+		Packages:  map[string]*cachedPackage{"app": {Info: info}},
+		Generated: map[string]string{"app/models": "2c26b46b"},
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		t.Fatal(err)
+	}
+	var decoded sourceCache
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Packages["app"].Info, info) {
+		t.Errorf("Expected %#v, got %#v", info, decoded.Packages["app"].Info)
+	}
+	if !reflect.DeepEqual(decoded.Generated, c.Generated) {
+		t.Errorf("Expected the generated hashes %v, got %v", c.Generated, decoded.Generated)
+	}
+}
+
+func TestSourceCacheLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.go")
+	if err = ioutil.WriteFile(filename, []byte("package app\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &sourceCache{Version: sourceCacheVersion, Packages: make(map[string]*cachedPackage)}
+	if _, files, found := c.lookup(dir); found {
+		t.Fatal("Expected no cached package")
+	} else {
+		c.store(dir, files, &SourceInfo{InitImportPaths: []string{"app"}})
+	}
+
+	info, _, found := c.lookup(dir)
+	if !found || !reflect.DeepEqual(info.InitImportPaths, []string{"app"}) {
+		t.Fatalf("Expected the cached package, got %v", info)
+	}
+	info.InitImportPaths[0] = "changed"
+	if cached, _, _ := c.lookup(dir); cached.InitImportPaths[0] != "app" {
+		t.Error("Expected the cached package to be unaffected by changes to the lookup result")
+	}
+
+	// Touching the file without changing it keeps the package cached.
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filename, later, later)
+	if _, _, found = c.lookup(dir); !found {
+		t.Error("Expected the package to stay cached when its content is unchanged")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "more.go"), []byte("package app\n"), 0666)
+	if _, _, found = c.lookup(dir); found {
+		t.Error("Expected the package not to be cached after adding a file")
+	}
+}
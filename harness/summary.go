@@ -0,0 +1,92 @@
+package harness
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hubply/gospf"
+)
+
+// After each successful build, the harness prints a summary of it, e.g.
+//
+//	Build succeeded in 2.4s: /home/me/go/bin/booking (14.2 MB)
+//	  3 controllers, 17 actions, 21 routes
+//	  Serving http://localhost:9000, watching 4 paths
+//
+// harness.summary=false leaves it out.
+
+// BuildSummary describes a build of the app.
+type BuildSummary struct {
+	Binary      string        // The path of the binary.
+	Size        int64         // The size of the binary, in bytes.
+	Duration    time.Duration // How long the build took.
+	Controllers int           // The number of controllers registered.
+	Actions     int           // The number of actions of the controllers.
+	Routes      int           // The number of routes of conf/routes.
+}
+
+// newBuildSummary returns the summary of the build of the binary, from the
+// source processed, which started at the given time.
+func newBuildSummary(sourceInfo *SourceInfo, binary string, started time.Time) *BuildSummary {
+	summary := &BuildSummary{
+		Binary:   binary,
+		Duration: time.Since(started),
+		Routes:   countRoutes(filepath.Join(gospf.BasePath, "conf", "routes")),
+	}
+	if info, err := os.Stat(binary); err == nil {
+		summary.Size = info.Size()
+	}
+	for _, spec := range sourceInfo.ControllerSpecs() {
+		summary.Controllers++
+		summary.Actions += len(spec.MethodSpecs)
+	}
+	return summary
+}
+
+// Banner returns the summary for the console, with the URL the app is
+// served on and the number of paths watched.
+func (s *BuildSummary) Banner(appURL string, watched int) string {
+	return fmt.Sprintf("Build succeeded in %s: %s (%s)\n  %d controller%s, %d action%s, %d route%s\n  Serving %s, watching %d path%s",
+		s.Duration.Round(100*time.Millisecond), s.Binary, FormatSize(s.Size),
+		s.Controllers, plural(s.Controllers), s.Actions, plural(s.Actions), s.Routes, plural(s.Routes),
+		appURL, watched, plural(watched))
+}
+
+// countRoutes returns the number of routes of the routes file: its lines
+// other than the blank lines, the comments and the modules' routes included.
+func countRoutes(filename string) int {
+	lines, err := gospf.ReadLines(filename)
+	if err != nil {
+		return 0
+	}
+	routes := 0
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") && !strings.HasPrefix(fields[0], "module:") {
+			routes++
+		}
+	}
+	return routes
+}
+
+// appURL returns the URL the harness serves the app on.
+func appURL() string {
+	scheme, host := "http", gospf.HttpAddr
+	if gospf.HttpSsl {
+		scheme = "https"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, gospf.HttpPort)
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
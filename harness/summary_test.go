@@ -0,0 +1,53 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCountRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	routes := filepath.Join(dir, "routes")
+	content := `# Routes
+GET     /                    App.Index
+
+module:testrunner
+POST    /login               App.Login
+  # GET /old                 App.Old
+GET     /public/*filepath    Static.Serve("public")
+`
+	if err = ioutil.WriteFile(routes, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if count := countRoutes(routes); count != 3 {
+		t.Errorf("Expected 3 routes, got %d", count)
+	}
+	if count := countRoutes(filepath.Join(dir, "missing")); count != 0 {
+		t.Errorf("Expected no routes without a routes file, got %d", count)
+	}
+}
+
+func TestBuildSummaryBanner(t *testing.T) {
+	summary := &BuildSummary{
+		Binary:      "/go/bin/booking",
+		Size:        3 << 20,
+		Duration:    2430 * time.Millisecond,
+		Controllers: 1,
+		Actions:     17,
+		Routes:      21,
+	}
+	expected := "Build succeeded in 2.4s: /go/bin/booking (3.0 MB)\n" +
+		"  1 controller, 17 actions, 21 routes\n" +
+		"  Serving http://localhost:9000, watching 4 paths"
+	if banner := summary.Banner("http://localhost:9000", 4); banner != expected {
+		t.Errorf("Expected %q, got %q", expected, banner)
+	}
+}
@@ -0,0 +1,103 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// The app may override the templates of the generated code, and generate
+// more files, with templates in conf/templates:
+//
+//	conf/templates/main.go.tmpl    replaces the template of app/tmp/main.go
+//	conf/templates/routes.go.tmpl  replaces the template of app/routes/routes.go
+//	conf/templates/NAME.go.tmpl    generates app/tmp/NAME.go, in package main
+//
+// The templates are Go text/templates, executed with the same arguments as
+// the stock ones, which are a contract kept across versions:
+//
+//	.Controllers       []*TypeInfo: the controllers, with their actions in
+//	                   MethodSpecs and their interceptor methods.
+//	.ImportPaths       map[string]string: the alias of each package imported
+//	                   by main.go, by import path.
+//	.RouteImportPaths  map[string]string: the same for routes.go, which may
+//	                   not import the controller packages.
+//	.ValidationKeys    map[string]map[int]string: the names of the validated
+//	                   variables, by function and line.
+//	.TestSuites        []*TypeInfo: the test suites, if compiled in.
+//	.Jobs              []*TypeInfo: the jobs run by the worker.
+//	.InterceptorFuncs  []*InterceptorSpec: the interceptor functions.
+//
+// The generated code has its unused imports pruned, and is formatted.  The
+// stock templates are MAIN and ROUTES.  The harness rebuilds the app when the
+// templates change.
+
+// userTemplatesDir returns the directory of the app's templates of the
+// generated code.
+func userTemplatesDir() string {
+	return filepath.Join(gospf.BasePath, "conf", "templates")
+}
+
+// codeTemplate returns the app's template of the generated file, e.g.
+// "main.go", and its path, or the stock template and the file name if the
+// app does not override it.
+func codeTemplate(filename, stock string) (string, string, *gospf.Error) {
+	templatePath := filepath.Join(userTemplatesDir(), filename+".tmpl")
+	source, err := ioutil.ReadFile(templatePath)
+	if os.IsNotExist(err) {
+		return filename, stock, nil
+	}
+	if err != nil {
+		return "", "", &gospf.Error{
+			Title:       codeGenerationErrorTitle,
+			Path:        templatePath,
+			Description: err.Error(),
+		}
+	}
+	gospf.TRACE.Println("Generating", filename, "from", templatePath)
+	return templatePath, string(source), nil
+}
+
+// extraTemplates returns the paths of the app's templates of additional
+// files for app/tmp, by the name of the file they generate.
+func extraTemplates() map[string]string {
+	matches, _ := filepath.Glob(filepath.Join(userTemplatesDir(), "*.go.tmpl"))
+	templates := make(map[string]string)
+	for _, match := range matches {
+		filename := strings.TrimSuffix(filepath.Base(match), ".tmpl")
+		if filename != "main.go" && filename != "routes.go" {
+			templates[filename] = match
+		}
+	}
+	return templates
+}
+
+// genExtraSources generates the additional files of the app's templates into
+// the directory of a main package, e.g. "tmp", and returns their names.
+func genExtraSources(dir string, args map[string]interface{}) ([]string, *gospf.Error) {
+	templates := extraTemplates()
+	var filenames []string
+	for filename := range templates {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		source, err := ioutil.ReadFile(templates[filename])
+		if err != nil {
+			return nil, &gospf.Error{
+				Title:       codeGenerationErrorTitle,
+				Path:        templates[filename],
+				Description: err.Error(),
+			}
+		}
+		if err := writeSource(dir, filename, templates[filename], string(source), args); err != nil {
+			return nil, err
+		}
+	}
+	return filenames, nil
+}
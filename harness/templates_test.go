@@ -0,0 +1,67 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hubply/gospf"
+)
+
+func TestUserTemplates(t *testing.T) {
+	root, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	savedBase, savedApp := gospf.BasePath, gospf.AppPath
+	defer func() { gospf.BasePath, gospf.AppPath = savedBase, savedApp }()
+	gospf.BasePath, gospf.AppPath = root, filepath.Join(root, "app")
+
+	if name, source, err := codeTemplate("main.go", MAIN); err != nil || name != "main.go" || source != MAIN {
+		t.Errorf("Expected the stock template without an override, got %q %v", name, err)
+	}
+
+	dir := userTemplatesDir()
+	os.MkdirAll(dir, 0777)
+	os.MkdirAll(gospf.AppPath, 0777)
+	const routes = "package routes\n"
+	const extra = "package main\n\nvar controllers = []string{ {{range .Controllers}}\"{{.StructName}}\",{{end}} }\n"
+	ioutil.WriteFile(filepath.Join(dir, "routes.go.tmpl"), []byte(routes), 0666)
+	ioutil.WriteFile(filepath.Join(dir, "registry.go.tmpl"), []byte(extra), 0666)
+	ioutil.WriteFile(filepath.Join(dir, "broken.go.tmpl.bak"), []byte("{{"), 0666)
+
+	if name, source, err := codeTemplate("routes.go", ROUTES); err != nil || name != filepath.Join(dir, "routes.go.tmpl") || source != routes {
+		t.Errorf("Expected the app's template, got %q %q %v", name, source, err)
+	}
+
+	args := map[string]interface{}{"Controllers": []*TypeInfo{{StructName: "App"}}}
+	filenames, genErr := genExtraSources("tmp", args)
+	if genErr != nil || len(filenames) != 1 || filenames[0] != "registry.go" {
+		t.Fatalf("Expected registry.go to be generated, got %v %v", filenames, genErr)
+	}
+	generated, err := ioutil.ReadFile(filepath.Join(gospf.AppPath, "tmp", "registry.go"))
+	if err != nil || !strings.Contains(string(generated), `[]string{"App"}`) {
+		t.Errorf("Unexpected generated file %q %v", generated, err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "broken.go.tmpl"), []byte("package main\n{{.Missing"), 0666)
+	if _, genErr = genExtraSources("tmp", args); !IsCodeGenerationError(genErr) || !strings.HasSuffix(genErr.Path, "broken.go.tmpl") {
+		t.Errorf("Expected a code generation error in broken.go.tmpl, got %v", genErr)
+	}
+
+	h := &Harness{}
+	for filename, expected := range map[string]bool{
+		filepath.Join(dir, "main.go.tmpl"):              true,
+		filepath.Join(dir, "broken.go.tmpl.bak"):        false,
+		filepath.Join(root, "conf", "app.conf"):         false,
+		filepath.Join(gospf.AppPath, "app.go"):          true,
+		filepath.Join(gospf.AppPath, "views", "x.tmpl"): false,
+	} {
+		if h.WatchFile(filename) != expected {
+			t.Errorf("Expected WatchFile(%s) to be %v", filename, expected)
+		}
+	}
+}
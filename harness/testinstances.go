@@ -0,0 +1,71 @@
+package harness
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// "gospf test -parallel N", or test.parallel = N, runs the test suites with N
+// instances of the app at once, each on a free port, and each suite with a
+// single instance.  So that the instances do not share their state, e.g. a
+// database, test.instance.env.NAME sets the variable NAME in the environment
+// of each instance, with {n} replaced by the number of the instance, from 1,
+// and {port} by its port:
+//
+//	test.instance.env.DB_SCHEMA = booking_test_{n}
+//
+// The suites run in parallel must not depend on each other.
+
+// TestInstance is an instance of the app that runs test suites.
+type TestInstance struct {
+	Number int      // The number of the instance, from 1.
+	Port   int      // The port it listens on.
+	Env    []string // The variables of test.instance.env, as KEY=VALUE.
+}
+
+// TestParallelism returns the number of app instances that run the test
+// suites, from test.parallel, unless given.
+func TestParallelism(n int) int {
+	if n <= 0 {
+		n = gospf.Config.IntDefault("test.parallel", 1)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// NewTestInstances returns the instances of the app that run the test suites.
+// A single instance listens on the app's port, as configured, and several on
+// free ports.
+func NewTestInstances(n int) []TestInstance {
+	templates := configValues("test.instance.env.")
+	instances := make([]TestInstance, n)
+	for i := range instances {
+		port := gospf.HttpPort
+		if n > 1 {
+			port = getFreePort()
+		}
+		instances[i] = TestInstance{
+			Number: i + 1,
+			Port:   port,
+			Env:    expandInstanceEnv(templates, i+1, port),
+		}
+	}
+	return instances
+}
+
+// expandInstanceEnv returns the variables of test.instance.env for the
+// instance, sorted by name.
+func expandInstanceEnv(templates map[string]string, number, port int) []string {
+	replacer := strings.NewReplacer("{n}", strconv.Itoa(number), "{port}", strconv.Itoa(port))
+	env := make([]string, 0, len(templates))
+	for name, value := range templates {
+		env = append(env, name+"="+replacer.Replace(value))
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,25 @@
+package harness
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandInstanceEnv(t *testing.T) {
+	templates := map[string]string{
+		"DB_SCHEMA": "booking_test_{n}",
+		"BASE_URL":  "http://localhost:{port}/",
+		"MODE":      "test",
+	}
+	expected := []string{
+		"BASE_URL=http://localhost:41002/",
+		"DB_SCHEMA=booking_test_2",
+		"MODE=test",
+	}
+	if env := expandInstanceEnv(templates, 2, 41002); !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %v, got %v", expected, env)
+	}
+	if env := expandInstanceEnv(nil, 1, 9000); len(env) != 0 {
+		t.Errorf("Expected no variables, got %v", env)
+	}
+}
@@ -0,0 +1,81 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// Variant is a variant of the app's build, e.g. the app with its test suites
+// registered for "gospf test" along with the app for "gospf run".  The
+// variants of a build share the processing of the source and the routes, and
+// their go build commands run concurrently.  Each has its own main package,
+// generated into app/tmp/<Name>, and its own binary, named after the app's
+// with the suffix -<Name>, e.g. "chat-test".
+type Variant struct {
+	Name       string // The name of the variant, empty for the app itself.
+	Tags       string // More build tags, separated by commas or spaces.
+	TestSuites bool   // Whether the app's test suites are registered.
+}
+
+// DefaultVariant returns the variant that Build builds: the app itself, with
+// its test suites registered as build.testsuites says.
+func DefaultVariant() Variant {
+	return Variant{TestSuites: includeTestSuites()}
+}
+
+// BuildVariants builds the variants of the app in one pass, and returns their
+// apps in the same order.  It fails with the first failure of a variant.
+func BuildVariants(ctx context.Context, variants []Variant, buildFlags ...string) ([]*App, *gospf.Error) {
+	return buildVariants(ctx, nil, variants, buildFlags)
+}
+
+// checkVariants returns an error if a variant is named twice, or its name
+// may not be used as the name of a directory, or is that of the directory of
+// the buildinfo package.
+func checkVariants(variants []Variant) *gospf.Error {
+	seen := make(map[string]bool)
+	for _, variant := range variants {
+		if strings.ContainsAny(variant.Name, `/\.`) || seen[variant.Name] || variant.dir() == buildInfoDir {
+			return &gospf.Error{
+				Title:       "Invalid Build Variant",
+				Description: fmt.Sprintf("The build variant %q is named twice, or its name is not a valid directory name, or is buildinfo.", variant.Name),
+			}
+		}
+		seen[variant.Name] = true
+	}
+	return nil
+}
+
+// dir returns the directory of the variant's main package, relative to the
+// app directory.
+func (v Variant) dir() string {
+	return path.Join("tmp", v.Name)
+}
+
+func (v Variant) importPath() string {
+	return path.Join(gospf.ImportPath, "app", v.dir())
+}
+
+func (v Variant) buildTags() string {
+	return mergeBuildTags(gospf.RunMode, BuildTags(), v.Tags)
+}
+
+func (v Variant) binaryPath() string {
+	return variantBinaryName(BinaryPath(), v.Name)
+}
+
+// variantBinaryName returns the name of the binary of the named variant,
+// given that of the app.
+func variantBinaryName(binName, name string) string {
+	if name == "" {
+		return binName
+	}
+	if strings.HasSuffix(binName, ".exe") {
+		return strings.TrimSuffix(binName, ".exe") + "-" + name + ".exe"
+	}
+	return binName + "-" + name
+}
@@ -0,0 +1,37 @@
+package harness
+
+import (
+	"testing"
+)
+
+func TestVariantBinaryName(t *testing.T) {
+	tests := []struct {
+		binName, name, expected string
+	}{
+		{"/go/bin/gospf.d/chat", "", "/go/bin/gospf.d/chat"},
+		{"/go/bin/gospf.d/chat", "test", "/go/bin/gospf.d/chat-test"},
+		{"/go/bin/gospf.d/chat-prod", "test", "/go/bin/gospf.d/chat-prod-test"},
+		{`C:\go\bin\gospf.d\chat.exe`, "test", `C:\go\bin\gospf.d\chat-test.exe`},
+	}
+	for _, test := range tests {
+		if binName := variantBinaryName(test.binName, test.name); binName != test.expected {
+			t.Errorf("variantBinaryName(%q, %q) = %q, expected %q", test.binName, test.name, binName, test.expected)
+		}
+	}
+}
+
+func TestCheckVariants(t *testing.T) {
+	if err := checkVariants([]Variant{{}, {Name: "test", TestSuites: true}, {Name: "netgo", Tags: "netgo"}}); err != nil {
+		t.Errorf("Expected the variants to be valid, got %v", err)
+	}
+	for _, variants := range [][]Variant{
+		{{}, {}},
+		{{Name: "test"}, {Name: "test", Tags: "netgo"}},
+		{{Name: "../test"}},
+		{{Name: "."}},
+	} {
+		if err := checkVariants(variants); err == nil {
+			t.Errorf("Expected the variants %v to be invalid", variants)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// With build.vet=true, or vet=true in the build profile, the app's code is
+// analyzed once it compiles: go vet runs over its code paths, and so does the
+// command of build.vet.analyzer, if set, e.g. "staticcheck".  Their findings
+// are reported like compile errors, on the error page and on the console.
+// Each has the severity of its tool:
+//
+//	build.vet.severity           that of go vet's findings, "error" by default
+//	build.vet.analyzer.severity  that of the analyzer's, "warning" by default
+//
+// The findings of the severity of build.vet.fail or higher, "error" by
+// default, fail the build, and the others are logged.  With
+// build.vet.fail=none, they are all logged.  The findings in the generated
+// code are ignored.
+
+// vetSeverities ranks the severities of the findings, and build.vet.fail.
+var vetSeverities = map[string]int{
+	"warning": 1,
+	"error":   2,
+	"none":    3,
+}
+
+// vetFindingPattern matches a finding of go vet or of an analyzer, e.g.
+// "app/controllers/app.go:12:2: unreachable code".
+var vetFindingPattern = regexp.MustCompile(`(?m)^(?:vet: )?([^\s:#][^:]*):(\d+):(?:(\d+):)? (.+)$`)
+
+// vetFinding is a finding of an analysis of the app's code.
+type vetFinding struct {
+	Tool     string // e.g. "go vet" or "staticcheck"
+	Severity string
+	Path     string // Relative to the app's root when under it.
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (f vetFinding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", f.Path, f.Line, f.Column, f.Message, f.Tool)
+}
+
+// vetEnabled reports whether the app's code is analyzed after it builds.
+func vetEnabled(profile *buildProfile) bool {
+	return gospf.Config.BoolDefault("build.vet", false) || profile != nil && profile.Vet
+}
+
+// vet analyzes the code paths of the app with go vet and build.vet.analyzer,
+// and returns the first finding of a severity that fails the build as an
+// error.
+func vet(ctx context.Context, goPath string, env []string) *gospf.Error {
+	var patterns []string
+	for _, root := range append(append([]string{}, gospf.CodePaths...), ExtraCodePaths()...) {
+		if importPath := importPathFromPath(root); importPath != "" {
+			patterns = append(patterns, importPath+"/...")
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	fail, compileError := vetSeverity("build.vet.fail", "error", true)
+	if compileError != nil {
+		return compileError
+	}
+	severity, compileError := vetSeverity("build.vet.severity", "error", false)
+	if compileError != nil {
+		return compileError
+	}
+	args := append([]string{goPath, "vet", "-tags", BuildTags()}, patterns...)
+	findings, compileError := runAnalyzer(ctx, "go vet", args, env, severity)
+	if compileError != nil {
+		return compileError
+	}
+
+	if analyzer := strings.Fields(gospf.Config.StringDefault("build.vet.analyzer", "")); len(analyzer) > 0 {
+		severity, compileError := vetSeverity("build.vet.analyzer.severity", "warning", false)
+		if compileError != nil {
+			return compileError
+		}
+		more, compileError := runAnalyzer(ctx, filepath.Base(analyzer[0]), append(analyzer, patterns...), env, severity)
+		if compileError != nil {
+			return compileError
+		}
+		findings = append(findings, more...)
+	}
+
+	var failed []vetFinding
+	for _, finding := range findings {
+		if vetSeverities[finding.Severity] >= vetSeverities[fail] {
+			gospf.ERROR.Println(finding)
+			failed = append(failed, finding)
+		} else {
+			gospf.WARN.Println(finding)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return newVetError(failed[0], len(failed))
+}
+
+// vetSeverity returns the severity configured by the key.  none is only a
+// threshold, not a severity.
+func vetSeverity(key, def string, threshold bool) (string, *gospf.Error) {
+	severity := strings.ToLower(strings.TrimSpace(gospf.Config.StringDefault(key, def)))
+	if _, found := vetSeverities[severity]; !found || severity == "none" && !threshold {
+		return "", &gospf.Error{
+			Title:       "Invalid Configuration",
+			Description: fmt.Sprintf("%s: %q is not a severity: expected error or warning.", key, severity),
+		}
+	}
+	return severity, nil
+}
+
+// runAnalyzer runs the command of the tool in the app's root directory, and
+// returns its findings, with the given severity.
+func runAnalyzer(ctx context.Context, tool string, args []string, env []string, severity string) ([]vetFinding, *gospf.Error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = gospf.BasePath
+	cmd.Env = env
+	gospf.TRACE.Println("Exec:", cmd.Args)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, newCanceledError(ctx, tool)
+	}
+	if _, notFound := err.(*exec.Error); notFound {
+		return nil, &gospf.Error{
+			Title:       "Analyzer Not Found",
+			Description: fmt.Sprintf("Failed to run %s, set by build.vet.analyzer: %s", tool, err),
+		}
+	}
+
+	findings, matched := parseVetFindings(output, tool, severity, gospf.BasePath)
+	if err != nil && !matched {
+		gospf.ERROR.Printf("%s failed:\n%s", tool, output)
+		return nil, &gospf.Error{
+			Title:       "Static Analysis Failed",
+			Description: fmt.Sprintf("%s failed: %s.  See the console for its output.", tool, err),
+		}
+	}
+	return findings, nil
+}
+
+// parseVetFindings returns the findings in the output of the tool, whose
+// relative paths are relative to basePath, except those in the generated
+// code.  It also reports whether the output had any finding.
+func parseVetFindings(output []byte, tool, severity, basePath string) (findings []vetFinding, matched bool) {
+	for _, match := range vetFindingPattern.FindAllSubmatch(output, -1) {
+		matched = true
+		path := filepath.FromSlash(string(match[1]))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(basePath, path)
+		}
+		if rel, err := filepath.Rel(basePath, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+			if dir := filepath.ToSlash(rel); strings.HasPrefix(dir, "app/tmp/") || strings.HasPrefix(dir, "app/routes/") || strings.HasSuffix(dir, "/routes/routes.go") {
+				continue
+			}
+		}
+		line, _ := strconv.Atoi(string(match[2]))
+		column, _ := strconv.Atoi(string(match[3]))
+		findings = append(findings, vetFinding{
+			Tool:     tool,
+			Severity: severity,
+			Path:     path,
+			Line:     line,
+			Column:   column,
+			Message:  strings.TrimSpace(string(match[4])),
+		})
+	}
+	return findings, matched
+}
+
+// newVetError returns the error page of a finding, one of count that fail the
+// build.
+func newVetError(finding vetFinding, count int) *gospf.Error {
+	title := "Static Analysis Error"
+	if finding.Tool == "go vet" {
+		title = "Go Vet Error"
+	}
+	description := finding.Message + " (" + finding.Tool + ")"
+	if count > 1 {
+		description += fmt.Sprintf("  %d more findings are listed on the console.", count-1)
+	}
+	vetError := &gospf.Error{
+		SourceType:  "Go code",
+		Title:       title,
+		Path:        finding.Path,
+		Line:        finding.Line,
+		Column:      finding.Column,
+		Description: description,
+	}
+	absPath := finding.Path
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(gospf.BasePath, absPath)
+	}
+	if lines, err := gospf.ReadLines(absPath); err == nil {
+		vetError.SourceLines = lines
+	}
+	setErrorLink(vetError)
+	return vetError
+}
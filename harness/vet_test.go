@@ -0,0 +1,28 @@
+package harness
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVetFindings(t *testing.T) {
+	basePath := filepath.FromSlash("/src/chat")
+	output := []byte(`# github.com/gospf/samples/chat/app/controllers
+app/controllers/app.go:12:2: unreachable code
+app/tmp/main.go:40:3: composite literal uses unkeyed fields
+../shared/util.go:7: result of fmt.Sprintf call not used
+`)
+	findings, matched := parseVetFindings(output, "go vet", "error", basePath)
+	expected := []vetFinding{
+		{Tool: "go vet", Severity: "error", Path: filepath.FromSlash("app/controllers/app.go"), Line: 12, Column: 2, Message: "unreachable code"},
+		{Tool: "go vet", Severity: "error", Path: filepath.FromSlash("/src/shared/util.go"), Line: 7, Message: "result of fmt.Sprintf call not used"},
+	}
+	if !matched || !reflect.DeepEqual(findings, expected) {
+		t.Errorf("Expected %v, got %v", expected, findings)
+	}
+
+	if findings, matched := parseVetFindings([]byte("go: cannot find main module\n"), "go vet", "error", basePath); matched || len(findings) > 0 {
+		t.Errorf("Expected no finding, got %v", findings)
+	}
+}
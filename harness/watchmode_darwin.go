@@ -0,0 +1,5 @@
+package harness
+
+// defaultWatchMode is the default of watch.mode.  FSEvents delivers events
+// late and coalesced, so the events alone may leave the build stale.
+const defaultWatchMode = "hybrid"
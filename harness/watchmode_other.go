@@ -0,0 +1,7 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package harness
+
+// defaultWatchMode is the default of watch.mode.
+const defaultWatchMode = "events"
@@ -0,0 +1,6 @@
+package harness
+
+// defaultWatchMode is the default of watch.mode.  The rename that completes
+// an atomic save is reported under the temporary name only, so the events
+// alone may leave the build stale.
+const defaultWatchMode = "hybrid"
@@ -0,0 +1,177 @@
+package harness
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hubply/gospf"
+)
+
+// The harness watches the code paths of the app and its modules for changes,
+// the directories listed in watch.paths, and those of the packages listed in
+// watch.packages, e.g.
+//
+//	watch.paths    = internal, ../shared
+//	watch.packages = github.com/hubply/gospf
+//	watch.ignore   = testdata, **/node_modules, assets/*/build
+//
+// Relative watch.paths are relative to the app's root directory.  The
+// packages are found as the app's imports are, and watched with the packages
+// under them, so that a change to the framework's source, e.g., rebuilds the
+// app without watching the whole GOPATH as watch.gopath does.
+//
+// The watch.ignore rules are globs matched against the path of a directory
+// relative to a watched path, so that "testdata" ignores the testdata
+// directory at the top of a watched path, but not a package of that name
+// deeper in the tree.  "**" matches any number of directories.  The
+// directories that the harness generates or reloads on its own, tmp, routes
+// and views at the top of a code path, are always ignored.  The
+// rules of the app's .gospfignore are applied as well.
+
+// defaultWatchIgnore are the rules of the directories that are never watched
+// for rebuilds: the generated code, and the templates.
+var defaultWatchIgnore = []string{"tmp", "routes", "views"}
+
+// WatchPaths returns the directories the harness watches for changes to the
+// app: the code paths and the modules of the workspace, those of watch.paths,
+// and those of the packages of watch.packages.
+func WatchPaths() []string {
+	paths := SourcePaths()
+	for _, dir := range configList("watch.paths") {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(gospf.BasePath, filepath.FromSlash(dir))
+		}
+		paths = append(paths, filepath.Clean(dir))
+	}
+	for _, importPath := range configList("watch.packages") {
+		dir, err := gospf.ResolveImportPath(importPath)
+		if err != nil {
+			gospf.WARN.Printf("Not watching the package %s of watch.packages: %s", importPath, err)
+			continue
+		}
+		paths = append(paths, filepath.Clean(dir))
+	}
+	return paths
+}
+
+// configList returns the comma-separated values of the config key.
+func configList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(gospf.Config.StringDefault(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// watchFilter tells the directories that are ignored by the watch.ignore
+// rules, and the files and directories ignored by the app's .gospfignore.
+type watchFilter struct {
+	roots      []string // The watched paths that the rules are relative to.
+	rules      []string
+	ignoreFile *ignoreFile // Relative to the app's root, if there is one.
+
+	mu      sync.Mutex
+	ignored []os.FileInfo // The ignored directories found by the last scan.
+}
+
+func newWatchFilter(roots []string) *watchFilter {
+	return &watchFilter{
+		roots:      roots,
+		rules:      append(append([]string{}, defaultWatchIgnore...), configList("watch.ignore")...),
+		ignoreFile: loadIgnoreFile(gospf.BasePath),
+	}
+}
+
+// ignores reports whether the directory is ignored, relative to any of the
+// watched paths it is in.
+func (f *watchFilter) ignores(dir string) bool {
+	if f.ignoreFile.ignores(dir, true) {
+		return true
+	}
+	for _, root := range f.roots {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		for _, rule := range f.rules {
+			if MatchGlob(rule, filepath.ToSlash(rel)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ignoresFile reports whether the file is ignored by the app's .gospfignore.
+func (f *watchFilter) ignoresFile(filename string) bool {
+	return f.ignoreFile.ignores(filename, false)
+}
+
+// scan records the ignored directories under the watched paths, for
+// ignoresInfo.
+func (f *watchFilter) scan() {
+	var ignored []os.FileInfo
+	for _, root := range f.roots {
+		filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+			if f.ignores(dir) {
+				ignored = append(ignored, info)
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+	f.mu.Lock()
+	f.ignored = ignored
+	f.mu.Unlock()
+}
+
+// ignoresInfo reports whether the directory is one of the ignored ones found
+// by the last scan.  It serves WatchDir, which is only given the info of a
+// directory, not its path.
+func (f *watchFilter) ignoresInfo(info os.FileInfo) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ignored := range f.ignored {
+		if os.SameFile(info, ignored) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports whether the slash-separated name matches the pattern, in
+// which "**" matches any number of path elements and the other elements are
+// matched with path.Match.  The rules of watch.ignore and package.exclude are
+// matched with it.
+func MatchGlob(pattern, name string) bool {
+	return matchElements(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
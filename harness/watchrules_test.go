@@ -0,0 +1,68 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"tmp", "tmp", true},
+		{"tmp", "models/tmp", false},
+		{"routes", "controllers/routes", false},
+		{"**/node_modules", "node_modules", true},
+		{"**/node_modules", "assets/js/node_modules", true},
+		{"assets/*/build", "assets/js/build", true},
+		{"assets/*/build", "assets/js/src/build", false},
+		{"assets/**/build", "assets/js/src/build", true},
+		{"test*", "testdata", true},
+		{"test*", "models/testdata", false},
+	}
+	for _, test := range tests {
+		if actual := MatchGlob(test.pattern, test.name); actual != test.expected {
+			t.Errorf("MatchGlob(%q, %q) = %v, expected %v", test.pattern, test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestWatchFilter(t *testing.T) {
+	root, err := ioutil.TempDir("", "watchfilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"tmp", "views", "routes", "controllers/routes", "assets/js/node_modules"} {
+		os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0777)
+	}
+
+	filter := newWatchFilter([]string{root})
+	filter.rules = append(filter.rules, "**/node_modules")
+	filter.scan()
+	for dir, expected := range map[string]bool{
+		"":                       false,
+		"tmp":                    true,
+		"views":                  true,
+		"routes":                 true,
+		"controllers":            false,
+		"controllers/routes":     false,
+		"assets/js":              false,
+		"assets/js/node_modules": true,
+	} {
+		path := filepath.Join(root, filepath.FromSlash(dir))
+		if actual := filter.ignores(path); actual != expected {
+			t.Errorf("Expected ignores(%q) to be %v", dir, expected)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := filter.ignoresInfo(info); actual != expected {
+			t.Errorf("Expected ignoresInfo(%q) to be %v", dir, expected)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package harness
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hubply/gospf"
+)
+
+// An app in a monorepo may use shared libraries in sibling modules, whose
+// local changes it should build with.  The modules of the app's workspace are
+// those listed in build.workspace, relative to the app's root directory unless
+// absolute, e.g. "build.workspace = ../shared, ../lib/auth", and those of the
+// use directives of the go.work file of the app, found as the go command
+// finds it: in GOWORK, or in the app's directory or a parent.
+//
+// Each module is linked at the path of its go.mod module in a workspace that
+// is put first in the GOPATH, so that the app builds with its local source.
+// Its source is processed along with the app's, e.g. for the controllers it
+// defines, and watched, so that a change to it rebuilds the app.
+
+// WorkspaceModule is a module of the app's workspace.
+type WorkspaceModule struct {
+	Dir  string // The directory of the module.
+	Path string // The path of the module in its go.mod.
+}
+
+// WorkspaceModules returns the modules of the app's workspace, other than the
+// app's own and those that contain the app, e.g. that of "use ." in a go.work
+// at the root of a monorepo, whose source the app's code paths already are.
+// The directories that are not modules are skipped, with a warning.
+func WorkspaceModules() []WorkspaceModule {
+	appDir := realPath(gospf.BasePath)
+	var dirs []string
+	for _, dir := range configList("build.workspace") {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(appDir, filepath.FromSlash(dir))
+		}
+		dirs = append(dirs, dir)
+	}
+	if goWork := findGoWork(appDir); goWork != "" {
+		data, err := ioutil.ReadFile(goWork)
+		if err != nil {
+			gospf.WARN.Println("Failed to read the workspace:", err)
+		}
+		for _, dir := range parseGoWork(data) {
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(goWork), filepath.FromSlash(dir))
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var modules []WorkspaceModule
+	seen := map[string]bool{appDir: true}
+	for _, dir := range dirs {
+		dir = realPath(dir)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		modulePath := ModulePath(filepath.Join(dir, "go.mod"))
+		if modulePath == "" {
+			gospf.WARN.Printf("Skipping %s of the workspace: it has no go.mod", dir)
+			continue
+		}
+		if modulePath == gospf.ImportPath || withinDir(dir, appDir) {
+			continue
+		}
+		modules = append(modules, WorkspaceModule{Dir: dir, Path: modulePath})
+	}
+	return modules
+}
+
+// SourcePaths returns the directories whose source is processed for the app:
+// its code paths, those of codepaths.extra, and those of the modules of its
+// workspace.
+func SourcePaths() []string {
+	paths := append([]string{}, gospf.CodePaths...)
+	paths = append(paths, ExtraCodePaths()...)
+	for _, module := range WorkspaceModules() {
+		paths = append(paths, module.Dir)
+	}
+	return paths
+}
+
+// ModulePath returns the path in the module directive of the go.mod file, or
+// "" if it can't be read.
+func ModulePath(goMod string) string {
+	file, err := os.Open(goMod)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			if unquoted, err := strconv.Unquote(fields[1]); err == nil {
+				return unquoted
+			}
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// findGoWork returns the go.work file of the directory: that of GOWORK, or
+// else the nearest in the directory or a parent, or "" if there is none.
+func findGoWork(dir string) string {
+	if goWork := os.Getenv("GOWORK"); goWork != "" {
+		if goWork == "off" {
+			return ""
+		}
+		return goWork
+	}
+	for {
+		goWork := filepath.Join(dir, "go.work")
+		if info, err := os.Stat(goWork); err == nil && !info.IsDir() {
+			return goWork
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// parseGoWork returns the directories of the use directives of a go.work
+// file, as written.
+func parseGoWork(data []byte) []string {
+	var dirs []string
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case inBlock:
+		case fields[0] == "use" && len(fields) > 1 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "use" && len(fields) > 1:
+			fields = fields[1:]
+		default:
+			continue
+		}
+		dir := fields[0]
+		if unquoted, err := strconv.Unquote(dir); err == nil {
+			dir = unquoted
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// linkWorkspace links the modules of the app's workspace at their paths in a
+// GOPATH workspace, and puts it first in the GOPATH of gospf and of the go
+// commands it runs.  The workspace is kept in the user cache directory, and
+// relinked on each build, as the modules may have changed.
+func linkWorkspace() *gospf.Error {
+	modules := WorkspaceModules()
+	if len(modules) == 0 {
+		return nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	workspace := filepath.Join(cacheDir, "gospf", "workspace", fmt.Sprintf("%x", sha1.Sum([]byte(gospf.BasePath)))[:12])
+
+	// The links are removed, not the modules they point to.
+	os.RemoveAll(filepath.Join(workspace, "src"))
+	for _, module := range modules {
+		link := filepath.Join(workspace, "src", filepath.FromSlash(path.Clean(module.Path)))
+		err := os.MkdirAll(filepath.Dir(link), 0777)
+		if err == nil {
+			err = os.Symlink(module.Dir, link)
+		}
+		if err != nil {
+			return &gospf.Error{
+				Title:       "Workspace Error",
+				Description: fmt.Sprintf("Failed to link the module %s into %s: %s", module.Path, workspace, err),
+			}
+		}
+		gospf.TRACE.Printf("Building with the module %s from %s", module.Path, module.Dir)
+	}
+
+	gopaths := filepath.SplitList(build.Default.GOPATH)
+	if len(gopaths) == 0 || gopaths[0] != workspace {
+		gopath := workspace
+		if build.Default.GOPATH != "" {
+			gopath += string(filepath.ListSeparator) + build.Default.GOPATH
+		}
+		os.Setenv("GOPATH", gopath)
+		build.Default.GOPATH = gopath
+	}
+	return nil
+}
+
+// workspaceImportPath returns the import path of the directory if it is in a
+// module of the workspace, or "".  Of nested modules, the innermost is that
+// of the directory.
+func workspaceImportPath(dir string) string {
+	var importPath, moduleDir string
+	for _, module := range WorkspaceModules() {
+		if withinDir(module.Dir, dir) && len(module.Dir) > len(moduleDir) {
+			rel, _ := filepath.Rel(module.Dir, dir)
+			importPath, moduleDir = path.Join(module.Path, filepath.ToSlash(rel)), module.Dir
+		}
+	}
+	return importPath
+}
+
+// skipSourceDir reports whether the directory found while walking the source
+// of root is not part of it: a testdata or vendor directory, or that of a
+// nested module, which is a workspace module of its own if the app uses it.
+func skipSourceDir(root, dir string) bool {
+	if dir == root {
+		return false
+	}
+	if name := filepath.Base(dir); name == "testdata" || name == "vendor" {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(dir, "go.mod"))
+	return err == nil && !info.IsDir()
+}
+
+// withinDir reports whether the directory is parent or one of its
+// subdirectories.
+func withinDir(parent, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath returns the absolute path of the file with the symbolic links
+// resolved, or the cleaned path if it can't be.
+func realPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		return resolved
+	}
+	return filepath.Clean(name)
+}
@@ -0,0 +1,105 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseGoWork(t *testing.T) {
+	goWork := `go 1.21
+
+use ./app // The app.
+use (
+	../shared
+	"./lib/auth"
+	// ./lib/old
+)
+
+replace example.com/x => ./x
+`
+	expected := []string{"./app", "../shared", "./lib/auth"}
+	if dirs := parseGoWork([]byte(goWork)); !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Expected %v, got %v", expected, dirs)
+	}
+}
+
+func TestModulePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	goMod := filepath.Join(dir, "go.mod")
+	if err = ioutil.WriteFile(goMod, []byte("// The shared code.\nmodule \"example.com/shared\"\n\ngo 1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if path := ModulePath(goMod); path != "example.com/shared" {
+		t.Errorf("Expected example.com/shared, got %q", path)
+	}
+	if path := ModulePath(filepath.Join(dir, "missing", "go.mod")); path != "" {
+		t.Errorf("Expected no module for a missing go.mod, got %q", path)
+	}
+}
+
+func TestFindGoWork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = realPath(dir)
+
+	appDir := filepath.Join(dir, "apps", "web")
+	if err = os.MkdirAll(appDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	goWork := filepath.Join(dir, "go.work")
+	if err = ioutil.WriteFile(goWork, []byte("go 1.21\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Unsetenv("GOWORK")
+	if found := findGoWork(appDir); found != goWork {
+		t.Errorf("Expected %s, got %q", goWork, found)
+	}
+	os.Setenv("GOWORK", "off")
+	defer os.Unsetenv("GOWORK")
+	if found := findGoWork(appDir); found != "" {
+		t.Errorf("Expected no go.work with GOWORK=off, got %q", found)
+	}
+}
+
+func TestSkipSourceDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sub := range []string{"lib", "lib/testdata", "vendor", "tools"} {
+		if err = os.MkdirAll(filepath.Join(dir, sub), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, goMod := range []string{"go.mod", "tools/go.mod"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, goMod), []byte("module example.com/x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for sub, expected := range map[string]bool{
+		"":             false, // The root, although it is a module.
+		"lib":          false,
+		"lib/testdata": true,
+		"vendor":       true,
+		"tools":        true, // A nested module.
+	} {
+		if skipped := skipSourceDir(dir, filepath.Join(dir, sub)); skipped != expected {
+			t.Errorf("Expected %q to be skipped: %v, got %v", sub, expected, skipped)
+		}
+	}
+}